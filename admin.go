@@ -0,0 +1,241 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package ibcni
+
+import (
+	"fmt"
+
+	ibclient "github.com/infobloxopen/infoblox-go-client"
+)
+
+// Extensible attribute holding the container ID of a fixed address, as set
+// by the cloud API.
+const EA_VM_ID = "VM ID"
+
+// Allocation is a fixed address allocated for a container.
+type Allocation struct {
+	Ref         string            `json:"ref"`
+	NetworkView string            `json:"network-view"`
+	Cidr        string            `json:"cidr"`
+	IPAddress   string            `json:"ip-address"`
+	Mac         string            `json:"mac"`
+	Name        string            `json:"name"`
+	ContainerID string            `json:"container-id,omitempty"`
+	Attachment  string            `json:"attachment,omitempty"`
+	Namespace   string            `json:"namespace,omitempty"`
+	PodName     string            `json:"pod-name,omitempty"`
+	PodUID      string            `json:"pod-uid,omitempty"`
+	NodeName    string            `json:"node-name,omitempty"`
+	AllocatedAt string            `json:"allocated-at,omitempty"`
+	ExtAttrs    map[string]string `json:"extattrs,omitempty"`
+}
+
+// AllocationFilter selects the allocations listed by the daemon's
+// Infoblox.ListAllocations RPC method. Empty fields match everything.
+type AllocationFilter struct {
+	NetworkView string
+	NodeName    string
+	ClusterName string
+	Namespace   string
+	PodUID      string
+	ContainerID string
+	Attachment  string
+}
+
+// ExtAttrs returns the extensible attribute search for the filter.
+func (f AllocationFilter) ExtAttrs() ibclient.EA {
+	ea := ibclient.EA{"CMP Type": CMP_TYPE}
+	if f.NodeName != "" {
+		ea[EA_NODE_NAME] = f.NodeName
+	}
+	if f.ClusterName != "" {
+		ea[EA_CLUSTER_NAME] = f.ClusterName
+	}
+	if f.Namespace != "" {
+		ea[EA_POD_NAMESPACE] = f.Namespace
+	}
+	if f.PodUID != "" {
+		ea[EA_POD_UID] = f.PodUID
+	}
+	if f.ContainerID != "" {
+		ea[EA_VM_ID] = f.ContainerID
+	}
+	if f.Attachment != "" {
+		ea[EA_ATTACHMENT] = f.Attachment
+	}
+	return ea
+}
+
+// ReleaseArgs are the arguments of the daemon's Infoblox.ForceRelease RPC
+// method.
+type ReleaseArgs struct {
+	NetworkView string
+	IPAddress   string
+}
+
+// UtilizationArgs are the arguments of the daemon's Infoblox.Utilization RPC
+// method. Without a CIDR, the utilization of the networks tracked by the
+// daemon is returned.
+type UtilizationArgs struct {
+	NetworkView string
+	Cidr        string
+}
+
+// MigrationEntry is an address allocated by another IPAM plugin, to be
+// moved to Infoblox.
+type MigrationEntry struct {
+	IPAddress   string `json:"ip-address"`
+	ContainerID string `json:"container-id"`
+	IfName      string `json:"ifname,omitempty"`
+}
+
+// MigrateArgs are the arguments of the daemon's Infoblox.Migrate RPC method.
+// NetConf is the Infoblox CNI network configuration the addresses are
+// migrated to.
+type MigrateArgs struct {
+	NetConf []byte
+	Entries []MigrationEntry
+	DryRun  bool
+}
+
+// Migration statuses
+const (
+	MigrationCreated     = "created"
+	MigrationWouldCreate = "would-create"
+	MigrationExists      = "exists"
+	MigrationConflict    = "conflict"
+	MigrationFailed      = "failed"
+)
+
+// MigrationResult reports the outcome of migrating one address.
+type MigrationResult struct {
+	MigrationEntry
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// getAllObjects reads all objects matching obj into res, a page at a time if
+// the connector supports paging.
+func getAllObjects(conn ibclient.IBConnector, obj ibclient.IBObject, res interface{}) error {
+	if pager, ok := conn.(interface {
+		GetAllObjects(obj ibclient.IBObject, res interface{}) error
+	}); ok {
+		return pager.GetAllObjects(obj, res)
+	}
+	return conn.GetObject(obj, "", res)
+}
+
+// ListAddresses returns the fixed addresses in the network view that carry
+// all the given extensible attributes, however many there are.
+func (ibDrv *InfobloxDriver) ListAddresses(netviewName string, ea ibclient.EA) ([]Allocation, error) {
+	if ibDrv.connector == nil {
+		return nil, fmt.Errorf("listing fixed addresses requires a WAPI connector")
+	}
+	if netviewName == "" {
+		netviewName = ibDrv.DefaultNetworkView
+	}
+
+	var res []fixedAddressSearch
+	err := getAllObjects(ibDrv.connector, newFixedAddressSearch(netviewName, "", ea), &res)
+	if err != nil {
+		return nil, err
+	}
+
+	allocations := make([]Allocation, 0, len(res))
+	for _, fa := range res {
+		allocations = append(allocations, fa.allocation())
+	}
+	return allocations, nil
+}
+
+// FindAddress returns the fixed address for ipAddr in the network view, or
+// nil if there is none.
+func (ibDrv *InfobloxDriver) FindAddress(netviewName string, ipAddr string) (*Allocation, error) {
+	if ibDrv.connector == nil {
+		return nil, fmt.Errorf("looking up fixed addresses requires a WAPI connector")
+	}
+	if netviewName == "" {
+		netviewName = ibDrv.DefaultNetworkView
+	}
+
+	var res []fixedAddressSearch
+	err := ibDrv.connector.GetObject(newFixedAddressSearch(netviewName, ipAddr, nil), "", &res)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, nil
+	}
+	allocation := res[0].allocation()
+	return &allocation, nil
+}
+
+// FindAttachment returns the fixed address in cidr allocated for the
+// container's attachment, or nil if there is none.
+func (ibDrv *InfobloxDriver) FindAttachment(netviewName string, cidr string, vmID string, attachment string) (*Allocation, error) {
+	allocations, err := ibDrv.ListAddresses(netviewName, ibclient.EA{EA_VM_ID: vmID, EA_ATTACHMENT: attachment})
+	if err != nil {
+		return nil, err
+	}
+	for i := range allocations {
+		if allocations[i].Cidr == cidr {
+			return &allocations[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (fa *fixedAddressSearch) allocation() Allocation {
+	allocation := Allocation{
+		Ref:         fa.Ref,
+		NetworkView: fa.NetviewName,
+		Cidr:        fa.Cidr,
+		IPAddress:   fa.IPAddress,
+		Mac:         fa.Mac,
+		Name:        fa.Name,
+		ExtAttrs:    map[string]string{},
+	}
+	for k, v := range fa.Ea {
+		allocation.ExtAttrs[k] = fmt.Sprint(v)
+	}
+	allocation.ContainerID = allocation.ExtAttrs[EA_VM_ID]
+	allocation.Attachment = allocation.ExtAttrs[EA_ATTACHMENT]
+	allocation.Namespace = allocation.ExtAttrs[EA_POD_NAMESPACE]
+	allocation.PodName = allocation.ExtAttrs[EA_POD_NAME]
+	allocation.PodUID = allocation.ExtAttrs[EA_POD_UID]
+	allocation.NodeName = allocation.ExtAttrs[EA_NODE_NAME]
+	allocation.AllocatedAt = allocation.ExtAttrs[EA_ALLOCATED_AT]
+	return allocation
+}
+
+// CheckGrid makes a cheap WAPI call, bypassing the cache, to verify that
+// the grid can be reached with the configured credentials.
+func (ibDrv *InfobloxDriver) CheckGrid() error {
+	if ibDrv.connector == nil {
+		_, err := ibDrv.objMgr.GetNetworkView(ibDrv.DefaultNetworkView)
+		return err
+	}
+
+	var res []ibclient.NetworkView
+	netview := ibclient.NewNetworkView(ibclient.NetworkView{Name: ibDrv.DefaultNetworkView})
+	if err := ibDrv.connector.GetObject(netview, "", &res); err != nil {
+		return err
+	}
+	if len(res) == 0 {
+		return fmt.Errorf("network view '%s' not found", ibDrv.DefaultNetworkView)
+	}
+	return nil
+}
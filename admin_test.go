@@ -0,0 +1,34 @@
+package ibcni
+
+import (
+	ibclient "github.com/infobloxopen/infoblox-go-client"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AllocationFilter", func() {
+	It("Should only select addresses of the CNI without filters", func() {
+		Expect(AllocationFilter{NetworkView: "default"}.ExtAttrs()).To(Equal(ibclient.EA{"CMP Type": CMP_TYPE}))
+	})
+
+	It("Should select addresses by all given fields", func() {
+		filter := AllocationFilter{
+			NodeName:    "node-1",
+			ClusterName: "cluster-a",
+			Namespace:   "web",
+			PodUID:      "uid-1",
+			ContainerID: "abc123",
+			Attachment:  "abc123/eth0",
+		}
+		Expect(filter.ExtAttrs()).To(Equal(ibclient.EA{
+			"CMP Type":       CMP_TYPE,
+			EA_NODE_NAME:     "node-1",
+			EA_CLUSTER_NAME:  "cluster-a",
+			EA_POD_NAMESPACE: "web",
+			EA_POD_UID:       "uid-1",
+			EA_VM_ID:         "abc123",
+			EA_ATTACHMENT:    "abc123/eth0",
+		}))
+	})
+})
@@ -0,0 +1,149 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package ibcni
+
+import (
+	"log"
+	"net"
+	"time"
+
+	ibclient "github.com/infobloxopen/infoblox-go-client"
+)
+
+// owned tells whether the driver created an object with the extensible
+// attributes ea.
+func (ibDrv *InfobloxDriver) owned(ea ibclient.EA) bool {
+	return ibDrv.Owner != "" && ea[EA_OWNER] == ibDrv.Owner
+}
+
+// settled tells whether an owned object with the extensible attributes ea
+// was created longer than the cleanup grace period ago, so that a network
+// another node has just created and is about to allocate from is not
+// deleted under it. Objects without a valid creation time are settled.
+func (ibDrv *InfobloxDriver) settled(ea ibclient.EA) bool {
+	createdAt, ok := ea[EA_CREATED_AT].(string)
+	if !ok || ibDrv.CleanupGrace <= 0 {
+		return true
+	}
+	created, err := time.Parse(time.RFC3339, createdAt)
+	return err != nil || time.Since(created) >= ibDrv.CleanupGrace
+}
+
+// DeleteNetworkIfUnused deletes the network if the driver created it longer
+// than the grace period ago and no fixed addresses are left in it other than
+// its gateway, which is deleted along with the network. It reports whether
+// the network was deleted. The grid is queried directly rather than through
+// the cache, so that an address allocated by another node before the check
+// is seen.
+//
+// WAPI has no conditional delete, so the check and the delete are not
+// atomic: an address another node allocates in the network between the two
+// is deleted along with the network. The grace period narrows the window
+// for networks that have just been created, but does not close it.
+func (ibDrv *InfobloxDriver) DeleteNetworkIfUnused(netviewName string, cidr string) (bool, error) {
+	if ibDrv.Owner == "" || ibDrv.connector == nil {
+		return false, nil
+	}
+	if netviewName == "" {
+		netviewName = ibDrv.DefaultNetworkView
+	}
+
+	var networks []networkOptions
+	err := ibDrv.connector.GetObject(newNetworkOptions(networkOptions{NetviewName: netviewName, Cidr: cidr}), "", &networks)
+	if err != nil {
+		return false, err
+	}
+	if len(networks) == 0 || !ibDrv.owned(networks[0].Ea) || !ibDrv.settled(networks[0].Ea) {
+		return false, nil
+	}
+	options, err := parseDHCPOptions(networks[0].Options)
+	if err != nil {
+		return false, err
+	}
+
+	var addrs []fixedAddressSearch
+	search := newFixedAddressSearch(netviewName, "", nil)
+	search.Cidr = cidr
+	if err = getAllObjects(ibDrv.connector, search, &addrs); err != nil {
+		return false, err
+	}
+	for _, addr := range addrs {
+		if !isGateway(addr, options.Routers) {
+			return false, nil
+		}
+	}
+
+	if _, err = ibDrv.connector.DeleteObject(networks[0].Ref); err != nil {
+		return false, err
+	}
+	ibDrv.InvalidateCache(netviewName)
+	log.Printf("Deleted unused network '%s' in network view '%s'", cidr, netviewName)
+	return true, nil
+}
+
+// isGateway tells whether a fixed address is the gateway of its network:
+// one of the network's routers, or the address CreateGateway reserved,
+// which has neither a name, a MAC address nor a container.
+func isGateway(addr fixedAddressSearch, routers []net.IP) bool {
+	ip := net.ParseIP(addr.IPAddress)
+	for _, router := range routers {
+		if router.Equal(ip) {
+			return true
+		}
+	}
+	vmID, _ := addr.Ea[EA_VM_ID].(string)
+	return addr.Name == "" && (addr.Mac == "" || addr.Mac == ZERO_MAC_ADDR) && (vmID == "" || vmID == "N/A") &&
+		addr.Ea[EA_ATTACHMENT] == nil && addr.Ea[EA_WARM_POOL] == nil
+}
+
+// DeleteNetworkViewIfUnused deletes the network view if the driver created
+// it longer than the grace period ago and it has no networks or network
+// containers left. It reports whether the network view was deleted. As with
+// DeleteNetworkIfUnused, a network created in the view between the check
+// and the delete is deleted along with it.
+func (ibDrv *InfobloxDriver) DeleteNetworkViewIfUnused(netviewName string) (bool, error) {
+	if ibDrv.Owner == "" || ibDrv.connector == nil {
+		return false, nil
+	}
+
+	var netviews []ibclient.NetworkView
+	err := ibDrv.connector.GetObject(ibclient.NewNetworkView(ibclient.NetworkView{Name: netviewName}), "", &netviews)
+	if err != nil {
+		return false, err
+	}
+	if len(netviews) == 0 || !ibDrv.owned(netviews[0].Ea) || !ibDrv.settled(netviews[0].Ea) {
+		return false, nil
+	}
+
+	var networks []ibclient.Network
+	if err = getAllObjects(ibDrv.connector, ibclient.NewNetwork(ibclient.Network{NetviewName: netviewName}), &networks); err != nil {
+		return false, err
+	}
+	var containers []ibclient.NetworkContainer
+	if err = getAllObjects(ibDrv.connector, ibclient.NewNetworkContainer(ibclient.NetworkContainer{NetviewName: netviewName}), &containers); err != nil {
+		return false, err
+	}
+	if len(networks) > 0 || len(containers) > 0 {
+		return false, nil
+	}
+
+	if _, err = ibDrv.connector.DeleteObject(netviews[0].Ref); err != nil {
+		return false, err
+	}
+	ibDrv.InvalidateCache(netviewName)
+	log.Printf("Deleted unused network view '%s'", netviewName)
+	return true, nil
+}
@@ -0,0 +1,129 @@
+package ibcni
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/infobloxopen/cni-infoblox/fakewapi"
+	ibclient "github.com/infobloxopen/infoblox-go-client"
+	"net"
+	"time"
+)
+
+var _ = Describe("Cleanup", func() {
+	testView := "test-view"
+	testCidr := "192.168.10.0/24"
+	gateway := net.ParseIP("192.168.10.1")
+
+	var server *fakewapi.Server
+	var ibDriver *InfobloxDriver
+
+	BeforeEach(func() {
+		server = fakewapi.NewServer()
+		conn, err := server.NewConnector()
+		Expect(err).To(BeNil())
+		objMgr := ibclient.NewObjectManager(conn, CMP_TYPE, "test-cluster")
+		ibDriver = NewInfobloxDriver(objMgr, conn, "default", "192.168.100.0/24", 24)
+		ibDriver.Owner = "test-cluster"
+
+		_, err = ibDriver.RequestNetworkView(testView, nil)
+		Expect(err).To(BeNil())
+		_, err = ibDriver.requestSpecificNetwork(testView, testCidr, "yellow", nil, nil)
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("Should mark the network views and networks it creates as owned", func() {
+		Expect(server.Objects("networkview")[1].EA(EA_OWNER)).To(Equal("test-cluster"))
+		Expect(server.Objects("network")[0].EA(EA_OWNER)).To(Equal("test-cluster"))
+		Expect(server.Objects("network")[0].EA(EA_CREATED_AT)).NotTo(BeNil())
+	})
+
+	It("Should not let the given EAs overwrite the owner", func() {
+		_, err := ibDriver.RequestNetworkView("other-view", ibclient.EA{EA_OWNER: "other-cluster", EA_CREATED_AT: "2000-01-01T00:00:00Z"})
+		Expect(err).To(BeNil())
+		views := server.Objects("networkview")
+		Expect(views[2].EA(EA_OWNER)).To(Equal("test-cluster"))
+		Expect(views[2].EA(EA_CREATED_AT)).NotTo(Equal("2000-01-01T00:00:00Z"))
+	})
+
+	It("Should keep owned objects for the grace period after they were created", func() {
+		ibDriver.CleanupGrace = time.Hour
+		deleted, err := ibDriver.DeleteNetworkIfUnused(testView, testCidr)
+		Expect(err).To(BeNil())
+		Expect(deleted).To(BeFalse())
+
+		ibDriver.CleanupGrace = 0
+		deleted, err = ibDriver.DeleteNetworkIfUnused(testView, testCidr)
+		Expect(err).To(BeNil())
+		Expect(deleted).To(BeTrue())
+	})
+
+	It("Should delete an owned network with only the gateway left, and then its empty view", func() {
+		_, err := ibDriver.CreateGateway(testCidr, gateway, testView)
+		Expect(err).To(BeNil())
+		ip, err := ibDriver.RequestAddress(testView, testCidr, "", "11:22:33:44:55:66", "", "container-1", nil, nil)
+		Expect(err).To(BeNil())
+
+		deleted, err := ibDriver.DeleteNetworkIfUnused(testView, testCidr)
+		Expect(err).To(BeNil())
+		Expect(deleted).To(BeFalse())
+
+		_, err = ibDriver.ReleaseAddress(testView, ip, "")
+		Expect(err).To(BeNil())
+		deleted, err = ibDriver.DeleteNetworkIfUnused(testView, testCidr)
+		Expect(err).To(BeNil())
+		Expect(deleted).To(BeTrue())
+		Expect(server.Objects("network")).To(BeEmpty())
+		Expect(server.Objects("fixedaddress")).To(BeEmpty())
+
+		deleted, err = ibDriver.DeleteNetworkViewIfUnused(testView)
+		Expect(err).To(BeNil())
+		Expect(deleted).To(BeTrue())
+		Expect(server.Objects("networkview")).To(HaveLen(1))
+	})
+
+	It("Should take the network's router for its gateway", func() {
+		_, err := ibDriver.RequestAddress(testView, testCidr, "192.168.10.254", "11:22:33:44:55:66", "router", "", nil, nil)
+		Expect(err).To(BeNil())
+
+		deleted, err := ibDriver.DeleteNetworkIfUnused(testView, testCidr)
+		Expect(err).To(BeNil())
+		Expect(deleted).To(BeFalse())
+
+		network := server.Objects("network")[0]
+		Expect(server.Update(network.Ref(), fakewapi.Object{
+			"options": []interface{}{map[string]interface{}{"name": DHCP_OPTION_ROUTERS, "value": "192.168.10.254"}},
+		})).To(BeNil())
+		deleted, err = ibDriver.DeleteNetworkIfUnused(testView, testCidr)
+		Expect(err).To(BeNil())
+		Expect(deleted).To(BeTrue())
+	})
+
+	It("Should keep a view that still has networks", func() {
+		deleted, err := ibDriver.DeleteNetworkViewIfUnused(testView)
+		Expect(err).To(BeNil())
+		Expect(deleted).To(BeFalse())
+		Expect(server.Objects("networkview")).To(HaveLen(2))
+	})
+
+	It("Should keep the network views and networks of other owners", func() {
+		_, err := server.AddNetworkView("other-view", map[string]interface{}{EA_OWNER: "other-cluster"})
+		Expect(err).To(BeNil())
+		_, err = server.AddNetwork("other-view", testCidr, nil)
+		Expect(err).To(BeNil())
+
+		deleted, err := ibDriver.DeleteNetworkIfUnused("other-view", testCidr)
+		Expect(err).To(BeNil())
+		Expect(deleted).To(BeFalse())
+
+		server.Delete(server.Objects("network")[1].Ref())
+		deleted, err = ibDriver.DeleteNetworkViewIfUnused("other-view")
+		Expect(err).To(BeNil())
+		Expect(deleted).To(BeFalse())
+		Expect(server.Objects("networkview")).To(HaveLen(3))
+	})
+})
@@ -16,7 +16,28 @@
 package ibcni
 
 import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+
 	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	ibclient "github.com/infobloxopen/infoblox-go-client"
+)
+
+// Extensible attributes set on fixed addresses from the pod identity
+const (
+	EA_POD_NAMESPACE      = "K8S Pod Namespace"
+	EA_POD_NAME           = "K8S Pod Name"
+	EA_POD_UID            = "K8S Pod UID"
+	EA_INFRA_CONTAINER_ID = "K8S Infra Container ID"
+
+	// Keys of CNI_ARGS not known to K8sArgs, as "key=value" pairs
+	// separated by semicolons.
+	EA_CNI_ARGS = "CNI Args"
 )
 
 // Extend skel.CmdArgs to include IfMac
@@ -25,3 +46,128 @@ type ExtCmdArgs struct {
 	skel.CmdArgs
 	IfMac string
 }
+
+// PodArgs returns the parsed CNI_ARGS of the request.
+func (a *ExtCmdArgs) PodArgs() (*PodArgs, error) {
+	return LoadPodArgs(a.Args)
+}
+
+// Attachment returns the AttachmentID of the request, from the name of the
+// network in the netconf and the interface name.
+func (a *ExtCmdArgs) Attachment() (string, error) {
+	conf := types.NetConf{}
+	if err := json.Unmarshal(a.StdinData, &conf); err != nil {
+		return "", fmt.Errorf("error parsing netconf: %v", err)
+	}
+	return AttachmentID(conf.Name, a.IfName), nil
+}
+
+// K8sArgs are the well-known CNI_ARGS keys set by the kubelet and by
+// runtimes that request a specific address.
+type K8sArgs struct {
+	types.CommonArgs
+	IP                         net.IP
+	MAC                        types.UnmarshallableString
+	K8S_POD_NAMESPACE          types.UnmarshallableString
+	K8S_POD_NAME               types.UnmarshallableString
+	K8S_POD_INFRA_CONTAINER_ID types.UnmarshallableString
+	K8S_POD_UID                types.UnmarshallableString
+}
+
+// PodArgs holds the typed CNI_ARGS along with any keys that are not known
+// to K8sArgs.
+type PodArgs struct {
+	K8sArgs
+	Custom map[string]string
+}
+
+// LoadPodArgs parses a CNI_ARGS string such as
+// "IgnoreUnknown=1;K8S_POD_NAMESPACE=default;K8S_POD_NAME=nginx".
+func LoadPodArgs(args string) (*PodArgs, error) {
+	podArgs := &PodArgs{Custom: map[string]string{}}
+	if args == "" {
+		return podArgs, nil
+	}
+
+	known := reflect.ValueOf(&podArgs.K8sArgs).Elem()
+	var pairs []string
+	for _, pair := range strings.Split(args, ";") {
+		if pair == "" {
+			continue
+		}
+		pairs = append(pairs, pair)
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("ARGS: invalid pair %q", pair)
+		}
+		if !known.FieldByName(kv[0]).IsValid() {
+			podArgs.Custom[kv[0]] = kv[1]
+		}
+	}
+
+	// Unknown keys have been collected above, so they must not fail the
+	// typed parsing.
+	pairs = append([]string{"IgnoreUnknown=1"}, pairs...)
+	if err := types.LoadArgs(strings.Join(pairs, ";"), &podArgs.K8sArgs); err != nil {
+		return nil, err
+	}
+	if podArgs.MAC != "" {
+		if _, err := net.ParseMAC(string(podArgs.MAC)); err != nil {
+			return nil, fmt.Errorf("ARGS: invalid MAC %q: %v", podArgs.MAC, err)
+		}
+	}
+	return podArgs, nil
+}
+
+func (p *PodArgs) Namespace() string {
+	return string(p.K8S_POD_NAMESPACE)
+}
+
+func (p *PodArgs) PodName() string {
+	return string(p.K8S_POD_NAME)
+}
+
+func (p *PodArgs) InfraContainerID() string {
+	return string(p.K8S_POD_INFRA_CONTAINER_ID)
+}
+
+func (p *PodArgs) PodUID() string {
+	return string(p.K8S_POD_UID)
+}
+
+// MacAddr returns the MAC address requested through CNI_ARGS, or ifMac if
+// none was.
+func (p *PodArgs) MacAddr(ifMac string) string {
+	if p.MAC != "" {
+		return string(p.MAC)
+	}
+	return ifMac
+}
+
+// CustomArgs returns the custom keys of CNI_ARGS as the value of the
+// EA_CNI_ARGS extensible attribute, sorted by key.
+func (p *PodArgs) CustomArgs() string {
+	pairs := make([]string, 0, len(p.Custom))
+	for k, v := range p.Custom {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ";")
+}
+
+// ExtAttrs returns the pod identity as extensible attributes to be set on
+// the objects allocated for the pod.
+func (p *PodArgs) ExtAttrs() ibclient.EA {
+	ea := ibclient.EA{}
+	for name, value := range map[string]string{
+		EA_POD_NAMESPACE:      p.Namespace(),
+		EA_POD_NAME:           p.PodName(),
+		EA_POD_UID:            p.PodUID(),
+		EA_INFRA_CONTAINER_ID: p.InfraContainerID(),
+	} {
+		if value != "" {
+			ea[name] = value
+		}
+	}
+	return ea
+}
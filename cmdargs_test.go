@@ -0,0 +1,104 @@
+package ibcni
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	ibclient "github.com/infobloxopen/infoblox-go-client"
+	"net"
+)
+
+var _ = Describe("LoadPodArgs", func() {
+	Context("When called with kubelet CNI_ARGS", func() {
+		args := "IgnoreUnknown=1;K8S_POD_NAMESPACE=default;K8S_POD_NAME=nginx-8478849b97-p2jhp;" +
+			"K8S_POD_INFRA_CONTAINER_ID=85f177f2f198;K8S_POD_UID=0c8e7c4a-2f5b-4d4e-9e8b-1d2c3b4a5f6e"
+
+		var podArgs *PodArgs
+		var err error
+		It("Should parse without error", func() {
+			podArgs, err = LoadPodArgs(args)
+			Expect(err).To(BeNil())
+		})
+		It("Should expose the pod identity", func() {
+			Expect(podArgs.Namespace()).To(Equal("default"))
+			Expect(podArgs.PodName()).To(Equal("nginx-8478849b97-p2jhp"))
+			Expect(podArgs.InfraContainerID()).To(Equal("85f177f2f198"))
+			Expect(podArgs.PodUID()).To(Equal("0c8e7c4a-2f5b-4d4e-9e8b-1d2c3b4a5f6e"))
+			Expect(podArgs.Custom).To(BeEmpty())
+		})
+		It("Should return the pod identity as extensible attributes", func() {
+			Expect(podArgs.ExtAttrs()).To(Equal(ibclient.EA{
+				EA_POD_NAMESPACE:      "default",
+				EA_POD_NAME:           "nginx-8478849b97-p2jhp",
+				EA_INFRA_CONTAINER_ID: "85f177f2f198",
+				EA_POD_UID:            "0c8e7c4a-2f5b-4d4e-9e8b-1d2c3b4a5f6e",
+			}))
+		})
+	})
+
+	Context("When called with IP, MAC and custom keys", func() {
+		args := "IP=10.0.0.5;MAC=66:c2:1c:94:6e:e5;TENANT=blue"
+
+		It("Should parse typed and custom keys", func() {
+			podArgs, err := LoadPodArgs(args)
+			Expect(err).To(BeNil())
+			Expect(podArgs.IP.Equal(net.ParseIP("10.0.0.5"))).To(BeTrue())
+			Expect(string(podArgs.MAC)).To(Equal("66:c2:1c:94:6e:e5"))
+			Expect(podArgs.Custom).To(Equal(map[string]string{"TENANT": "blue"}))
+			Expect(podArgs.ExtAttrs()).To(BeEmpty())
+		})
+	})
+
+	Context("When called with a trailing semicolon", func() {
+		It("Should skip the empty pair", func() {
+			podArgs, err := LoadPodArgs("K8S_POD_NAME=nginx;TENANT=blue;")
+			Expect(err).To(BeNil())
+			Expect(podArgs.PodName()).To(Equal("nginx"))
+			Expect(podArgs.CustomArgs()).To(Equal("TENANT=blue"))
+		})
+	})
+
+	Context("When called with a MAC key", func() {
+		It("Should prefer it over the interface's MAC address", func() {
+			podArgs, err := LoadPodArgs("MAC=66:c2:1c:94:6e:e5")
+			Expect(err).To(BeNil())
+			Expect(podArgs.MacAddr("11:22:33:44:55:66")).To(Equal("66:c2:1c:94:6e:e5"))
+
+			podArgs, err = LoadPodArgs("")
+			Expect(err).To(BeNil())
+			Expect(podArgs.MacAddr("11:22:33:44:55:66")).To(Equal("11:22:33:44:55:66"))
+		})
+		It("Should return an error if it is not a MAC address", func() {
+			_, err := LoadPodArgs("MAC=nope")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Context("When called with an invalid pair", func() {
+		It("Should return an error", func() {
+			_, err := LoadPodArgs("K8S_POD_NAME")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Context("When called with empty CNI_ARGS", func() {
+		It("Should return empty args", func() {
+			podArgs, err := LoadPodArgs("")
+			Expect(err).To(BeNil())
+			Expect(podArgs.PodName()).To(Equal(""))
+		})
+	})
+})
+
+var _ = Describe("ExtCmdArgs", func() {
+	It("Should identify the attachment by network name and interface", func() {
+		args := &ExtCmdArgs{}
+		args.IfName = "net1"
+		args.StdinData = []byte(`{"name": "green", "ipam": {"type": "infoblox"}}`)
+
+		attachment, err := args.Attachment()
+		Expect(err).To(BeNil())
+		Expect(attachment).To(Equal(AttachmentID("green", "net1")))
+		Expect(attachment).To(Equal("green/net1"))
+	})
+})
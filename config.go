@@ -16,9 +16,13 @@
 package ibcni
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
+	"strings"
 
 	"github.com/containernetworking/cni/pkg/types"
 )
@@ -26,6 +30,31 @@ import (
 const (
 	HTTP_REQUEST_TIMEOUT  = 60
 	HTTP_POOL_CONNECTIONS = 10
+	DEFAULT_CACHE_TTL     = 300
+
+	DEFAULT_WAPI_RATE_LIMIT   = 20
+	DEFAULT_WAPI_RATE_BURST   = 40
+	DEFAULT_BREAKER_THRESHOLD = 5
+	DEFAULT_BREAKER_COOLDOWN  = 30
+
+	DEFAULT_UTILIZATION_INTERVAL = 60
+	DEFAULT_UTILIZATION_WARNING  = 80
+	DEFAULT_UTILIZATION_CRITICAL = 95
+
+	DEFAULT_CONTROLLER_RESYNC = 600
+
+	DEFAULT_STICKY_SWEEP_INTERVAL = 300
+
+	DEFAULT_CLEANUP_GRACE_PERIOD = 300
+
+	DEFAULT_CLUSTER_NAME = "cluster-1"
+)
+
+// Cleanup policies for the network views and networks the daemon created
+const (
+	CLEANUP_KEEP               = "keep"
+	CLEANUP_NETWORKS           = "networks"
+	CLEANUP_NETWORKS_AND_VIEWS = "networks-and-views"
 )
 
 type GridConfig struct {
@@ -38,6 +67,10 @@ type GridConfig struct {
 	HttpRequestTimeout  int
 	HttpPoolConnections int
 	HttpPoolMaxSize     int
+	WapiRateLimit       float64
+	WapiRateBurst       int
+	BreakerThreshold    int
+	BreakerCooldown     int
 }
 
 type DriverConfig struct {
@@ -47,6 +80,37 @@ type DriverConfig struct {
 	NetworkContainer string
 	PrefixLength     uint
 	ClusterName      string
+	NodeName         string
+
+	WarmPoolSize           int
+	WarmPoolRefillInterval int
+
+	CacheDisabled bool
+	CacheTTL      int
+
+	NamespaceMappingFile string
+	NamespaceAnnotations bool
+
+	PodLabelEAs           string
+	PodAnnotationEAs      string
+	PodNetworkAnnotations bool
+
+	KubeEvents bool
+	AuditLog   string
+
+	UtilizationInterval int
+	UtilizationWarning  float64
+	UtilizationCritical float64
+	HighWaterMark       float64
+
+	StickyIPHoldTime      int
+	StickyIPSweepInterval int
+	CleanupPolicy         string
+	CleanupGracePeriod    int
+
+	Controller             bool
+	ControllerNetworkViews string
+	ControllerResync       int
 }
 
 type Config struct {
@@ -62,7 +126,8 @@ func LoadConfig() (config *Config) {
 	flag.StringVar(&config.WapiPort, "wapi-port", "443", "Infoblox WAPI Port.")
 	flag.StringVar(&config.WapiUsername, "wapi-username", "", "Infoblox WAPI Username")
 	config.WapiPassword = os.Getenv("WAPI_PASSWORD")
-	flag.StringVar(&config.ClusterName, "cluster-name", "cluster-1", "Cluster Name")
+	flag.StringVar(&config.ClusterName, "cluster-name", DEFAULT_CLUSTER_NAME, "Cluster Name")
+	flag.StringVar(&config.NodeName, "node-name", GetDefaultNodeName(), "Name of the node the daemon runs on")
 	flag.StringVar(&config.SslVerify, "ssl-verify", "false", "Specifies whether (true/false) to verify server certificate. If a file path is specified, it is assumed to be a certificate file and will be used to verify server certificate.")
 	flag.StringVar(&config.NetworkView, "network-view", "default", "Infoblox Network View")
 	flag.StringVar(&config.NetworkContainer, "network", "172.18.0.0/16", "Subnet used for pods ip allocation if subnet is not specified in network config file")
@@ -70,15 +135,51 @@ func LoadConfig() (config *Config) {
 	//flag.UintVar(&config.PrefixLength, "prefix-length", 24, "The CIDR prefix length when allocating a subnet from Network Container")
 	config.HttpRequestTimeout = HTTP_REQUEST_TIMEOUT
 	config.HttpPoolConnections = HTTP_POOL_CONNECTIONS
+	flag.Float64Var(&config.WapiRateLimit, "wapi-rate-limit", DEFAULT_WAPI_RATE_LIMIT, "Maximum number of WAPI calls per second (0 disables rate limiting)")
+	flag.IntVar(&config.WapiRateBurst, "wapi-rate-burst", DEFAULT_WAPI_RATE_BURST, "Number of WAPI calls allowed in a burst above the rate limit")
+	flag.IntVar(&config.BreakerThreshold, "breaker-threshold", DEFAULT_BREAKER_THRESHOLD, "Number of consecutive failures to reach the grid after which WAPI calls fail fast (0 disables the circuit breaker)")
+	flag.IntVar(&config.BreakerCooldown, "breaker-cooldown", DEFAULT_BREAKER_COOLDOWN, "Time in seconds WAPI calls fail fast before the grid is tried again")
 
 	flag.StringVar(&config.SocketDir, "socket-dir", GetDefaultSocketDir(), "Directory where Infoblox IPAM daemon sockets are created")
 	flag.StringVar(&config.DriverName, "driver-name", "infoblox", "Name of Infoblox IPAM driver")
+	flag.IntVar(&config.WarmPoolSize, "warm-pool-size", 0, "Number of fixed addresses kept reserved per subnet on this node for fast allocation (0 disables the warm pool)")
+	flag.IntVar(&config.WarmPoolRefillInterval, "warm-pool-refill-interval", 30, "Interval in seconds at which the warm pool is topped up")
+	flag.StringVar(&config.NamespaceMappingFile, "namespace-mapping", "", "JSON file mapping Kubernetes namespaces to Infoblox network views and subnets")
+	flag.BoolVar(&config.NamespaceAnnotations, "namespace-annotations", false, "Read network view and subnet for a namespace from its infoblox.com/* annotations")
+	flag.StringVar(&config.PodLabelEAs, "pod-label-eas", "", "Comma separated list of label=EA Name pairs; the listed pod labels are copied to the EAs of the pod's fixed address")
+	flag.StringVar(&config.PodAnnotationEAs, "pod-annotation-eas", "", "Comma separated list of annotation=EA Name pairs; the listed pod annotations are copied to the EAs of the pod's fixed address")
+	flag.BoolVar(&config.PodNetworkAnnotations, "pod-network-annotations", false, "Let pods request a network view, subnet or named network through their infoblox.com/* annotations")
+	flag.StringVar(&config.AuditLog, "audit-log", "", "File every allocate and release is appended to as a JSON line, for audits (default disabled)")
+	flag.BoolVar(&config.KubeEvents, "kube-events", false, "Report network utilization alerts and failed allocations as Kubernetes Events on the node and the pod")
+	flag.IntVar(&config.UtilizationInterval, "utilization-interval", DEFAULT_UTILIZATION_INTERVAL, "Interval in seconds at which the utilization of the networks in use is checked (0 disables the check)")
+	flag.Float64Var(&config.UtilizationWarning, "utilization-warning", DEFAULT_UTILIZATION_WARNING, "Network utilization in percent above which a warning is raised")
+	flag.Float64Var(&config.UtilizationCritical, "utilization-critical", DEFAULT_UTILIZATION_CRITICAL, "Network utilization in percent above which a critical alert is raised")
+	flag.Float64Var(&config.HighWaterMark, "high-water-mark", 0, "Network utilization in percent above which allocations for low priority pods are refused (0 disables)")
+	flag.IntVar(&config.StickyIPHoldTime, "sticky-ip-hold-time", 0, "Time in seconds the address of a deleted StatefulSet pod is kept reserved for the pod recreated under the same name (0 disables sticky IPs)")
+	flag.IntVar(&config.StickyIPSweepInterval, "sticky-ip-sweep-interval", DEFAULT_STICKY_SWEEP_INTERVAL, "Interval in seconds at which the sticky addresses held for pods of the node are released once their hold time has passed")
+	flag.StringVar(&config.CleanupPolicy, "cleanup-policy", CLEANUP_KEEP, "What the daemon deletes of the network views and networks it created once they are empty: keep, networks or networks-and-views")
+	flag.IntVar(&config.CleanupGracePeriod, "cleanup-grace-period", DEFAULT_CLEANUP_GRACE_PERIOD, "Time in seconds a network view or network the daemon created is kept at least, even if it is empty")
+	flag.BoolVar(&config.Controller, "controller", false, "Run as the cluster wide controller releasing the addresses of deleted pods and nodes, instead of serving the plugin on the node")
+	flag.StringVar(&config.ControllerNetworkViews, "controller-network-views", "", "Comma separated list of network views the controller releases addresses in (default the --network-view)")
+	flag.IntVar(&config.ControllerResync, "controller-resync", DEFAULT_CONTROLLER_RESYNC, "Interval in seconds at which the controller releases the addresses of pods and nodes that no longer exist")
+	flag.BoolVar(&config.CacheDisabled, "disable-cache", false, "Disable caching of network view and network lookups")
+	flag.IntVar(&config.CacheTTL, "cache-ttl", DEFAULT_CACHE_TTL, "Time in seconds network views, networks and their DHCP options are cached for")
 
 	flag.Parse()
 
 	return config
 }
 
+// GetDefaultNodeName returns the node name from the NODE_NAME environment
+// variable, falling back to the host name.
+func GetDefaultNodeName() string {
+	if name := os.Getenv("NODE_NAME"); name != "" {
+		return name
+	}
+	name, _ := os.Hostname()
+	return name
+}
+
 type IPAMConfig struct {
 	Type             string        `json:"type"`
 	SocketDir        string        `json:"socket-dir"`
@@ -88,6 +189,97 @@ type IPAMConfig struct {
 	Subnet           types.IPNet   `json:"subnet"`
 	Gateway          net.IP        `json:"gateway"`
 	Routes           []types.Route `json:"routes"`
+
+	// Restrict allocation to a named Infoblox DHCP range and/or an explicit
+	// start and end address, skipping the exclusion ranges and a number of
+	// reserved addresses at the start and end of the subnet.
+	Range         string   `json:"range"`
+	RangeStart    net.IP   `json:"range-start"`
+	RangeEnd      net.IP   `json:"range-end"`
+	Exclude       []string `json:"exclude"`
+	ReservedStart uint     `json:"reserved-start"`
+	ReservedEnd   uint     `json:"reserved-end"`
+
+	// Where to allocate from once the subnet is exhausted.
+	Overflow *OverflowConfig `json:"overflow"`
+
+	// Take the gateway, default route and DNS settings the netconf leaves
+	// out from the DHCP options of the Infoblox network.
+	UseDHCPOptions bool `json:"use-dhcp-options"`
+
+	// How the networks the daemon creates for the netconf are set up.
+	NetworkSettings *NetworkSettings `json:"network-settings"`
+
+	EATags   []string          `json:"ea-tags"`
+	ExtAttrs map[string]string `json:"extattrs"`
+
+	// Network views, subnets and named networks pods may request through
+	// their annotations. Requested subnets must lie within one of the
+	// allowed subnets.
+	AllowedNetworkViews []string `json:"allowed-network-views"`
+	AllowedSubnets      []string `json:"allowed-subnets"`
+	AllowedNetworks     []string `json:"allowed-networks"`
+}
+
+// OverflowConfig is the overflow policy of a network: once its subnet is
+// exhausted, addresses are allocated from the secondary subnets in order and
+// then from networks carved out of the network container.
+type OverflowConfig struct {
+	Subnets          []OverflowSubnet `json:"subnets"`
+	NetworkContainer string           `json:"network-container"`
+	PrefixLength     uint             `json:"prefix-length"`
+}
+
+// NetworkSettings make the networks the daemon creates look like the ones
+// created by hand: they are created from the named Infoblox network template,
+// with the comment, the extensible attributes and DHCP options for the
+// gateway of the netconf and the DNS servers.
+type NetworkSettings struct {
+	Template   string            `json:"template"`
+	Comment    string            `json:"comment"`
+	DNSServers []string          `json:"dns-servers"`
+	DomainName string            `json:"domain-name"`
+	ExtAttrs   map[string]string `json:"extattrs"`
+}
+
+type OverflowSubnet struct {
+	Subnet  types.IPNet `json:"subnet"`
+	Gateway net.IP      `json:"gateway"`
+}
+
+// AllowsNetworkView tells whether pods may request the network view.
+func (ipam *IPAMConfig) AllowsNetworkView(name string) bool {
+	return contains(ipam.AllowedNetworkViews, name)
+}
+
+// AllowsNetwork tells whether pods may request the named network.
+func (ipam *IPAMConfig) AllowsNetwork(name string) bool {
+	return contains(ipam.AllowedNetworks, name)
+}
+
+// AllowsSubnet tells whether pods may request the subnet.
+func (ipam *IPAMConfig) AllowsSubnet(subnet *net.IPNet) bool {
+	ones, _ := subnet.Mask.Size()
+	for _, allowed := range ipam.AllowedSubnets {
+		_, allowedNet, err := net.ParseCIDR(allowed)
+		if err != nil {
+			continue
+		}
+		allowedOnes, _ := allowedNet.Mask.Size()
+		if allowedNet.Contains(subnet.IP) && allowedOnes <= ones {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
 }
 
 type NetConfig struct {
@@ -97,3 +289,58 @@ type NetConfig struct {
 	IsGateway bool        `json:"isGateway"`
 	IPAM      *IPAMConfig `json:"ipam"`
 }
+
+// ParseEAMapping parses a comma separated list of key=EA Name pairs.
+func ParseEAMapping(s string) (map[string]string, error) {
+	mapping := map[string]string{}
+	if strings.TrimSpace(s) == "" {
+		return mapping, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			return nil, fmt.Errorf("invalid EA mapping %q, expected key=EA Name", pair)
+		}
+		mapping[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return mapping, nil
+}
+
+// NamespaceNetwork is the Infoblox network a Kubernetes namespace is mapped
+// to. Empty fields fall back to the network configuration file.
+type NamespaceNetwork struct {
+	NetworkView string `json:"network-view"`
+	Subnet      string `json:"subnet"`
+	Gateway     string `json:"gateway"`
+}
+
+// NamespaceMapping maps Kubernetes namespace names to Infoblox networks.
+type NamespaceMapping map[string]NamespaceNetwork
+
+func LoadNamespaceMapping(path string) (NamespaceMapping, error) {
+	mapping := NamespaceMapping{}
+	if path == "" {
+		return mapping, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading namespace mapping: %v", err)
+	}
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		return nil, fmt.Errorf("error parsing namespace mapping: %v", err)
+	}
+	for ns, nsNet := range mapping {
+		if nsNet.Subnet != "" {
+			if _, _, err := net.ParseCIDR(nsNet.Subnet); err != nil {
+				return nil, fmt.Errorf("invalid subnet '%s' for namespace '%s': %v", nsNet.Subnet, ns, err)
+			}
+		}
+		if nsNet.Gateway != "" && net.ParseIP(nsNet.Gateway) == nil {
+			return nil, fmt.Errorf("invalid gateway '%s' for namespace '%s'", nsNet.Gateway, ns)
+		}
+	}
+
+	return mapping, nil
+}
@@ -5,8 +5,9 @@ import (
 	. "github.com/onsi/gomega"
 
 	"fmt"
+	"io/ioutil"
+	"net"
 	"os"
-	"strconv"
 	"strings"
 )
 
@@ -24,14 +25,14 @@ var _ = Describe("LoadConfig", func() {
 			SslVerify        = "false"
 			NetworkView      = "default"
 			NetworkContainer = "192.168.0.0/24,192.169.0.0/24"
-			PrefixLength     = "25"
 		)
 
-		cmdLine := fmt.Sprintf("infoblox-cni-daemon --grid-host=%s --wapi-port=%s --wapi-username=%s --wapi-password=%s --wapi-version=%s --socket-dir=%s --driver-name=%s --ssl-verify=%s --network-view=%s --network-container=%s --prefix-length=%s",
-			GridHost, WapiPort, WapiUsername, WapiPassword, WapiVersion,
-			SocketDir, DriverName, SslVerify, NetworkView, NetworkContainer, PrefixLength)
+		cmdLine := fmt.Sprintf("infoblox-cni-daemon --grid-host=%s --wapi-port=%s --wapi-username=%s --wapi-version=%s --socket-dir=%s --driver-name=%s --ssl-verify=%s --network-view=%s --network=%s",
+			GridHost, WapiPort, WapiUsername, WapiVersion,
+			SocketDir, DriverName, SslVerify, NetworkView, NetworkContainer)
 
 		os.Args = strings.Split(cmdLine, " ")
+		os.Setenv("WAPI_PASSWORD", WapiPassword)
 
 		config := LoadConfig()
 
@@ -45,7 +46,101 @@ var _ = Describe("LoadConfig", func() {
 		Expect(config.SslVerify).To(Equal(SslVerify))
 		Expect(config.NetworkView).To(Equal(NetworkView))
 		Expect(config.NetworkContainer).To(Equal(NetworkContainer))
-		prefixLen, _ := strconv.ParseUint(PrefixLength, 10, 64)
-		Expect(config.PrefixLength).To(Equal(uint(prefixLen)))
+	})
+})
+
+var _ = Describe("LoadNamespaceMapping", func() {
+	writeMapping := func(data string) string {
+		f, err := ioutil.TempFile("", "namespace-mapping")
+		Expect(err).To(BeNil())
+		defer f.Close()
+		_, err = f.WriteString(data)
+		Expect(err).To(BeNil())
+		return f.Name()
+	}
+
+	It("Should map namespaces to network views and subnets", func() {
+		path := writeMapping(`{
+    "tenant-a": {"network-view": "tenant_a"},
+    "web": {"network-view": "tenant_b", "subnet": "10.20.0.0/24", "gateway": "10.20.0.1"}
+}`)
+		defer os.Remove(path)
+
+		mapping, err := LoadNamespaceMapping(path)
+		Expect(err).To(BeNil())
+		Expect(mapping).To(Equal(NamespaceMapping{
+			"tenant-a": {NetworkView: "tenant_a"},
+			"web":      {NetworkView: "tenant_b", Subnet: "10.20.0.0/24", Gateway: "10.20.0.1"},
+		}))
+	})
+
+	It("Should return an empty mapping without a file", func() {
+		mapping, err := LoadNamespaceMapping("")
+		Expect(err).To(BeNil())
+		Expect(mapping).To(BeEmpty())
+	})
+
+	It("Should reject invalid subnets and gateways", func() {
+		path := writeMapping(`{"web": {"subnet": "10.20.0.0"}}`)
+		defer os.Remove(path)
+		_, err := LoadNamespaceMapping(path)
+		Expect(err).NotTo(BeNil())
+
+		path = writeMapping(`{"web": {"subnet": "10.20.0.0/24", "gateway": "gw"}}`)
+		defer os.Remove(path)
+		_, err = LoadNamespaceMapping(path)
+		Expect(err).NotTo(BeNil())
+	})
+})
+
+var _ = Describe("ParseEAMapping", func() {
+
+	It("Should parse key=EA Name pairs", func() {
+		mapping, err := ParseEAMapping("app=K8S App, tier = K8S Tier")
+		Expect(err).To(BeNil())
+		Expect(mapping).To(Equal(map[string]string{"app": "K8S App", "tier": "K8S Tier"}))
+	})
+
+	It("Should return an empty mapping for an empty string", func() {
+		mapping, err := ParseEAMapping("")
+		Expect(err).To(BeNil())
+		Expect(mapping).To(BeEmpty())
+	})
+
+	It("Should reject pairs without an EA name", func() {
+		_, err := ParseEAMapping("app=K8S App,tier")
+		Expect(err).NotTo(BeNil())
+	})
+})
+
+var _ = Describe("IPAMConfig allowlists", func() {
+	ipam := &IPAMConfig{
+		AllowedNetworkViews: []string{"tenant_a"},
+		AllowedSubnets:      []string{"10.10.0.0/16"},
+		AllowedNetworks:     []string{"frontend"},
+	}
+
+	It("Should allow listed network views and networks only", func() {
+		Expect(ipam.AllowsNetworkView("tenant_a")).To(BeTrue())
+		Expect(ipam.AllowsNetworkView("default")).To(BeFalse())
+		Expect(ipam.AllowsNetwork("frontend")).To(BeTrue())
+		Expect(ipam.AllowsNetwork("backend")).To(BeFalse())
+	})
+
+	It("Should allow subnets within an allowed subnet", func() {
+		_, inside, _ := net.ParseCIDR("10.10.5.0/24")
+		_, wider, _ := net.ParseCIDR("10.0.0.0/8")
+		_, outside, _ := net.ParseCIDR("10.11.0.0/24")
+		Expect(ipam.AllowsSubnet(inside)).To(BeTrue())
+		Expect(ipam.AllowsSubnet(wider)).To(BeFalse())
+		Expect(ipam.AllowsSubnet(outside)).To(BeFalse())
+	})
+
+	It("Should allow nothing by default", func() {
+		_, subnet, _ := net.ParseCIDR("10.10.5.0/24")
+		empty := &IPAMConfig{}
+		Expect(empty.AllowsNetworkView("tenant_a")).To(BeFalse())
+		Expect(empty.AllowsSubnet(subnet)).To(BeFalse())
+		Expect(empty.AllowsNetwork("frontend")).To(BeFalse())
 	})
 })
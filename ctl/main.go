@@ -0,0 +1,333 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// infoblox-cni-ctl inspects and repairs the allocations of the Infoblox CNI
+// daemon. It talks to the daemon over its socket, so it has to run on a node
+// where the daemon runs, and the daemon talks to the grid.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	. "github.com/infobloxopen/cni-infoblox"
+)
+
+const usage = `Usage: infoblox-cni-ctl [options] <command> [command options]
+
+Commands:
+  list          list allocations, optionally by node, namespace or container
+  show          show the addresses of a container
+  release       force-release a leaked address
+  utilization   show network utilization
+  export        export allocations as CSV or JSON for audits
+  check         verify plugin, daemon and grid connectivity
+  migrate-host-local
+                create fixed addresses for the addresses allocated by host-local
+
+Options:
+`
+
+var (
+	socketDir  = flag.String("socket-dir", GetDefaultSocketDir(), "Directory where Infoblox IPAM daemon sockets are created")
+	driverName = flag.String("driver-name", "infoblox", "Name of Infoblox IPAM driver")
+	jsonOutput = flag.Bool("json", false, "Print results as JSON")
+)
+
+func socketFile() string {
+	return NewDriverSocket(*socketDir, *driverName).GetSocketFile()
+}
+
+func dial() (*rpc.Client, error) {
+	client, err := rpc.DialHTTP("unix", socketFile())
+	if err != nil {
+		return nil, fmt.Errorf("error dialing Infoblox daemon: %v", err)
+	}
+	return client, nil
+}
+
+func call(method string, args interface{}, reply interface{}) error {
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err = client.Call(method, args, reply); err != nil {
+		return fmt.Errorf("error calling %v: %v", method, err)
+	}
+	return nil
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func printAllocations(allocations []Allocation) error {
+	if *jsonOutput {
+		return printJSON(allocations)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "IP ADDRESS\tMAC\tNETWORK VIEW\tNETWORK\tNODE\tNAMESPACE\tPOD\tCONTAINER\tATTACHMENT")
+	for _, a := range allocations {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			a.IPAddress, a.Mac, a.NetworkView, a.Cidr, a.NodeName, a.Namespace, a.PodName, a.ContainerID, a.Attachment)
+	}
+	return w.Flush()
+}
+
+func cmdList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	filter := AllocationFilter{}
+	fs.StringVar(&filter.NetworkView, "network-view", "", "Network view to list allocations of (default: the daemon's network view)")
+	fs.StringVar(&filter.NodeName, "node", "", "Only list allocations of this node")
+	fs.StringVar(&filter.Namespace, "namespace", "", "Only list allocations of this namespace")
+	fs.StringVar(&filter.ContainerID, "container", "", "Only list allocations of this container")
+	fs.Parse(args)
+
+	var allocations []Allocation
+	if err := call("Infoblox.ListAllocations", filter, &allocations); err != nil {
+		return err
+	}
+	return printAllocations(allocations)
+}
+
+func cmdExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	filter := AllocationFilter{}
+	fs.StringVar(&filter.NetworkView, "network-view", "", "Network view to export allocations of (default: the daemon's network view)")
+	fs.StringVar(&filter.NodeName, "node", "", "Only export allocations of this node")
+	fs.StringVar(&filter.Namespace, "namespace", "", "Only export allocations of this namespace")
+	format := fs.String("format", ExportCSV, "Export format, csv or json")
+	output := fs.String("output", "", "File to write the export to (default: standard output)")
+	fs.Parse(args)
+
+	var allocations []Allocation
+	if err := call("Infoblox.ListAllocations", filter, &allocations); err != nil {
+		return err
+	}
+
+	if *output == "" {
+		return ExportAllocations(os.Stdout, *format, allocations)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err = ExportAllocations(f, *format, allocations); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func cmdShow(args []string) error {
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	filter := AllocationFilter{}
+	fs.StringVar(&filter.NetworkView, "network-view", "", "Network view of the container's addresses (default: the daemon's network view)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: infoblox-cni-ctl show [--network-view view] <container-id>")
+	}
+	filter.ContainerID = fs.Arg(0)
+
+	var allocations []Allocation
+	if err := call("Infoblox.ListAllocations", filter, &allocations); err != nil {
+		return err
+	}
+	if len(allocations) == 0 {
+		return fmt.Errorf("no addresses allocated for container '%s'", filter.ContainerID)
+	}
+	return printAllocations(allocations)
+}
+
+func cmdRelease(args []string) error {
+	fs := flag.NewFlagSet("release", flag.ExitOnError)
+	releaseArgs := ReleaseArgs{}
+	fs.StringVar(&releaseArgs.NetworkView, "network-view", "", "Network view of the address (default: the daemon's network view)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: infoblox-cni-ctl release [--network-view view] <ip-address>")
+	}
+	releaseArgs.IPAddress = fs.Arg(0)
+
+	var ref string
+	if err := call("Infoblox.ForceRelease", releaseArgs, &ref); err != nil {
+		return err
+	}
+	fmt.Printf("Released '%s' (%s)\n", releaseArgs.IPAddress, ref)
+	return nil
+}
+
+func cmdUtilization(args []string) error {
+	fs := flag.NewFlagSet("utilization", flag.ExitOnError)
+	utilizationArgs := UtilizationArgs{}
+	fs.StringVar(&utilizationArgs.NetworkView, "network-view", "", "Network view of the network")
+	fs.StringVar(&utilizationArgs.Cidr, "cidr", "", "Network to show the utilization of (default: all networks in use on the node)")
+	fs.Parse(args)
+
+	var networks []NetworkUtilization
+	if err := call("Infoblox.Utilization", utilizationArgs, &networks); err != nil {
+		return err
+	}
+	if *jsonOutput {
+		return printJSON(networks)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NETWORK VIEW\tNETWORK\tUTILIZATION\tLEVEL")
+	for _, n := range networks {
+		fmt.Fprintf(w, "%s\t%s\t%.1f%%\t%s\n", n.NetworkView, n.Cidr, n.Utilization, n.Level)
+	}
+	return w.Flush()
+}
+
+func cmdCheck(args []string) error {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	binDir := fs.String("cni-bin-dir", "/opt/cni/bin", "Directory the CNI plugins are installed in")
+	pluginName := fs.String("plugin", "infoblox", "Name of the Infoblox IPAM plugin binary")
+	fs.Parse(args)
+
+	failed := false
+	report := func(what string, err error) {
+		if err != nil {
+			failed = true
+			fmt.Printf("%-8s FAIL  %v\n", what, err)
+		} else {
+			fmt.Printf("%-8s OK\n", what)
+		}
+	}
+
+	// Plugin: the binary the container runtime executes.
+	pluginPath := filepath.Join(*binDir, *pluginName)
+	info, err := os.Stat(pluginPath)
+	if err == nil && info.Mode()&0111 == 0 {
+		err = fmt.Errorf("'%s' is not executable", pluginPath)
+	}
+	report("plugin", err)
+
+	// Daemon: the socket the plugin dials.
+	var status HealthStatus
+	err = call("Infoblox.Health", struct{}{}, &status)
+	if err == nil && !status.Healthy {
+		err = fmt.Errorf("daemon is unhealthy, circuit breaker %s: %s", status.Wapi.BreakerState, status.Wapi.LastError)
+	}
+	report("daemon", err)
+
+	// Grid: a live WAPI call made by the daemon.
+	report("grid", call("Infoblox.CheckGrid", struct{}{}, &struct{}{}))
+
+	if failed {
+		return fmt.Errorf("connectivity check failed")
+	}
+	return nil
+}
+
+func cmdMigrateHostLocal(args []string) error {
+	fs := flag.NewFlagSet("migrate-host-local", flag.ExitOnError)
+	netconfFile := fs.String("netconf", "", "Infoblox CNI network configuration the addresses are migrated to")
+	network := fs.String("network", "", "Name of the host-local network (default: the name in the network configuration)")
+	dataDir := fs.String("data-dir", DEFAULT_HOST_LOCAL_DIR, "Directory host-local keeps its state in")
+	dryRun := fs.Bool("dry-run", false, "Only report what would be migrated")
+	fs.Parse(args)
+	if *netconfFile == "" {
+		return fmt.Errorf("usage: infoblox-cni-ctl migrate-host-local --netconf file [--network name] [--data-dir dir] [--dry-run]")
+	}
+
+	netconf, err := ioutil.ReadFile(*netconfFile)
+	if err != nil {
+		return err
+	}
+	conf := NetConfig{}
+	if err = json.Unmarshal(netconf, &conf); err != nil {
+		return fmt.Errorf("error parsing netconf: %v", err)
+	}
+	if *network == "" {
+		*network = conf.Name
+	}
+
+	entries, err := ReadHostLocalAllocations(filepath.Join(*dataDir, *network))
+	if err != nil {
+		return fmt.Errorf("error reading host-local allocations: %v", err)
+	}
+
+	var results []MigrationResult
+	migrateArgs := MigrateArgs{NetConf: netconf, Entries: entries, DryRun: *dryRun}
+	if err = call("Infoblox.Migrate", migrateArgs, &results); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, r := range results {
+		if r.Status == MigrationConflict || r.Status == MigrationFailed {
+			failed++
+		}
+	}
+	if *jsonOutput {
+		err = printJSON(results)
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "IP ADDRESS\tCONTAINER\tSTATUS\tMESSAGE")
+		for _, r := range results {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.IPAddress, r.ContainerID, r.Status, r.Message)
+		}
+		err = w.Flush()
+	}
+	if err == nil && failed > 0 {
+		err = fmt.Errorf("%d of %d addresses could not be migrated", failed, len(results))
+	}
+	return err
+}
+
+var commands = map[string]func([]string) error{
+	"list":        cmdList,
+	"show":        cmdShow,
+	"export":      cmdExport,
+	"release":     cmdRelease,
+	"utilization": cmdUtilization,
+	"check":       cmdCheck,
+
+	"migrate-host-local": cmdMigrateHostLocal,
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command '%s'\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := cmd(flag.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
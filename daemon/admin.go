@@ -0,0 +1,119 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+
+	. "github.com/infobloxopen/cni-infoblox"
+)
+
+// The RPC methods in this file back the infoblox-cni-ctl admin tool.
+
+// ListAllocations lists the fixed addresses allocated for containers,
+// selected by node, namespace and container.
+func (ib *Infoblox) ListAllocations(filter AllocationFilter, allocations *[]Allocation) error {
+	res, err := ib.Drv.ListAddresses(filter.NetworkView, filter.ExtAttrs())
+	if err != nil {
+		return err
+	}
+	*allocations = res
+	return nil
+}
+
+// serveAllocations exports the allocations as CSV or JSON, selected by the
+// format, network-view, node, namespace and container query parameters.
+func (ib *Infoblox) serveAllocations(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := AllocationFilter{
+		NetworkView: q.Get("network-view"),
+		NodeName:    q.Get("node"),
+		Namespace:   q.Get("namespace"),
+		ContainerID: q.Get("container"),
+	}
+	format := q.Get("format")
+	if format == "" {
+		format = ExportJSON
+	}
+
+	allocations, err := ib.Drv.ListAddresses(filter.NetworkView, filter.ExtAttrs())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	var buf bytes.Buffer
+	if err = ExportAllocations(&buf, format, allocations); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if format == ExportCSV {
+		w.Header().Set("Content-Type", "text/csv")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Write(buf.Bytes())
+}
+
+// ForceRelease releases a fixed address regardless of the container it is
+// allocated for, to clean up leaked addresses.
+func (ib *Infoblox) ForceRelease(args ReleaseArgs, ref *string) error {
+	if args.IPAddress == "" {
+		return errors.New("no IP address given")
+	}
+	log.Printf("ForceRelease: releasing '%s' in network view '%s'", args.IPAddress, args.NetworkView)
+
+	released, err := ib.Drv.ReleaseAddress(args.NetworkView, args.IPAddress, "")
+	ib.audit.record(auditRecord{Event: auditForceRelease, NetworkView: args.NetworkView, IPAddress: args.IPAddress}, err)
+	if err != nil {
+		return err
+	}
+	if released == "" {
+		return errors.New("no fixed address found for " + args.IPAddress)
+	}
+	*ref = released
+	return nil
+}
+
+// Utilization returns the utilization of the given network or, if no CIDR
+// is given, of all networks tracked by the utilization monitor.
+func (ib *Infoblox) Utilization(args UtilizationArgs, networks *[]NetworkUtilization) error {
+	if args.Cidr != "" {
+		utilization, err := ib.Drv.GetNetworkUtilization(args.NetworkView, args.Cidr)
+		if err != nil {
+			return err
+		}
+		network := NetworkUtilization{NetworkView: args.NetworkView, Cidr: args.Cidr, Utilization: utilization, Level: UtilizationOK}
+		if ib.utilization != nil {
+			network.Level = ib.utilization.level(utilization)
+		}
+		*networks = []NetworkUtilization{network}
+		return nil
+	}
+
+	if ib.utilization == nil {
+		return errors.New("utilization monitoring is disabled, give a network")
+	}
+	*networks = ib.utilization.snapshot()
+	return nil
+}
+
+// CheckGrid verifies that the daemon can reach the grid.
+func (ib *Infoblox) CheckGrid(args struct{}, reply *struct{}) error {
+	return ib.Drv.CheckGrid()
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	current "github.com/containernetworking/cni/pkg/types/100"
+	. "github.com/infobloxopen/cni-infoblox"
+	"github.com/infobloxopen/cni-infoblox/fakewapi"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
+)
+
+var _ = Describe("Admin RPCs", func() {
+	testView := "test-view"
+	testCidr := "192.168.30.0/24"
+
+	var server *fakewapi.Server
+	var ib *Infoblox
+
+	BeforeEach(func() {
+		server = fakewapi.NewServer()
+		hostConfig := server.HostConfig()
+
+		config := &Config{}
+		config.GridHost = hostConfig.Host
+		config.WapiPort = hostConfig.Port
+		config.WapiUsername = hostConfig.Username
+		config.WapiPassword = hostConfig.Password
+		config.WapiVer = hostConfig.Version
+		config.SslVerify = "false"
+		config.NetworkView = "default"
+		config.NetworkContainer = "192.168.0.0/24"
+		config.PrefixLength = uint(26)
+		config.CacheDisabled = true
+
+		ib = newInfoblox(getInfobloxDriver(config, getConnector(config)))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	allocate := func(nodeName string, namespace string, containerID string) string {
+		ib.tagger = &ExtAttrTagger{NodeName: nodeName}
+		args := &ExtCmdArgs{}
+		args.ContainerID = containerID
+		args.IfName = "eth0"
+		args.Args = "IgnoreUnknown=1;K8S_POD_NAMESPACE=" + namespace + ";K8S_POD_NAME=pod-" + containerID
+		args.StdinData = []byte(`{"name": "yellow", "ipam": {"type": "infoblox", "network-view": "test-view", "subnet": "192.168.30.0/24"}}`)
+		result := &current.Result{}
+		Expect(ib.Allocate(args, result)).To(BeNil())
+		return result.IPs[0].Address.IP.String()
+	}
+
+	Describe("ListAllocations", func() {
+		It("Should list the allocations selected by the filter", func() {
+			allocate("node-1", "web", "container-1")
+			allocate("node-1", "db", "container-2")
+			allocate("node-2", "web", "container-3")
+
+			var allocations []Allocation
+			Expect(ib.ListAllocations(AllocationFilter{NetworkView: testView}, &allocations)).To(BeNil())
+			Expect(allocations).To(HaveLen(3))
+
+			Expect(ib.ListAllocations(AllocationFilter{NetworkView: testView, NodeName: "node-1"}, &allocations)).To(BeNil())
+			Expect(allocations).To(HaveLen(2))
+
+			Expect(ib.ListAllocations(AllocationFilter{NetworkView: testView, NodeName: "node-1", Namespace: "web"}, &allocations)).To(BeNil())
+			Expect(allocations).To(HaveLen(1))
+			Expect(allocations[0].ContainerID).To(Equal("container-1"))
+			Expect(allocations[0].Cidr).To(Equal(testCidr))
+
+			Expect(ib.ListAllocations(AllocationFilter{NetworkView: testView, ContainerID: "container-4"}, &allocations)).To(BeNil())
+			Expect(allocations).To(BeEmpty())
+		})
+
+		It("Should not list addresses the CNI did not allocate", func() {
+			allocate("node-1", "web", "container-1")
+			_, err := server.Create("fixedaddress", fakewapi.Object{"network_view": testView, "network": testCidr, "ipv4addr": "192.168.30.100"})
+			Expect(err).To(BeNil())
+
+			var allocations []Allocation
+			Expect(ib.ListAllocations(AllocationFilter{NetworkView: testView}, &allocations)).To(BeNil())
+			Expect(allocations).To(HaveLen(1))
+		})
+	})
+
+	Describe("serveAllocations", func() {
+		get := func(query string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			ib.serveAllocations(w, httptest.NewRequest(http.MethodGet, "/allocations?"+query, nil))
+			return w
+		}
+
+		It("Should export the selected allocations as JSON by default", func() {
+			ip := allocate("node-1", "web", "container-1")
+			allocate("node-2", "web", "container-2")
+
+			w := get("network-view=test-view&node=node-1")
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Header().Get("Content-Type")).To(Equal("application/json"))
+			var allocations []Allocation
+			Expect(json.Unmarshal(w.Body.Bytes(), &allocations)).To(BeNil())
+			Expect(allocations).To(HaveLen(1))
+			Expect(allocations[0].IPAddress).To(Equal(ip))
+		})
+
+		It("Should export the allocations as CSV", func() {
+			ip := allocate("node-1", "web", "container-1")
+
+			w := get("network-view=test-view&format=csv&namespace=web&container=container-1")
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Header().Get("Content-Type")).To(Equal("text/csv"))
+			lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+			Expect(lines).To(HaveLen(2))
+			Expect(lines[1]).To(HavePrefix(ip + ","))
+		})
+
+		It("Should refuse unknown formats", func() {
+			Expect(get("format=xml").Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("Should report a failure to reach the grid", func() {
+			server.Fail(http.MethodGet, "fixedaddress", "search failed")
+			Expect(get("network-view=test-view").Code).To(Equal(http.StatusBadGateway))
+		})
+	})
+
+	Describe("ForceRelease", func() {
+		It("Should release the address regardless of its container and audit it", func() {
+			var path string
+			ib.audit, path = tempAuditLog()
+			defer os.Remove(path)
+
+			ip := allocate("node-1", "web", "container-1")
+
+			var ref string
+			Expect(ib.ForceRelease(ReleaseArgs{NetworkView: testView, IPAddress: ip}, &ref)).To(BeNil())
+			Expect(ref).NotTo(BeEmpty())
+			Expect(server.Objects("fixedaddress")).To(BeEmpty())
+			Expect(auditEvents(path)).To(Equal([]string{auditAllocate, auditForceRelease}))
+		})
+
+		It("Should fail for an address without a fixed address", func() {
+			var ref string
+			Expect(ib.ForceRelease(ReleaseArgs{NetworkView: testView}, &ref)).NotTo(BeNil())
+			Expect(ib.ForceRelease(ReleaseArgs{NetworkView: testView, IPAddress: "192.168.30.200"}, &ref)).NotTo(BeNil())
+			Expect(ref).To(BeEmpty())
+		})
+	})
+
+	Describe("Utilization", func() {
+		It("Should read the utilization of a given network from the grid", func() {
+			allocate("node-1", "web", "container-1")
+
+			var networks []NetworkUtilization
+			Expect(ib.Utilization(UtilizationArgs{NetworkView: testView, Cidr: testCidr}, &networks)).To(BeNil())
+			Expect(networks).To(HaveLen(1))
+			Expect(networks[0].Utilization).To(BeNumerically("~", 0.3, 0.001))
+			Expect(networks[0].Level).To(Equal(UtilizationOK))
+
+			ib.utilization = newUtilizationMonitor(ib.Drv, time.Minute, 0.1, 0.2, 0)
+			Expect(ib.Utilization(UtilizationArgs{NetworkView: testView, Cidr: testCidr}, &networks)).To(BeNil())
+			Expect(networks[0].Level).To(Equal(UtilizationCritical))
+
+			Expect(ib.Utilization(UtilizationArgs{NetworkView: testView, Cidr: "192.168.31.0/24"}, &networks)).NotTo(BeNil())
+		})
+
+		It("Should report the networks tracked by the utilization monitor", func() {
+			var networks []NetworkUtilization
+			Expect(ib.Utilization(UtilizationArgs{}, &networks)).NotTo(BeNil())
+
+			ib.utilization = newUtilizationMonitor(ib.Drv, time.Minute, 80, 95, 0)
+			ib.utilization.networks[poolKey{netview: testView, cidr: testCidr}] = &NetworkUtilization{NetworkView: testView, Cidr: testCidr, Level: UtilizationOK}
+			Expect(ib.Utilization(UtilizationArgs{}, &networks)).To(BeNil())
+			Expect(networks).To(HaveLen(1))
+			Expect(networks[0].Cidr).To(Equal(testCidr))
+		})
+	})
+
+	Describe("CheckGrid", func() {
+		It("Should fail once the grid cannot be reached", func() {
+			Expect(ib.CheckGrid(struct{}{}, nil)).To(BeNil())
+
+			server.Close()
+			Expect(ib.CheckGrid(struct{}{}, nil)).NotTo(BeNil())
+		})
+	})
+})
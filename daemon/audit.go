@@ -0,0 +1,141 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+	. "github.com/infobloxopen/cni-infoblox"
+)
+
+// Audit log events
+const (
+	auditAllocate     = "allocate"
+	auditRelease      = "release"
+	auditForceRelease = "force-release"
+	auditMigrate      = "migrate"
+	auditGC           = "gc"
+	auditController   = "controller-release"
+	auditHold         = "hold"
+	auditStickyExpire = "sticky-expire"
+)
+
+// auditRecord is a line of the audit log.
+type auditRecord struct {
+	Time        time.Time `json:"time"`
+	Event       string    `json:"event"`
+	ContainerID string    `json:"container-id,omitempty"`
+	Namespace   string    `json:"namespace,omitempty"`
+	PodName     string    `json:"pod-name,omitempty"`
+	NodeName    string    `json:"node-name,omitempty"`
+	NetworkView string    `json:"network-view,omitempty"`
+	Cidr        string    `json:"cidr,omitempty"`
+	IPAddress   string    `json:"ip-address,omitempty"`
+	Mac         string    `json:"mac,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// auditLog appends a JSON line for every allocate and release to a file.
+// A nil auditLog records nothing.
+type auditLog struct {
+	mu       sync.Mutex
+	file     *os.File
+	nodeName string
+}
+
+func openAuditLog(path string, nodeName string) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{file: f, nodeName: nodeName}, nil
+}
+
+func (a *auditLog) record(rec auditRecord, err error) {
+	if a == nil {
+		return
+	}
+	rec.Time = time.Now().UTC()
+	rec.NodeName = a.nodeName
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	line, _ := json.Marshal(rec)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		log.Printf("Cannot write audit log: %v", err)
+	}
+}
+
+// releaseEvent returns the audit event of a release by event's path, telling
+// sticky addresses put on hold and released after their hold apart.
+func releaseEvent(event string, outcome stickyOutcome) string {
+	switch outcome {
+	case stickyHeld:
+		return auditHold
+	case stickyExpired:
+		return auditStickyExpire
+	}
+	return event
+}
+
+// allocationRecord returns the audit record of a release of allocation.
+func allocationRecord(event string, allocation Allocation) auditRecord {
+	return auditRecord{
+		Event:       event,
+		ContainerID: allocation.ContainerID,
+		Namespace:   allocation.Namespace,
+		PodName:     allocation.PodName,
+		NetworkView: allocation.NetworkView,
+		Cidr:        allocation.Cidr,
+		IPAddress:   allocation.IPAddress,
+		Mac:         allocation.Mac,
+	}
+}
+
+// auditCNI records an allocate or release requested by the plugin. The
+// address is taken from result for allocations and from ref for releases.
+func (ib *Infoblox) auditCNI(event string, args *ExtCmdArgs, conf *NetConfig, result *current.Result, ref string, err error) {
+	if ib.audit == nil {
+		return
+	}
+
+	rec := auditRecord{Event: event, ContainerID: args.ContainerID, Mac: args.IfMac}
+	if podArgs, perr := args.PodArgs(); perr == nil {
+		rec.Namespace = podArgs.Namespace()
+		rec.PodName = podArgs.PodName()
+	}
+	if conf.IPAM != nil {
+		rec.NetworkView = conf.IPAM.NetworkView
+	}
+	if result != nil && len(result.IPs) > 0 {
+		ipn := result.IPs[0].Address
+		rec.IPAddress = ipn.IP.String()
+		rec.Cidr = (&net.IPNet{IP: ipn.IP.Mask(ipn.Mask), Mask: ipn.Mask}).String()
+	}
+	if ref != "" {
+		rec.IPAddress = IPAddressFromRef(ref)
+	}
+	ib.audit.record(rec, err)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	current "github.com/containernetworking/cni/pkg/types/100"
+	. "github.com/infobloxopen/cni-infoblox"
+	"github.com/infobloxopen/cni-infoblox/fakewapi"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// auditEvents returns the events recorded in the audit log at path.
+func auditEvents(path string) []string {
+	data, err := ioutil.ReadFile(path)
+	Expect(err).To(BeNil())
+	var events []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		rec := auditRecord{}
+		Expect(json.Unmarshal([]byte(line), &rec)).To(BeNil())
+		events = append(events, rec.Event)
+	}
+	return events
+}
+
+// tempAuditLog opens an audit log in a temporary file and returns it with
+// the path of the file.
+func tempAuditLog() (*auditLog, string) {
+	f, err := ioutil.TempFile("", "audit")
+	Expect(err).To(BeNil())
+	f.Close()
+	audit, err := openAuditLog(f.Name(), "node-1")
+	Expect(err).To(BeNil())
+	return audit, f.Name()
+}
+
+var _ = Describe("Audit log", func() {
+	It("Should tell holds and expired holds of sticky addresses apart", func() {
+		Expect(releaseEvent(auditGC, stickyReleased)).To(Equal(auditGC))
+		Expect(releaseEvent(auditGC, stickyHeld)).To(Equal(auditHold))
+		Expect(releaseEvent(auditController, stickyExpired)).To(Equal(auditStickyExpire))
+	})
+
+	It("Should record releases on DEL and by GC as different events", func() {
+		server := fakewapi.NewServer()
+		defer server.Close()
+		hostConfig := server.HostConfig()
+
+		config := &Config{}
+		config.GridHost = hostConfig.Host
+		config.WapiPort = hostConfig.Port
+		config.WapiUsername = hostConfig.Username
+		config.WapiPassword = hostConfig.Password
+		config.WapiVer = hostConfig.Version
+		config.SslVerify = "false"
+		config.NetworkView = "default"
+		config.NetworkContainer = "192.168.0.0/24"
+		config.PrefixLength = uint(26)
+		config.CacheDisabled = true
+
+		ib := newInfoblox(getInfobloxDriver(config, getConnector(config)))
+		ib.tagger = &ExtAttrTagger{NodeName: "node-1", ClusterName: "test-cluster"}
+		var path string
+		ib.audit, path = tempAuditLog()
+		defer os.Remove(path)
+
+		newArgs := func(containerID string) *ExtCmdArgs {
+			args := &ExtCmdArgs{}
+			args.ContainerID = containerID
+			args.IfName = "eth0"
+			args.Args = "IgnoreUnknown=1;K8S_POD_NAMESPACE=default;K8S_POD_NAME=pod-" + containerID
+			args.StdinData = []byte(`{"cniVersion": "1.1.0", "name": "yellow", "ipam": {"type": "infoblox", "network-view": "test-view", "subnet": "192.168.30.0/24"}}`)
+			return args
+		}
+
+		Expect(ib.Allocate(newArgs("container-1"), &current.Result{})).To(BeNil())
+		Expect(ib.Allocate(newArgs("container-2"), &current.Result{})).To(BeNil())
+		Expect(ib.Release(newArgs("container-1"), nil)).To(BeNil())
+		var releasedIPs []string
+		Expect(ib.GC(newArgs(""), &releasedIPs)).To(BeNil())
+		Expect(releasedIPs).To(HaveLen(1))
+
+		Expect(auditEvents(path)).To(Equal([]string{auditAllocate, auditAllocate, auditRelease, auditGC}))
+	})
+})
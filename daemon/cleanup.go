@@ -0,0 +1,80 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+
+	. "github.com/infobloxopen/cni-infoblox"
+)
+
+// cleanupPolicy deletes the networks the daemon created, tagged with its
+// EA_OWNER, once the last address in them has been released, and optionally
+// their network views once they are empty. A nil cleanupPolicy keeps them.
+type cleanupPolicy struct {
+	views bool
+}
+
+// newCleanupPolicy returns the cleanup policy of the daemon tagging the
+// objects it creates with owner. Objects are only cleaned up with an owner
+// of their own, as the daemons of different clusters would otherwise delete
+// each other's.
+func newCleanupPolicy(policy string, owner string) (*cleanupPolicy, error) {
+	var p *cleanupPolicy
+	switch policy {
+	case "", CLEANUP_KEEP:
+		return nil, nil
+	case CLEANUP_NETWORKS:
+		p = &cleanupPolicy{}
+	case CLEANUP_NETWORKS_AND_VIEWS:
+		p = &cleanupPolicy{views: true}
+	default:
+		return nil, fmt.Errorf("unknown cleanup policy '%s'", policy)
+	}
+	if owner == "" || owner == DEFAULT_OWNER {
+		return nil, fmt.Errorf("cleanup policy '%s' needs a cluster name of its own; set --cluster-name", policy)
+	}
+	return p, nil
+}
+
+// ownerOf returns the owner the objects the daemon creates are tagged with:
+// the cluster name if it is set explicitly, or else DEFAULT_OWNER.
+func ownerOf(config *Config) string {
+	if config.ClusterName == "" || config.ClusterName == DEFAULT_CLUSTER_NAME {
+		return DEFAULT_OWNER
+	}
+	return config.ClusterName
+}
+
+// released deletes the network of a released address if it is no longer
+// used. Failures are only logged, as the address is released either way.
+func (p *cleanupPolicy) released(drv IBInfobloxDriver, netviewName string, cidr string) {
+	if p == nil || cidr == "" {
+		return
+	}
+	deleted, err := drv.DeleteNetworkIfUnused(netviewName, cidr)
+	if err != nil {
+		log.Printf("Cleanup: failed to delete network '%s' in network view '%s': %v", cidr, netviewName, err)
+		return
+	}
+	if !deleted || !p.views {
+		return
+	}
+	if _, err = drv.DeleteNetworkViewIfUnused(netviewName); err != nil {
+		log.Printf("Cleanup: failed to delete network view '%s': %v", netviewName, err)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	current "github.com/containernetworking/cni/pkg/types/100"
+	. "github.com/infobloxopen/cni-infoblox"
+	"github.com/infobloxopen/cni-infoblox/fakewapi"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cleanup policy", func() {
+	netConf := `
+{
+    "name": "yellow",
+    "ipam": {
+        "type": "infoblox",
+        "network-view": "test-view",
+        "subnet": "192.168.30.0/24",
+        "gateway": "192.168.30.1"
+    }
+}`
+
+	var server *fakewapi.Server
+	var config *Config
+
+	BeforeEach(func() {
+		server = fakewapi.NewServer()
+		hostConfig := server.HostConfig()
+
+		config = &Config{}
+		config.GridHost = hostConfig.Host
+		config.WapiPort = hostConfig.Port
+		config.WapiUsername = hostConfig.Username
+		config.WapiPassword = hostConfig.Password
+		config.WapiVer = hostConfig.Version
+		config.SslVerify = "false"
+		config.NetworkView = "default"
+		config.NetworkContainer = "192.168.0.0/24"
+		config.PrefixLength = uint(26)
+		config.ClusterName = "test-cluster"
+		config.CacheDisabled = true
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	newArgs := func(containerID string) *ExtCmdArgs {
+		args := &ExtCmdArgs{}
+		args.ContainerID = containerID
+		args.IfName = "eth0"
+		args.Args = "IgnoreUnknown=1;K8S_POD_NAMESPACE=default;K8S_POD_NAME=pod-" + containerID
+		args.StdinData = []byte(netConf)
+		return args
+	}
+
+	newCleanupInfoblox := func(policy string) *Infoblox {
+		ib := newInfoblox(getInfobloxDriver(config, getConnector(config)))
+		ib.tagger = &ExtAttrTagger{NodeName: "node-1", ClusterName: config.ClusterName}
+		var err error
+		ib.cleanup, err = newCleanupPolicy(policy, ownerOf(config))
+		Expect(err).To(BeNil())
+		return ib
+	}
+
+	It("Should reject an unknown policy", func() {
+		_, err := newCleanupPolicy("everything", "test-cluster")
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("Should refuse to clean up without a cluster name of its own", func() {
+		for _, name := range []string{"", DEFAULT_CLUSTER_NAME} {
+			config.ClusterName = name
+			Expect(ownerOf(config)).To(Equal(DEFAULT_OWNER))
+			_, err := newCleanupPolicy(CLEANUP_NETWORKS, ownerOf(config))
+			Expect(err).NotTo(BeNil())
+			policy, err := newCleanupPolicy(CLEANUP_KEEP, ownerOf(config))
+			Expect(err).To(BeNil())
+			Expect(policy).To(BeNil())
+		}
+	})
+
+	It("Should keep networks and views by default", func() {
+		ib := newCleanupInfoblox("")
+		Expect(ib.Allocate(newArgs("container-1"), &current.Result{})).To(BeNil())
+		Expect(ib.Release(newArgs("container-1"), nil)).To(BeNil())
+		Expect(server.Objects("network")).To(HaveLen(1))
+	})
+
+	It("Should delete the network once its last address is released", func() {
+		ib := newCleanupInfoblox(CLEANUP_NETWORKS)
+		Expect(ib.Allocate(newArgs("container-1"), &current.Result{})).To(BeNil())
+		Expect(ib.Allocate(newArgs("container-2"), &current.Result{})).To(BeNil())
+
+		Expect(ib.Release(newArgs("container-1"), nil)).To(BeNil())
+		Expect(server.Objects("network")).To(HaveLen(1))
+
+		Expect(ib.Release(newArgs("container-2"), nil)).To(BeNil())
+		Expect(server.Objects("network")).To(BeEmpty())
+		Expect(server.Objects("networkview")).To(HaveLen(2))
+	})
+
+	It("Should delete the network view once it is empty", func() {
+		ib := newCleanupInfoblox(CLEANUP_NETWORKS_AND_VIEWS)
+		Expect(ib.Allocate(newArgs("container-1"), &current.Result{})).To(BeNil())
+		Expect(ib.Release(newArgs("container-1"), nil)).To(BeNil())
+		Expect(server.Objects("network")).To(BeEmpty())
+		Expect(server.Objects("networkview")).To(HaveLen(1))
+	})
+
+	It("Should delete the network of an address released by its MAC address", func() {
+		ib := newCleanupInfoblox(CLEANUP_NETWORKS)
+		args := newArgs("container-1")
+		args.IfMac = "11:22:33:44:55:66"
+		Expect(ib.Allocate(args, &current.Result{})).To(BeNil())
+
+		// The runtime no longer knows the container the address is
+		// tagged with.
+		args = newArgs("container-2")
+		args.IfMac = "11:22:33:44:55:66"
+		Expect(ib.Release(args, nil)).To(BeNil())
+		Expect(server.Objects("fixedaddress")).To(BeEmpty())
+		Expect(server.Objects("network")).To(BeEmpty())
+	})
+
+	It("Should keep a network for the grace period after it was created", func() {
+		config.CleanupGracePeriod = 3600
+		ib := newCleanupInfoblox(CLEANUP_NETWORKS_AND_VIEWS)
+		Expect(ib.Allocate(newArgs("container-1"), &current.Result{})).To(BeNil())
+		Expect(server.Objects("network")[0].EA(EA_CREATED_AT)).NotTo(BeNil())
+
+		Expect(ib.Release(newArgs("container-1"), nil)).To(BeNil())
+		Expect(server.Objects("network")).To(HaveLen(1))
+		Expect(server.Objects("networkview")).To(HaveLen(2))
+	})
+
+	It("Should create a network deleted by another node again", func() {
+		config.CacheDisabled = false
+		config.CacheTTL = 300
+		node1 := newCleanupInfoblox(CLEANUP_NETWORKS)
+		node2 := newCleanupInfoblox(CLEANUP_NETWORKS)
+		node2.tagger.NodeName = "node-2"
+
+		Expect(node2.Allocate(newArgs("container-2"), &current.Result{})).To(BeNil())
+		Expect(node1.Allocate(newArgs("container-1"), &current.Result{})).To(BeNil())
+		Expect(node1.Release(newArgs("container-1"), nil)).To(BeNil())
+		Expect(node2.Release(newArgs("container-2"), nil)).To(BeNil())
+		Expect(server.Objects("network")).To(BeEmpty())
+
+		// node1 still has the network cached.
+		result := &current.Result{}
+		Expect(node1.Allocate(newArgs("container-3"), result)).To(BeNil())
+		Expect(result.IPs[0].Address.IP.String()).To(Equal("192.168.30.2"))
+		Expect(server.Objects("network")).To(HaveLen(1))
+		Expect(server.Objects("fixedaddress")).To(HaveLen(2))
+	})
+
+	It("Should leave networks it did not create alone", func() {
+		_, err := server.AddNetworkView("test-view", nil)
+		Expect(err).To(BeNil())
+		_, err = server.AddNetwork("test-view", "192.168.30.0/24", map[string]interface{}{"Network Name": "yellow"})
+		Expect(err).To(BeNil())
+
+		ib := newCleanupInfoblox(CLEANUP_NETWORKS_AND_VIEWS)
+		Expect(ib.Allocate(newArgs("container-1"), &current.Result{})).To(BeNil())
+		Expect(ib.Release(newArgs("container-1"), nil)).To(BeNil())
+		Expect(server.Objects("network")).To(HaveLen(1))
+		Expect(server.Objects("networkview")).To(HaveLen(2))
+	})
+
+	It("Should delete the network of an address released without its netconf, such as by the controller", func() {
+		ib := newCleanupInfoblox(CLEANUP_NETWORKS)
+		Expect(ib.Allocate(newArgs("container-1"), &current.Result{})).To(BeNil())
+		Expect(server.Objects("fixedaddress")).To(HaveLen(2))
+
+		allocations, err := ib.Drv.ListAddresses("test-view", AllocationFilter{ContainerID: "container-1"}.ExtAttrs())
+		Expect(err).To(BeNil())
+		Expect(allocations).To(HaveLen(1))
+		_, err = ib.releaseAllocation(auditController, allocations[0])
+		Expect(err).To(BeNil())
+		Expect(server.Objects("network")).To(BeEmpty())
+	})
+
+	It("Should keep a network with an address reserved by hand", func() {
+		ib := newCleanupInfoblox(CLEANUP_NETWORKS)
+		Expect(ib.Allocate(newArgs("container-1"), &current.Result{})).To(BeNil())
+		_, err := server.Create("fixedaddress", fakewapi.Object{
+			"network_view": "test-view", "ipv4addr": "192.168.30.100", "mac": "00:00:00:00:00:00", "name": "printer",
+		})
+		Expect(err).To(BeNil())
+
+		Expect(ib.Release(newArgs("container-1"), nil)).To(BeNil())
+		Expect(server.Objects("network")).To(HaveLen(1))
+	})
+})
@@ -0,0 +1,256 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	. "github.com/infobloxopen/cni-infoblox"
+)
+
+const (
+	podsPath  = "/api/v1/pods"
+	nodesPath = "/api/v1/nodes"
+
+	controllerWatchTimeout = 5 * time.Minute
+	controllerRetryDelay   = 5 * time.Second
+)
+
+// podController releases the fixed addresses of deleted pods, and of all
+// pods of removed nodes, for which kubelet never calls DEL, e.g. because the
+// node was lost. It watches Pod and Node objects across the cluster, and
+// periodically compares the addresses tagged with the cluster name to the
+// pods and nodes that exist, to catch up with deletions it missed. Only
+// addresses tagged with the pod UID or the node name are released, and
+// sticky addresses only once their hold time has passed. Addresses are
+// released the way DEL releases them, so they are audited and their networks
+// cleaned up alike.
+type podController struct {
+	ib          *Infoblox
+	kube        *kubeClient
+	netviews    []string
+	clusterName string
+	resync      time.Duration
+}
+
+func newPodController(drv IBInfobloxDriver, kube *kubeClient, config *Config) (*podController, error) {
+	if config.ClusterName == "" || config.ClusterName == DEFAULT_CLUSTER_NAME {
+		return nil, fmt.Errorf("the controller needs a cluster name of its own, to leave the addresses of other clusters alone; set --cluster-name")
+	}
+	ib := newInfoblox(drv)
+	ib.sticky = newStickyPolicy(config.StickyIPHoldTime)
+	cleanup, err := newCleanupPolicy(config.CleanupPolicy, ownerOf(config))
+	if err != nil {
+		return nil, err
+	}
+	ib.cleanup = cleanup
+	c := &podController{
+		ib:          ib,
+		kube:        kube,
+		clusterName: config.ClusterName,
+		resync:      time.Duration(config.ControllerResync) * time.Second,
+	}
+	for _, name := range strings.Split(config.ControllerNetworkViews, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			c.netviews = append(c.netviews, name)
+		}
+	}
+	if len(c.netviews) == 0 {
+		c.netviews = []string{config.NetworkView}
+	}
+	return c, nil
+}
+
+func (c *podController) run() {
+	go c.watch(podsPath, c.podEvent)
+	go c.watch(nodesPath, c.nodeEvent)
+	for {
+		if err := c.reconcile(); err != nil {
+			log.Printf("Controller: reconciling addresses failed: %v", err)
+		}
+		time.Sleep(c.resync)
+	}
+}
+
+// watch passes the changes to the collection to handle, listing it again
+// whenever the watch expires.
+func (c *podController) watch(path string, handle func(eventType string, obj *kubeObject)) {
+	resourceVersion := ""
+	for {
+		var err error
+		if resourceVersion == "" {
+			_, resourceVersion, err = c.kube.list(path)
+		}
+		if err == nil {
+			resourceVersion, err = c.kube.watch(path, resourceVersion, controllerWatchTimeout, handle)
+		}
+		if err == errWatchExpired {
+			resourceVersion = ""
+			continue
+		}
+		if err != nil {
+			log.Printf("Controller: watching %s failed: %v", path, err)
+			time.Sleep(controllerRetryDelay)
+		}
+	}
+}
+
+func (c *podController) podEvent(eventType string, pod *kubeObject) {
+	if eventType != watchDeleted || pod.Metadata.UID == "" {
+		return
+	}
+	log.Printf("Controller: pod '%s/%s' deleted", pod.Metadata.Namespace, pod.Metadata.Name)
+	c.releaseAll(AllocationFilter{ClusterName: c.clusterName, PodUID: pod.Metadata.UID})
+}
+
+func (c *podController) nodeEvent(eventType string, node *kubeObject) {
+	if eventType != watchDeleted || node.Metadata.Name == "" {
+		return
+	}
+	log.Printf("Controller: node '%s' removed", node.Metadata.Name)
+	c.releaseAll(AllocationFilter{ClusterName: c.clusterName, NodeName: node.Metadata.Name})
+}
+
+// releaseAll releases the addresses matching the filter in all network
+// views of the controller.
+func (c *podController) releaseAll(filter AllocationFilter) {
+	for _, netviewName := range c.netviews {
+		allocations, err := c.list(netviewName, filter)
+		if err != nil {
+			log.Printf("Controller: listing addresses in network view '%s' failed: %v", netviewName, err)
+			continue
+		}
+		for _, allocation := range allocations {
+			c.release(allocation)
+		}
+	}
+}
+
+// list returns the addresses of the controller's cluster matching the
+// filter in the network view.
+func (c *podController) list(netviewName string, filter AllocationFilter) ([]Allocation, error) {
+	filter.ClusterName = c.clusterName
+	allocations, err := c.ib.Drv.ListAddresses(netviewName, filter.ExtAttrs())
+	if err != nil {
+		return nil, err
+	}
+	var res []Allocation
+	for _, allocation := range allocations {
+		if allocation.ExtAttrs[EA_CLUSTER_NAME] == c.clusterName {
+			res = append(res, allocation)
+		}
+	}
+	return res, nil
+}
+
+// reconcile releases the addresses of pods and nodes that no longer exist.
+// The addresses are listed before the pods, so that an address allocated
+// meanwhile is not mistaken for one of a deleted pod.
+func (c *podController) reconcile() error {
+	var allocations []Allocation
+	for _, netviewName := range c.netviews {
+		res, err := c.list(netviewName, AllocationFilter{})
+		if err != nil {
+			return err
+		}
+		allocations = append(allocations, res...)
+	}
+
+	pods, _, err := c.kube.list(podsPath)
+	if err != nil {
+		return err
+	}
+	nodes, _, err := c.kube.list(nodesPath)
+	if err != nil {
+		return err
+	}
+	podUIDs := map[string]bool{}
+	for _, pod := range pods {
+		podUIDs[pod.Metadata.UID] = true
+	}
+	nodeNames := map[string]bool{}
+	for _, node := range nodes {
+		nodeNames[node.Metadata.Name] = true
+	}
+
+	for _, allocation := range allocations {
+		switch {
+		case allocation.PodUID != "" && !podUIDs[allocation.PodUID]:
+			log.Printf("Controller: pod '%s/%s' of '%s' no longer exists", allocation.Namespace, allocation.PodName, allocation.IPAddress)
+		case allocation.NodeName != "" && !nodeNames[allocation.NodeName]:
+			log.Printf("Controller: node '%s' of '%s' no longer exists", allocation.NodeName, allocation.IPAddress)
+		default:
+			continue
+		}
+		c.release(allocation)
+	}
+	return nil
+}
+
+// release releases a listed address, unless it was released meanwhile and
+// allocated again, to a container of this or another cluster.
+func (c *podController) release(allocation Allocation) {
+	current, err := c.ib.Drv.FindAddress(allocation.NetworkView, allocation.IPAddress)
+	if err != nil {
+		log.Printf("Controller: failed to look up '%s': %v", allocation.IPAddress, err)
+		return
+	}
+	if current == nil || current.Ref != allocation.Ref || current.ContainerID != allocation.ContainerID ||
+		current.PodUID != allocation.PodUID || current.ExtAttrs[EA_CLUSTER_NAME] != c.clusterName {
+		return
+	}
+	outcome, err := c.ib.releaseAllocation(auditController, *current)
+	if err != nil {
+		log.Printf("Controller: failed to release '%s': %v", allocation.IPAddress, err)
+		return
+	}
+	if !outcome.released() {
+		return
+	}
+	log.Printf("Controller: released '%s' in network view '%s'", allocation.IPAddress, allocation.NetworkView)
+}
+
+// runController runs the daemon as the cluster wide controller rather than
+// serving the plugin on a node.
+func runController(config *Config) {
+	configToLog := *config
+	configToLog.WapiPassword = "******"
+	log.Printf("Config is '%v'\n", configToLog)
+
+	kube, err := newInClusterKubeClient()
+	if err != nil {
+		log.Printf("Error setting up Kubernetes client: %v", err)
+		return
+	}
+
+	c, err := newPodController(getInfobloxDriver(config, getConnector(config)), kube, config)
+	if err != nil {
+		log.Printf("Error setting up controller: %v", err)
+		return
+	}
+	if config.AuditLog != "" {
+		c.ib.audit, err = openAuditLog(config.AuditLog, config.NodeName)
+		if err != nil {
+			log.Printf("Error opening audit log: %v", err)
+			return
+		}
+	}
+	log.Printf("Controller: releasing addresses of deleted pods and nodes in network views %v", c.netviews)
+	c.run()
+}
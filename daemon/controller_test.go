@@ -0,0 +1,258 @@
+package main
+
+import (
+	current "github.com/containernetworking/cni/pkg/types/100"
+	. "github.com/infobloxopen/cni-infoblox"
+	"github.com/infobloxopen/cni-infoblox/fakewapi"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"time"
+)
+
+var _ = Describe("Controller", func() {
+	testView := "test-view"
+	netConf := `
+{
+    "name": "yellow",
+    "ipam": {
+        "type": "infoblox",
+        "network-view": "test-view",
+        "subnet": "192.168.30.0/24"
+    }
+}`
+
+	var server *fakewapi.Server
+	var config *Config
+	var pods, nodes []kubeObject
+	var watchEvents []string
+	var kube *httptest.Server
+
+	BeforeEach(func() {
+		server = fakewapi.NewServer()
+		hostConfig := server.HostConfig()
+
+		config = &Config{}
+		config.GridHost = hostConfig.Host
+		config.WapiPort = hostConfig.Port
+		config.WapiUsername = hostConfig.Username
+		config.WapiPassword = hostConfig.Password
+		config.WapiVer = hostConfig.Version
+		config.SslVerify = "false"
+		config.NetworkView = "default"
+		config.NetworkContainer = "192.168.0.0/24"
+		config.PrefixLength = uint(26)
+		config.ClusterName = "test-cluster"
+		config.CacheDisabled = true
+		config.ControllerNetworkViews = "default, " + testView
+
+		pods = nil
+		nodes = []kubeObject{{Metadata: objectMeta{Name: "node-1"}}, {Metadata: objectMeta{Name: "node-2"}}}
+		watchEvents = nil
+		// Read the pods and nodes a page of one at a time.
+		kubeListLimit = 1
+		kube = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("watch") == "true" {
+				for _, event := range watchEvents {
+					fmt.Fprintln(w, event)
+				}
+				return
+			}
+			list := kubeObjectList{}
+			list.Metadata.ResourceVersion = "100"
+			switch r.URL.Path {
+			case podsPath:
+				list.Items = pods
+			case nodesPath:
+				list.Items = nodes
+			default:
+				http.NotFound(w, r)
+				return
+			}
+			// Pages are continued from the offset of their first item.
+			offset, _ := strconv.Atoi(r.URL.Query().Get("continue"))
+			limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+			list.Items = list.Items[offset:]
+			if limit > 0 && len(list.Items) > limit {
+				list.Items = list.Items[:limit]
+				list.Metadata.Continue = strconv.Itoa(offset + limit)
+			}
+			json.NewEncoder(w).Encode(list)
+		}))
+	})
+
+	AfterEach(func() {
+		kube.Close()
+		server.Close()
+		kubeListLimit = 500
+	})
+
+	newController := func() *podController {
+		client := &kubeClient{host: kube.URL, client: kube.Client()}
+		c, err := newPodController(getInfobloxDriver(config, getConnector(config)), client, config)
+		Expect(err).To(BeNil())
+		return c
+	}
+
+	allocate := func(nodeName string, containerID string, podUID string) {
+		ib := newInfoblox(getInfobloxDriver(config, getConnector(config)))
+		ib.tagger = &ExtAttrTagger{NodeName: nodeName, ClusterName: config.ClusterName}
+
+		args := &ExtCmdArgs{}
+		args.ContainerID = containerID
+		args.IfName = "eth0"
+		args.Args = "IgnoreUnknown=1;K8S_POD_NAMESPACE=default;K8S_POD_NAME=pod-" + containerID + ";K8S_POD_UID=" + podUID
+		args.StdinData = []byte(netConf)
+		Expect(ib.Allocate(args, &current.Result{})).To(BeNil())
+	}
+
+	podUIDs := func() []string {
+		var res []string
+		for _, addr := range server.Objects("fixedaddress") {
+			res = append(res, addr.EA(EA_POD_UID).(string))
+		}
+		return res
+	}
+
+	It("Should refuse to run without a cluster name of its own", func() {
+		client := &kubeClient{host: kube.URL, client: kube.Client()}
+		for _, name := range []string{"", DEFAULT_CLUSTER_NAME} {
+			config.ClusterName = name
+			_, err := newPodController(getInfobloxDriver(config, getConnector(config)), client, config)
+			Expect(err).NotTo(BeNil())
+		}
+	})
+
+	It("Should default to the network view of the daemon", func() {
+		config.ControllerNetworkViews = ""
+		Expect(newController().netviews).To(Equal([]string{"default"}))
+	})
+
+	It("Should release the addresses of a deleted pod", func() {
+		allocate("node-1", "container-1", "uid-1")
+		allocate("node-1", "container-2", "uid-2")
+
+		c := newController()
+		c.podEvent("MODIFIED", &kubeObject{Metadata: objectMeta{UID: "uid-1"}})
+		Expect(server.Objects("fixedaddress")).To(HaveLen(2))
+
+		c.podEvent(watchDeleted, &kubeObject{Metadata: objectMeta{Name: "pod-container-1", UID: "uid-1"}})
+		Expect(podUIDs()).To(Equal([]string{"uid-2"}))
+	})
+
+	It("Should release the addresses of the pods of a removed node", func() {
+		allocate("node-1", "container-1", "uid-1")
+		allocate("node-2", "container-2", "uid-2")
+
+		newController().nodeEvent(watchDeleted, &kubeObject{Metadata: objectMeta{Name: "node-1"}})
+		Expect(podUIDs()).To(Equal([]string{"uid-2"}))
+	})
+
+	It("Should release the addresses of pods and nodes that no longer exist", func() {
+		allocate("node-1", "container-1", "uid-1")
+		allocate("node-1", "container-2", "uid-2")
+		allocate("node-3", "container-3", "uid-3")
+		pods = []kubeObject{
+			{Metadata: objectMeta{UID: "uid-2"}},
+			{Metadata: objectMeta{UID: "uid-3"}},
+		}
+
+		Expect(newController().reconcile()).To(BeNil())
+		Expect(podUIDs()).To(Equal([]string{"uid-2"}))
+	})
+
+	It("Should leave the addresses of other clusters alone", func() {
+		allocate("node-1", "container-1", "uid-1")
+
+		config.ClusterName = "other-cluster"
+		Expect(newController().reconcile()).To(BeNil())
+		Expect(server.Objects("fixedaddress")).To(HaveLen(1))
+	})
+
+	It("Should only release the addresses of its own cluster on a shared grid", func() {
+		config.ClusterName = "cluster-b"
+		allocate("node-1", "container-b1", "uid-b1")
+		allocate("node-2", "container-b2", "uid-b2")
+		config.ClusterName = "cluster-a"
+		allocate("node-1", "container-a1", "uid-a1")
+		allocate("node-2", "container-a2", "uid-a2")
+		c := newController()
+
+		c.nodeEvent(watchDeleted, &kubeObject{Metadata: objectMeta{Name: "node-1"}})
+		Expect(podUIDs()).To(ConsistOf("uid-b1", "uid-b2", "uid-a2"))
+
+		c.podEvent(watchDeleted, &kubeObject{Metadata: objectMeta{UID: "uid-b2"}})
+		Expect(podUIDs()).To(ConsistOf("uid-b1", "uid-b2", "uid-a2"))
+
+		nodes = nil
+		Expect(c.reconcile()).To(BeNil())
+		Expect(podUIDs()).To(ConsistOf("uid-b1", "uid-b2"))
+	})
+
+	It("Should not release an address allocated again since it was listed", func() {
+		config.ClusterName = "cluster-a"
+		allocate("node-1", "container-a1", "uid-a1")
+		c := newController()
+		stale, err := c.list(testView, AllocationFilter{PodUID: "uid-a1"})
+		Expect(err).To(BeNil())
+		Expect(stale).To(HaveLen(1))
+
+		Expect(server.Delete(stale[0].Ref)).To(BeNil())
+		config.ClusterName = "cluster-b"
+		allocate("node-1", "container-b1", "uid-b1")
+		Expect(server.Objects("fixedaddress")[0].String("ipv4addr")).To(Equal(stale[0].IPAddress))
+
+		c.release(stale[0])
+		Expect(podUIDs()).To(Equal([]string{"uid-b1"}))
+	})
+
+	It("Should audit its releases and clean up the networks like DEL", func() {
+		config.CleanupPolicy = CLEANUP_NETWORKS
+		allocate("node-1", "container-1", "uid-1")
+		Expect(server.Objects("network")).To(HaveLen(1))
+
+		c := newController()
+		var path string
+		c.ib.audit, path = tempAuditLog()
+		c.podEvent(watchDeleted, &kubeObject{Metadata: objectMeta{UID: "uid-1"}})
+		Expect(server.Objects("fixedaddress")).To(BeEmpty())
+		Expect(server.Objects("network")).To(BeEmpty())
+		Expect(auditEvents(path)).To(Equal([]string{auditController}))
+	})
+
+	Context("Watching objects", func() {
+		It("Should pass the events to the handler and return the last resource version", func() {
+			watchEvents = []string{
+				`{"type": "ADDED", "object": {"metadata": {"name": "a", "resourceVersion": "101"}}}`,
+				`{"type": "BOOKMARK", "object": {"metadata": {"resourceVersion": "102"}}}`,
+				`{"type": "DELETED", "object": {"metadata": {"name": "a", "resourceVersion": "103"}}}`,
+			}
+			client := &kubeClient{host: kube.URL, client: kube.Client()}
+
+			var events []string
+			resourceVersion, err := client.watch(podsPath, "100", time.Second, func(eventType string, obj *kubeObject) {
+				events = append(events, eventType+" "+obj.Metadata.Name)
+			})
+			Expect(err).To(BeNil())
+			Expect(resourceVersion).To(Equal("103"))
+			Expect(events).To(Equal([]string{"ADDED a", "DELETED a"}))
+		})
+
+		It("Should report an expired resource version", func() {
+			watchEvents = []string{
+				`{"type": "ERROR", "object": {"kind": "Status", "code": 410, "reason": "Expired", "message": "too old resource version"}}`,
+			}
+			client := &kubeClient{host: kube.URL, client: kube.Client()}
+
+			resourceVersion, err := client.watch(podsPath, "100", time.Second, func(string, *kubeObject) {})
+			Expect(err).To(Equal(errWatchExpired))
+			Expect(resourceVersion).To(Equal("100"))
+		})
+	})
+})
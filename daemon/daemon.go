@@ -22,29 +22,57 @@ import (
 	"net"
 	"net/http"
 	"net/rpc"
+	"os"
+	"os/signal"
 	"runtime"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/containernetworking/cni/pkg/types"
-	"github.com/containernetworking/cni/pkg/types/current"
-	"github.com/containernetworking/plugins/pkg/utils/hwaddr"
+	current "github.com/containernetworking/cni/pkg/types/100"
 	. "github.com/infobloxopen/cni-infoblox"
 	ibclient "github.com/infobloxopen/infoblox-go-client"
 )
 
 type Infoblox struct {
 	Drv IBInfobloxDriver
+
+	pool       *warmPool
+	wapi       *ThrottledConnector
+	namespaces *namespaceResolver
+	tagger     *ExtAttrTagger
+	kube       *kubeClient
+	podEAs     *podEAMapping
+
+	podNetworks bool
+	audit       *auditLog
+	sticky      *stickyPolicy
+	cleanup     *cleanupPolicy
+
+	events      *eventRecorder
+	utilization *utilizationMonitor
+
+	overflowCursor overflowCursor
 }
 
 func newInfoblox(drv IBInfobloxDriver) *Infoblox {
 	return &Infoblox{
-		Drv: drv,
+		Drv:    drv,
+		tagger: &ExtAttrTagger{},
 	}
 }
 
 // Allocate acquires an IP from Infoblox for a specified container.
 func (ib *Infoblox) Allocate(args *ExtCmdArgs, result *current.Result) (err error) {
 	conf := NetConfig{}
+	var podArgs *PodArgs
+	defer func() {
+		ib.auditCNI(auditAllocate, args, &conf, result, "", err)
+		if err != nil && ib.events != nil && podArgs != nil {
+			go ib.events.allocationFailed(podArgs, err)
+		}
+	}()
 
 	log.Printf("Allocate: called with args '%s'", *args)
 	/* Sample args passed in K8s
@@ -60,19 +88,79 @@ func (ib *Infoblox) Allocate(args *ExtCmdArgs, result *current.Result) (err erro
 	if err = json.Unmarshal(args.StdinData, &conf); err != nil {
 		return fmt.Errorf("error parsing netconf: %v", err)
 	}
+	podArgs, err = args.PodArgs()
+	if err != nil {
+		return fmt.Errorf("error parsing CNI_ARGS: %v", err)
+	}
+	// A MAC address requested through CNI_ARGS is the one the address is
+	// allocated for, rather than the interface's current one.
+	args.IfMac = podArgs.MacAddr(args.IfMac)
+	nsNet, err := ib.applyNamespace(&conf, podArgs)
+	if err != nil {
+		return err
+	}
+	pod, err := ib.lookupPod(podArgs)
+	if err != nil {
+		if ib.podNetworks {
+			return fmt.Errorf("error looking up pod '%s/%s': %v", podArgs.Namespace(), podArgs.PodName(), err)
+		}
+		// Without pod network annotations the pod is only needed for its
+		// EAs, which are not worth failing the allocation for.
+		log.Printf("Cannot look up pod '%s/%s' for its labels and annotations: %v", podArgs.Namespace(), podArgs.PodName(), err)
+		pod = nil
+	}
+	if err = ib.applyPodNetwork(&conf, pod, nsNet); err != nil {
+		return err
+	}
 
 	cidr := net.IPNet{IP: conf.IPAM.Subnet.IP, Mask: conf.IPAM.Subnet.Mask}
 	netviewName := conf.IPAM.NetworkView
-	gw := conf.IPAM.Gateway
 	log.Printf("RequestNetwork: '%s', '%s'", netviewName, cidr.String())
-	netview, _ := ib.Drv.RequestNetworkView(netviewName)
-	if netview == "" {
-		return nil
+	var eaNames []string
+	for name := range conf.IPAM.ExtAttrs {
+		eaNames = append(eaNames, name)
+	}
+	if conf.IPAM.NetworkSettings != nil {
+		for name := range conf.IPAM.NetworkSettings.ExtAttrs {
+			eaNames = append(eaNames, name)
+		}
+	}
+	if len(eaNames) > 0 {
+		if err = ib.Drv.EnsureEADefinitions(eaNames); err != nil {
+			return fmt.Errorf("error creating EA definitions: %v", err)
+		}
+	}
+
+	err = ib.allocateInNetwork(conf, args, podArgs, pod, result)
+	if IsNetworkNotFound(err) {
+		// The network, or its view, was deleted once unused, by this or
+		// another node, after it had been looked up. Look it up afresh so
+		// that it is created again.
+		log.Printf("Network of '%s' no longer exists, creating it again: %v", cidr.String(), err)
+		ib.Drv.InvalidateCache(netviewName)
+		err = ib.allocateInNetwork(conf, args, podArgs, pod, result)
 	}
+	return err
+}
+
+// allocateInNetwork allocates an address in the network of conf, creating
+// the network view, the network and its gateway if they do not exist yet.
+func (ib *Infoblox) allocateInNetwork(conf NetConfig, args *ExtCmdArgs, podArgs *PodArgs, pod *kubeObject, result *current.Result) error {
+	cidr := net.IPNet{IP: conf.IPAM.Subnet.IP, Mask: conf.IPAM.Subnet.Mask}
+	netviewName := conf.IPAM.NetworkView
+	gw := conf.IPAM.Gateway
 
-	subnet, _ := ib.Drv.RequestNetwork(conf, netview)
+	netview, err := ib.Drv.RequestNetworkView(netviewName, ib.tagger.NetworkViewEA(conf))
+	if err != nil {
+		return err
+	}
+
+	subnet, err := ib.Drv.RequestNetwork(conf, netview, ib.tagger.NetworkEA(conf))
+	if err != nil {
+		return err
+	}
 	if subnet == "" {
-		return nil
+		return fmt.Errorf("subnet '%s' of network '%s' conflicts with another network in network view '%s'", cidr.String(), conf.Name, netview)
 	}
 
 	//cni is not calling gateway creation call, so it is implemented here
@@ -83,36 +171,154 @@ func (ib *Infoblox) Allocate(args *ExtCmdArgs, result *current.Result) (err erro
 		}
 	}
 
+	if ib.utilization != nil {
+		ib.utilization.track(netviewName, subnet)
+		if err = ib.utilization.admit(pod, netviewName, subnet); err != nil {
+			return err
+		}
+	}
+
 	mac := args.IfMac
 
-	return ib.requestAddress(conf, args, result, netviewName, subnet, mac)
+	err = ib.requestAddress(conf, args, podArgs, pod, result, netviewName, subnet, mac)
+	if IsNetworkExhausted(err) && conf.IPAM.Overflow != nil {
+		log.Printf("Subnet '%s' is exhausted, allocating from the overflow networks", subnet)
+		err = ib.allocateOverflow(conf, args, podArgs, pod, result, netviewName, subnet, mac)
+	}
+	return err
+}
+
+// allocationRanges returns the ranges of cidr addresses are allocated from,
+// or nil if the whole subnet may be used.
+func (ib *Infoblox) allocationRanges(conf NetConfig, netviewName string, cidr string) ([]IPRange, error) {
+	if !conf.IPAM.RestrictsAllocation() {
+		return nil, nil
+	}
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	var dhcpRange *IPRange
+	var dhcpExcludes []IPRange
+	if conf.IPAM.Range != "" {
+		dhcpRange, dhcpExcludes, err = ib.Drv.GetRange(netviewName, conf.IPAM.Range)
+		if err != nil {
+			return nil, fmt.Errorf("error looking up DHCP range '%s': %v", conf.IPAM.Range, err)
+		}
+	}
+	return conf.IPAM.AllocationRanges(subnet, dhcpRange, dhcpExcludes)
+}
+
+func inRanges(ip net.IP, ranges []IPRange) bool {
+	for _, r := range ranges {
+		if r.Contains(ip) {
+			return true
+		}
+	}
+	return false
 }
 
-func (ib *Infoblox) requestAddress(conf NetConfig, args *ExtCmdArgs, result *current.Result, netviewName string, cidr string, macAddr string) (err error) {
+func (ib *Infoblox) requestAddress(conf NetConfig, args *ExtCmdArgs, podArgs *PodArgs, pod *kubeObject, result *current.Result, netviewName string, cidr string, macAddr string) (err error) {
 
 	// In Kubernetes to get the container name/hostname
-	containerName := ""
-	str1 := strings.Split(args.Args, "K8S_POD_NAME=")
-	if len(str1) != 1 {
-		str2 := strings.Split(str1[1], ";")
-		containerName = str2[0]
+	containerName := podArgs.PodName()
+	ea := ib.tagger.AddressEA(conf, podArgs)
+	for k, v := range ib.podMetadataEA(pod) {
+		ea[k] = v
+	}
+	ea[EA_ALLOCATED_AT] = time.Now().UTC().Format(time.RFC3339)
+	if ea[EA_ATTACHMENT], err = args.Attachment(); err != nil {
+		return err
+	}
+
+	// A specific address may be requested through the IP key of CNI_ARGS
+	requestedIP := ""
+	if podArgs.IP != nil {
+		requestedIP = podArgs.IP.String()
+	}
+
+	ranges, err := ib.allocationRanges(conf, netviewName, cidr)
+	if err != nil {
+		return err
+	}
+	if requestedIP != "" && ranges != nil && !inRanges(podArgs.IP, ranges) {
+		return fmt.Errorf("requested IP '%s' is outside the allocation ranges of '%s'", requestedIP, cidr)
+	}
+
+	// The options are read before allocating, so that failing to read them
+	// does not leave an address behind.
+	var dhcpOptions *DHCPOptions
+	if conf.IPAM.UseDHCPOptions {
+		if dhcpOptions, err = ib.Drv.GetDHCPOptions(netviewName, cidr); err != nil {
+			return fmt.Errorf("error reading DHCP options of network '%s': %v", cidr, err)
+		}
 	}
 
-	log.Printf("RequestAddress: '%s', '%s', '%s'", netviewName, cidr, macAddr)
-	ip, _ := ib.Drv.RequestAddress(netviewName, cidr, "", macAddr, containerName, args.ContainerID)
+	log.Printf("RequestAddress: '%s', '%s', '%s', '%s'", netviewName, cidr, requestedIP, macAddr)
+	var ip string
+	if key := ib.sticky.key(pod); key != "" {
+		ea[EA_STICKY_POD] = key
+		if requestedIP == "" {
+			ip, err = ib.sticky.reclaim(ib.Drv, netviewName, cidr, args, macAddr, containerName, ea)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	var pooled *ibclient.FixedAddress
+	// The warm pool reserves addresses anywhere in the subnet, so it is
+	// bypassed when allocation is restricted to ranges.
+	if ib.pool != nil && ip == "" && requestedIP == "" && ranges == nil {
+		// A retried ADD gets the address the attachment already holds
+		// rather than another one from the pool, even if its hand-over
+		// has not reached the grid yet.
+		held := ib.pool.wait(args.ContainerID, args.IfName)
+		existing, err := ib.Drv.FindAttachment(netviewName, cidr, args.ContainerID, fmt.Sprint(ea[EA_ATTACHMENT]))
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			ip = existing.IPAddress
+		} else if held != nil {
+			ip = held.IPAddress
+		} else {
+			pooled = ib.pool.get(netviewName, cidr)
+		}
+	}
+	if pooled != nil {
+		ip = pooled.IPAddress
+	} else if ip == "" {
+		ip, err = ib.Drv.RequestAddress(netviewName, cidr, requestedIP, macAddr, containerName, args.ContainerID, ea, ranges)
+		if err != nil {
+			return err
+		}
+	}
 
 	log.Printf("Allocated IP: '%s'", ip)
 
-	// As bridge plugin in CNI generates MAC address based on ip, so the daemon also generating MAC address based on
-	// ip and updating GRID host with the new MAC address
-	if conf.Type == "bridge" {
-		hwAddr, err := hwaddr.GenerateHardwareAddr4(net.ParseIP(ip), hwaddr.PrivateMACPrefix)
+	if pooled != nil {
+		// The address was reserved ahead of time, hand it over to the
+		// container in the background so the plugin gets its answer now.
+		if conf.Type == "bridge" {
+			hwAddr, err := hardwareAddr4(net.ParseIP(ip))
+			if err != nil {
+				log.Printf("Problem while generating hardware address using ip: %s", err)
+				return err
+			}
+			macAddr = hwAddr.String()
+		}
+		ib.pool.assign(args.ContainerID, args.IfName, pooled, macAddr, containerName, ea)
+	} else if conf.Type == "bridge" {
+		// As bridge plugin in CNI generates MAC address based on ip, so the daemon also generating MAC address based on
+		// ip and updating GRID host with the new MAC address
+		hwAddr, err := hardwareAddr4(net.ParseIP(ip))
 		if err != nil {
 			log.Printf("Problem while generating hardware address using ip: %s", err)
 			return err
 		}
 
-		err = ib.updateAddress(netviewName, cidr, ip, hwAddr.String(), containerName)
+		err = ib.updateAddress(netviewName, cidr, ip, hwAddr.String(), containerName, ea)
 		if err != nil {
 			log.Printf("Problem while updating MacAddress: %s", err)
 			return err
@@ -121,25 +327,27 @@ func (ib *Infoblox) requestAddress(conf NetConfig, args *ExtCmdArgs, result *cur
 	ipn, _ := types.ParseCIDR(cidr)
 	ipn.IP = net.ParseIP(ip)
 	ipConfig := &current.IPConfig{
-		Version: "4",
 		Address: *ipn,
 		Gateway: conf.IPAM.Gateway,
 	}
 	routes := convertRoutesToCurrent(conf.IPAM.Routes)
 	result.IPs = []*current.IPConfig{ipConfig}
 	result.Routes = routes
+	if dhcpOptions != nil {
+		dhcpOptions.Apply(result)
+	}
 
 	log.Printf("Allocate result: '%s'", result)
 	return nil
 }
 
-func (ib *Infoblox) updateAddress(netviewName string, cidr string, ipAddr string, macAddr string, name string) error {
+func (ib *Infoblox) updateAddress(netviewName string, cidr string, ipAddr string, macAddr string, name string, ea ibclient.EA) error {
 
 	fixedAddr, err := ib.Drv.GetAddress(netviewName, cidr, ipAddr, "")
 	if err != nil {
 		return err
 	}
-	updatedFixedAddr, err := ib.Drv.UpdateAddress(fixedAddr.Ref, macAddr, name, "")
+	updatedFixedAddr, err := ib.Drv.UpdateAddress(fixedAddr.Ref, macAddr, name, "", ea)
 	if err != nil {
 		return err
 	}
@@ -164,20 +372,294 @@ func (ib *Infoblox) Release(args *ExtCmdArgs, reply *struct{}) error {
 	if err := json.Unmarshal(args.StdinData, &conf); err != nil {
 		return fmt.Errorf("error parsing netconf: %v", err)
 	}
+	podArgs, err := args.PodArgs()
+	if err != nil {
+		return fmt.Errorf("error parsing CNI_ARGS: %v", err)
+	}
+	args.IfMac = podArgs.MacAddr(args.IfMac)
+	if err := ib.applyAttachmentNetwork(&conf, podArgs); err != nil {
+		return err
+	}
 
-	ref, err := ib.Drv.ReleaseAddress(conf.IPAM.NetworkView, "", args.IfMac)
+	if ib.pool != nil {
+		// Make sure an address handed out from the warm pool carries the
+		// container's attachment before looking it up. One whose hand-over
+		// failed is returned to the grid directly.
+		ib.pool.wait(args.ContainerID, args.IfName)
+		ib.pool.abandon(args.ContainerID, args.IfName)
+	}
+
+	ref, err := ib.releaseAttachment(conf, args)
 	log.Printf("Fixed Address released: '%s'", ref)
 
 	return err
 }
 
+// applyAttachmentNetwork selects the network view of the pod for DEL and
+// CHECK, as Allocate does for ADD. The pod may already be gone when it is
+// deleted, in which case the network view of the namespace or the netconf
+// is used. The namespace may be gone as well, so failing to resolve it falls
+// back to the netconf rather than leaking the address.
+func (ib *Infoblox) applyAttachmentNetwork(conf *NetConfig, podArgs *PodArgs) error {
+	var nsNet *NamespaceNetwork
+	if conf.IPAM != nil {
+		nsConf := *conf
+		nsIPAM := *conf.IPAM
+		nsConf.IPAM = &nsIPAM
+		var err error
+		if nsNet, err = ib.applyNamespace(&nsConf, podArgs); err != nil {
+			log.Printf("Using the network view of the netconf: %v", err)
+		} else {
+			*conf = nsConf
+		}
+	}
+	if !ib.podNetworks {
+		return nil
+	}
+	pod, err := ib.lookupPod(podArgs)
+	if err != nil {
+		log.Printf("Cannot look up pod '%s/%s' for its network: %v", podArgs.Namespace(), podArgs.PodName(), err)
+		return nil
+	}
+	return ib.applyPodNetwork(conf, pod, nsNet)
+}
+
+// Check verifies that the addresses of the container's prevResult are still
+// allocated to it on the grid. Without a prevResult it verifies that the
+// container's interface has an address at all.
+func (ib *Infoblox) Check(args *ExtCmdArgs, reply *struct{}) error {
+	conf := NetConfig{}
+	log.Printf("Check: called with args '%s'", *args)
+	if err := json.Unmarshal(args.StdinData, &conf); err != nil {
+		return fmt.Errorf("error parsing netconf: %v", err)
+	}
+	podArgs, err := args.PodArgs()
+	if err != nil {
+		return fmt.Errorf("error parsing CNI_ARGS: %v", err)
+	}
+	args.IfMac = podArgs.MacAddr(args.IfMac)
+	if err := ib.applyAttachmentNetwork(&conf, podArgs); err != nil {
+		return err
+	}
+	prevResult, err := ParsePrevResult(args.StdinData)
+	if err != nil {
+		return fmt.Errorf("error parsing prevResult: %v", err)
+	}
+
+	if ib.pool != nil {
+		ib.pool.wait(args.ContainerID, args.IfName)
+	}
+
+	netviewName := conf.IPAM.NetworkView
+	if prevResult == nil {
+		allocations, err := ib.attachmentAllocations(netviewName, args)
+		if err != nil {
+			return err
+		}
+		if len(allocations) == 0 {
+			return fmt.Errorf("no address is allocated to interface '%s' of container '%s'", args.IfName, args.ContainerID)
+		}
+		return nil
+	}
+
+	attachment, err := args.Attachment()
+	if err != nil {
+		return err
+	}
+	for _, ipConfig := range prevResult.IPs {
+		if ipConfig.Address.IP.To4() == nil || !OnInterface(prevResult, ipConfig, args.IfName) {
+			continue
+		}
+		ip := ipConfig.Address.IP.String()
+		allocation, err := ib.Drv.FindAddress(netviewName, ip)
+		if err != nil {
+			return err
+		}
+		if allocation == nil {
+			return fmt.Errorf("address '%s' of container '%s' is not allocated in network view '%s'", ip, args.ContainerID, netviewName)
+		}
+		if allocation.ContainerID != args.ContainerID {
+			return fmt.Errorf("address '%s' is allocated to container '%s', not '%s'", ip, allocation.ContainerID, args.ContainerID)
+		}
+		if allocation.Attachment != "" && allocation.Attachment != attachment {
+			return fmt.Errorf("address '%s' is allocated to attachment '%s' of container '%s', not '%s'", ip, allocation.Attachment, args.ContainerID, attachment)
+		}
+	}
+	return nil
+}
+
+// attachmentAllocations returns the fixed addresses of the container's
+// attachment in the network view. Addresses allocated before attachments
+// were tagged are matched by MAC address, if it is known. It returns nil if
+// the container has no addresses in the network view at all.
+func (ib *Infoblox) attachmentAllocations(netviewName string, args *ExtCmdArgs) ([]Allocation, error) {
+	if args.ContainerID == "" {
+		return nil, nil
+	}
+	attachment, err := args.Attachment()
+	if err != nil {
+		return nil, err
+	}
+	allocations, err := ib.Drv.ListAddresses(netviewName, AllocationFilter{ContainerID: args.ContainerID}.ExtAttrs())
+	if err != nil || len(allocations) == 0 {
+		return nil, err
+	}
+
+	res := []Allocation{}
+	for _, allocation := range allocations {
+		if allocation.Attachment == attachment || allocation.Attachment == "" && sameMac(allocation.Mac, args.IfMac) {
+			res = append(res, allocation)
+		}
+	}
+	return res, nil
+}
+
+// sameMac reports whether the MAC address of a fixed address may be the
+// interface's. Migrated addresses do not carry a MAC address.
+func sameMac(fixedAddrMac string, ifMac string) bool {
+	if fixedAddrMac == "" || fixedAddrMac == ZERO_MAC_ADDR || ifMac == "" {
+		return true
+	}
+	return strings.EqualFold(fixedAddrMac, ifMac)
+}
+
+// releaseAttachment releases the addresses of the container's attachment,
+// looking for them in each of the attachment's possible network views. Only
+// when the container has no addresses tagged with its ID at all, an address
+// with the interface's MAC address is released.
+func (ib *Infoblox) releaseAttachment(conf NetConfig, args *ExtCmdArgs) (string, error) {
+	netviewName := conf.IPAM.NetworkView
+	var allocations []Allocation
+	for _, view := range ib.attachmentViews(conf) {
+		var err error
+		allocations, err = ib.attachmentAllocations(view, args)
+		if err != nil {
+			ib.auditCNI(auditRelease, args, &conf, nil, "", err)
+			return "", err
+		}
+		if allocations != nil {
+			break
+		}
+	}
+	if allocations == nil {
+		if args.IfMac == "" {
+			return "", nil
+		}
+		// The address is looked up first for its network, which is
+		// cleaned up like those of the tagged addresses.
+		fixedAddr, err := ib.Drv.GetAddress(netviewName, "", "", args.IfMac)
+		if err != nil || fixedAddr == nil {
+			ib.auditCNI(auditRelease, args, &conf, nil, "", err)
+			return "", err
+		}
+		ref, err := ib.Drv.ReleaseAddress(netviewName, fixedAddr.IPAddress, args.IfMac)
+		ib.auditCNI(auditRelease, args, &conf, nil, ref, err)
+		if err == nil && ref != "" {
+			ib.cleanup.released(ib.Drv, netviewName, fixedAddr.Cidr)
+		}
+		return ref, err
+	}
+
+	var ref string
+	for _, allocation := range allocations {
+		if _, err := ib.releaseAllocation(auditRelease, allocation); err != nil {
+			return allocation.Ref, err
+		}
+		ref = allocation.Ref
+	}
+	return ref, nil
+}
+
+// releaseAllocation releases a listed address, or holds it if it is sticky.
+// It records the outcome in the audit log under event, the release path, and
+// deletes the network of a released address once it is unused, as the
+// cleanup policy says.
+func (ib *Infoblox) releaseAllocation(event string, allocation Allocation) (stickyOutcome, error) {
+	outcome, err := ib.sticky.release(ib.Drv, allocation)
+	if outcome == stickyKept {
+		return outcome, nil
+	}
+	ib.audit.record(allocationRecord(releaseEvent(event, outcome), allocation), err)
+	if err == nil && outcome.released() {
+		ib.cleanup.released(ib.Drv, allocation.NetworkView, allocation.Cidr)
+	}
+	return outcome, err
+}
+
+// attachmentViews returns the network views that may hold the addresses of
+// an attachment, starting with the one of the netconf. Pods may request any
+// of the allowed network views, and a pod that is gone by the time it is
+// deleted can no longer tell which one it used.
+func (ib *Infoblox) attachmentViews(conf NetConfig) []string {
+	views := []string{conf.IPAM.NetworkView}
+	if !ib.podNetworks {
+		return views
+	}
+	for _, view := range conf.IPAM.AllowedNetworkViews {
+		if view != conf.IPAM.NetworkView {
+			views = append(views, view)
+		}
+	}
+	return views
+}
+
+// InvalidateCache drops the daemon's cached network views and networks, for
+// instance after they have been changed on the grid directly. An empty
+// network view invalidates everything.
+func (ib *Infoblox) InvalidateCache(netviewName string, reply *struct{}) error {
+	log.Printf("InvalidateCache: called for network view '%s'", netviewName)
+	ib.Drv.InvalidateCache(netviewName)
+
+	return nil
+}
+
+// Health reports whether the daemon can currently talk to the grid.
+func (ib *Infoblox) Health(args struct{}, status *HealthStatus) error {
+	*status = ib.health()
+	return nil
+}
+
+// Status reports whether ADD requests can be served, for the CNI STATUS
+// verb. The grid is asked directly, so an unreachable grid is noticed
+// before the circuit breaker opens.
+func (ib *Infoblox) Status(args *ExtCmdArgs, reply *struct{}) error {
+	if status := ib.health(); !status.Healthy {
+		return fmt.Errorf("WAPI calls are failing fast: %s", status.Wapi.LastError)
+	}
+	if err := ib.Drv.CheckGrid(); err != nil {
+		return fmt.Errorf("grid is not reachable: %v", err)
+	}
+	return nil
+}
+
+func (ib *Infoblox) health() HealthStatus {
+	status := HealthStatus{Healthy: true}
+	if ib.wapi != nil {
+		status.Wapi = ib.wapi.Health()
+		status.Healthy = status.Wapi.BreakerState != BreakerOpen
+	}
+	if ib.utilization != nil {
+		status.Networks = ib.utilization.snapshot()
+	}
+	return status
+}
+
+func (ib *Infoblox) serveHealth(w http.ResponseWriter, r *http.Request) {
+	status := ib.health()
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
 func getListener(driverSocket *DriverSocket) (net.Listener, error) {
 	socketFile := driverSocket.SetupSocket()
 
 	return net.Listen("unix", socketFile)
 }
 
-func getInfobloxDriver(config *Config) *InfobloxDriver {
+func getConnector(config *Config) *ThrottledConnector {
 	hostConfig := ibclient.HostConfig{
 		Host:     config.GridHost,
 		Version:  config.WapiVer,
@@ -196,9 +678,41 @@ func getInfobloxDriver(config *Config) *InfobloxDriver {
 	conn, _ := ibclient.NewConnector(hostConfig, transportConfig,
 		requestBuilder, requestor)
 
-	objMgr := ibclient.NewObjectManager(conn, "Kubernetes", config.ClusterName)
+	return NewThrottledConnector(conn, config.GridConfig)
+}
+
+func getInfobloxDriver(config *Config, conn ibclient.IBConnector) *InfobloxDriver {
+	objMgr := ibclient.NewObjectManager(conn, CMP_TYPE, config.ClusterName)
 	CheckForCloudLicense(objMgr)
-	return NewInfobloxDriver(objMgr, config.NetworkView, config.NetworkContainer, config.PrefixLength)
+
+	var ibDrv *InfobloxDriver
+	if config.CacheDisabled {
+		ibDrv = NewInfobloxDriver(objMgr, conn, config.NetworkView, config.NetworkContainer, config.PrefixLength)
+	} else {
+		cachingObjMgr := NewCachingObjectManager(objMgr, time.Duration(config.CacheTTL)*time.Second)
+		ibDrv = NewInfobloxDriver(cachingObjMgr, conn, config.NetworkView, config.NetworkContainer, config.PrefixLength)
+	}
+	ibDrv.TenantID = config.ClusterName
+	ibDrv.Owner = ownerOf(config)
+	ibDrv.CleanupGrace = time.Duration(config.CleanupGracePeriod) * time.Second
+
+	if err := ibDrv.EnsureEADefinitions(TagExtAttrNames()); err != nil {
+		log.Printf("Error creating EA definitions, objects may not be tagged: %v", err)
+	}
+	return ibDrv
+}
+
+func getNamespaceResolver(config *Config, kube *kubeClient) (*namespaceResolver, error) {
+	mapping, err := LoadNamespaceMapping(config.NamespaceMappingFile)
+	if err != nil {
+		return nil, err
+	}
+
+	resolver := &namespaceResolver{mapping: mapping}
+	if config.NamespaceAnnotations {
+		resolver.kube = kube
+	}
+	return resolver, nil
 }
 
 func runDaemon(config *Config) {
@@ -218,15 +732,106 @@ func runDaemon(config *Config) {
 		return
 	}
 
-	ibDrv := getInfobloxDriver(config)
+	conn := getConnector(config)
+	ibDrv := getInfobloxDriver(config, conn)
 
 	ib := newInfoblox(ibDrv)
+	ib.wapi = conn
+	ib.tagger = &ExtAttrTagger{NodeName: config.NodeName, ClusterName: config.ClusterName}
+	ib.podEAs, err = newPodEAMapping(config)
+	if err != nil {
+		log.Printf("Error parsing pod EA mapping: %v", err)
+		return
+	}
+	if ib.podEAs.empty() {
+		ib.podEAs = nil
+	} else if err = ibDrv.EnsureEADefinitions(ib.podEAs.eaNames()); err != nil {
+		log.Printf("Error creating EA definitions for pod labels and annotations: %v", err)
+	}
+	ib.podNetworks = config.PodNetworkAnnotations
+	ib.sticky = newStickyPolicy(config.StickyIPHoldTime)
+	ib.cleanup, err = newCleanupPolicy(config.CleanupPolicy, ownerOf(config))
+	if err != nil {
+		log.Printf("Error parsing cleanup policy: %v", err)
+		return
+	}
+	if config.NamespaceAnnotations || ib.podEAs != nil || ib.podNetworks || config.KubeEvents || config.HighWaterMark > 0 || ib.sticky != nil {
+		ib.kube, err = newInClusterKubeClient()
+		if err != nil {
+			log.Printf("Error setting up Kubernetes client: %v", err)
+			return
+		}
+	}
+	if config.AuditLog != "" {
+		ib.audit, err = openAuditLog(config.AuditLog, config.NodeName)
+		if err != nil {
+			log.Printf("Error opening audit log: %v", err)
+			return
+		}
+	}
+	if config.KubeEvents {
+		ib.events = &eventRecorder{kube: ib.kube, nodeName: config.NodeName}
+	}
+	if config.UtilizationInterval > 0 {
+		ib.utilization = newUtilizationMonitor(ibDrv, time.Duration(config.UtilizationInterval)*time.Second,
+			config.UtilizationWarning, config.UtilizationCritical, config.HighWaterMark)
+		ib.utilization.events = ib.events
+		ib.utilization.start()
+	}
+	if config.NamespaceMappingFile != "" || config.NamespaceAnnotations {
+		ib.namespaces, err = getNamespaceResolver(config, ib.kube)
+		if err != nil {
+			log.Printf("Error setting up namespace mapping: %v", err)
+			return
+		}
+	}
+	if config.WarmPoolSize > 0 {
+		hostname, _ := os.Hostname()
+		ib.pool = newWarmPool(ibDrv, config.WarmPoolSize,
+			time.Duration(config.WarmPoolRefillInterval)*time.Second, "warm-pool-"+hostname,
+			config.NodeName, config.ClusterName)
+		netviews := []string{config.NetworkView}
+		if ib.namespaces != nil {
+			netviews = append(netviews, ib.namespaces.netviews()...)
+		}
+		ib.pool.start(netviews)
+		go closeOnSignal(l)
+	}
+	if ib.sticky != nil && config.StickyIPSweepInterval > 0 {
+		ib.startHeldSweep(time.Duration(config.StickyIPSweepInterval)*time.Second,
+			NetConfig{IPAM: &IPAMConfig{NetworkView: config.NetworkView}})
+	}
+
 	rpc.Register(ib)
 	rpc.HandleHTTP()
+	http.HandleFunc("/health", ib.serveHealth)
+	http.HandleFunc("/allocations", ib.serveAllocations)
 	http.Serve(l, nil)
+
+	if ib.pool != nil {
+		// Serve returns once the listener is closed; return the warm pool
+		// to the grid before the daemon exits.
+		log.Printf("Returning warm pool addresses to the grid")
+		ib.pool.drain()
+	}
+}
+
+// closeOnSignal stops accepting requests when the daemon is stopped, so
+// that runDaemon can drain the warm pool before exiting.
+func closeOnSignal(l net.Listener) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, os.Interrupt)
+	sig := <-sigCh
+
+	log.Printf("Received signal '%s', shutting down", sig)
+	l.Close()
 }
 
 func main() {
 	config := LoadConfig()
+	if config.Controller {
+		runController(config)
+		return
+	}
 	runDaemon(config)
 }
@@ -2,203 +2,608 @@ package main
 
 import (
 	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
 	. "github.com/infobloxopen/cni-infoblox"
+	"github.com/infobloxopen/cni-infoblox/fakewapi"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"strings"
+	"sync"
 )
 
-type MockInfobloxDriver struct {
-	netviewNameArg, cidrArg, ipAddrArg, macAddrArg, vmIDArg string
+var _ = Describe("Daemon", func() {
+	log.SetOutput(ioutil.Discard)
 
-	netconfArg NetConfig
+	testNetworkName := "yellow"
+	testIpamType := "infoblox"
+	testView := "test-view"
+	testCidr := "192.168.30.0/24"
 
-	requestNetworkViewRet, requestAddressRet, releaseAddressRet, requestNetworkRet string
+	testContainerID := "abcdef123456"
+	testIfMac := "11:22:33:44:55:66"
+	testPodArgs := "IgnoreUnknown=1;K8S_POD_NAMESPACE=default;K8S_POD_NAME=test-pod"
 
-	requestNetworkViewCnt, requestAddressCnt, releaseAddressCnt, requestNetworkCnt int
+	ipamConf := func(gateway string) string {
+		return fmt.Sprintf(`
+{
+    "name": "%s",
+    "ipam": {
+        "type": "%s",
+		"network-view": "%s",
+        "subnet": "%s",
+        "gateway": "%s"
+    }
+}`, testNetworkName, testIpamType, testView, testCidr, gateway)
+	}
 
-	err error
-}
+	var server *fakewapi.Server
+	var config *Config
 
-func (ibDrv *MockInfobloxDriver) RequestNetworkView(netviewName string) (string, error) {
-	Expect(netviewName).To(Equal(ibDrv.netviewNameArg))
+	BeforeEach(func() {
+		server = fakewapi.NewServer()
+		hostConfig := server.HostConfig()
 
-	ibDrv.requestNetworkViewCnt++
+		config = &Config{}
+		config.GridHost = hostConfig.Host
+		config.WapiPort = hostConfig.Port
+		config.WapiUsername = hostConfig.Username
+		config.WapiPassword = hostConfig.Password
+		config.WapiVer = hostConfig.Version
+		config.SslVerify = "false"
+		config.SocketDir = "/run/cni"
+		config.DriverName = "infoblox"
+		config.NetworkView = "default"
+		config.NetworkContainer = "192.168.0.0/24,192.169.0.0/24"
+		config.PrefixLength = uint(26)
+		config.ClusterName = "test-cluster"
+		config.CacheDisabled = true
+	})
 
-	return ibDrv.requestNetworkViewRet, ibDrv.err
-}
+	AfterEach(func() {
+		server.Close()
+	})
 
-func (ibDrv *MockInfobloxDriver) RequestAddress(netviewName string, cidr string, ipAddr string, macAddr string, vmID string) (string, error) {
-	Expect(netviewName).To(Equal(ibDrv.netviewNameArg))
-	Expect(cidr).To(Equal(ibDrv.cidrArg))
-	Expect(ipAddr).To(Equal(ibDrv.ipAddrArg))
-	Expect(macAddr).To(Equal(ibDrv.macAddrArg))
-	Expect(vmID).To(Equal(ibDrv.vmIDArg))
+	withPrevResult := func(args *ExtCmdArgs, result *current.Result) {
+		conf := map[string]interface{}{}
+		Expect(json.Unmarshal(args.StdinData, &conf)).To(BeNil())
+		conf["cniVersion"] = "1.0.0"
+		conf["prevResult"] = result
+		data, err := json.Marshal(conf)
+		Expect(err).To(BeNil())
+		args.StdinData = data
+	}
 
-	ibDrv.requestAddressCnt++
+	newArgs := func(gateway string) *ExtCmdArgs {
+		args := &ExtCmdArgs{}
+		args.ContainerID = testContainerID
+		args.IfName = "eth0"
+		args.IfMac = testIfMac
+		args.Args = testPodArgs
+		args.StdinData = []byte(ipamConf(gateway))
+		return args
+	}
 
-	return ibDrv.requestAddressRet, ibDrv.err
-}
+	Context("Allocate Method", func() {
+		It("Should create the network view and network and allocate the next available address", func() {
+			ib := newInfoblox(getInfobloxDriver(config, getConnector(config)))
 
-func (ibDrv *MockInfobloxDriver) ReleaseAddress(netviewName string, ipAddr string, macAddr string) (string, error) {
-	Expect(netviewName).To(Equal(ibDrv.netviewNameArg))
-	Expect(ipAddr).To(Equal(ibDrv.ipAddrArg))
-	Expect(macAddr).To(Equal(ibDrv.macAddrArg))
+			result := &current.Result{}
+			err := ib.Allocate(newArgs(""), result)
+			Expect(err).To(BeNil())
+			Expect(result.IPs).To(HaveLen(1))
+			Expect(result.IPs[0].Address.String()).To(Equal("192.168.30.1/24"))
+
+			views := server.Objects("networkview")
+			Expect(views[len(views)-1].String("name")).To(Equal(testView))
+
+			networks := server.Objects("network")
+			Expect(networks).To(HaveLen(1))
+			Expect(networks[0].String("network")).To(Equal(testCidr))
+			Expect(networks[0].EA("Network Name")).To(Equal(testNetworkName))
+
+			addrs := server.Objects("fixedaddress")
+			Expect(addrs).To(HaveLen(1))
+			Expect(addrs[0].String("mac")).To(Equal(testIfMac))
+			Expect(addrs[0].String("name")).To(Equal("test-pod"))
+			Expect(addrs[0].EA("VM ID")).To(Equal(testContainerID))
+		})
 
-	ibDrv.releaseAddressCnt++
+		It("Should create the gateway address before allocating the container's", func() {
+			ib := newInfoblox(getInfobloxDriver(config, getConnector(config)))
 
-	return ibDrv.releaseAddressRet, ibDrv.err
-}
+			result := &current.Result{}
+			err := ib.Allocate(newArgs("192.168.30.1"), result)
+			Expect(err).To(BeNil())
+			Expect(result.IPs[0].Address.String()).To(Equal("192.168.30.2/24"))
+			Expect(result.IPs[0].Gateway.String()).To(Equal("192.168.30.1"))
+			Expect(server.Objects("fixedaddress")).To(HaveLen(2))
+		})
 
-func (ibDrv *MockInfobloxDriver) RequestNetwork(netconf NetConfig) (string, error) {
-	Expect(netconf).To(Equal(ibDrv.netconfArg))
+		It("Should allocate for the MAC address and tag the custom keys of CNI_ARGS", func() {
+			ib := newInfoblox(getInfobloxDriver(config, getConnector(config)))
 
-	ibDrv.requestNetworkCnt++
+			args := newArgs("")
+			args.Args = testPodArgs + ";MAC=66:c2:1c:94:6e:e5;TENANT=blue;"
+			Expect(ib.Allocate(args, &current.Result{})).To(BeNil())
 
-	return ibDrv.requestNetworkRet, ibDrv.err
-}
+			addrs := server.Objects("fixedaddress")
+			Expect(addrs).To(HaveLen(1))
+			Expect(addrs[0].String("mac")).To(Equal("66:c2:1c:94:6e:e5"))
+			Expect(addrs[0].EA(EA_CNI_ARGS)).To(Equal("TENANT=blue"))
+		})
+	})
 
-var _ = Describe("Daemon", func() {
-	log.SetOutput(ioutil.Discard)
+	Context("With use-dhcp-options", func() {
+		dhcpConf := func() *ExtCmdArgs {
+			args := newArgs("")
+			args.StdinData = []byte(strings.Replace(ipamConf(""), `"gateway": ""`, `"gateway": "", "use-dhcp-options": true`, 1))
+			return args
+		}
 
-	testNetworkName := "yellow"
-	testIpamType := "infoblox"
-	testView := "test-view"
+		It("Should take gateway, default route and DNS from the network's options", func() {
+			_, err := server.AddNetworkView(testView, nil)
+			Expect(err).To(BeNil())
+			ref, err := server.AddNetwork(testView, testCidr, map[string]interface{}{"Network Name": testNetworkName})
+			Expect(err).To(BeNil())
+			Expect(server.Update(ref, fakewapi.Object{"options": []interface{}{
+				map[string]interface{}{"name": "routers", "num": 3, "value": "192.168.30.254", "use_option": true},
+				map[string]interface{}{"name": "domain-name-servers", "num": 6, "value": "192.168.30.53", "use_option": true},
+				map[string]interface{}{"name": "domain-name", "num": 15, "value": "example.com", "use_option": true},
+			}})).To(Succeed())
+
+			ib := newInfoblox(getInfobloxDriver(config, getConnector(config)))
+			result := &current.Result{}
+			Expect(ib.Allocate(dhcpConf(), result)).To(BeNil())
+			Expect(result.IPs[0].Gateway.String()).To(Equal("192.168.30.254"))
+			Expect(result.Routes).To(HaveLen(1))
+			Expect(result.Routes[0].Dst.String()).To(Equal("0.0.0.0/0"))
+			Expect(result.DNS.Nameservers).To(Equal([]string{"192.168.30.53"}))
+			Expect(result.DNS.Domain).To(Equal("example.com"))
+		})
+	})
+
+	Context("When chained after another plugin", func() {
+		prevResult := `{
+    "cniVersion": "1.0.0",
+    "interfaces": [
+        {"name": "veth1234"},
+        {"name": "eth0", "mac": "11:22:33:44:55:66", "sandbox": "/var/run/netns/test"}
+    ],
+    "ips": [{"address": "10.99.0.5/24", "interface": 0}]
+}`
+		newChainedArgs := func() *ExtCmdArgs {
+			conf := map[string]interface{}{}
+			Expect(json.Unmarshal([]byte(ipamConf("")), &conf)).To(BeNil())
+			conf["cniVersion"] = "1.0.0"
+			conf["prevResult"] = json.RawMessage(prevResult)
+			data, err := json.Marshal(conf)
+			Expect(err).To(BeNil())
 
-	testIp := net.IPv4(byte(192), byte(168), byte(30), byte(0))
-	testMask := net.IPv4Mask(byte(255), byte(255), byte(255), byte(0))
-	testIPNet := net.IPNet{IP: testIp, Mask: testMask}
-	testCidr := testIPNet.String()
+			args := newArgs("")
+			args.StdinData = data
+			return args
+		}
 
-	testContainerID := "abcdef123456"
-	testIfMac := "11:22:33:44:55:66"
+		It("Should check only the addresses on the container interface", func() {
+			ib := newInfoblox(getInfobloxDriver(config, getConnector(config)))
+
+			args := newChainedArgs()
+			prev, err := ParsePrevResult(args.StdinData)
+			Expect(err).To(BeNil())
+			result := &current.Result{}
+			Expect(ib.Allocate(args, result)).To(BeNil())
+			MergeResult(result, prev, args)
+			Expect(result.IPs).To(HaveLen(2))
+
+			// The address of the host side is not the daemon's to check.
+			args = newChainedArgs()
+			withPrevResult(args, result)
+			Expect(ib.Check(args, &struct{}{})).To(BeNil())
+
+			Expect(ib.Release(newArgs(""), nil)).To(BeNil())
+			Expect(ib.Check(args, &struct{}{})).NotTo(BeNil())
+		})
+	})
+
+	Context("Release Method", func() {
+		It("Should release the container's address by MAC address", func() {
+			ib := newInfoblox(getInfobloxDriver(config, getConnector(config)))
+
+			err := ib.Allocate(newArgs(""), &current.Result{})
+			Expect(err).To(BeNil())
+
+			err = ib.Release(newArgs(""), nil)
+			Expect(err).To(BeNil())
+			Expect(server.Objects("fixedaddress")).To(BeEmpty())
+		})
 
-	testAllocatedIPStr := "192.168.30.21"
-	testAllocatedIP := net.ParseIP(testAllocatedIPStr)
-	testAllocatedIPNet := net.IPNet{IP: testAllocatedIP, Mask: testMask}
+		It("Should release an address without MAC address by container ID", func() {
+			ib := newInfoblox(getInfobloxDriver(config, getConnector(config)))
+
+			args := newArgs("")
+			args.IfMac = ""
+			err := ib.Allocate(args, &current.Result{})
+			Expect(err).To(BeNil())
+			Expect(server.Objects("fixedaddress")).To(HaveLen(1))
+
+			args.IfMac = testIfMac
+			err = ib.Release(args, nil)
+			Expect(err).To(BeNil())
+			Expect(server.Objects("fixedaddress")).To(BeEmpty())
+		})
+	})
+
+	Context("Check Method", func() {
+		It("Should succeed while the prevResult addresses are allocated to the container", func() {
+			ib := newInfoblox(getInfobloxDriver(config, getConnector(config)))
+
+			result := &current.Result{}
+			err := ib.Allocate(newArgs(""), result)
+			Expect(err).To(BeNil())
+
+			args := newArgs("")
+			withPrevResult(args, result)
+			Expect(ib.Check(args, &struct{}{})).To(BeNil())
+			Expect(ib.Check(newArgs(""), &struct{}{})).To(BeNil())
+
+			args.ContainerID = "other-container"
+			Expect(ib.Check(args, &struct{}{})).NotTo(BeNil())
+		})
+
+		It("Should fail once the container's address is released", func() {
+			ib := newInfoblox(getInfobloxDriver(config, getConnector(config)))
+
+			result := &current.Result{}
+			err := ib.Allocate(newArgs(""), result)
+			Expect(err).To(BeNil())
+			Expect(ib.Release(newArgs(""), nil)).To(BeNil())
+
+			args := newArgs("")
+			withPrevResult(args, result)
+			Expect(ib.Check(args, &struct{}{})).NotTo(BeNil())
+			Expect(ib.Check(newArgs(""), &struct{}{})).NotTo(BeNil())
+		})
+	})
 
-	testIpamConf := fmt.Sprintf(`
+	Context("With several interfaces per container", func() {
+		greenConf := `
 {
-    "name": "%s",
+    "name": "green",
     "ipam": {
-        "type": "%s",
-		"network-view": "%s",
-        "subnet": "%s"
+        "type": "infoblox",
+        "network-view": "green-view",
+        "subnet": "192.168.40.0/24"
     }
-}`, testNetworkName, testIpamType, testView, testCidr)
-
-	netconf := NetConfig{
-		Name: testNetworkName,
-		IPAM: &IPAMConfig{
-			Type:        testIpamType,
-			NetworkView: testView,
-			Subnet: types.IPNet{
-				IP:   testIp,
-				Mask: testMask,
-			},
-		},
-	}
-
-	Context("Allocate Method", func() {
-		ibDriver := &MockInfobloxDriver{
-			netviewNameArg: testView,
-			netconfArg:     netconf,
-			cidrArg:        testCidr,
-			ipAddrArg:      "",
-			macAddrArg:     testIfMac,
-			vmIDArg:        testContainerID,
-
-			requestNetworkViewRet: testView,
-			requestNetworkRet:     testCidr,
-			requestAddressRet:     testAllocatedIPStr,
+}`
+		newGreenArgs := func() *ExtCmdArgs {
+			args := newArgs("")
+			args.IfName = "net1"
+			args.StdinData = []byte(greenConf)
+			return args
 		}
 
-		ib := newInfoblox(ibDriver)
+		It("Should allocate, check and release each interface on its own", func() {
+			ib := newInfoblox(getInfobloxDriver(config, getConnector(config)))
 
-		args := &ExtCmdArgs{}
-		args.ContainerID = testContainerID
-		args.IfMac = testIfMac
-		args.StdinData = []byte(testIpamConf)
+			// Interfaces such as ipvlan ones share the MAC address.
+			Expect(ib.Allocate(newArgs(""), &current.Result{})).To(BeNil())
+			Expect(ib.Allocate(newGreenArgs(), &current.Result{})).To(BeNil())
+
+			addrs := server.Objects("fixedaddress")
+			Expect(addrs).To(HaveLen(2))
+			Expect(addrs[0].EA(EA_ATTACHMENT)).To(Equal("yellow/eth0"))
+			Expect(addrs[1].EA(EA_ATTACHMENT)).To(Equal("green/net1"))
+			Expect(addrs[1].String("network_view")).To(Equal("green-view"))
 
-		allocateResult := &types.Result{}
+			Expect(ib.Release(newGreenArgs(), nil)).To(BeNil())
+			addrs = server.Objects("fixedaddress")
+			Expect(addrs).To(HaveLen(1))
+			Expect(addrs[0].EA(EA_ATTACHMENT)).To(Equal("yellow/eth0"))
 
-		var err error
-		It("Should pass expected arguments to InfobloxDriver methods", func() {
-			err = ib.Allocate(args, allocateResult)
+			Expect(ib.Check(newArgs(""), &struct{}{})).To(BeNil())
+			Expect(ib.Check(newGreenArgs(), &struct{}{})).NotTo(BeNil())
+
+			// Releasing again must not touch the other interface.
+			Expect(ib.Release(newGreenArgs(), nil)).To(BeNil())
+			Expect(server.Objects("fixedaddress")).To(HaveLen(1))
+		})
+
+		It("Should only release the interface's addresses in the same network view", func() {
+			ib := newInfoblox(getInfobloxDriver(config, getConnector(config)))
+
+			Expect(ib.Allocate(newArgs(""), &current.Result{})).To(BeNil())
+			args := newArgs("")
+			args.IfName = "net1"
+			args.IfMac = "11:22:33:44:55:77"
+			Expect(ib.Allocate(args, &current.Result{})).To(BeNil())
+			Expect(server.Objects("fixedaddress")).To(HaveLen(2))
+
+			Expect(ib.Release(args, nil)).To(BeNil())
+			addrs := server.Objects("fixedaddress")
+			Expect(addrs).To(HaveLen(1))
+			Expect(addrs[0].String("mac")).To(Equal(testIfMac))
 		})
-		It("Should call InfobloxDriver methods the expected no. of times", func() {
-			Expect(ibDriver.requestNetworkViewCnt).To(Equal(1))
-			Expect(ibDriver.requestNetworkCnt).To(Equal(1))
-			Expect(ibDriver.requestAddressCnt).To(Equal(1))
+
+		It("Should release addresses allocated before attachments were tagged", func() {
+			ib := newInfoblox(getInfobloxDriver(config, getConnector(config)))
+
+			Expect(ib.Allocate(newArgs(""), &current.Result{})).To(BeNil())
+			addr := server.Objects("fixedaddress")[0]
+			ea := map[string]interface{}{}
+			for k, v := range addr["extattrs"].(map[string]interface{}) {
+				if k != EA_ATTACHMENT {
+					ea[k] = v
+				}
+			}
+			Expect(server.Update(addr.Ref(), fakewapi.Object{"extattrs": ea})).To(Succeed())
+			Expect(server.Objects("fixedaddress")[0].EA(EA_ATTACHMENT)).To(BeNil())
+
+			Expect(ib.Release(newArgs(""), nil)).To(BeNil())
+			Expect(server.Objects("fixedaddress")).To(BeEmpty())
 		})
-		It("Should return the expected result", func() {
+	})
+
+	Context("GC Method", func() {
+		gcArgs := func(valid ...types.GCAttachment) *ExtCmdArgs {
+			conf := map[string]interface{}{}
+			Expect(json.Unmarshal([]byte(ipamConf("")), &conf)).To(BeNil())
+			conf["cniVersion"] = "1.1.0"
+			conf["cni.dev/valid-attachments"] = valid
+			data, err := json.Marshal(conf)
+			Expect(err).To(BeNil())
+
+			args := &ExtCmdArgs{}
+			args.StdinData = data
+			return args
+		}
+
+		It("Should release the node's addresses of attachments that are no longer valid", func() {
+			ib := newInfoblox(getInfobloxDriver(config, getConnector(config)))
+			ib.tagger = &ExtAttrTagger{NodeName: "node-1", ClusterName: config.ClusterName}
+			other := newInfoblox(getInfobloxDriver(config, getConnector(config)))
+			other.tagger = &ExtAttrTagger{NodeName: "node-2", ClusterName: config.ClusterName}
+
+			Expect(ib.Allocate(newArgs(""), &current.Result{})).To(BeNil())
+			stale := newArgs("")
+			stale.ContainerID = "stale-container"
+			stale.IfMac = "11:22:33:44:55:77"
+			Expect(ib.Allocate(stale, &current.Result{})).To(BeNil())
+			otherNode := newArgs("")
+			otherNode.ContainerID = "other-node-container"
+			otherNode.IfMac = "11:22:33:44:55:78"
+			Expect(other.Allocate(otherNode, &current.Result{})).To(BeNil())
+
+			var released []string
+			err := ib.GC(gcArgs(types.GCAttachment{ContainerID: testContainerID, IfName: "eth0"}), &released)
 			Expect(err).To(BeNil())
-			Expect(allocateResult.IP4.IP).To(Equal(testAllocatedIPNet))
+			Expect(released).To(Equal([]string{"192.168.30.2"}))
+
+			var containers []interface{}
+			for _, addr := range server.Objects("fixedaddress") {
+				containers = append(containers, addr.EA(EA_VM_ID))
+			}
+			Expect(containers).To(ConsistOf(testContainerID, "other-node-container"))
+		})
+
+		It("Should release the address of an interface that is no longer valid", func() {
+			ib := newInfoblox(getInfobloxDriver(config, getConnector(config)))
+			ib.tagger = &ExtAttrTagger{NodeName: "node-1", ClusterName: config.ClusterName}
+
+			Expect(ib.Allocate(newArgs(""), &current.Result{})).To(BeNil())
+
+			var released []string
+			err := ib.GC(gcArgs(types.GCAttachment{ContainerID: testContainerID, IfName: "net1"}), &released)
+			Expect(err).To(BeNil())
+			Expect(released).To(Equal([]string{"192.168.30.1"}))
+			Expect(server.Objects("fixedaddress")).To(BeEmpty())
+		})
+
+		It("Should release stale addresses in the network views of mapped and annotated namespaces", func() {
+			kube := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				annotated := kubeObject{Metadata: objectMeta{Name: "annotated", Annotations: map[string]string{annotationNetworkView: "tenant_c"}}}
+				if r.URL.Path == namespacesPath {
+					json.NewEncoder(w).Encode(kubeObjectList{Items: []kubeObject{annotated, {Metadata: objectMeta{Name: "default"}}}})
+					return
+				}
+				if strings.HasSuffix(r.URL.Path, "/annotated") {
+					json.NewEncoder(w).Encode(annotated)
+					return
+				}
+				json.NewEncoder(w).Encode(kubeObject{Metadata: objectMeta{Name: "default"}})
+			}))
+			defer kube.Close()
+
+			ib := newInfoblox(getInfobloxDriver(config, getConnector(config)))
+			ib.tagger = &ExtAttrTagger{NodeName: "node-1", ClusterName: config.ClusterName}
+			ib.namespaces = &namespaceResolver{
+				mapping: NamespaceMapping{"mapped": {NetworkView: "tenant_a"}},
+				kube:    &kubeClient{host: kube.URL, client: kube.Client()},
+			}
+
+			for _, namespace := range []string{"default", "mapped", "annotated"} {
+				args := newArgs("")
+				args.ContainerID = "container-" + namespace
+				args.Args = "IgnoreUnknown=1;K8S_POD_NAMESPACE=" + namespace + ";K8S_POD_NAME=test-pod"
+				Expect(ib.Allocate(args, &current.Result{})).To(BeNil())
+			}
+			var views []string
+			for _, addr := range server.Objects("fixedaddress") {
+				views = append(views, addr.String("network_view"))
+			}
+			Expect(views).To(ConsistOf(testView, "tenant_a", "tenant_c"))
+
+			var released []string
+			Expect(ib.GC(gcArgs(types.GCAttachment{ContainerID: "container-default", IfName: "eth0"}), &released)).To(BeNil())
+			Expect(released).To(HaveLen(2))
+			fixedAddrs := server.Objects("fixedaddress")
+			Expect(fixedAddrs).To(HaveLen(1))
+			Expect(fixedAddrs[0].EA(EA_VM_ID)).To(Equal("container-default"))
+		})
+
+		It("Should fail without the node and cluster name", func() {
+			ib := newInfoblox(getInfobloxDriver(config, getConnector(config)))
+			ib.tagger = &ExtAttrTagger{NodeName: "node-1"}
+			Expect(ib.Allocate(newArgs(""), &current.Result{})).To(BeNil())
+
+			var released []string
+			Expect(ib.GC(gcArgs(), &released)).NotTo(BeNil())
+			Expect(server.Objects("fixedaddress")).To(HaveLen(1))
 		})
 	})
 
-	Context("Release Method", func() {
-		testAddrRef := "fixedaddress/ZG5zLmJpbmRfY25h:192.168.30.21/test-view"
+	Context("With Kubernetes Events", func() {
+		var kube *httptest.Server
+		var mu sync.Mutex
+		var events []kubeEvent
+
+		BeforeEach(func() {
+			events = nil
+			kube = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				event := kubeEvent{}
+				Expect(json.NewDecoder(r.Body).Decode(&event)).To(Succeed())
+				mu.Lock()
+				defer mu.Unlock()
+				if r.Method == http.MethodPatch {
+					Expect(r.Header.Get("Content-Type")).To(Equal("application/merge-patch+json"))
+					for i := range events {
+						if r.URL.Path == "/api/v1/namespaces/default/events/"+events[i].Metadata.Name {
+							events[i].Count = event.Count
+							events[i].LastTimestamp = event.LastTimestamp
+							events[i].Message = event.Message
+							return
+						}
+					}
+					http.Error(w, "event not found", http.StatusNotFound)
+					return
+				}
+				events = append(events, event)
+				w.WriteHeader(http.StatusCreated)
+			}))
+		})
 
-		ibDriver := &MockInfobloxDriver{
-			netviewNameArg: testView,
-			ipAddrArg:      "",
-			macAddrArg:     testIfMac,
+		AfterEach(func() {
+			kube.Close()
+		})
 
-			releaseAddressRet: testAddrRef,
+		newEventInfoblox := func() *Infoblox {
+			ib := newInfoblox(getInfobloxDriver(config, getConnector(config)))
+			ib.events = &eventRecorder{kube: &kubeClient{host: kube.URL, client: kube.Client()}, nodeName: "node-1"}
+			return ib
+		}
+		recorded := func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			var res []string
+			for _, event := range events {
+				res = append(res, event.InvolvedObject.Kind+" "+event.InvolvedObject.Name+" "+event.Reason)
+			}
+			return res
 		}
 
-		ib := newInfoblox(ibDriver)
-
-		args := &ExtCmdArgs{}
-		args.ContainerID = testContainerID
-		args.IfMac = testIfMac
-		args.StdinData = []byte(testIpamConf)
+		It("Should report a missing network view on the pod", func() {
+			server.Fail(http.MethodPost, "networkview", "permission denied")
 
-		var err error
-		It("Should pass expected arguments to InfobloxDriver methods", func() {
-			err = ib.Release(args, nil)
+			err := newEventInfoblox().Allocate(newArgs(""), &current.Result{})
+			Expect(IsNetworkViewMissing(err)).To(BeTrue())
+			Eventually(recorded).Should(Equal([]string{"Pod test-pod NetworkViewNotFound"}))
+			Expect(events[0].InvolvedObject.Namespace).To(Equal("default"))
+			Expect(events[0].Type).To(Equal(eventWarning))
+			Expect(events[0].Message).To(ContainSubstring("permission denied"))
 		})
-		It("Should call InfobloxDriver methods the expected no. of times", func() {
-			Expect(ibDriver.releaseAddressCnt).To(Equal(1))
+
+		It("Should report license errors on the node as well", func() {
+			server.Fail(http.MethodPost, "fixedaddress", "License 'Cloud Network Automation' is not installed")
+
+			Expect(newEventInfoblox().Allocate(newArgs(""), &current.Result{})).NotTo(BeNil())
+			Eventually(recorded).Should(ConsistOf(
+				"Pod test-pod LicenseUnavailable",
+				"Node node-1 LicenseUnavailable",
+			))
 		})
-		It("Should return the expected result", func() {
+
+		It("Should count repeated failures in the pod's existing event", func() {
+			server.Fail(http.MethodPost, "networkview", "permission denied")
+
+			recorder := &eventRecorder{kube: &kubeClient{host: kube.URL, client: kube.Client()}, nodeName: "node-1"}
+			podArgs, err := LoadPodArgs(testPodArgs)
 			Expect(err).To(BeNil())
+			for i := 0; i < 3; i++ {
+				err = newInfoblox(getInfobloxDriver(config, getConnector(config))).Allocate(newArgs(""), &current.Result{})
+				Expect(err).NotTo(BeNil())
+				recorder.allocationFailed(podArgs, err)
+			}
+			Expect(recorded()).To(Equal([]string{"Pod test-pod NetworkViewNotFound"}))
+			Expect(events[0].Count).To(Equal(3))
+
+			// An event gone from the API server is created anew.
+			mu.Lock()
+			events = nil
+			mu.Unlock()
+			recorder.allocationFailed(podArgs, err)
+			Expect(recorded()).To(Equal([]string{"Pod test-pod NetworkViewNotFound"}))
+			Expect(events[0].Count).To(Equal(1))
+		})
+
+		It("Should not report successful allocations", func() {
+			Expect(newEventInfoblox().Allocate(newArgs(""), &current.Result{})).To(BeNil())
+			Consistently(recorded, "200ms").Should(BeEmpty())
 		})
 	})
 
-	Context("getInfobloxDriver", func() {
-		containersArr := []string{"192.168.0.0/24", "192.169.0.0/24"}
-
-		config := &Config{}
-		config.GridHost = "192.168.124.200"
-		config.WapiPort = "443"
-		config.WapiUsername = "ibadmin"
-		config.WapiPassword = "ibpassword"
-		config.WapiVer = "2.0"
-		config.SocketDir = "/run/cni"
-		config.DriverName = "infoblox"
-		config.SslVerify = "false"
-		config.NetworkView = "default"
-		config.NetworkContainer = strings.Join(containersArr, ",")
-		config.PrefixLength = uint(26)
+	Context("Status Method", func() {
+		It("Should fail while the grid cannot be reached", func() {
+			ib := newInfoblox(getInfobloxDriver(config, getConnector(config)))
+			Expect(ib.Status(&ExtCmdArgs{}, &struct{}{})).To(BeNil())
 
-		ibDrv := getInfobloxDriver(config)
+			server.Fail(http.MethodGet, "networkview", "grid unavailable")
+			err := ib.Status(&ExtCmdArgs{}, &struct{}{})
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(ContainSubstring("grid unavailable"))
+		})
+	})
 
+	Context("hardwareAddr4", func() {
+		It("Should generate the MAC address of the bridge plugin", func() {
+			hwAddr, err := hardwareAddr4(net.ParseIP("192.168.30.2"))
+			Expect(err).To(BeNil())
+			Expect(hwAddr.String()).To(Equal("0a:58:c0:a8:1e:02"))
+
+			_, err = hardwareAddr4(net.ParseIP("2001:db8::1"))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Context("getInfobloxDriver", func() {
 		It("Should initialize driver with expected values", func() {
+			ibDrv := getInfobloxDriver(config, getConnector(config))
+
+			containersArr := strings.Split(config.NetworkContainer, ",")
 			Expect(ibDrv.DefaultNetworkView).To(Equal(config.NetworkView))
 			Expect(ibDrv.DefaultPrefixLen).To(Equal(config.PrefixLength))
 			Expect(len(ibDrv.Containers)).To(Equal(len(containersArr)))
 			for i, c := range ibDrv.Containers {
 				Expect(c.NetworkContainer).To(Equal(containersArr[i]))
 			}
+			Expect(ibDrv.TenantID).To(Equal(config.ClusterName))
 		})
-	})
 
+		It("Should create the EA definitions used for tagging", func() {
+			getInfobloxDriver(config, getConnector(config))
+
+			var names []string
+			for _, eadef := range server.Objects("extensibleattributedef") {
+				names = append(names, eadef.String("name"))
+			}
+			Expect(names).To(ConsistOf(TagExtAttrNames()))
+		})
+	})
 })
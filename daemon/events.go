@@ -0,0 +1,164 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	. "github.com/infobloxopen/cni-infoblox"
+)
+
+// Event types
+const (
+	eventNormal  = "Normal"
+	eventWarning = "Warning"
+)
+
+const eventComponent = "cni-infoblox-daemon"
+
+// An event repeated within eventAggregateWindow of its last occurrence bumps
+// the count of the existing event instead of creating a new one, as the
+// client-go recorder does. At most eventCacheSize series are remembered.
+const (
+	eventAggregateWindow = 10 * time.Minute
+	eventCacheSize       = 4096
+)
+
+// eventKey identifies the series of events of the same kind on an object.
+type eventKey struct {
+	involved  objectReference
+	eventType string
+	reason    string
+}
+
+// eventSeries is the event created for an eventKey, along with the number of
+// times it occurred.
+type eventSeries struct {
+	name      string
+	namespace string
+	count     int
+	last      time.Time
+}
+
+// eventRecorder reports Kubernetes Events. Failures to create an event are
+// only logged.
+type eventRecorder struct {
+	kube     *kubeClient
+	nodeName string
+
+	mu     sync.Mutex
+	series map[eventKey]*eventSeries
+}
+
+func (r *eventRecorder) record(involved objectReference, eventType string, reason string, message string) {
+	namespace := involved.Namespace
+	if namespace == "" {
+		// Events of cluster scoped objects such as nodes go to the default
+		// namespace.
+		namespace = "default"
+	}
+
+	// The lock is held across the API calls so that concurrent repeats
+	// update the same event.
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	key := eventKey{involved: involved, eventType: eventType, reason: reason}
+	if series, ok := r.series[key]; ok && now.Sub(series.last) < eventAggregateWindow {
+		patch := map[string]interface{}{"count": series.count + 1, "lastTimestamp": now, "message": message}
+		err := r.kube.patchEvent(series.namespace, series.name, patch)
+		if err == nil {
+			series.count++
+			series.last = now
+			return
+		}
+		// The event may have expired on the API server, so a new one is
+		// created instead.
+		log.Printf("Cannot update event '%s' for %s '%s': %v", reason, involved.Kind, involved.Name, err)
+	}
+
+	event := &kubeEvent{
+		// Named like the events of client-go, so that the name is known
+		// without reading the response.
+		Metadata:       objectMeta{Name: fmt.Sprintf("%s.%x", involved.Name, now.UnixNano()), Namespace: namespace},
+		InvolvedObject: involved,
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         eventSource{Component: eventComponent, Host: r.nodeName},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if err := r.kube.createEvent(event); err != nil {
+		log.Printf("Cannot create event '%s' for %s '%s': %v", reason, involved.Kind, involved.Name, err)
+		return
+	}
+	r.remember(key, &eventSeries{name: event.Metadata.Name, namespace: namespace, count: 1, last: now})
+}
+
+// remember adds a series to the cache, dropping the series outside the
+// aggregation window, or all of them, once the cache is full.
+func (r *eventRecorder) remember(key eventKey, series *eventSeries) {
+	if r.series == nil {
+		r.series = make(map[eventKey]*eventSeries)
+	}
+	if len(r.series) >= eventCacheSize {
+		for k, s := range r.series {
+			if series.last.Sub(s.last) >= eventAggregateWindow {
+				delete(r.series, k)
+			}
+		}
+		if len(r.series) >= eventCacheSize {
+			r.series = make(map[eventKey]*eventSeries)
+		}
+	}
+	r.series[key] = series
+}
+
+// nodeEvent reports an event on the node the daemon runs on.
+func (r *eventRecorder) nodeEvent(eventType string, reason string, message string) {
+	// Like the kubelet, use the node name as UID so that the events show up
+	// in "kubectl describe node".
+	r.record(objectReference{APIVersion: "v1", Kind: "Node", Name: r.nodeName, UID: r.nodeName}, eventType, reason, message)
+}
+
+// allocationFailed reports the failure to allocate an address for the pod on
+// the pod, with the classified reason. Failures to reach the grid or for lack
+// of a license are not the pod's own, and are reported on the node as well.
+func (r *eventRecorder) allocationFailed(podArgs *PodArgs, err error) {
+	if podArgs.PodName() == "" {
+		return
+	}
+	reason := FailureReason(err)
+	pod := objectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Namespace:  podArgs.Namespace(),
+		Name:       podArgs.PodName(),
+		UID:        podArgs.PodUID(),
+	}
+	r.record(pod, eventWarning, reason, fmt.Sprintf("Failed to allocate an IP address from Infoblox: %v", err))
+
+	if reason == ReasonGridUnreachable || reason == ReasonLicenseUnavailable {
+		r.nodeEvent(eventWarning, reason, fmt.Sprintf("Failed to allocate an IP address for pod '%s/%s' from Infoblox: %v",
+			podArgs.Namespace(), podArgs.PodName(), err))
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+	. "github.com/infobloxopen/cni-infoblox"
+)
+
+// GC releases the addresses this node allocated for the network whose
+// attachments are not among the valid attachments the runtime passes with
+// the CNI GC verb, in each network view the network's addresses may be in.
+// Only addresses tagged with the cluster and node name and an attachment to
+// the network are considered, so addresses of other nodes and networks, and
+// those allocated before attachments were tagged, are left alone. Sticky
+// addresses are held rather than released until their hold time has passed.
+// The released IP addresses are returned.
+func (ib *Infoblox) GC(args *ExtCmdArgs, releasedIPs *[]string) error {
+	conf := NetConfig{}
+	if err := json.Unmarshal(args.StdinData, &conf); err != nil {
+		return fmt.Errorf("error parsing netconf: %v", err)
+	}
+	gcConf := types.NetConf{}
+	if err := json.Unmarshal(args.StdinData, &gcConf); err != nil {
+		return fmt.Errorf("error parsing netconf: %v", err)
+	}
+	if conf.IPAM == nil {
+		return fmt.Errorf("netconf of network '%s' has no IPAM section", conf.Name)
+	}
+	if ib.tagger.NodeName == "" || ib.tagger.ClusterName == "" {
+		return errors.New("node or cluster name unknown, addresses of this node cannot be told apart; set --node-name and --cluster-name")
+	}
+
+	valid := map[string]bool{}
+	for _, attachment := range gcConf.ValidAttachments {
+		valid[attachment.ContainerID+"/"+attachment.IfName] = true
+	}
+
+	var failed int
+	for _, netviewName := range ib.netconfViews(conf) {
+		n, err := ib.gcNetworkView(conf, netviewName, valid, releasedIPs)
+		if err != nil {
+			log.Printf("GC: cannot list the addresses in network view '%s': %v", netviewName, err)
+			if netviewName == conf.IPAM.NetworkView {
+				return err
+			}
+		}
+		failed += n
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to release %d stale addresses of network '%s'", failed, conf.Name)
+	}
+	return nil
+}
+
+// gcNetworkView releases the stale addresses of the network in the network
+// view. It returns the number of addresses it failed to release.
+func (ib *Infoblox) gcNetworkView(conf NetConfig, netviewName string, valid map[string]bool, releasedIPs *[]string) (int, error) {
+	filter := AllocationFilter{NodeName: ib.tagger.NodeName, ClusterName: ib.tagger.ClusterName}
+	allocations, err := ib.Drv.ListAddresses(netviewName, filter.ExtAttrs())
+	if err != nil {
+		return 0, err
+	}
+
+	prefix := AttachmentID(conf.Name, "")
+	var failed int
+	for _, allocation := range allocations {
+		if allocation.ContainerID == "" || !strings.HasPrefix(allocation.Attachment, prefix) {
+			continue
+		}
+		ifName := strings.TrimPrefix(allocation.Attachment, prefix)
+		if valid[allocation.ContainerID+"/"+ifName] {
+			continue
+		}
+
+		log.Printf("GC: releasing '%s' of stale attachment '%s' of container '%s'", allocation.IPAddress, allocation.Attachment, allocation.ContainerID)
+		outcome, err := ib.releaseAllocation(auditGC, allocation)
+		if err != nil {
+			log.Printf("GC: failed to release '%s': %v", allocation.IPAddress, err)
+			failed++
+			continue
+		}
+		if outcome.released() {
+			*releasedIPs = append(*releasedIPs, allocation.IPAddress)
+		}
+	}
+
+	return failed, nil
+}
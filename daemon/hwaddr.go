@@ -0,0 +1,38 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// privateMACPrefix is the prefix of the MAC addresses the bridge plugin
+// generates from IPv4 addresses.
+var privateMACPrefix = []byte{0x0a, 0x58}
+
+// hardwareAddr4 returns the MAC address the bridge plugin gives the
+// container interface with the IPv4 address ip: the private prefix
+// followed by the four bytes of the address.
+func hardwareAddr4(ip net.IP) (net.HardwareAddr, error) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return nil, fmt.Errorf("'%s' is not an IPv4 address", ip)
+	}
+	hwAddr := make(net.HardwareAddr, 0, 6)
+	hwAddr = append(hwAddr, privateMACPrefix...)
+	return append(hwAddr, ip4...), nil
+}
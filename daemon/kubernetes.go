@@ -0,0 +1,324 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Number of objects read per page of a list.
+var kubeListLimit = 500
+
+// objectMeta holds the subset of Kubernetes object metadata used by the
+// daemon.
+type objectMeta struct {
+	Name            string            `json:"name,omitempty"`
+	Namespace       string            `json:"namespace,omitempty"`
+	UID             string            `json:"uid,omitempty"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Annotations     map[string]string `json:"annotations,omitempty"`
+	OwnerReferences []objectReference `json:"ownerReferences,omitempty"`
+}
+
+type kubeObject struct {
+	Metadata objectMeta `json:"metadata"`
+}
+
+// kubeObjectList is the list of objects returned by a list request.
+type kubeObjectList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+		Continue        string `json:"continue,omitempty"`
+	} `json:"metadata"`
+	Items []kubeObject `json:"items"`
+}
+
+// Watch event types
+const (
+	watchDeleted  = "DELETED"
+	watchBookmark = "BOOKMARK"
+	watchError    = "ERROR"
+)
+
+// watchEvent is an event of a watch stream. The object of an ERROR event is
+// a Status.
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+type kubeStatus struct {
+	Code    int    `json:"code"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// errWatchExpired is returned by watch when the resource version it was
+// started from is too old, so the objects have to be listed again.
+var errWatchExpired = errors.New("watch expired")
+
+type objectReference struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	UID        string `json:"uid,omitempty"`
+}
+
+type eventSource struct {
+	Component string `json:"component,omitempty"`
+	Host      string `json:"host,omitempty"`
+}
+
+// kubeEvent is a core/v1 Event.
+type kubeEvent struct {
+	Metadata       objectMeta      `json:"metadata"`
+	InvolvedObject objectReference `json:"involvedObject"`
+	Reason         string          `json:"reason"`
+	Message        string          `json:"message"`
+	Type           string          `json:"type"`
+	Source         eventSource     `json:"source"`
+	FirstTimestamp time.Time       `json:"firstTimestamp"`
+	LastTimestamp  time.Time       `json:"lastTimestamp"`
+	Count          int             `json:"count"`
+}
+
+// kubeClient is a minimal client for the Kubernetes API server, using the
+// service account the daemon pod runs with.
+type kubeClient struct {
+	host   string
+	token  string
+	client *http.Client
+}
+
+func newInClusterKubeClient() (*kubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a Kubernetes cluster: KUBERNETES_SERVICE_HOST/PORT not set")
+	}
+
+	token, err := ioutil.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, fmt.Errorf("error reading service account token: %v", err)
+	}
+	caCert, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("error reading service account CA: %v", err)
+	}
+	caPool := x509.NewCertPool()
+	caPool.AppendCertsFromPEM(caCert)
+
+	return &kubeClient{
+		host:  "https://" + net.JoinHostPort(host, port),
+		token: strings.TrimSpace(string(token)),
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: caPool},
+			},
+		},
+	}, nil
+}
+
+func (k *kubeClient) newRequest(method string, path string, body interface{}) (*http.Request, error) {
+	if body == nil {
+		req, err := http.NewRequest(method, k.host+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+k.token)
+		req.Header.Set("Accept", "application/json")
+		return req, nil
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, k.host+path, strings.NewReader(string(data)))
+	if err != nil {
+		return nil, err
+	}
+	if method == "PATCH" {
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Authorization", "Bearer "+k.token)
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
+
+func checkResponse(method string, path string, resp *http.Response) error {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("kubernetes API %s %s returned %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (k *kubeClient) do(method string, path string, body interface{}, result interface{}) error {
+	req, err := k.newRequest(method, path, body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(method, path, resp); err != nil {
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (k *kubeClient) getNamespace(name string) (*kubeObject, error) {
+	ns := &kubeObject{}
+	if err := k.do("GET", "/api/v1/namespaces/"+url.PathEscape(name), nil, ns); err != nil {
+		return nil, err
+	}
+	return ns, nil
+}
+
+func (k *kubeClient) getPod(namespace string, name string) (*kubeObject, error) {
+	pod := &kubeObject{}
+	path := "/api/v1/namespaces/" + url.PathEscape(namespace) + "/pods/" + url.PathEscape(name)
+	if err := k.do("GET", path, nil, pod); err != nil {
+		return nil, err
+	}
+	return pod, nil
+}
+
+func (k *kubeClient) createEvent(event *kubeEvent) error {
+	return k.do("POST", "/api/v1/namespaces/"+url.PathEscape(event.Metadata.Namespace)+"/events", event, nil)
+}
+
+// patchEvent applies a JSON merge patch to an existing event.
+func (k *kubeClient) patchEvent(namespace string, name string, patch interface{}) error {
+	return k.do("PATCH", "/api/v1/namespaces/"+url.PathEscape(namespace)+"/events/"+url.PathEscape(name), patch, nil)
+}
+
+// list returns the objects of a collection, such as "/api/v1/pods", read a
+// page at a time, and the resource version to watch them from.
+func (k *kubeClient) list(path string) ([]kubeObject, string, error) {
+	var items []kubeObject
+	resourceVersion := ""
+	continueToken := ""
+	for {
+		query := url.Values{}
+		query.Set("limit", strconv.Itoa(kubeListLimit))
+		if continueToken != "" {
+			query.Set("continue", continueToken)
+		}
+		list := &kubeObjectList{}
+		if err := k.do("GET", path+"?"+query.Encode(), nil, list); err != nil {
+			return nil, "", err
+		}
+		items = append(items, list.Items...)
+		// All pages are read at the resource version of the first one.
+		if resourceVersion == "" {
+			resourceVersion = list.Metadata.ResourceVersion
+		}
+		if list.Metadata.Continue == "" {
+			return items, resourceVersion, nil
+		}
+		continueToken = list.Metadata.Continue
+	}
+}
+
+// watch streams the changes to a collection from resourceVersion on to
+// handle, until the API server ends the watch after timeout. It returns the
+// resource version to resume from, or errWatchExpired if the collection has
+// to be listed again.
+func (k *kubeClient) watch(path string, resourceVersion string, timeout time.Duration, handle func(eventType string, obj *kubeObject)) (string, error) {
+	query := url.Values{}
+	query.Set("watch", "true")
+	query.Set("resourceVersion", resourceVersion)
+	query.Set("allowWatchBookmarks", "true")
+	query.Set("timeoutSeconds", strconv.Itoa(int(timeout.Seconds())))
+	req, err := k.newRequest("GET", path+"?"+query.Encode(), nil)
+	if err != nil {
+		return resourceVersion, err
+	}
+
+	// The watch outlives the timeout of the client, so only the transport
+	// is shared.
+	client := &http.Client{Transport: k.client.Transport, Timeout: timeout + k.client.Timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return resourceVersion, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone {
+		return resourceVersion, errWatchExpired
+	}
+	if err := checkResponse("GET", path, resp); err != nil {
+		return resourceVersion, err
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		event := watchEvent{}
+		if err := decoder.Decode(&event); err == io.EOF {
+			return resourceVersion, nil
+		} else if err != nil {
+			return resourceVersion, err
+		}
+
+		if event.Type == watchError {
+			status := kubeStatus{}
+			json.Unmarshal(event.Object, &status)
+			if status.Code == http.StatusGone {
+				return resourceVersion, errWatchExpired
+			}
+			return resourceVersion, fmt.Errorf("watch of %s failed: %s", path, status.Message)
+		}
+
+		obj := &kubeObject{}
+		if err := json.Unmarshal(event.Object, obj); err != nil {
+			return resourceVersion, err
+		}
+		if obj.Metadata.ResourceVersion != "" {
+			resourceVersion = obj.Metadata.ResourceVersion
+		}
+		if event.Type != watchBookmark {
+			handle(event.Type, obj)
+		}
+	}
+}
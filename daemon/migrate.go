@@ -0,0 +1,120 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+
+	. "github.com/infobloxopen/cni-infoblox"
+	ibclient "github.com/infobloxopen/infoblox-go-client"
+)
+
+// Migrate creates fixed addresses for addresses allocated by another IPAM
+// plugin, so that running pods keep their addresses when the network is
+// switched to Infoblox. Addresses already allocated on the grid for a
+// different container are reported as conflicts and left alone. With
+// DryRun, nothing is created on the grid.
+func (ib *Infoblox) Migrate(args MigrateArgs, results *[]MigrationResult) error {
+	conf := NetConfig{}
+	if err := json.Unmarshal(args.NetConf, &conf); err != nil {
+		return fmt.Errorf("error parsing netconf: %v", err)
+	}
+	if conf.IPAM == nil || conf.IPAM.Subnet.IP == nil {
+		return fmt.Errorf("netconf of network '%s' has no IPAM subnet", conf.Name)
+	}
+	netviewName := conf.IPAM.NetworkView
+	subnet := &net.IPNet{IP: conf.IPAM.Subnet.IP, Mask: conf.IPAM.Subnet.Mask}
+	cidr := subnet.String()
+
+	if args.DryRun {
+		network, err := ib.Drv.FindNetwork(netviewName, cidr, "")
+		if err != nil {
+			return err
+		}
+		if network == nil {
+			log.Printf("Migrate: network '%s' does not exist in view '%s' and would be created", cidr, netviewName)
+		}
+	} else {
+		netview, err := ib.Drv.RequestNetworkView(netviewName, ib.tagger.NetworkViewEA(conf))
+		if err != nil {
+			return err
+		}
+		if cidr, err = ib.Drv.RequestNetwork(conf, netview, ib.tagger.NetworkEA(conf)); err != nil {
+			return err
+		}
+		if cidr == "" {
+			return fmt.Errorf("network '%s' cannot be used for network '%s'", subnet, conf.Name)
+		}
+	}
+
+	ea := ib.tagger.AddressEA(conf, nil)
+	for _, entry := range args.Entries {
+		result := MigrationResult{MigrationEntry: entry}
+		result.Status, result.Message = ib.migrateEntry(netviewName, cidr, subnet, entry, conf.Name, ea, args.DryRun)
+		log.Printf("Migrate: '%s' of container '%s': %s %s", entry.IPAddress, entry.ContainerID, result.Status, result.Message)
+		*results = append(*results, result)
+	}
+	return nil
+}
+
+func (ib *Infoblox) migrateEntry(netviewName string, cidr string, subnet *net.IPNet, entry MigrationEntry, name string, ea ibclient.EA, dryRun bool) (string, string) {
+	ip := net.ParseIP(entry.IPAddress)
+	if ip == nil {
+		return MigrationFailed, "invalid IP address"
+	}
+	if !subnet.Contains(ip) {
+		return MigrationConflict, fmt.Sprintf("address is outside of '%s'", subnet)
+	}
+
+	existing, err := ib.Drv.FindAddress(netviewName, entry.IPAddress)
+	if err != nil {
+		return MigrationFailed, err.Error()
+	}
+	if existing != nil {
+		if existing.ContainerID == entry.ContainerID {
+			return MigrationExists, ""
+		}
+		return MigrationConflict, fmt.Sprintf("address is allocated on the grid for '%s' (%s)", existing.ContainerID, existing.Name)
+	}
+
+	if dryRun {
+		return MigrationWouldCreate, ""
+	}
+	// The MAC address of the container is not known, the address is
+	// released by container ID and interface name when the pod goes away.
+	entryEA := ibclient.EA{}
+	for k, v := range ea {
+		entryEA[k] = v
+	}
+	if entry.IfName != "" {
+		entryEA[EA_ATTACHMENT] = AttachmentID(name, entry.IfName)
+	}
+	_, err = ib.Drv.RequestAddress(netviewName, cidr, entry.IPAddress, "", name, entry.ContainerID, entryEA, nil)
+	ib.audit.record(auditRecord{
+		Event:       auditMigrate,
+		ContainerID: entry.ContainerID,
+		NetworkView: netviewName,
+		Cidr:        cidr,
+		IPAddress:   entry.IPAddress,
+	}, err)
+	if err != nil {
+		return MigrationFailed, err.Error()
+	}
+	return MigrationCreated, ""
+}
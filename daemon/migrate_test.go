@@ -0,0 +1,117 @@
+package main
+
+import (
+	. "github.com/infobloxopen/cni-infoblox"
+	"github.com/infobloxopen/cni-infoblox/fakewapi"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Migrate", func() {
+	netConf := []byte(`{"name": "yellow", "ipam": {"type": "infoblox", "network-view": "test-view", "subnet": "192.168.30.0/24"}}`)
+
+	var server *fakewapi.Server
+	var ib *Infoblox
+
+	BeforeEach(func() {
+		server = fakewapi.NewServer()
+		hostConfig := server.HostConfig()
+
+		config := &Config{}
+		config.GridHost = hostConfig.Host
+		config.WapiPort = hostConfig.Port
+		config.WapiUsername = hostConfig.Username
+		config.WapiPassword = hostConfig.Password
+		config.WapiVer = hostConfig.Version
+		config.SslVerify = "false"
+		config.NetworkView = "default"
+		config.NetworkContainer = "192.168.0.0/24"
+		config.PrefixLength = uint(26)
+		config.CacheDisabled = true
+
+		ib = newInfoblox(getInfobloxDriver(config, getConnector(config)))
+		ib.tagger = &ExtAttrTagger{NodeName: "node-1"}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	migrate := func(dryRun bool, entries ...MigrationEntry) []MigrationResult {
+		var results []MigrationResult
+		Expect(ib.Migrate(MigrateArgs{NetConf: netConf, Entries: entries, DryRun: dryRun}, &results)).To(BeNil())
+		Expect(results).To(HaveLen(len(entries)))
+		return results
+	}
+
+	statuses := func(results []MigrationResult) []string {
+		var res []string
+		for _, result := range results {
+			res = append(res, result.Status)
+		}
+		return res
+	}
+
+	It("Should create fixed addresses for the containers and their attachments", func() {
+		results := migrate(false, MigrationEntry{IPAddress: "192.168.30.10", ContainerID: "container-1", IfName: "eth0"})
+		Expect(statuses(results)).To(Equal([]string{MigrationCreated}))
+
+		Expect(server.Objects("network")).To(HaveLen(1))
+		fixedAddrs := server.Objects("fixedaddress")
+		Expect(fixedAddrs).To(HaveLen(1))
+		Expect(fixedAddrs[0].String("ipv4addr")).To(Equal("192.168.30.10"))
+		Expect(fixedAddrs[0].EA(EA_VM_ID)).To(Equal("container-1"))
+		Expect(fixedAddrs[0].EA(EA_ATTACHMENT)).To(Equal(AttachmentID("yellow", "eth0")))
+		Expect(fixedAddrs[0].EA(EA_NODE_NAME)).To(Equal("node-1"))
+	})
+
+	It("Should report addresses already migrated for the container as existing", func() {
+		entry := MigrationEntry{IPAddress: "192.168.30.10", ContainerID: "container-1", IfName: "eth0"}
+		migrate(false, entry)
+
+		Expect(statuses(migrate(false, entry))).To(Equal([]string{MigrationExists}))
+		Expect(statuses(migrate(true, entry))).To(Equal([]string{MigrationExists}))
+		Expect(server.Objects("fixedaddress")).To(HaveLen(1))
+	})
+
+	It("Should leave addresses of other containers and outside of the subnet alone", func() {
+		migrate(false, MigrationEntry{IPAddress: "192.168.30.10", ContainerID: "container-1"})
+
+		results := migrate(false,
+			MigrationEntry{IPAddress: "192.168.30.10", ContainerID: "container-2"},
+			MigrationEntry{IPAddress: "192.168.31.10", ContainerID: "container-3"},
+			MigrationEntry{IPAddress: "not-an-address", ContainerID: "container-4"},
+		)
+		Expect(statuses(results)).To(Equal([]string{MigrationConflict, MigrationConflict, MigrationFailed}))
+		Expect(results[0].Message).To(ContainSubstring("container-1"))
+
+		fixedAddrs := server.Objects("fixedaddress")
+		Expect(fixedAddrs).To(HaveLen(1))
+		Expect(fixedAddrs[0].EA(EA_VM_ID)).To(Equal("container-1"))
+	})
+
+	It("Should create nothing on the grid in a dry run", func() {
+		results := migrate(true,
+			MigrationEntry{IPAddress: "192.168.30.10", ContainerID: "container-1"},
+			MigrationEntry{IPAddress: "192.168.31.10", ContainerID: "container-2"},
+		)
+		Expect(statuses(results)).To(Equal([]string{MigrationWouldCreate, MigrationConflict}))
+		Expect(server.Objects("network")).To(BeEmpty())
+		Expect(server.Objects("fixedaddress")).To(BeEmpty())
+	})
+
+	It("Should report conflicts in a dry run", func() {
+		migrate(false, MigrationEntry{IPAddress: "192.168.30.10", ContainerID: "container-1"})
+
+		results := migrate(true, MigrationEntry{IPAddress: "192.168.30.10", ContainerID: "container-2"})
+		Expect(statuses(results)).To(Equal([]string{MigrationConflict}))
+		Expect(server.Objects("fixedaddress")).To(HaveLen(1))
+	})
+
+	It("Should refuse a netconf without a subnet", func() {
+		var results []MigrationResult
+		err := ib.Migrate(MigrateArgs{NetConf: []byte(`{"name": "yellow", "ipam": {"type": "infoblox"}}`)}, &results)
+		Expect(err).NotTo(BeNil())
+	})
+})
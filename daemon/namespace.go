@@ -0,0 +1,188 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/containernetworking/cni/pkg/types"
+	. "github.com/infobloxopen/cni-infoblox"
+)
+
+const namespacesPath = "/api/v1/namespaces"
+
+// Namespace annotations that select the Infoblox network of a namespace.
+const (
+	annotationNetworkView = "infoblox.com/network-view"
+	annotationSubnet      = "infoblox.com/subnet"
+	annotationGateway     = "infoblox.com/gateway"
+)
+
+// namespaceResolver finds the Infoblox network a Kubernetes namespace is
+// mapped to, from the mapping table and, if enabled, from the namespace's
+// annotations. Annotations take precedence over the mapping table.
+type namespaceResolver struct {
+	mapping NamespaceMapping
+	kube    *kubeClient
+}
+
+// resolve returns the network for the namespace, or nil if the namespace is
+// not mapped and the network configuration file applies unchanged.
+func (r *namespaceResolver) resolve(namespace string) (*NamespaceNetwork, error) {
+	if namespace == "" {
+		return nil, nil
+	}
+
+	var nsNet *NamespaceNetwork
+	if mapped, ok := r.mapping[namespace]; ok {
+		nsNet = &mapped
+	}
+
+	if r.kube != nil {
+		ns, err := r.kube.getNamespace(namespace)
+		if err != nil {
+			return nil, err
+		}
+		annotated := NamespaceNetwork{
+			NetworkView: ns.Metadata.Annotations[annotationNetworkView],
+			Subnet:      ns.Metadata.Annotations[annotationSubnet],
+			Gateway:     ns.Metadata.Annotations[annotationGateway],
+		}
+		if annotated != (NamespaceNetwork{}) {
+			if nsNet == nil {
+				nsNet = &NamespaceNetwork{}
+			}
+			if annotated.NetworkView != "" {
+				nsNet.NetworkView = annotated.NetworkView
+			}
+			if annotated.Subnet != "" {
+				nsNet.Subnet = annotated.Subnet
+				nsNet.Gateway = annotated.Gateway
+			}
+		}
+	}
+
+	return nsNet, nil
+}
+
+// netviews returns the network views of the mapping table. Views set
+// through namespace annotations are not known up front.
+func (r *namespaceResolver) netviews() []string {
+	var netviews []string
+	for _, nsNet := range r.mapping {
+		if nsNet.NetworkView != "" {
+			netviews = append(netviews, nsNet.NetworkView)
+		}
+	}
+	return netviews
+}
+
+// annotatedNetviews returns the network views namespaces are annotated with.
+// It returns nil if namespace annotations are not enabled.
+func (r *namespaceResolver) annotatedNetviews() ([]string, error) {
+	if r.kube == nil {
+		return nil, nil
+	}
+	namespaces, _, err := r.kube.list(namespacesPath)
+	if err != nil {
+		return nil, err
+	}
+	var netviews []string
+	for _, ns := range namespaces {
+		if netview := ns.Metadata.Annotations[annotationNetworkView]; netview != "" {
+			netviews = append(netviews, netview)
+		}
+	}
+	return netviews, nil
+}
+
+// netconfViews returns the network views the addresses of the netconf may
+// have been allocated in: its own, those namespaces are mapped or annotated
+// with and those pods may request. Each view is listed once.
+func (ib *Infoblox) netconfViews(conf NetConfig) []string {
+	views := ib.attachmentViews(conf)
+	if ib.namespaces != nil {
+		views = append(views, ib.namespaces.netviews()...)
+		annotated, err := ib.namespaces.annotatedNetviews()
+		if err != nil {
+			log.Printf("Cannot list the network views of namespace annotations: %v", err)
+		}
+		views = append(views, annotated...)
+	}
+
+	seen := map[string]bool{}
+	res := views[:0]
+	for _, view := range views {
+		if !seen[view] {
+			seen[view] = true
+			res = append(res, view)
+		}
+	}
+	return res
+}
+
+// applyNamespaceNetwork points the IPAM configuration at the namespace's
+// network.
+func applyNamespaceNetwork(conf *NetConfig, namespace string, nsNet *NamespaceNetwork) error {
+	if nsNet.NetworkView != "" {
+		conf.IPAM.NetworkView = nsNet.NetworkView
+	}
+	if nsNet.Subnet != "" {
+		_, subnet, err := net.ParseCIDR(nsNet.Subnet)
+		if err != nil {
+			return fmt.Errorf("invalid subnet '%s' for namespace '%s': %v", nsNet.Subnet, namespace, err)
+		}
+		conf.IPAM.Subnet = types.IPNet(*subnet)
+		// The gateway of the configured subnet does not apply to the
+		// namespace's subnet.
+		conf.IPAM.Gateway = nil
+		// Networks are identified by name on the grid, so every namespace
+		// subnet needs a name of its own.
+		conf.Name = conf.Name + "-" + namespace
+	}
+	if nsNet.Gateway != "" {
+		gw := net.ParseIP(nsNet.Gateway)
+		if gw == nil {
+			return fmt.Errorf("invalid gateway '%s' for namespace '%s'", nsNet.Gateway, namespace)
+		}
+		conf.IPAM.Gateway = gw
+	}
+
+	return nil
+}
+
+// applyNamespace rewrites conf for the namespace of the pod, if the
+// namespace is mapped to a network of its own. It returns the namespace's
+// network, or nil if the namespace is not mapped.
+func (ib *Infoblox) applyNamespace(conf *NetConfig, podArgs *PodArgs) (*NamespaceNetwork, error) {
+	if ib.namespaces == nil || conf.IPAM == nil {
+		return nil, nil
+	}
+
+	namespace := podArgs.Namespace()
+	nsNet, err := ib.namespaces.resolve(namespace)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving network for namespace '%s': %v", namespace, err)
+	}
+	if nsNet == nil {
+		return nil, nil
+	}
+
+	log.Printf("Namespace '%s' is mapped to network view '%s', subnet '%s'", namespace, nsNet.NetworkView, nsNet.Subnet)
+	return nsNet, applyNamespaceNetwork(conf, namespace, nsNet)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	. "github.com/infobloxopen/cni-infoblox"
+	"github.com/infobloxopen/cni-infoblox/fakewapi"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+var _ = Describe("Namespace networks", func() {
+	var kube *httptest.Server
+
+	BeforeEach(func() {
+		kube = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+			ns := kubeObject{Metadata: objectMeta{Name: name}}
+			switch name {
+			case "annotated":
+				ns.Metadata.Annotations = map[string]string{
+					annotationNetworkView: "tenant_c",
+					annotationSubnet:      "10.30.0.0/24",
+					annotationGateway:     "10.30.0.1",
+				}
+			case "view-only":
+				ns.Metadata.Annotations = map[string]string{annotationNetworkView: "tenant_d"}
+			case "gone":
+				http.Error(w, "namespace not found", http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(ns)
+		}))
+	})
+
+	AfterEach(func() {
+		kube.Close()
+	})
+
+	mapping := NamespaceMapping{
+		"tenant-a":  {NetworkView: "tenant_a"},
+		"web":       {NetworkView: "tenant_b", Subnet: "10.20.0.0/24", Gateway: "10.20.0.1"},
+		"view-only": {NetworkView: "tenant_b", Subnet: "10.20.0.0/24"},
+	}
+
+	Describe("namespaceResolver", func() {
+		It("Should resolve namespaces from the mapping table", func() {
+			resolver := &namespaceResolver{mapping: mapping}
+
+			nsNet, err := resolver.resolve("web")
+			Expect(err).To(BeNil())
+			Expect(*nsNet).To(Equal(mapping["web"]))
+
+			nsNet, err = resolver.resolve("other")
+			Expect(err).To(BeNil())
+			Expect(nsNet).To(BeNil())
+
+			nsNet, err = resolver.resolve("")
+			Expect(err).To(BeNil())
+			Expect(nsNet).To(BeNil())
+		})
+
+		It("Should let namespace annotations take precedence over the mapping table", func() {
+			resolver := &namespaceResolver{mapping: mapping, kube: &kubeClient{host: kube.URL, client: kube.Client()}}
+
+			nsNet, err := resolver.resolve("annotated")
+			Expect(err).To(BeNil())
+			Expect(*nsNet).To(Equal(NamespaceNetwork{NetworkView: "tenant_c", Subnet: "10.30.0.0/24", Gateway: "10.30.0.1"}))
+
+			nsNet, err = resolver.resolve("view-only")
+			Expect(err).To(BeNil())
+			Expect(*nsNet).To(Equal(NamespaceNetwork{NetworkView: "tenant_d", Subnet: "10.20.0.0/24"}))
+
+			nsNet, err = resolver.resolve("tenant-a")
+			Expect(err).To(BeNil())
+			Expect(*nsNet).To(Equal(mapping["tenant-a"]))
+		})
+
+		It("Should return the error of a failed namespace lookup", func() {
+			resolver := &namespaceResolver{mapping: mapping, kube: &kubeClient{host: kube.URL, client: kube.Client()}}
+
+			_, err := resolver.resolve("gone")
+			Expect(err).NotTo(BeNil())
+		})
+
+		It("Should list the network views of the mapping table", func() {
+			resolver := &namespaceResolver{mapping: mapping}
+			Expect(resolver.netviews()).To(ConsistOf("tenant_a", "tenant_b", "tenant_b"))
+		})
+	})
+
+	Describe("applyNamespaceNetwork", func() {
+		_, subnet, _ := net.ParseCIDR("192.168.30.0/24")
+
+		newConf := func() NetConfig {
+			return NetConfig{
+				Name: "yellow",
+				IPAM: &IPAMConfig{
+					NetworkView: "test-view",
+					Subnet:      types.IPNet(*subnet),
+					Gateway:     net.ParseIP("192.168.30.1"),
+				},
+			}
+		}
+
+		It("Should point the config at the namespace's view and subnet", func() {
+			conf := newConf()
+			Expect(applyNamespaceNetwork(&conf, "web", &NamespaceNetwork{NetworkView: "tenant_b", Subnet: "10.20.0.0/24", Gateway: "10.20.0.1"})).To(BeNil())
+			Expect(conf.Name).To(Equal("yellow-web"))
+			Expect(conf.IPAM.NetworkView).To(Equal("tenant_b"))
+			Expect((&net.IPNet{IP: conf.IPAM.Subnet.IP, Mask: conf.IPAM.Subnet.Mask}).String()).To(Equal("10.20.0.0/24"))
+			Expect(conf.IPAM.Gateway.String()).To(Equal("10.20.0.1"))
+		})
+
+		It("Should keep the subnet of the config for a view only mapping", func() {
+			conf := newConf()
+			Expect(applyNamespaceNetwork(&conf, "tenant-a", &NamespaceNetwork{NetworkView: "tenant_a"})).To(BeNil())
+			Expect(conf.Name).To(Equal("yellow"))
+			Expect(conf.IPAM.NetworkView).To(Equal("tenant_a"))
+			Expect((&net.IPNet{IP: conf.IPAM.Subnet.IP, Mask: conf.IPAM.Subnet.Mask}).String()).To(Equal("192.168.30.0/24"))
+			Expect(conf.IPAM.Gateway.String()).To(Equal("192.168.30.1"))
+		})
+
+		It("Should drop the gateway of the config with the namespace's subnet", func() {
+			conf := newConf()
+			Expect(applyNamespaceNetwork(&conf, "web", &NamespaceNetwork{Subnet: "10.20.0.0/24"})).To(BeNil())
+			Expect(conf.IPAM.Gateway).To(BeNil())
+		})
+	})
+
+	Describe("Release", func() {
+		It("Should release from the view of the netconf if the namespace cannot be looked up", func() {
+			server := fakewapi.NewServer()
+			defer server.Close()
+			hostConfig := server.HostConfig()
+
+			config := &Config{}
+			config.GridHost = hostConfig.Host
+			config.WapiPort = hostConfig.Port
+			config.WapiUsername = hostConfig.Username
+			config.WapiPassword = hostConfig.Password
+			config.WapiVer = hostConfig.Version
+			config.SslVerify = "false"
+			config.NetworkView = "default"
+			config.NetworkContainer = "192.168.0.0/24"
+			config.PrefixLength = uint(26)
+			config.CacheDisabled = true
+
+			ib := newInfoblox(getInfobloxDriver(config, getConnector(config)))
+			ib.tagger = &ExtAttrTagger{NodeName: "node-1"}
+
+			args := &ExtCmdArgs{}
+			args.ContainerID = "abcdef123456"
+			args.IfName = "eth0"
+			args.IfMac = "11:22:33:44:55:66"
+			args.Args = "IgnoreUnknown=1;K8S_POD_NAMESPACE=gone;K8S_POD_NAME=test-pod"
+			args.StdinData = []byte(`{"name": "yellow", "ipam": {"type": "infoblox", "network-view": "test-view", "subnet": "192.168.30.0/24"}}`)
+
+			Expect(ib.Allocate(args, &current.Result{})).To(BeNil())
+			Expect(server.Objects("fixedaddress")).To(HaveLen(1))
+
+			ib.namespaces = &namespaceResolver{mapping: NamespaceMapping{}, kube: &kubeClient{host: kube.URL, client: kube.Client()}}
+			Expect(ib.Release(args, nil)).To(BeNil())
+			Expect(server.Objects("fixedaddress")).To(BeEmpty())
+		})
+	})
+})
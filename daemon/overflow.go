@@ -0,0 +1,187 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	. "github.com/infobloxopen/cni-infoblox"
+)
+
+// allocateOverflow allocates the address from the overflow networks of conf
+// once its primary subnet is exhausted: first from the secondary subnets in
+// order, then from networks carved out of the network container, as many as
+// fit into it.
+func (ib *Infoblox) allocateOverflow(conf NetConfig, args *ExtCmdArgs, podArgs *PodArgs, pod *kubeObject, result *current.Result, netviewName string, primary string, mac string) error {
+	overflow := conf.IPAM.Overflow
+
+	for _, sec := range overflow.Subnets {
+		cidr := net.IPNet{IP: sec.Subnet.IP, Mask: sec.Subnet.Mask}
+		secConf := overflowNetConf(conf, subnetNetworkName(conf.Name, cidr.String()), cidr, sec.Gateway, primary)
+		subnet, err := ib.Drv.RequestNetwork(secConf, netviewName, ib.tagger.NetworkEA(secConf))
+		if err != nil || subnet == "" {
+			log.Printf("Cannot use overflow subnet '%s': %v", cidr.String(), err)
+			continue
+		}
+		if err = ib.allocateIn(secConf, args, podArgs, pod, result, netviewName, subnet, mac); !IsNetworkExhausted(err) {
+			return err
+		}
+		log.Printf("Overflow subnet '%s' is exhausted", subnet)
+	}
+
+	if overflow.NetworkContainer != "" {
+		count, err := overflowNetworkCount(overflow.NetworkContainer, overflow.PrefixLength)
+		if err != nil {
+			return err
+		}
+		// Start from the network the last address was allocated from, so
+		// that the exhausted networks before it are not tried every time.
+		key := netviewName + "/" + conf.Name
+		start := ib.overflowCursor.get(key)
+		for n := 0; n < count; n++ {
+			i := (start-1+n)%count + 1
+			name := fmt.Sprintf("%s-overflow-%d", conf.Name, i)
+			nameConf := conf
+			nameConf.Name = name
+			subnet, err := ib.Drv.RequestContainerNetwork(netviewName, overflow.NetworkContainer, overflow.PrefixLength, name, ib.tagger.NetworkEA(nameConf), conf.IPAM.NetworkSettings)
+			if err != nil {
+				return fmt.Errorf("error carving overflow network from '%s': %v", overflow.NetworkContainer, err)
+			}
+			_, cidr, err := net.ParseCIDR(subnet)
+			if err != nil {
+				return fmt.Errorf("invalid overflow network '%s': %v", subnet, err)
+			}
+			secConf := overflowNetConf(conf, name, *cidr, CarvedGateway(cidr), primary)
+			err = ib.allocateIn(secConf, args, podArgs, pod, result, netviewName, subnet, mac)
+			if !IsNetworkExhausted(err) {
+				if err == nil {
+					ib.overflowCursor.set(key, i)
+				}
+				return err
+			}
+			log.Printf("Overflow network '%s' is exhausted", subnet)
+		}
+	}
+
+	return &NetworkExhaustedError{Cidr: primary, Err: errors.New("all overflow networks are exhausted")}
+}
+
+// maxOverflowNetworks bounds the number of networks carved out of an
+// overflow network container for a single netconf.
+const maxOverflowNetworks = 256
+
+// overflowNetworkCount returns the number of networks of prefixLen the
+// overflow network container can be carved into, up to
+// maxOverflowNetworks. Without a prefix length the driver's default is
+// used, and only the upper bound applies.
+func overflowNetworkCount(container string, prefixLen uint) (int, error) {
+	_, containerNet, err := net.ParseCIDR(container)
+	if err != nil {
+		return 0, fmt.Errorf("invalid overflow network container '%s': %v", container, err)
+	}
+	ones, bits := containerNet.Mask.Size()
+	if prefixLen == 0 {
+		return maxOverflowNetworks, nil
+	}
+	if int(prefixLen) < ones || int(prefixLen) > bits {
+		return 0, fmt.Errorf("overflow prefix length %d does not fit network container '%s'", prefixLen, container)
+	}
+	if int(prefixLen)-ones >= 8 {
+		return maxOverflowNetworks, nil
+	}
+	return 1 << (prefixLen - uint(ones)), nil
+}
+
+// overflowCursor remembers, per network view and netconf, the overflow
+// network the last address was allocated from.
+type overflowCursor struct {
+	sync.Mutex
+	next map[string]int
+}
+
+func (c *overflowCursor) get(key string) int {
+	c.Lock()
+	defer c.Unlock()
+	if i, ok := c.next[key]; ok {
+		return i
+	}
+	return 1
+}
+
+func (c *overflowCursor) set(key string, i int) {
+	c.Lock()
+	defer c.Unlock()
+	if c.next == nil {
+		c.next = map[string]int{}
+	}
+	c.next[key] = i
+}
+
+// allocateIn creates the gateway of an overflow network and allocates the
+// address from it.
+func (ib *Infoblox) allocateIn(conf NetConfig, args *ExtCmdArgs, podArgs *PodArgs, pod *kubeObject, result *current.Result, netviewName string, subnet string, mac string) error {
+	if conf.IPAM.Gateway != nil {
+		if _, err := ib.Drv.CreateGateway(subnet, conf.IPAM.Gateway, netviewName); err != nil {
+			return fmt.Errorf("error creating gateway:%v", err)
+		}
+	}
+	if ib.utilization != nil {
+		ib.utilization.track(netviewName, subnet)
+	}
+	return ib.requestAddress(conf, args, podArgs, pod, result, netviewName, subnet, mac)
+}
+
+// overflowNetConf returns a copy of conf for an overflow network. Routes via
+// the gateway of the primary subnet are pointed at the overflow gateway and,
+// unless there is a default route, a route to the primary subnet is added.
+// The allocation ranges of the primary subnet do not apply.
+func overflowNetConf(conf NetConfig, name string, cidr net.IPNet, gw net.IP, primary string) NetConfig {
+	ipam := *conf.IPAM
+	ipam.Subnet = types.IPNet(cidr)
+	ipam.Gateway = gw
+	ipam.Range = ""
+	ipam.RangeStart = nil
+	ipam.RangeEnd = nil
+	ipam.Exclude = nil
+	ipam.Overflow = nil
+
+	ipam.Routes = nil
+	hasDefault := false
+	for _, r := range conf.IPAM.Routes {
+		if r.GW != nil && conf.IPAM.Gateway != nil && r.GW.Equal(conf.IPAM.Gateway) {
+			r.GW = gw
+		}
+		if ones, _ := r.Dst.Mask.Size(); ones == 0 {
+			hasDefault = true
+		}
+		ipam.Routes = append(ipam.Routes, r)
+	}
+	if gw != nil && !hasDefault {
+		if _, primaryNet, err := net.ParseCIDR(primary); err == nil {
+			ipam.Routes = append(ipam.Routes, types.Route{Dst: *primaryNet, GW: gw})
+		}
+	}
+
+	conf.Name = name
+	conf.IPAM = &ipam
+	return conf
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	. "github.com/infobloxopen/cni-infoblox"
+	"github.com/infobloxopen/cni-infoblox/fakewapi"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"net"
+)
+
+var _ = Describe("overflowNetConf", func() {
+	_, primary, _ := net.ParseCIDR("10.0.0.0/24")
+	_, other, _ := net.ParseCIDR("192.168.0.0/16")
+	_, overflow, _ := net.ParseCIDR("10.0.1.0/24")
+	conf := NetConfig{
+		Name: "yellow",
+		IPAM: &IPAMConfig{
+			Subnet:  types.IPNet(*primary),
+			Gateway: net.ParseIP("10.0.0.1"),
+			Routes:  []types.Route{{Dst: *other, GW: net.ParseIP("10.0.0.1")}},
+			Exclude: []string{"10.0.0.100-10.0.0.120"},
+			Overflow: &OverflowConfig{
+				NetworkContainer: "10.0.128.0/17",
+			},
+		},
+	}
+
+	secConf := overflowNetConf(conf, "yellow-overflow-1", *overflow, net.ParseIP("10.0.1.1"), "10.0.0.0/24")
+
+	It("Should point the IPAM configuration at the overflow network", func() {
+		Expect(secConf.Name).To(Equal("yellow-overflow-1"))
+		Expect(secConf.IPAM.Subnet.IP.String()).To(Equal("10.0.1.0"))
+		Expect(secConf.IPAM.Gateway.String()).To(Equal("10.0.1.1"))
+		Expect(secConf.IPAM.Exclude).To(BeNil())
+		Expect(secConf.IPAM.Overflow).To(BeNil())
+	})
+
+	It("Should route via the overflow gateway", func() {
+		Expect(secConf.IPAM.Routes).To(HaveLen(2))
+		Expect(secConf.IPAM.Routes[0].GW.String()).To(Equal("10.0.1.1"))
+		Expect(secConf.IPAM.Routes[1].Dst.String()).To(Equal("10.0.0.0/24"))
+		Expect(secConf.IPAM.Routes[1].GW.String()).To(Equal("10.0.1.1"))
+	})
+
+	It("Should leave the original configuration alone", func() {
+		Expect(conf.Name).To(Equal("yellow"))
+		Expect(conf.IPAM.Gateway.String()).To(Equal("10.0.0.1"))
+		Expect(conf.IPAM.Routes[0].GW.String()).To(Equal("10.0.0.1"))
+	})
+})
+
+var _ = Describe("overflowNetworkCount", func() {
+	It("Should bound the number of networks by the network container", func() {
+		Expect(overflowNetworkCount("10.0.128.0/24", 26)).To(Equal(4))
+		Expect(overflowNetworkCount("10.0.128.0/24", 24)).To(Equal(1))
+		Expect(overflowNetworkCount("10.0.0.0/8", 24)).To(Equal(maxOverflowNetworks))
+		Expect(overflowNetworkCount("10.0.128.0/24", 0)).To(Equal(maxOverflowNetworks))
+	})
+
+	It("Should refuse a prefix length that does not fit the network container", func() {
+		_, err := overflowNetworkCount("10.0.128.0/24", 16)
+		Expect(err).NotTo(BeNil())
+		_, err = overflowNetworkCount("not-a-cidr", 24)
+		Expect(err).NotTo(BeNil())
+	})
+})
+
+var _ = Describe("allocateOverflow", func() {
+	var server *fakewapi.Server
+	var ib *Infoblox
+	var settings string
+
+	BeforeEach(func() {
+		settings = "null"
+		server = fakewapi.NewServer()
+		hostConfig := server.HostConfig()
+
+		config := &Config{}
+		config.GridHost = hostConfig.Host
+		config.WapiPort = hostConfig.Port
+		config.WapiUsername = hostConfig.Username
+		config.WapiPassword = hostConfig.Password
+		config.WapiVer = hostConfig.Version
+		config.SslVerify = "false"
+		config.NetworkView = "default"
+		config.NetworkContainer = "192.168.0.0/24"
+		config.PrefixLength = uint(26)
+		config.CacheDisabled = true
+
+		ib = newInfoblox(getInfobloxDriver(config, getConnector(config)))
+		ib.tagger = &ExtAttrTagger{NodeName: "node-1"}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	allocate := func(containerID string) (*current.Result, error) {
+		args := &ExtCmdArgs{}
+		args.ContainerID = containerID
+		args.IfName = "eth0"
+		args.Args = "IgnoreUnknown=1;K8S_POD_NAMESPACE=default;K8S_POD_NAME=" + containerID
+		args.StdinData = []byte(`{"name": "yellow", "ipam": {"type": "infoblox", "network-view": "test-view", "subnet": "10.0.0.0/30", "gateway": "10.0.0.1",
+			"overflow": {"network-container": "10.0.128.0/29", "prefix-length": 30}, "network-settings": ` + settings + `}}`)
+		result := &current.Result{}
+		return result, ib.Allocate(args, result)
+	}
+
+	It("Should carve overflow networks out of the network container once the subnet is exhausted", func() {
+		result, err := allocate("pod-1")
+		Expect(err).To(BeNil())
+		Expect(result.IPs[0].Address.IP.String()).To(Equal("10.0.0.2"))
+
+		result, err = allocate("pod-2")
+		Expect(err).To(BeNil())
+		Expect(result.IPs[0].Address.IP.String()).To(Equal("10.0.128.2"))
+		Expect(result.IPs[0].Gateway.String()).To(Equal("10.0.128.1"))
+
+		result, err = allocate("pod-3")
+		Expect(err).To(BeNil())
+		Expect(result.IPs[0].Address.IP.String()).To(Equal("10.0.128.6"))
+		Expect(ib.overflowCursor.get("test-view/yellow")).To(Equal(2))
+
+		_, err = allocate("pod-4")
+		Expect(IsNetworkExhausted(err)).To(BeTrue())
+		Expect(server.Objects("network")).To(HaveLen(3))
+	})
+
+	It("Should create carved networks with the network settings, routed via their first address", func() {
+		settings = `{"comment": "Pods of the yellow network", "dns-servers": ["10.0.0.53"]}`
+		_, err := allocate("pod-1")
+		Expect(err).To(BeNil())
+		_, err = allocate("pod-2")
+		Expect(err).To(BeNil())
+
+		var comments []string
+		for _, network := range server.Objects("network") {
+			comments = append(comments, network.String("network")+" "+network.String("comment"))
+		}
+		Expect(comments).To(ContainElement("10.0.128.0/30 Pods of the yellow network"))
+		options, err := ib.Drv.GetDHCPOptions("test-view", "10.0.128.0/30")
+		Expect(err).To(BeNil())
+		Expect(options.Routers).To(Equal([]net.IP{net.ParseIP("10.0.128.1")}))
+		Expect(options.DomainNameServers).To(Equal([]string{"10.0.0.53"}))
+	})
+})
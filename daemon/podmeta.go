@@ -0,0 +1,95 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	. "github.com/infobloxopen/cni-infoblox"
+	ibclient "github.com/infobloxopen/infoblox-go-client"
+)
+
+// podEAMapping lists the pod labels and annotations that are copied to the
+// extensible attributes of the pod's fixed address, and the EA each of them
+// is copied to.
+type podEAMapping struct {
+	labels      map[string]string
+	annotations map[string]string
+}
+
+func newPodEAMapping(config *Config) (*podEAMapping, error) {
+	labels, err := ParseEAMapping(config.PodLabelEAs)
+	if err != nil {
+		return nil, err
+	}
+	annotations, err := ParseEAMapping(config.PodAnnotationEAs)
+	if err != nil {
+		return nil, err
+	}
+	return &podEAMapping{labels: labels, annotations: annotations}, nil
+}
+
+func (m *podEAMapping) empty() bool {
+	return len(m.labels) == 0 && len(m.annotations) == 0
+}
+
+// eaNames returns the names of all EAs the mapping may set.
+func (m *podEAMapping) eaNames() []string {
+	var names []string
+	for _, name := range m.labels {
+		names = append(names, name)
+	}
+	for _, name := range m.annotations {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (m *podEAMapping) extAttrs(pod *kubeObject) ibclient.EA {
+	ea := ibclient.EA{}
+	for key, name := range m.labels {
+		if value, ok := pod.Metadata.Labels[key]; ok && value != "" {
+			ea[name] = value
+		}
+	}
+	for key, name := range m.annotations {
+		if value, ok := pod.Metadata.Annotations[key]; ok && value != "" {
+			ea[name] = value
+		}
+	}
+	return ea
+}
+
+// lookupPod fetches the pod from the Kubernetes API if any of the pod based
+// features is enabled. It returns nil if the pod is not needed or cannot be
+// identified from CNI_ARGS.
+func (ib *Infoblox) lookupPod(podArgs *PodArgs) (*kubeObject, error) {
+	if ib.kube == nil || podArgs.PodName() == "" {
+		return nil, nil
+	}
+	lowPriority := ib.utilization != nil && ib.utilization.highWater > 0
+	if ib.podEAs == nil && !ib.podNetworks && !lowPriority && ib.sticky == nil {
+		return nil, nil
+	}
+	return ib.kube.getPod(podArgs.Namespace(), podArgs.PodName())
+}
+
+// podMetadataEA returns the EAs for the whitelisted labels and annotations
+// of the pod.
+func (ib *Infoblox) podMetadataEA(pod *kubeObject) ibclient.EA {
+	if ib.podEAs == nil || pod == nil {
+		return nil
+	}
+	return ib.podEAs.extAttrs(pod)
+}
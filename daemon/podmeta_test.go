@@ -0,0 +1,88 @@
+package main
+
+import (
+	current "github.com/containernetworking/cni/pkg/types/100"
+	. "github.com/infobloxopen/cni-infoblox"
+	"github.com/infobloxopen/cni-infoblox/fakewapi"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+)
+
+var _ = Describe("Pod metadata EAs", func() {
+	var server *fakewapi.Server
+	var kube *httptest.Server
+
+	BeforeEach(func() {
+		server = fakewapi.NewServer()
+		kube = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Path).To(Equal("/api/v1/namespaces/default/pods/test-pod"))
+			pod := kubeObject{Metadata: objectMeta{
+				Name:      "test-pod",
+				Namespace: "default",
+				Labels: map[string]string{
+					"app":     "web",
+					"tier":    "",
+					"release": "canary",
+				},
+				Annotations: map[string]string{
+					"owner":  "team-a",
+					"secret": "s3cr3t",
+				},
+			}}
+			json.NewEncoder(w).Encode(pod)
+		}))
+	})
+
+	AfterEach(func() {
+		kube.Close()
+		server.Close()
+	})
+
+	It("Should copy the whitelisted pod labels and annotations to the fixed address", func() {
+		hostConfig := server.HostConfig()
+
+		config := &Config{}
+		config.GridHost = hostConfig.Host
+		config.WapiPort = hostConfig.Port
+		config.WapiUsername = hostConfig.Username
+		config.WapiPassword = hostConfig.Password
+		config.WapiVer = hostConfig.Version
+		config.SslVerify = "false"
+		config.NetworkView = "default"
+		config.NetworkContainer = "192.168.0.0/24"
+		config.PrefixLength = uint(26)
+		config.CacheDisabled = true
+		config.PodLabelEAs = "app=K8S App,tier=K8S Tier"
+		config.PodAnnotationEAs = "owner=K8S Owner"
+
+		ib := newInfoblox(getInfobloxDriver(config, getConnector(config)))
+		ib.tagger = &ExtAttrTagger{NodeName: "node-1"}
+		ib.kube = &kubeClient{host: kube.URL, client: kube.Client()}
+		var err error
+		ib.podEAs, err = newPodEAMapping(config)
+		Expect(err).To(BeNil())
+
+		args := &ExtCmdArgs{}
+		args.ContainerID = "abcdef123456"
+		args.IfName = "eth0"
+		args.IfMac = "11:22:33:44:55:66"
+		args.Args = "IgnoreUnknown=1;K8S_POD_NAMESPACE=default;K8S_POD_NAME=test-pod"
+		args.StdinData = []byte(`{"name": "yellow", "ipam": {"type": "infoblox", "network-view": "test-view", "subnet": "192.168.30.0/24"}}`)
+
+		Expect(ib.Allocate(args, &current.Result{})).To(BeNil())
+
+		fixedAddrs := server.Objects("fixedaddress")
+		Expect(fixedAddrs).To(HaveLen(1))
+		Expect(fixedAddrs[0].EA("K8S App")).To(Equal("web"))
+		Expect(fixedAddrs[0].EA("K8S Owner")).To(Equal("team-a"))
+		Expect(fixedAddrs[0].EA("K8S Tier")).To(BeNil())
+		for name := range fixedAddrs[0]["extattrs"].(map[string]interface{}) {
+			Expect(fixedAddrs[0].EA(name)).NotTo(BeElementOf("canary", "s3cr3t"))
+		}
+	})
+})
@@ -0,0 +1,138 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+	. "github.com/infobloxopen/cni-infoblox"
+)
+
+// Pod annotation that selects a named Infoblox network. The network view and
+// subnet annotations are shared with namespaces.
+const annotationNetwork = "infoblox.com/network"
+
+// applyPodNetwork points the IPAM configuration at the network view, subnet
+// or named network requested by the pod's annotations. Requests are checked
+// against the allowlists of the IPAM configuration, so that pods cannot
+// allocate from arbitrary parts of the grid. The pod of a namespace mapped
+// to a network of its own, nsNet, may only request a network within it.
+func (ib *Infoblox) applyPodNetwork(conf *NetConfig, pod *kubeObject, nsNet *NamespaceNetwork) error {
+	if !ib.podNetworks || pod == nil || conf.IPAM == nil {
+		return nil
+	}
+
+	podName := pod.Metadata.Namespace + "/" + pod.Metadata.Name
+	netviewName := pod.Metadata.Annotations[annotationNetworkView]
+	subnet := pod.Metadata.Annotations[annotationSubnet]
+	networkName := pod.Metadata.Annotations[annotationNetwork]
+	if netviewName == "" && subnet == "" && networkName == "" {
+		return nil
+	}
+	if subnet != "" && networkName != "" {
+		return fmt.Errorf("pod '%s' requests both subnet '%s' and network '%s'", podName, subnet, networkName)
+	}
+
+	if netviewName != "" {
+		// conf already points at the view of a mapped namespace.
+		if nsNet != nil && netviewName != conf.IPAM.NetworkView {
+			return fmt.Errorf("pod '%s' requests network view '%s', but its namespace is mapped to network view '%s'", podName, netviewName, conf.IPAM.NetworkView)
+		}
+		if !conf.IPAM.AllowsNetworkView(netviewName) {
+			return fmt.Errorf("pod '%s' requests network view '%s', which is not allowed", podName, netviewName)
+		}
+		conf.IPAM.NetworkView = netviewName
+	}
+
+	var cidr *net.IPNet
+	var name string
+	switch {
+	case networkName != "":
+		if !conf.IPAM.AllowsNetwork(networkName) {
+			return fmt.Errorf("pod '%s' requests network '%s', which is not allowed", podName, networkName)
+		}
+		network, err := ib.Drv.FindNetwork(conf.IPAM.NetworkView, "", networkName)
+		if err != nil {
+			return fmt.Errorf("error looking up network '%s': %v", networkName, err)
+		}
+		if network == nil {
+			return fmt.Errorf("network '%s' requested by pod '%s' does not exist in network view '%s'", networkName, podName, conf.IPAM.NetworkView)
+		}
+		if _, cidr, err = net.ParseCIDR(network.Cidr); err != nil {
+			return fmt.Errorf("network '%s' has an invalid CIDR '%s': %v", networkName, network.Cidr, err)
+		}
+		name = networkName
+	case subnet != "":
+		var err error
+		if _, cidr, err = net.ParseCIDR(subnet); err != nil {
+			return fmt.Errorf("invalid subnet '%s' requested by pod '%s': %v", subnet, podName, err)
+		}
+		if !conf.IPAM.AllowsSubnet(cidr) {
+			return fmt.Errorf("pod '%s' requests subnet '%s', which is not allowed", podName, cidr)
+		}
+		// Networks are identified by name on the grid, so an existing
+		// network keeps its name and a new one gets a name of its own.
+		name = subnetNetworkName(conf.Name, cidr.String())
+		network, err := ib.Drv.FindNetwork(conf.IPAM.NetworkView, cidr.String(), "")
+		if err != nil {
+			return fmt.Errorf("error looking up subnet '%s': %v", cidr, err)
+		}
+		if network != nil {
+			if n, ok := network.Ea["Network Name"].(string); ok && n != "" {
+				name = n
+			}
+		}
+	}
+
+	if cidr != nil && nsNet != nil && nsNet.Subnet != "" {
+		_, nsSubnet, err := net.ParseCIDR(nsNet.Subnet)
+		if err != nil {
+			return fmt.Errorf("invalid subnet '%s' for namespace '%s': %v", nsNet.Subnet, pod.Metadata.Namespace, err)
+		}
+		if !containsNet(nsSubnet, cidr) {
+			return fmt.Errorf("pod '%s' requests subnet '%s', but its namespace is mapped to subnet '%s'", podName, cidr, nsSubnet)
+		}
+	}
+
+	if cidr != nil {
+		conf.IPAM.Subnet = types.IPNet(*cidr)
+		// The gateway of the configured subnet does not apply to the
+		// requested one.
+		conf.IPAM.Gateway = nil
+		conf.Name = name
+	}
+
+	subnetStr := (&net.IPNet{IP: conf.IPAM.Subnet.IP, Mask: conf.IPAM.Subnet.Mask}).String()
+	log.Printf("Pod '%s' requests network view '%s', subnet '%s'", podName, conf.IPAM.NetworkView, subnetStr)
+	return nil
+}
+
+// containsNet reports whether subnet lies within outer.
+func containsNet(outer *net.IPNet, subnet *net.IPNet) bool {
+	outerOnes, _ := outer.Mask.Size()
+	ones, _ := subnet.Mask.Size()
+	return ones >= outerOnes && outer.Contains(subnet.IP)
+}
+
+// subnetNetworkName returns the name of the Infoblox network created for
+// an additional subnet of the CNI network.
+func subnetNetworkName(name string, cidr string) string {
+	return name + "-" + strings.Replace(cidr, "/", "_", 1)
+}
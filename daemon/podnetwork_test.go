@@ -0,0 +1,201 @@
+package main
+
+import (
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	. "github.com/infobloxopen/cni-infoblox"
+	"github.com/infobloxopen/cni-infoblox/fakewapi"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+)
+
+var _ = Describe("Pod networks", func() {
+	var server *fakewapi.Server
+	var ib *Infoblox
+
+	BeforeEach(func() {
+		server = fakewapi.NewServer()
+		hostConfig := server.HostConfig()
+
+		config := &Config{}
+		config.GridHost = hostConfig.Host
+		config.WapiPort = hostConfig.Port
+		config.WapiUsername = hostConfig.Username
+		config.WapiPassword = hostConfig.Password
+		config.WapiVer = hostConfig.Version
+		config.SslVerify = "false"
+		config.NetworkView = "default"
+		config.NetworkContainer = "192.168.0.0/24"
+		config.PrefixLength = uint(26)
+		config.CacheDisabled = true
+
+		ib = newInfoblox(getInfobloxDriver(config, getConnector(config)))
+		ib.tagger = &ExtAttrTagger{NodeName: "node-1"}
+		ib.podNetworks = true
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("applyPodNetwork", func() {
+		_, subnet, _ := net.ParseCIDR("192.168.30.0/24")
+
+		newConf := func() NetConfig {
+			return NetConfig{
+				Name: "yellow",
+				IPAM: &IPAMConfig{
+					NetworkView:         "test-view",
+					Subnet:              types.IPNet(*subnet),
+					Gateway:             net.ParseIP("192.168.30.1"),
+					AllowedNetworkViews: []string{"tenant_a"},
+					AllowedSubnets:      []string{"10.10.0.0/16"},
+					AllowedNetworks:     []string{"frontend"},
+				},
+			}
+		}
+
+		newPod := func(annotations map[string]string) *kubeObject {
+			return &kubeObject{Metadata: objectMeta{Name: "test-pod", Namespace: "default", Annotations: annotations}}
+		}
+
+		subnetOf := func(conf NetConfig) string {
+			return (&net.IPNet{IP: conf.IPAM.Subnet.IP, Mask: conf.IPAM.Subnet.Mask}).String()
+		}
+
+		It("Should leave the config alone without pod annotations", func() {
+			conf := newConf()
+			Expect(ib.applyPodNetwork(&conf, newPod(nil), nil)).To(BeNil())
+			Expect(conf).To(Equal(newConf()))
+
+			ib.podNetworks = false
+			Expect(ib.applyPodNetwork(&conf, newPod(map[string]string{annotationNetworkView: "tenant_a"}), nil)).To(BeNil())
+			Expect(conf.IPAM.NetworkView).To(Equal("test-view"))
+		})
+
+		It("Should point the config at an allowed network view", func() {
+			conf := newConf()
+			Expect(ib.applyPodNetwork(&conf, newPod(map[string]string{annotationNetworkView: "tenant_a"}), nil)).To(BeNil())
+			Expect(conf.IPAM.NetworkView).To(Equal("tenant_a"))
+			Expect(subnetOf(conf)).To(Equal("192.168.30.0/24"))
+			Expect(conf.IPAM.Gateway.String()).To(Equal("192.168.30.1"))
+		})
+
+		It("Should point the config at an allowed subnet and name a new network after it", func() {
+			conf := newConf()
+			Expect(ib.applyPodNetwork(&conf, newPod(map[string]string{annotationSubnet: "10.10.1.0/24"}), nil)).To(BeNil())
+			Expect(subnetOf(conf)).To(Equal("10.10.1.0/24"))
+			Expect(conf.IPAM.Gateway).To(BeNil())
+			Expect(conf.Name).To(Equal("yellow-10.10.1.0_24"))
+		})
+
+		It("Should keep the name of an existing network for an allowed subnet", func() {
+			_, err := server.AddNetworkView("test-view", nil)
+			Expect(err).To(BeNil())
+			_, err = server.AddNetwork("test-view", "10.10.2.0/24", map[string]interface{}{"Network Name": "green"})
+			Expect(err).To(BeNil())
+
+			conf := newConf()
+			Expect(ib.applyPodNetwork(&conf, newPod(map[string]string{annotationSubnet: "10.10.2.0/24"}), nil)).To(BeNil())
+			Expect(conf.Name).To(Equal("green"))
+		})
+
+		It("Should point the config at an allowed named network", func() {
+			_, err := server.AddNetworkView("test-view", nil)
+			Expect(err).To(BeNil())
+			_, err = server.AddNetwork("test-view", "10.10.3.0/24", map[string]interface{}{"Network Name": "frontend"})
+			Expect(err).To(BeNil())
+
+			conf := newConf()
+			Expect(ib.applyPodNetwork(&conf, newPod(map[string]string{annotationNetwork: "frontend"}), nil)).To(BeNil())
+			Expect(subnetOf(conf)).To(Equal("10.10.3.0/24"))
+			Expect(conf.IPAM.Gateway).To(BeNil())
+			Expect(conf.Name).To(Equal("frontend"))
+		})
+
+		It("Should refuse requests outside the allowlists", func() {
+			for _, annotations := range []map[string]string{
+				{annotationNetworkView: "tenant_b"},
+				{annotationSubnet: "10.20.0.0/24"},
+				{annotationSubnet: "10.0.0.0/8"},
+				{annotationNetwork: "backend"},
+				{annotationSubnet: "10.10.1.0/24", annotationNetwork: "frontend"},
+				{annotationSubnet: "not-a-subnet"},
+			} {
+				conf := newConf()
+				Expect(ib.applyPodNetwork(&conf, newPod(annotations), nil)).NotTo(BeNil(), "%v", annotations)
+			}
+		})
+
+		It("Should keep the pod of a mapped namespace within the namespace's network", func() {
+			nsNet := &NamespaceNetwork{NetworkView: "tenant_a", Subnet: "10.10.0.0/20"}
+			newNsConf := func() NetConfig {
+				conf := newConf()
+				Expect(applyNamespaceNetwork(&conf, "default", nsNet)).To(BeNil())
+				return conf
+			}
+
+			for _, annotations := range []map[string]string{
+				{annotationNetworkView: "test-view"},
+				{annotationSubnet: "10.10.16.0/24"},
+				{annotationSubnet: "10.10.0.0/16"},
+			} {
+				conf := newNsConf()
+				Expect(ib.applyPodNetwork(&conf, newPod(annotations), nsNet)).NotTo(BeNil(), "%v", annotations)
+			}
+
+			conf := newNsConf()
+			annotations := map[string]string{annotationNetworkView: "tenant_a", annotationSubnet: "10.10.1.0/24"}
+			Expect(ib.applyPodNetwork(&conf, newPod(annotations), nsNet)).To(BeNil())
+			Expect(conf.IPAM.NetworkView).To(Equal("tenant_a"))
+			Expect(subnetOf(conf)).To(Equal("10.10.1.0/24"))
+		})
+
+		It("Should fail for an allowed network that does not exist", func() {
+			conf := newConf()
+			Expect(ib.applyPodNetwork(&conf, newPod(map[string]string{annotationNetwork: "frontend"}), nil)).NotTo(BeNil())
+		})
+	})
+
+	Describe("Release", func() {
+		It("Should find the addresses of a deleted pod in the network view it requested", func() {
+			podGone := false
+			kube := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if podGone {
+					http.Error(w, "pod not found", http.StatusNotFound)
+					return
+				}
+				pod := kubeObject{Metadata: objectMeta{
+					Name:        "test-pod",
+					Namespace:   "default",
+					Annotations: map[string]string{annotationNetworkView: "tenant_a"},
+				}}
+				json.NewEncoder(w).Encode(pod)
+			}))
+			defer kube.Close()
+			ib.kube = &kubeClient{host: kube.URL, client: kube.Client()}
+
+			args := &ExtCmdArgs{}
+			args.ContainerID = "abcdef123456"
+			args.IfName = "eth0"
+			args.IfMac = "11:22:33:44:55:66"
+			args.Args = "IgnoreUnknown=1;K8S_POD_NAMESPACE=default;K8S_POD_NAME=test-pod"
+			args.StdinData = []byte(`{"name": "yellow", "ipam": {"type": "infoblox", "network-view": "test-view", "subnet": "192.168.30.0/24", "allowed-network-views": ["tenant_a"]}}`)
+
+			Expect(ib.Allocate(args, &current.Result{})).To(BeNil())
+			fixedAddrs := server.Objects("fixedaddress")
+			Expect(fixedAddrs).To(HaveLen(1))
+			Expect(fixedAddrs[0].String("network_view")).To(Equal("tenant_a"))
+
+			podGone = true
+			Expect(ib.Release(args, nil)).To(BeNil())
+			Expect(server.Objects("fixedaddress")).To(BeEmpty())
+		})
+	})
+})
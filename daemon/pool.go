@@ -0,0 +1,411 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	. "github.com/infobloxopen/cni-infoblox"
+	ibclient "github.com/infobloxopen/infoblox-go-client"
+)
+
+// Number of times the hand-over of a pooled address to a container is
+// attempted before giving up.
+const poolAssignRetries = 3
+
+// Delay before retrying a failed hand-over, multiplied by the attempt.
+var poolAssignBackoff = time.Second
+
+type poolKey struct {
+	netview string
+	cidr    string
+}
+
+// handover is a pooled address given to a container's interface, along with
+// the values it is to be tagged with on the grid.
+type handover struct {
+	containerID string
+	fixedAddr   *ibclient.FixedAddress
+	macAddr     string
+	name        string
+	ea          ibclient.EA
+}
+
+// warmPool keeps a number of fixed addresses reserved on the grid for every
+// subnet this node allocates from, so that Allocate can answer without waiting
+// for next-available-ip. Reserved addresses are handed over to the container
+// asynchronously and the pool is topped up in the background. Reservations
+// carry the pool's EAs, so that a restarted daemon reclaims the ones left
+// behind instead of leaking them. An address whose hand-over fails has
+// already been given to a container, so it stays reserved and the hand-over
+// is retried in the background.
+type warmPool struct {
+	drv      IBInfobloxDriver
+	size     int
+	interval time.Duration
+	name     string
+	ea       ibclient.EA
+
+	mu        sync.Mutex
+	subnets   map[poolKey][]*ibclient.FixedAddress
+	pending   map[string]chan struct{}
+	failed    map[string]*handover
+	reclaimed map[string]bool
+	draining  bool
+
+	refill chan poolKey
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newWarmPool(drv IBInfobloxDriver, size int, interval time.Duration, name string, nodeName string, clusterName string) *warmPool {
+	ea := ibclient.EA{EA_WARM_POOL: nodeName, EA_NODE_NAME: nodeName}
+	if clusterName != "" {
+		ea[EA_CLUSTER_NAME] = clusterName
+	}
+	return &warmPool{
+		drv:       drv,
+		size:      size,
+		interval:  interval,
+		name:      name,
+		ea:        ea,
+		subnets:   make(map[poolKey][]*ibclient.FixedAddress),
+		pending:   make(map[string]chan struct{}),
+		failed:    make(map[string]*handover),
+		reclaimed: make(map[string]bool),
+		refill:    make(chan poolKey, 16),
+		stop:      make(chan struct{}),
+	}
+}
+
+// start reclaims the reservations left behind in the given network views
+// and starts topping up the pool in the background.
+func (p *warmPool) start(netviews []string) {
+	p.wg.Add(1)
+	go p.run(netviews)
+}
+
+func (p *warmPool) run(netviews []string) {
+	defer p.wg.Done()
+
+	for _, netview := range netviews {
+		p.reclaim(netview)
+	}
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case key := <-p.refill:
+			p.fill(key)
+		case <-ticker.C:
+			p.retryHandovers()
+			for _, key := range p.keys() {
+				p.fill(key)
+			}
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+func (p *warmPool) keys() []poolKey {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	keys := make([]poolKey, 0, len(p.subnets))
+	for key := range p.subnets {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// handedOut reports whether the reservation has been given to a container
+// whose hand-over has not completed yet.
+func (p *warmPool) handedOut(ref string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, h := range p.failed {
+		if h.fixedAddr.Ref == ref {
+			return true
+		}
+	}
+	return false
+}
+
+// reclaim takes the reservations of this node's pool in the network view,
+// left behind by a previous run of the daemon, back into the pool if their
+// subnet is in use. The others are returned to the grid. Each view is only
+// reclaimed once.
+func (p *warmPool) reclaim(netview string) {
+	p.mu.Lock()
+	done := p.reclaimed[netview]
+	p.reclaimed[netview] = true
+	p.mu.Unlock()
+	if done {
+		return
+	}
+
+	allocations, err := p.drv.ListAddresses(netview, p.ea)
+	if err != nil {
+		log.Printf("warmPool: cannot look up reserved addresses in view '%s': %s", netview, err)
+		return
+	}
+	for _, allocation := range allocations {
+		if p.handedOut(allocation.Ref) {
+			continue
+		}
+		key := poolKey{netview: netview, cidr: allocation.Cidr}
+		fixedAddr := &ibclient.FixedAddress{
+			Ref:         allocation.Ref,
+			NetviewName: netview,
+			Cidr:        allocation.Cidr,
+			IPAddress:   allocation.IPAddress,
+		}
+
+		p.mu.Lock()
+		addrs, known := p.subnets[key]
+		keep := known && len(addrs) < p.size
+		if keep {
+			p.subnets[key] = append(p.subnets[key], fixedAddr)
+		}
+		p.mu.Unlock()
+
+		if keep {
+			log.Printf("warmPool: reclaimed reserved address '%s' in '%s' (view '%s')", allocation.IPAddress, allocation.Cidr, netview)
+		} else {
+			p.release(fixedAddr)
+		}
+	}
+}
+
+func (p *warmPool) release(fixedAddr *ibclient.FixedAddress) {
+	if _, err := p.drv.ReleaseAddress(fixedAddr.NetviewName, fixedAddr.IPAddress, ""); err != nil {
+		log.Printf("warmPool: failed to return '%s' to the grid: %s", fixedAddr.IPAddress, err)
+	}
+}
+
+// fill reserves addresses in the given subnet until the pool holds size
+// entries for it.
+func (p *warmPool) fill(key poolKey) {
+	p.reclaim(key.netview)
+
+	p.mu.Lock()
+	missing := p.size - len(p.subnets[key])
+	p.mu.Unlock()
+
+	for i := 0; i < missing; i++ {
+		fixedAddr, err := p.drv.ReserveAddress(key.netview, key.cidr, p.name, p.ea)
+		if err != nil {
+			log.Printf("warmPool: cannot reserve address in '%s' (view '%s'): %s", key.cidr, key.netview, err)
+			return
+		}
+		p.mu.Lock()
+		p.subnets[key] = append(p.subnets[key], fixedAddr)
+		p.mu.Unlock()
+	}
+	if missing > 0 {
+		log.Printf("warmPool: reserved %d address(es) in '%s' (view '%s')", missing, key.cidr, key.netview)
+	}
+}
+
+func (p *warmPool) triggerRefill(key poolKey) {
+	select {
+	case p.refill <- key:
+	default:
+		// A refill is already queued; the ticker catches up otherwise.
+	}
+}
+
+// get takes a reserved address for the given subnet out of the pool. It
+// returns nil if the pool is empty, in which case the caller should allocate
+// the address directly. The first call for a subnet registers it with the pool.
+func (p *warmPool) get(netview string, cidr string) *ibclient.FixedAddress {
+	key := poolKey{netview: netview, cidr: cidr}
+
+	p.mu.Lock()
+	if p.draining {
+		p.mu.Unlock()
+		return nil
+	}
+	addrs, known := p.subnets[key]
+	if !known {
+		p.subnets[key] = nil
+	}
+	var fixedAddr *ibclient.FixedAddress
+	if len(addrs) > 0 {
+		fixedAddr = addrs[0]
+		p.subnets[key] = addrs[1:]
+	}
+	p.mu.Unlock()
+
+	p.triggerRefill(key)
+	return fixedAddr
+}
+
+// assign hands a pooled address over to a container in the background.
+// Hand-overs are tracked per interface, as a container may get addresses
+// for several interfaces at once. An address that cannot be handed over is
+// kept reserved, as the container already uses it, and the hand-over is
+// retried along with the refill.
+func (p *warmPool) assign(containerID string, ifName string, fixedAddr *ibclient.FixedAddress, macAddr string, name string, ea ibclient.EA) {
+	done := make(chan struct{})
+	key := containerID + "/" + ifName
+	h := &handover{containerID: containerID, fixedAddr: fixedAddr, macAddr: macAddr, name: name, ea: ea}
+
+	p.mu.Lock()
+	p.pending[key] = done
+	p.mu.Unlock()
+
+	go func() {
+		var err error
+		for attempt := 1; attempt <= poolAssignRetries; attempt++ {
+			if err = p.handOver(h); err == nil {
+				break
+			}
+			time.Sleep(time.Duration(attempt) * poolAssignBackoff)
+		}
+
+		p.mu.Lock()
+		if err != nil {
+			log.Printf("warmPool: failed to assign '%s' to container '%s', keeping it reserved: %s", fixedAddr.IPAddress, containerID, err)
+			p.failed[key] = h
+		}
+		delete(p.pending, key)
+		p.mu.Unlock()
+		close(done)
+	}()
+}
+
+func (p *warmPool) handOver(h *handover) error {
+	ea := ibclient.EA{EA_WARM_POOL: nil}
+	for k, v := range h.ea {
+		ea[k] = v
+	}
+	_, err := p.drv.UpdateAddress(h.fixedAddr.Ref, h.macAddr, h.name, h.containerID, ea)
+	return err
+}
+
+// retryHandovers retries the hand-overs that failed so far.
+func (p *warmPool) retryHandovers() {
+	p.mu.Lock()
+	failed := make(map[string]*handover, len(p.failed))
+	for key, h := range p.failed {
+		failed[key] = h
+	}
+	p.mu.Unlock()
+
+	for key, h := range failed {
+		if err := p.handOver(h); err != nil {
+			log.Printf("warmPool: failed to assign '%s' to container '%s': %s", h.fixedAddr.IPAddress, h.containerID, err)
+			continue
+		}
+		p.mu.Lock()
+		if p.failed[key] == h {
+			delete(p.failed, key)
+		}
+		p.mu.Unlock()
+	}
+}
+
+// wait blocks until a pending hand-over for the container's interface has
+// completed. It returns the address given to the interface if the hand-over
+// failed, as the grid does not know yet that the container holds it.
+func (p *warmPool) wait(containerID string, ifName string) *ibclient.FixedAddress {
+	key := containerID + "/" + ifName
+
+	p.mu.Lock()
+	done, ok := p.pending[key]
+	p.mu.Unlock()
+
+	if ok {
+		<-done
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if h, ok := p.failed[key]; ok {
+		return h.fixedAddr
+	}
+	return nil
+}
+
+// abandon returns the address given to the container's interface to the
+// grid if its hand-over failed, once the container is gone.
+func (p *warmPool) abandon(containerID string, ifName string) {
+	key := containerID + "/" + ifName
+
+	p.mu.Lock()
+	h, ok := p.failed[key]
+	delete(p.failed, key)
+	p.mu.Unlock()
+
+	if ok {
+		p.release(h.fixedAddr)
+	}
+}
+
+// drain stops the background refill and returns all reserved addresses to
+// the grid, including any left behind in the views the pool has seen. The
+// addresses given to containers whose hand-over failed stay reserved.
+func (p *warmPool) drain() {
+	p.mu.Lock()
+	p.draining = true
+	p.mu.Unlock()
+
+	close(p.stop)
+	p.wg.Wait()
+
+	p.mu.Lock()
+	pending := make([]chan struct{}, 0, len(p.pending))
+	for _, done := range p.pending {
+		pending = append(pending, done)
+	}
+	p.mu.Unlock()
+	for _, done := range pending {
+		<-done
+	}
+
+	p.mu.Lock()
+	subnets := p.subnets
+	p.subnets = make(map[poolKey][]*ibclient.FixedAddress)
+	p.mu.Unlock()
+
+	for _, addrs := range subnets {
+		for _, fixedAddr := range addrs {
+			p.release(fixedAddr)
+		}
+	}
+	for netview := range p.reclaimed {
+		allocations, err := p.drv.ListAddresses(netview, p.ea)
+		if err != nil {
+			log.Printf("warmPool: cannot look up reserved addresses in view '%s': %s", netview, err)
+			continue
+		}
+		for _, allocation := range allocations {
+			if p.handedOut(allocation.Ref) {
+				log.Printf("warmPool: keeping '%s' reserved, it is in use by a container", allocation.IPAddress)
+				continue
+			}
+			p.release(&ibclient.FixedAddress{NetviewName: netview, IPAddress: allocation.IPAddress})
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	current "github.com/containernetworking/cni/pkg/types/100"
+	. "github.com/infobloxopen/cni-infoblox"
+	"github.com/infobloxopen/cni-infoblox/fakewapi"
+	ibclient "github.com/infobloxopen/infoblox-go-client"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"net/http"
+	"time"
+)
+
+var _ = Describe("Warm pool", func() {
+	testView := "test-view"
+	testCidr := "192.168.30.0/24"
+	testKey := poolKey{netview: testView, cidr: testCidr}
+
+	var server *fakewapi.Server
+	var drv *InfobloxDriver
+
+	BeforeEach(func() {
+		server = fakewapi.NewServer()
+		hostConfig := server.HostConfig()
+
+		config := &Config{}
+		config.GridHost = hostConfig.Host
+		config.WapiPort = hostConfig.Port
+		config.WapiUsername = hostConfig.Username
+		config.WapiPassword = hostConfig.Password
+		config.WapiVer = hostConfig.Version
+		config.SslVerify = "false"
+		config.NetworkView = "default"
+		config.NetworkContainer = "192.168.0.0/24"
+		config.PrefixLength = uint(26)
+		config.CacheDisabled = true
+		drv = getInfobloxDriver(config, getConnector(config))
+
+		_, err := server.AddNetworkView(testView, nil)
+		Expect(err).To(BeNil())
+		_, err = server.AddNetwork(testView, testCidr, map[string]interface{}{"Network Name": "yellow"})
+		Expect(err).To(BeNil())
+
+		poolAssignBackoff = time.Millisecond
+	})
+
+	AfterEach(func() {
+		server.Close()
+		poolAssignBackoff = time.Second
+	})
+
+	newPool := func() *warmPool {
+		return newWarmPool(drv, 2, time.Hour, "warm-pool-node-1", "node-1", "test-cluster")
+	}
+
+	reserved := func() []fakewapi.Object {
+		var res []fakewapi.Object
+		for _, obj := range server.Objects("fixedaddress") {
+			if obj.EA(EA_WARM_POOL) == "node-1" {
+				res = append(res, obj)
+			}
+		}
+		return res
+	}
+
+	It("Should reserve addresses tagged with the node and cluster up to the pool size", func() {
+		pool := newPool()
+		Expect(pool.get(testView, testCidr)).To(BeNil())
+
+		pool.fill(testKey)
+		Expect(pool.subnets[testKey]).To(HaveLen(2))
+		Expect(reserved()).To(HaveLen(2))
+		for _, obj := range reserved() {
+			Expect(obj.EA(EA_NODE_NAME)).To(Equal("node-1"))
+			Expect(obj.EA(EA_CLUSTER_NAME)).To(Equal("test-cluster"))
+		}
+
+		pool.fill(testKey)
+		Expect(server.Objects("fixedaddress")).To(HaveLen(2))
+	})
+
+	It("Should hand out reserved addresses and hand them over to the container", func() {
+		pool := newPool()
+		pool.get(testView, testCidr)
+		pool.fill(testKey)
+
+		fixedAddr := pool.get(testView, testCidr)
+		Expect(fixedAddr).NotTo(BeNil())
+		Expect(fixedAddr.IPAddress).To(Equal("192.168.30.1"))
+		Expect(pool.subnets[testKey]).To(HaveLen(1))
+
+		pool.assign("container-1", "eth0", fixedAddr, "11:22:33:44:55:66", "pod-1", ibclient.EA{EA_POD_NAME: "pod-1"})
+		pool.wait("container-1", "eth0")
+
+		assigned := server.Get(fixedAddr.Ref)
+		Expect(assigned.String("mac")).To(Equal("11:22:33:44:55:66"))
+		Expect(assigned.EA(EA_VM_ID)).To(Equal("container-1"))
+		Expect(assigned.EA(EA_POD_NAME)).To(Equal("pod-1"))
+		Expect(assigned.EA(EA_WARM_POOL)).To(BeNil())
+		Expect(reserved()).To(HaveLen(1))
+	})
+
+	It("Should keep an address reserved and retry its hand-over if it cannot be handed over", func() {
+		pool := newPool()
+		pool.get(testView, testCidr)
+		pool.fill(testKey)
+		fixedAddr := pool.get(testView, testCidr)
+
+		server.Fail(http.MethodPut, "fixedaddress", "update failed")
+		pool.assign("container-1", "eth0", fixedAddr, "11:22:33:44:55:66", "pod-1", nil)
+		Expect(pool.wait("container-1", "eth0")).To(Equal(fixedAddr))
+		Expect(server.Get(fixedAddr.Ref)).NotTo(BeNil())
+
+		pool.drain()
+		Expect(server.Objects("fixedaddress")).To(HaveLen(1))
+		Expect(server.Get(fixedAddr.Ref)).NotTo(BeNil())
+
+		server.ClearFailures()
+		pool.retryHandovers()
+		Expect(pool.wait("container-1", "eth0")).To(BeNil())
+		Expect(server.Get(fixedAddr.Ref).EA(EA_WARM_POOL)).To(BeNil())
+	})
+
+	It("Should return an address whose hand-over failed to the grid once its container is gone", func() {
+		pool := newPool()
+		pool.get(testView, testCidr)
+		pool.fill(testKey)
+		fixedAddr := pool.get(testView, testCidr)
+
+		server.Fail(http.MethodPut, "fixedaddress", "update failed")
+		pool.assign("container-1", "eth0", fixedAddr, "11:22:33:44:55:66", "pod-1", nil)
+		pool.wait("container-1", "eth0")
+
+		pool.abandon("container-1", "eth0")
+		Expect(server.Get(fixedAddr.Ref)).To(BeNil())
+		Expect(pool.wait("container-1", "eth0")).To(BeNil())
+	})
+
+	It("Should not block waiting for a container without a pending hand-over", func() {
+		pool := newPool()
+		done := make(chan struct{})
+		go func() {
+			pool.wait("container-1", "eth0")
+			close(done)
+		}()
+		Eventually(done).Should(BeClosed())
+	})
+
+	It("Should return the reservations of a previous run on start and adopt them for subnets in use", func() {
+		leaveReservations := func() {
+			previous := newPool()
+			previous.get(testView, testCidr)
+			previous.fill(testKey)
+			Expect(reserved()).To(HaveLen(2))
+		}
+
+		leaveReservations()
+		restarted := newPool()
+		restarted.start([]string{testView})
+		restarted.drain()
+		Expect(server.Objects("fixedaddress")).To(BeEmpty())
+
+		leaveReservations()
+		adopting := newPool()
+		adopting.get(testView, testCidr)
+		adopting.fill(testKey)
+		Expect(adopting.subnets[testKey]).To(HaveLen(2))
+		Expect(server.Objects("fixedaddress")).To(HaveLen(2))
+	})
+
+	It("Should return all reserved addresses to the grid on drain", func() {
+		pool := newPool()
+		pool.start(nil)
+		pool.get(testView, testCidr)
+		Eventually(reserved).Should(HaveLen(2))
+
+		pool.drain()
+		Expect(server.Objects("fixedaddress")).To(BeEmpty())
+	})
+
+	It("Should give a retried ADD the address the attachment already holds", func() {
+		ib := newInfoblox(drv)
+		ib.tagger = &ExtAttrTagger{NodeName: "node-1"}
+		ib.pool = newPool()
+
+		args := &ExtCmdArgs{}
+		args.ContainerID = "container-1"
+		args.IfName = "eth0"
+		args.IfMac = "11:22:33:44:55:66"
+		args.Args = "IgnoreUnknown=1;K8S_POD_NAMESPACE=default;K8S_POD_NAME=pod-1"
+		args.StdinData = []byte(`{"name": "yellow", "ipam": {"type": "infoblox", "network-view": "test-view", "subnet": "192.168.30.0/24"}}`)
+
+		ib.pool.get(testView, testCidr)
+		ib.pool.fill(testKey)
+
+		result := &current.Result{}
+		Expect(ib.Allocate(args, result)).To(BeNil())
+		first := result.IPs[0].Address.IP.String()
+
+		result = &current.Result{}
+		Expect(ib.Allocate(args, result)).To(BeNil())
+		Expect(result.IPs[0].Address.IP.String()).To(Equal(first))
+		Expect(ib.pool.subnets[testKey]).To(HaveLen(1))
+	})
+})
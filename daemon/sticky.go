@@ -0,0 +1,189 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"log"
+	"time"
+
+	. "github.com/infobloxopen/cni-infoblox"
+	ibclient "github.com/infobloxopen/infoblox-go-client"
+)
+
+// stickyPolicy keeps the addresses of StatefulSet pods, which are recreated
+// under the same name, across rescheduling. The address of such a pod is
+// keyed by its namespace and name rather than by its container, kept
+// reserved for the hold time after the pod is deleted, and reclaimed by the
+// next pod of the same name. A nil stickyPolicy keeps no addresses.
+type stickyPolicy struct {
+	hold time.Duration
+}
+
+func newStickyPolicy(holdTime int) *stickyPolicy {
+	if holdTime <= 0 {
+		return nil
+	}
+	return &stickyPolicy{hold: time.Duration(holdTime) * time.Second}
+}
+
+// key returns the key the address of the pod is kept by, or "" if the pod
+// is not part of a StatefulSet.
+func (p *stickyPolicy) key(pod *kubeObject) string {
+	if p == nil || pod == nil {
+		return ""
+	}
+	for _, owner := range pod.Metadata.OwnerReferences {
+		if owner.Kind == "StatefulSet" {
+			return pod.Metadata.Namespace + "/" + pod.Metadata.Name
+		}
+	}
+	return ""
+}
+
+// reclaim hands the address kept under the pod's key in cidr over to the
+// container, with the MAC address, name and EAs of a new allocation. It
+// returns "" if there is no such address. The previous pod of the same name
+// is gone by the time a StatefulSet recreates it, so an address is reclaimed
+// whether or not it has been released to the hold yet.
+func (p *stickyPolicy) reclaim(drv IBInfobloxDriver, netviewName string, cidr string, args *ExtCmdArgs, macAddr string, name string, ea ibclient.EA) (string, error) {
+	search := ibclient.EA{EA_STICKY_POD: ea[EA_STICKY_POD], EA_ATTACHMENT: ea[EA_ATTACHMENT]}
+	if clusterName, ok := ea[EA_CLUSTER_NAME]; ok {
+		search[EA_CLUSTER_NAME] = clusterName
+	}
+	allocations, err := drv.ListAddresses(netviewName, search)
+	if err != nil {
+		return "", err
+	}
+
+	for _, allocation := range allocations {
+		if allocation.Cidr != cidr {
+			continue
+		}
+		fixedAddr, err := drv.GetAddress(netviewName, cidr, allocation.IPAddress, "")
+		if err != nil {
+			return "", err
+		}
+		if fixedAddr == nil {
+			continue
+		}
+
+		if macAddr == "" {
+			macAddr = ZERO_MAC_ADDR
+		}
+		reclaimEA := ibclient.EA{EA_HELD_UNTIL: nil}
+		for k, v := range ea {
+			reclaimEA[k] = v
+		}
+		if _, err := drv.UpdateAddress(fixedAddr.Ref, macAddr, name, args.ContainerID, reclaimEA); err != nil {
+			return "", err
+		}
+		log.Printf("Reclaimed sticky address '%s' of pod '%s' for container '%s'", allocation.IPAddress, ea[EA_STICKY_POD], args.ContainerID)
+		return allocation.IPAddress, nil
+	}
+	return "", nil
+}
+
+// Outcomes of stickyPolicy.release
+type stickyOutcome int
+
+const (
+	// The address is still held for a pod of the same name.
+	stickyKept stickyOutcome = iota
+	// The address was released.
+	stickyReleased
+	// The address of a sticky pod was put on hold.
+	stickyHeld
+	// The hold of the address expired and it was released.
+	stickyExpired
+)
+
+// released reports whether the address was returned to the grid.
+func (o stickyOutcome) released() bool {
+	return o == stickyReleased || o == stickyExpired
+}
+
+// release releases an address that is no longer used by its container.
+// Sticky addresses are kept reserved for the hold time instead, and only
+// released once it has passed. On error, the outcome tells what was tried.
+func (p *stickyPolicy) release(drv IBInfobloxDriver, allocation Allocation) (stickyOutcome, error) {
+	if p == nil || allocation.ExtAttrs[EA_STICKY_POD] == "" {
+		_, err := drv.ReleaseAddress(allocation.NetworkView, allocation.IPAddress, "")
+		return stickyReleased, err
+	}
+
+	if heldUntil, ok := allocation.ExtAttrs[EA_HELD_UNTIL]; ok {
+		until, err := time.Parse(time.RFC3339, heldUntil)
+		if err == nil && time.Now().Before(until) {
+			return stickyKept, nil
+		}
+		log.Printf("Hold of sticky address '%s' of pod '%s' expired", allocation.IPAddress, allocation.ExtAttrs[EA_STICKY_POD])
+		_, err = drv.ReleaseAddress(allocation.NetworkView, allocation.IPAddress, "")
+		return stickyExpired, err
+	}
+
+	until := time.Now().Add(p.hold).UTC().Format(time.RFC3339)
+	_, err := drv.UpdateAddress(allocation.Ref, allocation.Mac, allocation.Name, "", ibclient.EA{EA_HELD_UNTIL: until})
+	if err == nil {
+		log.Printf("Holding sticky address '%s' of pod '%s' until %s", allocation.IPAddress, allocation.ExtAttrs[EA_STICKY_POD], until)
+	}
+	return stickyHeld, err
+}
+
+// startHeldSweep periodically releases the held addresses of the node once
+// their hold has passed, so that they are not kept forever when neither the
+// runtime's GC nor the controller runs.
+func (ib *Infoblox) startHeldSweep(interval time.Duration, conf NetConfig) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			ib.sweepHeld(conf)
+		}
+	}()
+}
+
+// sweepHeld releases the held addresses of the node whose hold has passed,
+// in the network views conf resolves to, and returns how many it released.
+// Addresses are told apart by the node and cluster tags, so nothing is swept
+// without them.
+func (ib *Infoblox) sweepHeld(conf NetConfig) int {
+	if ib.tagger.NodeName == "" || ib.tagger.ClusterName == "" {
+		return 0
+	}
+	filter := AllocationFilter{NodeName: ib.tagger.NodeName, ClusterName: ib.tagger.ClusterName}
+	released := 0
+	for _, netviewName := range ib.netconfViews(conf) {
+		allocations, err := ib.Drv.ListAddresses(netviewName, filter.ExtAttrs())
+		if err != nil {
+			log.Printf("Sticky: listing addresses in network view '%s' failed: %v", netviewName, err)
+			continue
+		}
+		for _, allocation := range allocations {
+			if allocation.ExtAttrs[EA_HELD_UNTIL] == "" {
+				continue
+			}
+			outcome, err := ib.releaseAllocation(auditStickyExpire, allocation)
+			if err != nil {
+				log.Printf("Sticky: failed to release '%s': %v", allocation.IPAddress, err)
+				continue
+			}
+			if outcome.released() {
+				released++
+			}
+		}
+	}
+	return released
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	current "github.com/containernetworking/cni/pkg/types/100"
+	. "github.com/infobloxopen/cni-infoblox"
+	"github.com/infobloxopen/cni-infoblox/fakewapi"
+	ibclient "github.com/infobloxopen/infoblox-go-client"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"time"
+)
+
+var _ = Describe("Sticky IPs", func() {
+	netConf := `
+{
+    "name": "yellow",
+    "ipam": {
+        "type": "infoblox",
+        "network-view": "test-view",
+        "subnet": "192.168.30.0/24"
+    }
+}`
+
+	var server *fakewapi.Server
+	var config *Config
+	var kube *httptest.Server
+	var owner string
+
+	BeforeEach(func() {
+		server = fakewapi.NewServer()
+		hostConfig := server.HostConfig()
+
+		config = &Config{}
+		config.GridHost = hostConfig.Host
+		config.WapiPort = hostConfig.Port
+		config.WapiUsername = hostConfig.Username
+		config.WapiPassword = hostConfig.Password
+		config.WapiVer = hostConfig.Version
+		config.SslVerify = "false"
+		config.NetworkView = "default"
+		config.NetworkContainer = "192.168.0.0/24"
+		config.PrefixLength = uint(26)
+		config.ClusterName = "test-cluster"
+		config.CacheDisabled = true
+
+		owner = "StatefulSet"
+		kube = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pod := kubeObject{Metadata: objectMeta{
+				Namespace:       "default",
+				Name:            "web-0",
+				OwnerReferences: []objectReference{{APIVersion: "apps/v1", Kind: owner, Name: "web"}},
+			}}
+			json.NewEncoder(w).Encode(pod)
+		}))
+	})
+
+	AfterEach(func() {
+		kube.Close()
+		server.Close()
+	})
+
+	newStickyInfoblox := func() *Infoblox {
+		ib := newInfoblox(getInfobloxDriver(config, getConnector(config)))
+		ib.tagger = &ExtAttrTagger{NodeName: "node-1", ClusterName: config.ClusterName}
+		ib.kube = &kubeClient{host: kube.URL, client: kube.Client()}
+		ib.sticky = newStickyPolicy(3600)
+		return ib
+	}
+
+	newArgs := func(containerID string, mac string) *ExtCmdArgs {
+		args := &ExtCmdArgs{}
+		args.ContainerID = containerID
+		args.IfName = "eth0"
+		args.IfMac = mac
+		args.Args = "IgnoreUnknown=1;K8S_POD_NAMESPACE=default;K8S_POD_NAME=web-0"
+		args.StdinData = []byte(netConf)
+		return args
+	}
+
+	It("Should be disabled without a hold time", func() {
+		Expect(newStickyPolicy(0)).To(BeNil())
+		Expect(newStickyPolicy(0).key(&kubeObject{})).To(BeEmpty())
+	})
+
+	It("Should hold the address of a deleted StatefulSet pod for the pod recreated under its name", func() {
+		ib := newStickyInfoblox()
+
+		result := &current.Result{}
+		Expect(ib.Allocate(newArgs("container-1", "11:22:33:44:55:66"), result)).To(BeNil())
+		ip := result.IPs[0].Address.IP.String()
+		addrs := server.Objects("fixedaddress")
+		Expect(addrs).To(HaveLen(1))
+		Expect(addrs[0].EA(EA_STICKY_POD)).To(Equal("default/web-0"))
+
+		Expect(ib.Release(newArgs("container-1", "11:22:33:44:55:66"), nil)).To(BeNil())
+		addrs = server.Objects("fixedaddress")
+		Expect(addrs).To(HaveLen(1))
+		Expect(addrs[0].EA(EA_HELD_UNTIL)).NotTo(BeNil())
+
+		// Other pods do not get the held address.
+		owner = "ReplicaSet"
+		Expect(ib.Allocate(newArgs("container-3", "11:22:33:44:55:88"), &current.Result{})).To(BeNil())
+		Expect(server.Objects("fixedaddress")).To(HaveLen(2))
+		owner = "StatefulSet"
+
+		result = &current.Result{}
+		Expect(ib.Allocate(newArgs("container-2", "11:22:33:44:55:77"), result)).To(BeNil())
+		Expect(result.IPs[0].Address.IP.String()).To(Equal(ip))
+		addrs = server.Objects("fixedaddress")
+		Expect(addrs).To(HaveLen(2))
+		Expect(addrs[0].String("mac")).To(Equal("11:22:33:44:55:77"))
+		Expect(addrs[0].EA("VM ID")).To(Equal("container-2"))
+		Expect(addrs[0].EA(EA_HELD_UNTIL)).To(BeNil())
+	})
+
+	It("Should release addresses of other pods on DEL", func() {
+		ib := newStickyInfoblox()
+		owner = "ReplicaSet"
+
+		Expect(ib.Allocate(newArgs("container-1", "11:22:33:44:55:66"), &current.Result{})).To(BeNil())
+		Expect(server.Objects("fixedaddress")[0].EA(EA_STICKY_POD)).To(BeNil())
+		Expect(ib.Release(newArgs("container-1", "11:22:33:44:55:66"), nil)).To(BeNil())
+		Expect(server.Objects("fixedaddress")).To(BeEmpty())
+	})
+
+	It("Should audit holding a sticky address and releasing it after the hold", func() {
+		ib := newStickyInfoblox()
+		var path string
+		ib.audit, path = tempAuditLog()
+		defer os.Remove(path)
+
+		args := newArgs("container-1", "11:22:33:44:55:66")
+		Expect(ib.Allocate(args, &current.Result{})).To(BeNil())
+		Expect(ib.Release(args, nil)).To(BeNil())
+
+		fixedAddr := server.Objects("fixedaddress")[0]
+		expired := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+		_, err := ib.Drv.UpdateAddress(fixedAddr.Ref(), fixedAddr.String("mac"), fixedAddr.String("name"), "", ibclient.EA{EA_HELD_UNTIL: expired})
+		Expect(err).To(BeNil())
+
+		var releasedIPs []string
+		Expect(ib.GC(newArgs("", ""), &releasedIPs)).To(BeNil())
+		Expect(server.Objects("fixedaddress")).To(BeEmpty())
+
+		Expect(auditEvents(path)).To(Equal([]string{auditAllocate, auditHold, auditStickyExpire}))
+	})
+
+	It("Should sweep the held addresses of the node once their hold has passed", func() {
+		ib := newStickyInfoblox()
+		var path string
+		ib.audit, path = tempAuditLog()
+		defer os.Remove(path)
+
+		eth0 := newArgs("container-1", "11:22:33:44:55:66")
+		net1 := newArgs("container-1", "11:22:33:44:55:77")
+		net1.IfName = "net1"
+		for _, args := range []*ExtCmdArgs{eth0, net1} {
+			Expect(ib.Allocate(args, &current.Result{})).To(BeNil())
+			Expect(ib.Release(args, nil)).To(BeNil())
+		}
+		expire := func(attachment string) {
+			for _, fixedAddr := range server.Objects("fixedaddress") {
+				if fixedAddr.EA(EA_ATTACHMENT) == attachment {
+					expired := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+					_, err := ib.Drv.UpdateAddress(fixedAddr.Ref(), fixedAddr.String("mac"), fixedAddr.String("name"), "", ibclient.EA{EA_HELD_UNTIL: expired})
+					Expect(err).To(BeNil())
+				}
+			}
+		}
+		conf := NetConfig{IPAM: &IPAMConfig{NetworkView: "test-view"}}
+
+		expire(AttachmentID("yellow", "eth0"))
+		Expect(ib.sweepHeld(conf)).To(Equal(1))
+		addrs := server.Objects("fixedaddress")
+		Expect(addrs).To(HaveLen(1))
+		Expect(addrs[0].EA(EA_ATTACHMENT)).To(Equal(AttachmentID("yellow", "net1")))
+		Expect(addrs[0].EA(EA_HELD_UNTIL)).NotTo(BeNil())
+
+		// Held addresses of other nodes are left to their daemons.
+		expire(AttachmentID("yellow", "net1"))
+		other := newStickyInfoblox()
+		other.tagger = &ExtAttrTagger{NodeName: "node-2", ClusterName: config.ClusterName}
+		Expect(other.sweepHeld(conf)).To(Equal(0))
+		Expect(server.Objects("fixedaddress")).To(HaveLen(1))
+
+		Expect(auditEvents(path)).To(Equal([]string{auditAllocate, auditHold, auditAllocate, auditHold, auditStickyExpire}))
+	})
+
+	It("Should release a held address once the hold time has passed", func() {
+		ib := newStickyInfoblox()
+		Expect(ib.Allocate(newArgs("container-1", "11:22:33:44:55:66"), &current.Result{})).To(BeNil())
+
+		allocations, err := ib.Drv.ListAddresses("test-view", AllocationFilter{ContainerID: "container-1"}.ExtAttrs())
+		Expect(err).To(BeNil())
+		allocation := allocations[0]
+
+		allocation.ExtAttrs[EA_HELD_UNTIL] = time.Now().Add(time.Minute).UTC().Format(time.RFC3339)
+		outcome, err := ib.sticky.release(ib.Drv, allocation)
+		Expect(err).To(BeNil())
+		Expect(outcome).To(Equal(stickyKept))
+		Expect(server.Objects("fixedaddress")).To(HaveLen(1))
+
+		allocation.ExtAttrs[EA_HELD_UNTIL] = time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+		outcome, err = ib.sticky.release(ib.Drv, allocation)
+		Expect(err).To(BeNil())
+		Expect(outcome).To(Equal(stickyExpired))
+		Expect(server.Objects("fixedaddress")).To(BeEmpty())
+	})
+})
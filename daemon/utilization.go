@@ -0,0 +1,199 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	. "github.com/infobloxopen/cni-infoblox"
+)
+
+// Pod annotation that marks a pod as low priority, so that its allocations
+// are refused once a network is beyond the high-water mark.
+const (
+	annotationPriority = "infoblox.com/priority"
+	priorityLow        = "low"
+)
+
+var utilizationStats = expvar.NewMap("network_utilization")
+
+// utilizationMonitor periodically reads the utilization of the networks
+// this node allocates from, and raises alerts when it crosses the warning
+// and critical thresholds.
+type utilizationMonitor struct {
+	drv       IBInfobloxDriver
+	interval  time.Duration
+	warning   float64
+	critical  float64
+	highWater float64
+	events    *eventRecorder
+
+	mu       sync.Mutex
+	networks map[poolKey]*NetworkUtilization
+}
+
+func newUtilizationMonitor(drv IBInfobloxDriver, interval time.Duration, warning float64, critical float64, highWater float64) *utilizationMonitor {
+	return &utilizationMonitor{
+		drv:       drv,
+		interval:  interval,
+		warning:   warning,
+		critical:  critical,
+		highWater: highWater,
+		networks:  make(map[poolKey]*NetworkUtilization),
+	}
+}
+
+func (m *utilizationMonitor) start() {
+	go func() {
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			for _, key := range m.keys() {
+				m.update(key)
+			}
+		}
+	}()
+}
+
+func (m *utilizationMonitor) keys() []poolKey {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]poolKey, 0, len(m.networks))
+	for key := range m.networks {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// track registers a network the node allocates from. The utilization of a
+// new network is read right away.
+func (m *utilizationMonitor) track(netview string, cidr string) {
+	key := poolKey{netview: netview, cidr: cidr}
+
+	m.mu.Lock()
+	_, known := m.networks[key]
+	if !known {
+		m.networks[key] = &NetworkUtilization{NetworkView: netview, Cidr: cidr, Level: UtilizationOK}
+	}
+	m.mu.Unlock()
+
+	if !known {
+		go m.update(key)
+	}
+}
+
+func (m *utilizationMonitor) level(utilization float64) string {
+	switch {
+	case m.critical > 0 && utilization >= m.critical:
+		return UtilizationCritical
+	case m.warning > 0 && utilization >= m.warning:
+		return UtilizationWarning
+	}
+	return UtilizationOK
+}
+
+func (m *utilizationMonitor) update(key poolKey) {
+	utilization, err := m.drv.GetNetworkUtilization(key.netview, key.cidr)
+	if err != nil {
+		log.Printf("Cannot read utilization of '%s' (view '%s'): %v", key.cidr, key.netview, err)
+		return
+	}
+	level := m.level(utilization)
+
+	m.mu.Lock()
+	network := m.networks[key]
+	previous := network.Level
+	network.Utilization = utilization
+	network.Level = level
+	network.UpdatedAt = time.Now()
+	m.mu.Unlock()
+
+	stat := new(expvar.Float)
+	stat.Set(utilization)
+	utilizationStats.Set(key.netview+"/"+key.cidr, stat)
+
+	if level != previous {
+		m.alert(key, utilization, previous, level)
+	}
+}
+
+func (m *utilizationMonitor) alert(key poolKey, utilization float64, previous string, level string) {
+	message := fmt.Sprintf("Utilization of network '%s' (view '%s') is %.1f%%, level changed from %s to %s",
+		key.cidr, key.netview, utilization, previous, level)
+
+	eventType, reason := eventWarning, "NetworkUtilizationHigh"
+	switch level {
+	case UtilizationCritical:
+		reason = "NetworkUtilizationCritical"
+	case UtilizationOK:
+		eventType, reason = eventNormal, "NetworkUtilizationNormal"
+	}
+
+	log.Printf("%s: %s", reason, message)
+	if m.events != nil {
+		m.events.nodeEvent(eventType, reason, message)
+	}
+}
+
+// utilization returns the last known utilization of the network.
+func (m *utilizationMonitor) utilization(netview string, cidr string) (float64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	network, ok := m.networks[poolKey{netview: netview, cidr: cidr}]
+	if !ok || network.UpdatedAt.IsZero() {
+		return 0, false
+	}
+	return network.Utilization, true
+}
+
+// snapshot returns the utilization of all tracked networks.
+func (m *utilizationMonitor) snapshot() []NetworkUtilization {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res := make([]NetworkUtilization, 0, len(m.networks))
+	for _, network := range m.networks {
+		res = append(res, *network)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].NetworkView != res[j].NetworkView {
+			return res[i].NetworkView < res[j].NetworkView
+		}
+		return res[i].Cidr < res[j].Cidr
+	})
+	return res
+}
+
+// admit refuses allocations for low priority pods once the network is
+// beyond the high-water mark.
+func (m *utilizationMonitor) admit(pod *kubeObject, netview string, cidr string) error {
+	if m.highWater <= 0 || pod == nil || pod.Metadata.Annotations[annotationPriority] != priorityLow {
+		return nil
+	}
+	utilization, ok := m.utilization(netview, cidr)
+	if ok && utilization >= m.highWater {
+		return fmt.Errorf("network '%s' is %.1f%% utilized, beyond the high-water mark of %.1f%%; refusing allocation for low priority pod '%s/%s'",
+			cidr, utilization, m.highWater, pod.Metadata.Namespace, pod.Metadata.Name)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	. "github.com/infobloxopen/cni-infoblox"
+	"github.com/infobloxopen/cni-infoblox/fakewapi"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+var _ = Describe("utilizationMonitor", func() {
+	m := newUtilizationMonitor(nil, time.Minute, 80, 95, 90)
+	m.networks[poolKey{netview: "default", cidr: "10.0.0.0/24"}] = &NetworkUtilization{
+		NetworkView: "default",
+		Cidr:        "10.0.0.0/24",
+		Utilization: 92,
+		Level:       UtilizationWarning,
+		UpdatedAt:   time.Now(),
+	}
+
+	lowPriority := &kubeObject{Metadata: objectMeta{
+		Namespace:   "default",
+		Name:        "batch",
+		Annotations: map[string]string{annotationPriority: priorityLow},
+	}}
+	normalPriority := &kubeObject{Metadata: objectMeta{Namespace: "default", Name: "web"}}
+
+	It("Should classify utilization by the thresholds", func() {
+		Expect(m.level(50)).To(Equal(UtilizationOK))
+		Expect(m.level(80)).To(Equal(UtilizationWarning))
+		Expect(m.level(99)).To(Equal(UtilizationCritical))
+	})
+
+	It("Should refuse low priority pods beyond the high-water mark", func() {
+		Expect(m.admit(lowPriority, "default", "10.0.0.0/24")).NotTo(BeNil())
+		Expect(m.admit(normalPriority, "default", "10.0.0.0/24")).To(BeNil())
+	})
+
+	It("Should admit pods to networks of unknown utilization", func() {
+		Expect(m.admit(lowPriority, "default", "10.0.1.0/24")).To(BeNil())
+	})
+
+	It("Should report all tracked networks", func() {
+		Expect(m.snapshot()).To(HaveLen(1))
+	})
+})
+
+var _ = Describe("utilizationMonitor updates", func() {
+	testView := "test-view"
+	testCidr := "10.2.0.0/29"
+	testKey := poolKey{netview: testView, cidr: testCidr}
+
+	var server *fakewapi.Server
+	var kube *httptest.Server
+	var drv *InfobloxDriver
+	var m *utilizationMonitor
+	var events chan kubeEvent
+
+	BeforeEach(func() {
+		server = fakewapi.NewServer()
+		hostConfig := server.HostConfig()
+
+		config := &Config{}
+		config.GridHost = hostConfig.Host
+		config.WapiPort = hostConfig.Port
+		config.WapiUsername = hostConfig.Username
+		config.WapiPassword = hostConfig.Password
+		config.WapiVer = hostConfig.Version
+		config.SslVerify = "false"
+		config.NetworkView = "default"
+		config.NetworkContainer = "192.168.0.0/24"
+		config.PrefixLength = uint(26)
+		config.CacheDisabled = true
+		drv = getInfobloxDriver(config, getConnector(config))
+
+		_, err := server.AddNetworkView(testView, nil)
+		Expect(err).To(BeNil())
+		_, err = server.AddNetwork(testView, testCidr, nil)
+		Expect(err).To(BeNil())
+
+		events = make(chan kubeEvent, 10)
+		kube = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			event := kubeEvent{}
+			Expect(json.NewDecoder(r.Body).Decode(&event)).To(BeNil())
+			events <- event
+			w.WriteHeader(http.StatusCreated)
+		}))
+
+		m = newUtilizationMonitor(drv, time.Minute, 30, 60, 0)
+		m.events = &eventRecorder{kube: &kubeClient{host: kube.URL, client: kube.Client()}, nodeName: "node-1"}
+		m.networks[testKey] = &NetworkUtilization{NetworkView: testView, Cidr: testCidr, Level: UtilizationOK}
+	})
+
+	AfterEach(func() {
+		kube.Close()
+		server.Close()
+	})
+
+	reserve := func(n int) []string {
+		var refs []string
+		for i := 0; i < n; i++ {
+			fixedAddr, err := drv.ReserveAddress(testView, testCidr, "", nil)
+			Expect(err).To(BeNil())
+			refs = append(refs, fixedAddr.Ref)
+		}
+		return refs
+	}
+
+	It("Should read the utilization of the network from the grid", func() {
+		reserve(1)
+		m.update(testKey)
+
+		utilization, ok := m.utilization(testView, testCidr)
+		Expect(ok).To(BeTrue())
+		Expect(utilization).To(BeNumerically("~", 16.6, 0.001))
+		Expect(m.snapshot()[0].Level).To(Equal(UtilizationOK))
+		Expect(events).To(BeEmpty())
+	})
+
+	It("Should raise an alert each time the level changes", func() {
+		refs := reserve(2)
+		m.update(testKey)
+		event := <-events
+		Expect(event.Type).To(Equal(eventWarning))
+		Expect(event.Reason).To(Equal("NetworkUtilizationHigh"))
+		Expect(event.InvolvedObject.Name).To(Equal("node-1"))
+
+		m.update(testKey)
+		Consistently(events, 100*time.Millisecond).Should(BeEmpty())
+
+		refs = append(refs, reserve(2)...)
+		m.update(testKey)
+		event = <-events
+		Expect(event.Type).To(Equal(eventWarning))
+		Expect(event.Reason).To(Equal("NetworkUtilizationCritical"))
+		Expect(m.snapshot()[0].Level).To(Equal(UtilizationCritical))
+
+		for _, ref := range refs[1:] {
+			Expect(server.Delete(ref)).To(BeNil())
+		}
+		m.update(testKey)
+		event = <-events
+		Expect(event.Type).To(Equal(eventNormal))
+		Expect(event.Reason).To(Equal("NetworkUtilizationNormal"))
+	})
+
+	It("Should keep the last known utilization if the grid cannot be read", func() {
+		reserve(2)
+		m.update(testKey)
+		<-events
+
+		server.Fail(http.MethodGet, "network", "read failed")
+		m.update(testKey)
+		utilization, ok := m.utilization(testView, testCidr)
+		Expect(ok).To(BeTrue())
+		Expect(utilization).To(BeNumerically("~", 33.3, 0.001))
+		Expect(m.snapshot()[0].Level).To(Equal(UtilizationWarning))
+	})
+})
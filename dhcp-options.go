@@ -0,0 +1,171 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package ibcni
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+)
+
+// DHCP options read from and set on Infoblox networks
+const (
+	DHCP_OPTION_ROUTERS             = "routers"
+	DHCP_OPTION_DOMAIN_NAME_SERVERS = "domain-name-servers"
+	DHCP_OPTION_DOMAIN_NAME         = "domain-name"
+	DHCP_OPTION_DOMAIN_SEARCH       = "domain-search"
+)
+
+// DHCPOptions are the DHCP options of an Infoblox network that make up the
+// gateway, routes and DNS settings of a CNI result.
+type DHCPOptions struct {
+	Routers           []net.IP
+	DomainNameServers []string
+	DomainName        string
+	DomainSearch      []string
+}
+
+// GetDHCPOptions returns the DHCP options of the network. They are cached
+// along with the network if caching is enabled.
+func (ibDrv *InfobloxDriver) GetDHCPOptions(netviewName string, cidr string) (*DHCPOptions, error) {
+	if ibDrv.connector == nil {
+		return nil, errors.New("reading DHCP options requires a WAPI connector")
+	}
+	if netviewName == "" {
+		netviewName = ibDrv.DefaultNetworkView
+	}
+
+	get := func() (*DHCPOptions, error) {
+		return ibDrv.getDHCPOptions(netviewName, cidr)
+	}
+	if cache, ok := ibDrv.objMgr.(*CachingObjectManager); ok {
+		return cache.cachedDHCPOptions(netviewName, cidr, get)
+	}
+	return get()
+}
+
+func (ibDrv *InfobloxDriver) getDHCPOptions(netviewName string, cidr string) (*DHCPOptions, error) {
+	var res []networkOptions
+	err := ibDrv.connector.GetObject(newNetworkOptions(networkOptions{NetviewName: netviewName, Cidr: cidr}), "", &res)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("network '%s' not found in network view '%s'", cidr, netviewName)
+	}
+	return parseDHCPOptions(res[0].Options)
+}
+
+func parseDHCPOptions(options []dhcpOption) (*DHCPOptions, error) {
+	opts := &DHCPOptions{}
+	for _, o := range options {
+		if o.UseOption != nil && !*o.UseOption {
+			continue
+		}
+		switch o.Name {
+		case DHCP_OPTION_ROUTERS:
+			for _, s := range splitOptionValue(o.Value) {
+				ip := net.ParseIP(s)
+				if ip == nil {
+					return nil, fmt.Errorf("invalid router '%s' in DHCP option '%s'", s, o.Name)
+				}
+				opts.Routers = append(opts.Routers, ip)
+			}
+		case DHCP_OPTION_DOMAIN_NAME_SERVERS:
+			opts.DomainNameServers = splitOptionValue(o.Value)
+		case DHCP_OPTION_DOMAIN_NAME:
+			opts.DomainName = strings.TrimSpace(o.Value)
+		case DHCP_OPTION_DOMAIN_SEARCH:
+			for _, s := range splitOptionValue(o.Value) {
+				opts.DomainSearch = append(opts.DomainSearch, strings.Trim(s, `"`))
+			}
+		}
+	}
+	return opts, nil
+}
+
+// splitOptionValue splits the comma separated value of a DHCP option.
+func splitOptionValue(value string) []string {
+	var res []string
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// Apply fills in what the netconf left out of result: the gateway of its
+// addresses and a default route via the first router, and the DNS settings.
+// The domain name is searched unless there is a domain-search option.
+func (o *DHCPOptions) Apply(result *current.Result) {
+	if len(o.Routers) > 0 {
+		for _, ipConfig := range result.IPs {
+			if ipConfig.Gateway == nil && ipConfig.Address.Contains(o.Routers[0]) {
+				ipConfig.Gateway = o.Routers[0]
+			}
+		}
+	}
+
+	// The default route goes via the gateway of the first address, which
+	// is the netconf's if it has one.
+	hasDefault := false
+	for _, r := range result.Routes {
+		if ones, _ := r.Dst.Mask.Size(); ones == 0 {
+			hasDefault = true
+		}
+	}
+	if len(o.Routers) > 0 && !hasDefault && len(result.IPs) > 0 && result.IPs[0].Gateway != nil {
+		result.Routes = append(result.Routes, &types.Route{
+			Dst: net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)},
+			GW:  result.IPs[0].Gateway,
+		})
+	}
+
+	if len(result.DNS.Nameservers) == 0 {
+		result.DNS.Nameservers = o.DomainNameServers
+	}
+	if result.DNS.Domain == "" {
+		result.DNS.Domain = o.DomainName
+	}
+	if len(result.DNS.Search) == 0 {
+		result.DNS.Search = o.DomainSearch
+		if len(o.DomainSearch) == 0 && o.DomainName != "" {
+			result.DNS.Search = []string{o.DomainName}
+		}
+	}
+}
+
+// dhcpOptions returns the DHCP options of a network created with the
+// settings: the gateway as router, and the DNS servers and domain name.
+func (s *NetworkSettings) dhcpOptions(gateway net.IP) []dhcpOption {
+	useOption := true
+	var options []dhcpOption
+	if gateway != nil {
+		options = append(options, dhcpOption{Name: DHCP_OPTION_ROUTERS, Value: gateway.String(), UseOption: &useOption})
+	}
+	if len(s.DNSServers) > 0 {
+		options = append(options, dhcpOption{Name: DHCP_OPTION_DOMAIN_NAME_SERVERS, Value: strings.Join(s.DNSServers, ","), UseOption: &useOption})
+	}
+	if s.DomainName != "" {
+		options = append(options, dhcpOption{Name: DHCP_OPTION_DOMAIN_NAME, Value: s.DomainName, UseOption: &useOption})
+	}
+	return options
+}
@@ -0,0 +1,92 @@
+package ibcni
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+
+	"net"
+)
+
+var _ = Describe("DHCPOptions", func() {
+	useOption := func(b bool) *bool { return &b }
+
+	newResult := func(gateway string) *current.Result {
+		ip, ipn, _ := net.ParseCIDR("10.0.0.5/24")
+		ipn.IP = ip
+		return &current.Result{IPs: []*current.IPConfig{{Address: *ipn, Gateway: net.ParseIP(gateway)}}}
+	}
+
+	Context("Parsing the options of a network", func() {
+		It("Should read routers, name servers and domains", func() {
+			opts, err := parseDHCPOptions([]dhcpOption{
+				{Name: "routers", Num: 3, Value: "10.0.0.1, 10.0.0.2", UseOption: useOption(true)},
+				{Name: "domain-name-servers", Num: 6, Value: "10.0.0.53,10.0.1.53", UseOption: useOption(true)},
+				{Name: "domain-name", Num: 15, Value: "example.com", UseOption: useOption(true)},
+				{Name: "domain-search", Num: 119, Value: `"example.com","corp.example.com"`},
+				{Name: "dhcp-lease-time", Num: 51, Value: "43200", UseOption: useOption(true)},
+			})
+			Expect(err).To(BeNil())
+			Expect(opts.Routers).To(Equal([]net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")}))
+			Expect(opts.DomainNameServers).To(Equal([]string{"10.0.0.53", "10.0.1.53"}))
+			Expect(opts.DomainName).To(Equal("example.com"))
+			Expect(opts.DomainSearch).To(Equal([]string{"example.com", "corp.example.com"}))
+		})
+
+		It("Should skip options inherited rather than set on the network", func() {
+			opts, err := parseDHCPOptions([]dhcpOption{
+				{Name: "routers", Num: 3, Value: "10.0.0.1", UseOption: useOption(false)},
+			})
+			Expect(err).To(BeNil())
+			Expect(opts.Routers).To(BeEmpty())
+		})
+
+		It("Should fail on an invalid router", func() {
+			_, err := parseDHCPOptions([]dhcpOption{{Name: "routers", Num: 3, Value: "router-1"}})
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Context("Applying the options to a result", func() {
+		opts := &DHCPOptions{
+			Routers:           []net.IP{net.ParseIP("10.0.0.1")},
+			DomainNameServers: []string{"10.0.0.53"},
+			DomainName:        "example.com",
+		}
+
+		It("Should set the gateway, a default route and the DNS settings", func() {
+			result := newResult("")
+			opts.Apply(result)
+			Expect(result.IPs[0].Gateway.String()).To(Equal("10.0.0.1"))
+			Expect(result.Routes).To(HaveLen(1))
+			Expect(result.Routes[0].Dst.String()).To(Equal("0.0.0.0/0"))
+			Expect(result.Routes[0].GW.String()).To(Equal("10.0.0.1"))
+			Expect(result.DNS.Nameservers).To(Equal([]string{"10.0.0.53"}))
+			Expect(result.DNS.Domain).To(Equal("example.com"))
+			Expect(result.DNS.Search).To(Equal([]string{"example.com"}))
+		})
+
+		It("Should keep the gateway and routes of the netconf", func() {
+			result := newResult("10.0.0.254")
+			opts.Apply(result)
+			Expect(result.IPs[0].Gateway.String()).To(Equal("10.0.0.254"))
+			Expect(result.Routes[0].GW.String()).To(Equal("10.0.0.254"))
+
+			result = newResult("")
+			_, defaultNet, _ := net.ParseCIDR("0.0.0.0/0")
+			result.Routes = append(result.Routes, &types.Route{Dst: *defaultNet, GW: net.ParseIP("10.0.0.254")})
+			opts.Apply(result)
+			Expect(result.Routes).To(HaveLen(1))
+			Expect(result.Routes[0].GW.String()).To(Equal("10.0.0.254"))
+		})
+
+		It("Should not use a router outside the subnet as gateway", func() {
+			result := newResult("")
+			(&DHCPOptions{Routers: []net.IP{net.ParseIP("192.168.0.1")}}).Apply(result)
+			Expect(result.IPs[0].Gateway).To(BeNil())
+			Expect(result.Routes).To(BeEmpty())
+		})
+	})
+})
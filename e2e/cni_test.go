@@ -0,0 +1,292 @@
+//go:build e2e
+// +build e2e
+
+package e2e
+
+import (
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/infobloxopen/cni-infoblox/fakewapi"
+	"github.com/vishvananda/netlink"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+const (
+	testNetworkView = "e2e"
+	testSubnet      = "10.10.0.0/24"
+	testGateway     = "10.10.0.1"
+	testIfName      = "eth0"
+	macvlanParent   = "e2e-br0"
+	macvlanTmpName  = "e2e-mv0"
+)
+
+var containerCount int
+
+// container is a network namespace with an interface for the plugin to
+// configure, like the one a runtime sets up before calling IPAM.
+type container struct {
+	id    string
+	netns ns.NetNS
+	mac   string
+}
+
+func (c *container) close() {
+	c.netns.Close()
+	testutils.UnmountNS(c.netns)
+}
+
+func newVethContainer(id string) *container {
+	netns, err := testutils.NewNS()
+	Expect(err).To(BeNil())
+	hostNS, err := ns.GetCurrentNS()
+	Expect(err).To(BeNil())
+	defer hostNS.Close()
+
+	c := &container{id: id, netns: netns}
+	err = netns.Do(func(_ ns.NetNS) error {
+		_, contVeth, err := ip.SetupVeth(testIfName, 1500, "", hostNS)
+		if err != nil {
+			return err
+		}
+		c.mac = contVeth.HardwareAddr.String()
+		return nil
+	})
+	Expect(err).To(BeNil())
+	return c
+}
+
+func newMacvlanContainer(id string) *container {
+	netns, err := testutils.NewNS()
+	Expect(err).To(BeNil())
+
+	parent, err := netlink.LinkByName(macvlanParent)
+	Expect(err).To(BeNil())
+	macvlan := &netlink.Macvlan{
+		LinkAttrs: netlink.LinkAttrs{
+			Name:        macvlanTmpName,
+			ParentIndex: parent.Attrs().Index,
+			Namespace:   netlink.NsFd(int(netns.Fd())),
+		},
+		Mode: netlink.MACVLAN_MODE_BRIDGE,
+	}
+	Expect(netlink.LinkAdd(macvlan)).To(Succeed())
+
+	c := &container{id: id, netns: netns}
+	err = netns.Do(func(_ ns.NetNS) error {
+		link, err := netlink.LinkByName(macvlanTmpName)
+		if err != nil {
+			return err
+		}
+		if err := netlink.LinkSetName(link, testIfName); err != nil {
+			return err
+		}
+		c.mac = link.Attrs().HardwareAddr.String()
+		return netlink.LinkSetUp(link)
+	})
+	Expect(err).To(BeNil())
+	return c
+}
+
+// netConf returns the network configuration the runtime passes on stdin,
+// with prevResult set for CHECK.
+func netConf(pluginType string, prevResult []byte) []byte {
+	conf := map[string]interface{}{
+		"cniVersion": "1.0.0",
+		"name":       "e2e-net",
+		"type":       pluginType,
+		"ipam": map[string]interface{}{
+			"type":         driverName,
+			"socket-dir":   socketDir,
+			"network-view": testNetworkView,
+			"subnet":       testSubnet,
+			"gateway":      testGateway,
+		},
+	}
+	if prevResult != nil {
+		conf["prevResult"] = json.RawMessage(prevResult)
+	}
+	data, err := json.Marshal(conf)
+	Expect(err).To(BeNil())
+	return data
+}
+
+// gcConf returns the network configuration the runtime passes for GC, with
+// the attachments of the containers listed as valid.
+func gcConf(pluginType string, valid ...*container) []byte {
+	conf := map[string]interface{}{}
+	Expect(json.Unmarshal(netConf(pluginType, nil), &conf)).To(Succeed())
+	conf["cniVersion"] = "1.1.0"
+	attachments := []map[string]string{}
+	for _, c := range valid {
+		attachments = append(attachments, map[string]string{"containerID": c.id, "ifname": testIfName})
+	}
+	conf["cni.dev/valid-attachments"] = attachments
+	data, err := json.Marshal(conf)
+	Expect(err).To(BeNil())
+	return data
+}
+
+// execPlugin runs the plugin binary the way a runtime does and returns what
+// it printed. GC and STATUS are not about a container, c is nil for them.
+func execPlugin(command string, c *container, stdin []byte) ([]byte, error) {
+	cmd := exec.Command(filepath.Join(binDir, driverName))
+	cmd.Env = append(os.Environ(),
+		"CNI_COMMAND="+command,
+		"CNI_PATH="+binDir)
+	if c != nil {
+		cmd.Env = append(cmd.Env,
+			"CNI_CONTAINERID="+c.id,
+			"CNI_NETNS="+c.netns.Path(),
+			"CNI_IFNAME="+testIfName,
+			"CNI_ARGS=IgnoreUnknown=1;K8S_POD_NAMESPACE=e2e;K8S_POD_NAME=pod-"+c.id)
+	}
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stderr = GinkgoWriter
+	out, err := cmd.Output()
+	if err != nil {
+		return out, fmt.Errorf("%s %v: %s", command, err, out)
+	}
+	return out, nil
+}
+
+func fixedAddress(ip string) fakewapi.Object {
+	for _, obj := range server.Objects("fixedaddress") {
+		if obj.String("network_view") == testNetworkView && obj.String("ipv4addr") == ip {
+			return obj
+		}
+	}
+	return nil
+}
+
+func containerAddresses(id string) []fakewapi.Object {
+	var res []fakewapi.Object
+	for _, obj := range server.Objects("fixedaddress") {
+		if obj.EA("VM ID") == id {
+			res = append(res, obj)
+		}
+	}
+	return res
+}
+
+func describeInterface(kind string, pluginType string, newContainer func(id string) *container) {
+	Context("With a "+kind+" interface", func() {
+		var c *container
+
+		BeforeEach(func() {
+			containerCount++
+			c = newContainer(fmt.Sprintf("e2e-%s-%d", kind, containerCount))
+		})
+
+		AfterEach(func() {
+			execPlugin("DEL", c, netConf(pluginType, nil))
+			c.close()
+		})
+
+		It("Should allocate an address on ADD and verify it on CHECK", func() {
+			out, err := execPlugin("ADD", c, netConf(pluginType, nil))
+			Expect(err).To(BeNil())
+
+			result := &current.Result{}
+			Expect(json.Unmarshal(out, result)).To(Succeed())
+			Expect(result.CNIVersion).To(Equal("1.0.0"))
+			Expect(result.IPs).To(HaveLen(1))
+			Expect(result.IPs[0].Gateway.String()).To(Equal(testGateway))
+			Expect(result.IPs[0].Interface).NotTo(BeNil())
+			iface := result.Interfaces[*result.IPs[0].Interface]
+			Expect(iface.Name).To(Equal(testIfName))
+			Expect(iface.Mac).To(Equal(c.mac))
+			Expect(iface.Sandbox).To(Equal(c.netns.Path()))
+			_, subnet, _ := net.ParseCIDR(testSubnet)
+			Expect(subnet.Contains(result.IPs[0].Address.IP)).To(BeTrue())
+
+			addr := fixedAddress(result.IPs[0].Address.IP.String())
+			Expect(addr).NotTo(BeNil())
+			Expect(addr.String("mac")).To(Equal(c.mac))
+			Expect(addr.EA("VM ID")).To(Equal(c.id))
+
+			_, err = execPlugin("CHECK", c, netConf(pluginType, out))
+			Expect(err).To(BeNil())
+
+			Expect(server.Delete(addr.Ref())).To(Succeed())
+			_, err = execPlugin("CHECK", c, netConf(pluginType, out))
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(ContainSubstring("not allocated"))
+		})
+
+		It("Should release the address on DEL, and DEL again should succeed", func() {
+			_, err := execPlugin("ADD", c, netConf(pluginType, nil))
+			Expect(err).To(BeNil())
+			Expect(containerAddresses(c.id)).To(HaveLen(1))
+
+			_, err = execPlugin("DEL", c, netConf(pluginType, nil))
+			Expect(err).To(BeNil())
+			Expect(containerAddresses(c.id)).To(BeEmpty())
+
+			_, err = execPlugin("DEL", c, netConf(pluginType, nil))
+			Expect(err).To(BeNil())
+		})
+
+		It("Should only release the address on GC once the attachment is no longer valid", func() {
+			_, err := execPlugin("ADD", c, netConf(pluginType, nil))
+			Expect(err).To(BeNil())
+
+			_, err = execPlugin("GC", nil, gcConf(pluginType, c))
+			Expect(err).To(BeNil())
+			Expect(containerAddresses(c.id)).To(HaveLen(1))
+
+			_, err = execPlugin("GC", nil, gcConf(pluginType))
+			Expect(err).To(BeNil())
+			Expect(containerAddresses(c.id)).To(BeEmpty())
+		})
+	})
+}
+
+var _ = Describe("Plugin", func() {
+	It("Should report itself available on STATUS while the grid can be reached", func() {
+		conf := map[string]interface{}{}
+		Expect(json.Unmarshal(netConf("ptp", nil), &conf)).To(Succeed())
+		conf["cniVersion"] = "1.1.0"
+		stdin, err := json.Marshal(conf)
+		Expect(err).To(BeNil())
+
+		_, err = execPlugin("STATUS", nil, stdin)
+		Expect(err).To(BeNil())
+
+		server.Fail(http.MethodGet, "networkview", "grid unavailable")
+		defer server.ClearFailures()
+		out, err := execPlugin("STATUS", nil, stdin)
+		Expect(err).NotTo(BeNil())
+		Expect(string(out)).To(ContainSubstring(`"code": 50`))
+	})
+
+	describeInterface("veth", "ptp", newVethContainer)
+
+	Context("On a macvlan parent", func() {
+		BeforeEach(func() {
+			parent := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: macvlanParent}}
+			Expect(netlink.LinkAdd(parent)).To(Succeed())
+			Expect(netlink.LinkSetUp(parent)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			if link, err := netlink.LinkByName(macvlanParent); err == nil {
+				netlink.LinkDel(link)
+			}
+		})
+
+		describeInterface("macvlan", "macvlan", newMacvlanContainer)
+	})
+})
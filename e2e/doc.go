@@ -0,0 +1,25 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package e2e holds the end-to-end tests of the plugin. They build the plugin
+// and the daemon, run the daemon against the fake WAPI of package fakewapi,
+// and invoke the plugin binary with ADD, CHECK, DEL, GC and STATUS for
+// interfaces in real network namespaces.
+//
+// The tests create network namespaces and links, so they need root, and are
+// only built with the e2e build tag:
+//
+//	sudo go test -tags e2e ./e2e/
+package e2e
@@ -0,0 +1,95 @@
+//go:build e2e
+// +build e2e
+
+package e2e
+
+import (
+	. "github.com/infobloxopen/cni-infoblox"
+	"github.com/infobloxopen/cni-infoblox/fakewapi"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"io/ioutil"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const driverName = "infoblox"
+
+var (
+	binDir    string
+	socketDir string
+	server    *fakewapi.Server
+	daemon    *exec.Cmd
+)
+
+func TestE2E(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("the e2e tests create network namespaces and must run as root")
+	}
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "E2E Suite")
+}
+
+func buildBinary(pkg string, name string) {
+	cmd := exec.Command("go", "build", "-o", filepath.Join(binDir, name), pkg)
+	out, err := cmd.CombinedOutput()
+	Expect(err).To(BeNil(), "building %s: %s", pkg, out)
+}
+
+var _ = BeforeSuite(func() {
+	var err error
+	binDir, err = ioutil.TempDir("", "cni-infoblox-e2e")
+	Expect(err).To(BeNil())
+	socketDir = filepath.Join(binDir, "run")
+
+	// CNI looks plugins up by the IPAM type, so the plugin is named after
+	// the driver.
+	buildBinary("github.com/infobloxopen/cni-infoblox/plugin", driverName)
+	buildBinary("github.com/infobloxopen/cni-infoblox/daemon", "infoblox-daemon")
+
+	server = fakewapi.NewServer()
+	hostConfig := server.HostConfig()
+
+	daemon = exec.Command(filepath.Join(binDir, "infoblox-daemon"),
+		"--grid-host="+hostConfig.Host,
+		"--wapi-port="+hostConfig.Port,
+		"--wapi-username="+hostConfig.Username,
+		"--wapi-version="+hostConfig.Version,
+		"--ssl-verify=false",
+		"--socket-dir="+socketDir,
+		"--driver-name="+driverName,
+		"--cluster-name=e2e",
+		"--node-name=e2e-node")
+	daemon.Env = append(os.Environ(), "WAPI_PASSWORD="+hostConfig.Password)
+	daemon.Stdout = GinkgoWriter
+	daemon.Stderr = GinkgoWriter
+	Expect(daemon.Start()).To(Succeed())
+
+	socketFile := NewDriverSocket(socketDir, driverName).GetSocketFile()
+	Eventually(func() error {
+		client, err := rpc.DialHTTP("unix", socketFile)
+		if err != nil {
+			return err
+		}
+		return client.Close()
+	}, 30*time.Second, 100*time.Millisecond).Should(Succeed())
+})
+
+var _ = AfterSuite(func() {
+	if daemon != nil && daemon.Process != nil {
+		daemon.Process.Kill()
+		daemon.Wait()
+	}
+	if server != nil {
+		server.Close()
+	}
+	if binDir != "" {
+		os.RemoveAll(binDir)
+	}
+})
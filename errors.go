@@ -0,0 +1,148 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package ibcni
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// Failure reasons, as reported in the Kubernetes Events of failed
+// allocations
+const (
+	ReasonNetworkExhausted    = "NetworkExhausted"
+	ReasonNetworkViewNotFound = "NetworkViewNotFound"
+	ReasonLicenseUnavailable  = "LicenseUnavailable"
+	ReasonGridUnreachable     = "GridUnreachable"
+	ReasonAllocationFailed    = "IPAllocationFailed"
+)
+
+// NetworkExhaustedError is returned when a network has no address left to
+// allocate.
+type NetworkExhaustedError struct {
+	Cidr string
+	Err  error
+}
+
+func (e *NetworkExhaustedError) Error() string {
+	return fmt.Sprintf("network '%s' is exhausted: %v", e.Cidr, e.Err)
+}
+
+func (e *NetworkExhaustedError) Unwrap() error {
+	return e.Err
+}
+
+// IsNetworkExhausted tells whether err means that a network has no address
+// left to allocate.
+func IsNetworkExhausted(err error) bool {
+	var exhausted *NetworkExhaustedError
+	return errors.As(err, &exhausted)
+}
+
+// NetworkViewError is returned when a network view neither exists nor can
+// be created.
+type NetworkViewError struct {
+	Name string
+	Err  error
+}
+
+func (e *NetworkViewError) Error() string {
+	return fmt.Sprintf("network view '%s' not found and cannot be created: %v", e.Name, e.Err)
+}
+
+func (e *NetworkViewError) Unwrap() error {
+	return e.Err
+}
+
+// IsNetworkViewMissing tells whether err means that a network view neither
+// exists nor can be created.
+func IsNetworkViewMissing(err error) bool {
+	var viewErr *NetworkViewError
+	return errors.As(err, &viewErr)
+}
+
+// IsGridUnreachable tells whether err means that the grid could not be
+// reached at all, as opposed to the grid refusing a request.
+func IsGridUnreachable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	if errors.Is(err, ErrCircuitOpen) || errors.As(err, &netErr) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"connection refused", "no route to host", "network is unreachable", "no such host",
+		"i/o timeout", "client.timeout exceeded", "circuit breaker open"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// licenseErrorText matches the texts of the WAPI errors returned for a
+// missing or expired license, and that of CheckLicense, but not names that
+// merely contain the word, e.g. of a network view.
+var licenseErrorText = regexp.MustCompile(`(?i)\blicense\b[^:;,]{0,60}?\b(is not installed|not installed|not available|has expired|expired|is required)\b|\bnot licensed\b|\bvalid license\b`)
+
+// IsLicenseError tells whether err means that the grid refused a request
+// for lack of a valid license.
+func IsLicenseError(err error) bool {
+	return err != nil && licenseErrorText.MatchString(err.Error())
+}
+
+// FailureReason classifies the error of a failed allocation into one of the
+// Reason constants.
+func FailureReason(err error) string {
+	// Errors of the grid are often wrapped in those of the objects they were
+	// returned for, so the grid is looked at first.
+	switch {
+	case IsGridUnreachable(err):
+		return ReasonGridUnreachable
+	case IsLicenseError(err):
+		return ReasonLicenseUnavailable
+	case IsNetworkExhausted(err):
+		return ReasonNetworkExhausted
+	case IsNetworkViewMissing(err):
+		return ReasonNetworkViewNotFound
+	}
+	return ReasonAllocationFailed
+}
+
+// networkNotFoundText matches the texts of the WAPI errors returned for an
+// address in a network or network view that does not exist.
+var networkNotFoundText = regexp.MustCompile(`AdmConDataNotFoundError: (Network( view)? \S+ not found|Cannot find a network)`)
+
+// IsNetworkNotFound tells whether err means that the network, or its network
+// view, an address was requested in does not exist, e.g. because it was
+// deleted once unused after it had been looked up.
+func IsNetworkNotFound(err error) bool {
+	return err != nil && networkNotFoundText.MatchString(err.Error())
+}
+
+// isNoAvailableIPError tells whether err is the WAPI error returned by
+// next-available-ip when no address is left.
+func isNoAvailableIPError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "available ip address") || strings.Contains(msg, "no available ip")
+}
@@ -0,0 +1,64 @@
+package ibcni
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"errors"
+	"fmt"
+	"net"
+)
+
+var _ = Describe("FailureReason", func() {
+	It("Should classify exhausted networks and missing network views", func() {
+		Expect(FailureReason(&NetworkExhaustedError{Cidr: "10.0.0.0/24", Err: errors.New("no available IP")})).To(Equal(ReasonNetworkExhausted))
+		Expect(FailureReason(&NetworkViewError{Name: "blue", Err: errors.New("permission denied")})).To(Equal(ReasonNetworkViewNotFound))
+	})
+
+	It("Should classify wrapped errors and unwrap them", func() {
+		exhausted := &NetworkExhaustedError{Cidr: "10.0.0.0/24", Err: errors.New("no available IP")}
+		Expect(FailureReason(fmt.Errorf("overflow: %w", exhausted))).To(Equal(ReasonNetworkExhausted))
+		Expect(IsNetworkViewMissing(fmt.Errorf("request: %w", &NetworkViewError{Name: "blue", Err: errors.New("permission denied")}))).To(BeTrue())
+
+		opErr := &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+		var netErr net.Error
+		Expect(errors.As(&NetworkViewError{Name: "blue", Err: opErr}, &netErr)).To(BeTrue())
+		Expect(errors.Unwrap(&NetworkExhaustedError{Cidr: "10.0.0.0/24", Err: opErr})).To(Equal(opErr))
+	})
+
+	It("Should classify errors reaching the grid", func() {
+		Expect(FailureReason(ErrCircuitOpen)).To(Equal(ReasonGridUnreachable))
+		Expect(FailureReason(&net.OpError{Op: "dial", Err: errors.New("connection refused")})).To(Equal(ReasonGridUnreachable))
+		Expect(FailureReason(fmt.Errorf("Post https://grid/wapi/v2.5/network: dial tcp 10.0.0.1:443: connect: connection refused"))).To(Equal(ReasonGridUnreachable))
+	})
+
+	It("Should classify license errors, even when wrapped", func() {
+		err := &NetworkViewError{Name: "blue", Err: errors.New("License 'Cloud Network Automation' is not installed")}
+		Expect(FailureReason(err)).To(Equal(ReasonLicenseUnavailable))
+		Expect(IsLicenseError(errors.New("AdmConProtoError: The Cloud Network Automation license has expired"))).To(BeTrue())
+		Expect(IsLicenseError(errors.New("AdmConProtoError: Operation not licensed"))).To(BeTrue())
+		Expect(IsLicenseError(errors.New("Cloud Network Automation License not available or Infoblox WAPI user not having sufficient permissions. "))).To(BeTrue())
+	})
+
+	It("Should not take names containing the word license for license errors", func() {
+		err := &NetworkViewError{Name: "license-lab", Err: errors.New("network view 'license-lab' not found")}
+		Expect(IsLicenseError(err)).To(BeFalse())
+		Expect(FailureReason(err)).To(Equal(ReasonNetworkViewNotFound))
+
+		err2 := &NetworkExhaustedError{Cidr: "10.0.0.0/24", Err: errors.New("network 'license-net' is exhausted: no available IP address")}
+		Expect(IsLicenseError(err2)).To(BeFalse())
+		Expect(FailureReason(err2)).To(Equal(ReasonNetworkExhausted))
+	})
+
+	It("Should tell when the network of an address does not exist", func() {
+		Expect(IsNetworkNotFound(errors.New("AdmConDataNotFoundError: Network 10.0.0.0/24 not found in network view 'blue'"))).To(BeTrue())
+		Expect(IsNetworkNotFound(errors.New("AdmConDataNotFoundError: Network view 'blue' not found"))).To(BeTrue())
+		Expect(IsNetworkNotFound(errors.New("AdmConDataNotFoundError: Cannot find a network for IP address 10.0.0.5 in network view 'blue'"))).To(BeTrue())
+		Expect(IsNetworkNotFound(errors.New("AdmConDataNotFoundError: Reference fixedaddress/abc not found"))).To(BeFalse())
+		Expect(IsNetworkNotFound(nil)).To(BeFalse())
+	})
+
+	It("Should fall back to a generic reason", func() {
+		Expect(FailureReason(errors.New("requested IP is outside the allocation ranges"))).To(Equal(ReasonAllocationFailed))
+	})
+})
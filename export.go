@@ -0,0 +1,57 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package ibcni
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Export formats
+const (
+	ExportCSV  = "csv"
+	ExportJSON = "json"
+)
+
+var exportHeader = []string{
+	"ip-address", "mac", "network-view", "cidr", "container-id",
+	"pod-name", "namespace", "node-name", "allocated-at",
+}
+
+// ExportAllocations writes the allocations to w in the given format, for
+// audits.
+func ExportAllocations(w io.Writer, format string, allocations []Allocation) error {
+	switch format {
+	case ExportCSV:
+		cw := csv.NewWriter(w)
+		cw.Write(exportHeader)
+		for _, a := range allocations {
+			cw.Write([]string{
+				a.IPAddress, a.Mac, a.NetworkView, a.Cidr, a.ContainerID,
+				a.PodName, a.Namespace, a.NodeName, a.AllocatedAt,
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	case ExportJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(allocations)
+	}
+	return fmt.Errorf("unknown export format '%s', expected %s or %s", format, ExportCSV, ExportJSON)
+}
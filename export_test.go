@@ -0,0 +1,41 @@
+package ibcni
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"bytes"
+)
+
+var _ = Describe("ExportAllocations", func() {
+	allocations := []Allocation{{
+		IPAddress:   "10.0.0.5",
+		Mac:         "0a:58:0a:00:00:05",
+		NetworkView: "default",
+		Cidr:        "10.0.0.0/24",
+		ContainerID: "abc123",
+		PodName:     "nginx",
+		Namespace:   "web",
+		NodeName:    "node-1",
+		AllocatedAt: "2018-06-01T10:00:00Z",
+	}}
+
+	It("Should write CSV with a header", func() {
+		var buf bytes.Buffer
+		Expect(ExportAllocations(&buf, ExportCSV, allocations)).To(BeNil())
+		Expect(buf.String()).To(Equal(
+			"ip-address,mac,network-view,cidr,container-id,pod-name,namespace,node-name,allocated-at\n" +
+				"10.0.0.5,0a:58:0a:00:00:05,default,10.0.0.0/24,abc123,nginx,web,node-1,2018-06-01T10:00:00Z\n"))
+	})
+
+	It("Should write JSON", func() {
+		var buf bytes.Buffer
+		Expect(ExportAllocations(&buf, ExportJSON, allocations)).To(BeNil())
+		Expect(buf.String()).To(ContainSubstring(`"ip-address": "10.0.0.5"`))
+	})
+
+	It("Should reject unknown formats", func() {
+		var buf bytes.Buffer
+		Expect(ExportAllocations(&buf, "xml", allocations)).NotTo(BeNil())
+	})
+})
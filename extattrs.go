@@ -0,0 +1,171 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package ibcni
+
+import (
+	ibclient "github.com/infobloxopen/infoblox-go-client"
+)
+
+// Extensible attributes set on the objects the daemon creates
+const (
+	EA_NODE_NAME    = "K8S Node Name"
+	EA_CLUSTER_NAME = "K8S Cluster Name"
+	EA_NETWORK_NAME = "CNI Network Name"
+
+	// Time a fixed address was allocated for a container, always set for
+	// audits regardless of "ea-tags".
+	EA_ALLOCATED_AT = "CNI Allocated At"
+
+	// Attachment of a fixed address to a pod, as returned by AttachmentID.
+	// Always set, so that the addresses of a pod with several interfaces
+	// can be told apart.
+	EA_ATTACHMENT = "CNI Attachment"
+
+	// Namespace and name of the StatefulSet pod a sticky address belongs
+	// to, which a recreated pod of the same name reclaims it by.
+	EA_STICKY_POD = "CNI Sticky Pod"
+
+	// Time until which a sticky address is kept reserved after its pod was
+	// deleted.
+	EA_HELD_UNTIL = "CNI Held Until"
+
+	// Node whose warm pool holds a reserved fixed address, which the pool
+	// reclaims it by after a restart. Removed once the address is handed
+	// over to a container.
+	EA_WARM_POOL = "CNI Warm Pool"
+
+	// Owner of the network views and networks the daemon created, which it
+	// may delete again once they are empty. Set to the cluster name, or
+	// DEFAULT_OWNER without an explicit one. Objects owned by DEFAULT_OWNER
+	// are never cleaned up, as the daemons of several clusters may share it.
+	EA_OWNER      = "CNI Owner"
+	DEFAULT_OWNER = "cni-infoblox"
+
+	// Time an owned network view or network was created, which it is not
+	// deleted again before the cleanup grace period has passed.
+	EA_CREATED_AT = "CNI Created At"
+)
+
+// Tags that can be listed in the "ea-tags" IPAM attribute
+const (
+	TAG_POD_NAMESPACE      = "pod-namespace"
+	TAG_POD_NAME           = "pod-name"
+	TAG_POD_UID            = "pod-uid"
+	TAG_INFRA_CONTAINER_ID = "infra-container-id"
+	TAG_NODE_NAME          = "node-name"
+	TAG_CLUSTER_NAME       = "cluster-name"
+	TAG_NETWORK_NAME       = "network-name"
+	TAG_CNI_ARGS           = "cni-args"
+)
+
+var tagExtAttrs = map[string]string{
+	TAG_POD_NAMESPACE:      EA_POD_NAMESPACE,
+	TAG_POD_NAME:           EA_POD_NAME,
+	TAG_POD_UID:            EA_POD_UID,
+	TAG_INFRA_CONTAINER_ID: EA_INFRA_CONTAINER_ID,
+	TAG_NODE_NAME:          EA_NODE_NAME,
+	TAG_CLUSTER_NAME:       EA_CLUSTER_NAME,
+	TAG_NETWORK_NAME:       EA_NETWORK_NAME,
+	TAG_CNI_ARGS:           EA_CNI_ARGS,
+}
+
+// TagExtAttrNames returns the names of all extensible attributes the daemon
+// sets on the objects it creates, so that their definitions can be created
+// up front.
+func TagExtAttrNames() []string {
+	names := make([]string, 0, len(tagExtAttrs)+7)
+	for _, name := range tagExtAttrs {
+		names = append(names, name)
+	}
+	return append(names, EA_ALLOCATED_AT, EA_ATTACHMENT, EA_STICKY_POD, EA_HELD_UNTIL, EA_WARM_POOL, EA_OWNER, EA_CREATED_AT)
+}
+
+// AttachmentID identifies the attachment of a container to a CNI network
+// through an interface. A container may be attached to several networks,
+// e.g. by Multus, each of which gets an address of its own.
+func AttachmentID(networkName string, ifName string) string {
+	return networkName + "/" + ifName
+}
+
+// ExtAttrTagger builds the extensible attributes set on fixed addresses,
+// networks and network views, so that grid admins can tell which cluster,
+// node and workload owns them. Which attributes are set is controlled by the
+// "ea-tags" IPAM attribute (all of them by default), and the static
+// "extattrs" of the IPAM configuration are added to every object.
+type ExtAttrTagger struct {
+	NodeName    string
+	ClusterName string
+}
+
+func tagEnabled(ipam *IPAMConfig, tag string) bool {
+	if ipam == nil || ipam.EATags == nil {
+		return true
+	}
+	for _, t := range ipam.EATags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *ExtAttrTagger) tag(ea ibclient.EA, ipam *IPAMConfig, tag string, value string) {
+	if value != "" && tagEnabled(ipam, tag) {
+		ea[tagExtAttrs[tag]] = value
+	}
+}
+
+func (t *ExtAttrTagger) common(conf NetConfig) ibclient.EA {
+	ea := ibclient.EA{}
+	if conf.IPAM != nil {
+		for k, v := range conf.IPAM.ExtAttrs {
+			ea[k] = v
+		}
+	}
+	t.tag(ea, conf.IPAM, TAG_CLUSTER_NAME, t.ClusterName)
+	return ea
+}
+
+// AddressEA returns the extensible attributes of a fixed address allocated
+// for a pod.
+func (t *ExtAttrTagger) AddressEA(conf NetConfig, podArgs *PodArgs) ibclient.EA {
+	ea := t.common(conf)
+	t.tag(ea, conf.IPAM, TAG_NETWORK_NAME, conf.Name)
+	t.tag(ea, conf.IPAM, TAG_NODE_NAME, t.NodeName)
+	if podArgs != nil {
+		t.tag(ea, conf.IPAM, TAG_POD_NAMESPACE, podArgs.Namespace())
+		t.tag(ea, conf.IPAM, TAG_POD_NAME, podArgs.PodName())
+		t.tag(ea, conf.IPAM, TAG_POD_UID, podArgs.PodUID())
+		t.tag(ea, conf.IPAM, TAG_INFRA_CONTAINER_ID, podArgs.InfraContainerID())
+		t.tag(ea, conf.IPAM, TAG_CNI_ARGS, podArgs.CustomArgs())
+	}
+	return ea
+}
+
+// NetworkEA returns the extensible attributes of a network created for a
+// CNI network. Networks are shared between nodes, so they are not tagged
+// with the node name.
+func (t *ExtAttrTagger) NetworkEA(conf NetConfig) ibclient.EA {
+	ea := t.common(conf)
+	t.tag(ea, conf.IPAM, TAG_NETWORK_NAME, conf.Name)
+	return ea
+}
+
+// NetworkViewEA returns the extensible attributes of a network view created
+// for a CNI network.
+func (t *ExtAttrTagger) NetworkViewEA(conf NetConfig) ibclient.EA {
+	return t.common(conf)
+}
@@ -0,0 +1,63 @@
+package ibcni
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	ibclient "github.com/infobloxopen/infoblox-go-client"
+)
+
+var _ = Describe("ExtAttrTagger", func() {
+	tagger := &ExtAttrTagger{NodeName: "node-1", ClusterName: "cluster-1"}
+	podArgs := &PodArgs{}
+	podArgs.K8S_POD_NAMESPACE = "default"
+	podArgs.K8S_POD_NAME = "nginx"
+	podArgs.Custom = map[string]string{"TENANT": "blue", "APP": "web"}
+
+	Context("When ea-tags is not set", func() {
+		conf := NetConfig{
+			Name: "yellow",
+			IPAM: &IPAMConfig{ExtAttrs: map[string]string{"Site": "DC1"}},
+		}
+
+		It("Should tag fixed addresses with everything known", func() {
+			Expect(tagger.AddressEA(conf, podArgs)).To(Equal(ibclient.EA{
+				"Site":           "DC1",
+				EA_CLUSTER_NAME:  "cluster-1",
+				EA_NODE_NAME:     "node-1",
+				EA_NETWORK_NAME:  "yellow",
+				EA_POD_NAMESPACE: "default",
+				EA_POD_NAME:      "nginx",
+				EA_CNI_ARGS:      "APP=web;TENANT=blue",
+			}))
+		})
+		It("Should not tag networks with the node name", func() {
+			Expect(tagger.NetworkEA(conf)).To(Equal(ibclient.EA{
+				"Site":          "DC1",
+				EA_CLUSTER_NAME: "cluster-1",
+				EA_NETWORK_NAME: "yellow",
+			}))
+		})
+		It("Should tag network views with the cluster name", func() {
+			Expect(tagger.NetworkViewEA(conf)).To(Equal(ibclient.EA{
+				"Site":          "DC1",
+				EA_CLUSTER_NAME: "cluster-1",
+			}))
+		})
+	})
+
+	Context("When ea-tags selects some tags", func() {
+		conf := NetConfig{
+			Name: "yellow",
+			IPAM: &IPAMConfig{EATags: []string{TAG_POD_NAME, TAG_NODE_NAME}},
+		}
+
+		It("Should only set the selected tags", func() {
+			Expect(tagger.AddressEA(conf, podArgs)).To(Equal(ibclient.EA{
+				EA_NODE_NAME: "node-1",
+				EA_POD_NAME:  "nginx",
+			}))
+			Expect(tagger.NetworkViewEA(conf)).To(BeEmpty())
+		})
+	})
+})
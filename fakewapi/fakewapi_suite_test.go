@@ -0,0 +1,13 @@
+package fakewapi_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestFakewapi(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Fakewapi Suite")
+}
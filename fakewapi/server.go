@@ -0,0 +1,389 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package fakewapi is an in-process fake of the Infoblox WAPI, so that the
+// driver and the daemon can be tested against ibclient without a grid.
+//
+// It implements the objects the driver uses (networkview, network,
+// networkcontainer, fixedaddress, range, extensibleattributedef and the
+// license objects) with the next available IP and network functions, and
+// answers failed requests with the status codes and error documents of the
+// WAPI. Searches are paged with _paging, _max_results, _return_as_object
+// and _page_id, and fail like the WAPI's once they would return more than
+// MaxResults objects unpaged.
+package fakewapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	ibclient "github.com/infobloxopen/infoblox-go-client"
+)
+
+const (
+	DefaultNetworkView = "default"
+	Username           = "admin"
+	Password           = "infoblox"
+	WapiVersion        = "2.5"
+
+	// Number of objects an unpaged search may return, as on a grid.
+	DefaultMaxResults = 1000
+)
+
+type failure struct {
+	method     string
+	objectType string
+	err        *wapiError
+}
+
+// Server is a fake grid serving the WAPI over TLS.
+type Server struct {
+	*httptest.Server
+
+	// MaxResults is the number of objects an unpaged search may return.
+	MaxResults int
+
+	mu       sync.Mutex
+	objects  []Object
+	nextID   int
+	failures []failure
+}
+
+// NewServer starts a fake grid with the default network view, a cloud
+// license and a user profile for Username.
+func NewServer() *Server {
+	s := &Server{MaxResults: DefaultMaxResults}
+	s.insert("networkview", DefaultNetworkView+"/true", Object{"name": DefaultNetworkView, "is_default": true})
+	s.insert("userprofile", Username, Object{"name": Username})
+	s.setLicenses("CLOUD")
+	s.Server = httptest.NewTLSServer(s)
+	return s
+}
+
+// HostConfig returns the ibclient configuration for the server.
+func (s *Server) HostConfig() ibclient.HostConfig {
+	host, port, _ := net.SplitHostPort(s.Listener.Addr().String())
+	return ibclient.HostConfig{
+		Host:     host,
+		Port:     port,
+		Version:  WapiVersion,
+		Username: Username,
+		Password: Password,
+	}
+}
+
+// NewConnector returns an ibclient connector to the server.
+func (s *Server) NewConnector() (*ibclient.Connector, error) {
+	return ibclient.NewConnector(s.HostConfig(),
+		ibclient.NewTransportConfig("false", 10, 1),
+		&ibclient.WapiRequestBuilder{}, &ibclient.WapiHttpRequestor{})
+}
+
+// Create creates an object as a POST request would, so the usual
+// validation and next available functions apply.
+func (s *Server) Create(objType string, fields Object) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ref, werr := s.create(objType, fields)
+	if werr != nil {
+		return "", werr
+	}
+	return ref, nil
+}
+
+// Update updates the fields of an object as a PUT request would.
+func (s *Server) Update(ref string, fields Object) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, werr := s.update(ref, fields); werr != nil {
+		return werr
+	}
+	return nil
+}
+
+// Delete deletes an object as a DELETE request would, along with the
+// objects it contains.
+func (s *Server) Delete(ref string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, werr := s.remove(ref); werr != nil {
+		return werr
+	}
+	return nil
+}
+
+// AddNetworkView creates a network view with the given extensible
+// attributes.
+func (s *Server) AddNetworkView(name string, ea map[string]interface{}) (string, error) {
+	return s.Create("networkview", Object{"name": name, "extattrs": extAttrs(ea)})
+}
+
+// AddNetworkContainer creates a network container.
+func (s *Server) AddNetworkContainer(netview string, cidr string) (string, error) {
+	return s.Create("networkcontainer", Object{"network_view": netview, "network": cidr})
+}
+
+// AddNetwork creates a network with the given extensible attributes.
+func (s *Server) AddNetwork(netview string, cidr string, ea map[string]interface{}) (string, error) {
+	return s.Create("network", Object{"network_view": netview, "network": cidr, "extattrs": extAttrs(ea)})
+}
+
+// AddRange creates a named DHCP range.
+func (s *Server) AddRange(netview string, name string, start string, end string) (string, error) {
+	return s.Create("range", Object{"network_view": netview, "name": name, "start_addr": start, "end_addr": end})
+}
+
+// SetLicenses replaces the member licenses with licenses of the given
+// types, such as "CLOUD".
+func (s *Server) SetLicenses(types ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.setLicenses(types...)
+}
+
+func (s *Server) setLicenses(types ...string) {
+	kept := s.objects[:0]
+	for _, obj := range s.objects {
+		if objectType(obj.Ref()) != "member:license" {
+			kept = append(kept, obj)
+		}
+	}
+	s.objects = kept
+
+	for _, t := range types {
+		s.insert("member:license", t, Object{"type": t, "kind": "Static", "expiration_status": "PERMANENT"})
+	}
+}
+
+// Objects returns copies of all objects of the type, in creation order.
+func (s *Server) Objects(objType string) []Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var res []Object
+	for _, obj := range s.search(objType, nil) {
+		res = append(res, obj.copy())
+	}
+	return res
+}
+
+// Get returns a copy of the object, or nil if there is none.
+func (s *Server) Get(ref string) Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, obj := s.find(ref); obj != nil {
+		return obj.copy()
+	}
+	return nil
+}
+
+// Fail makes requests with the HTTP method on objects of objType fail with
+// a data conflict carrying text, until ClearFailures is called. ibclient
+// retries failed requests, so a failure has to outlast the first attempt.
+func (s *Server) Fail(method string, objType string, text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = append(s.failures, failure{method: method, objectType: objType, err: conflictError("%s", text)})
+}
+
+// ClearFailures makes requests succeed again after Fail.
+func (s *Server) ClearFailures() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = nil
+}
+
+func (s *Server) failure(method string, objType string) *wapiError {
+	for _, f := range s.failures {
+		if f.method == method && f.objectType == objType {
+			return f.err
+		}
+	}
+	return nil
+}
+
+// ServeHTTP serves requests for /wapi/v<version>/<object type or reference>.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, password, ok := r.BasicAuth(); !ok || user != Username || password != Password {
+		w.Header().Set("WWW-Authenticate", `Basic realm="InfoBlox ONE Platform"`)
+		http.Error(w, "Authorization Required", http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/wapi/"), "/", 2)
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "v") {
+		http.NotFound(w, r)
+		return
+	}
+	target := parts[1]
+
+	var body Object
+	if data, _ := ioutil.ReadAll(r.Body); len(data) > 0 {
+		if err := json.Unmarshal(data, &body); err != nil {
+			writeError(w, protoError("Invalid JSON in request body: %v", err))
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if werr := s.failure(r.Method, objectType(target)); werr != nil {
+		writeError(w, werr)
+		return
+	}
+
+	isRef := strings.Contains(target, "/")
+	var returnFields []string
+	if f := r.URL.Query().Get("_return_fields"); f != "" {
+		returnFields = strings.Split(f, ",")
+	}
+
+	switch {
+	case r.Method == http.MethodGet && isRef:
+		_, obj := s.find(target)
+		if obj == nil {
+			writeError(w, notFoundError("Reference %s not found", target))
+			return
+		}
+		writeJSON(w, http.StatusOK, s.render(obj, returnFields))
+	case r.Method == http.MethodGet:
+		if _, ok := objectTypes[target]; !ok {
+			writeError(w, protoError("Unknown object type (%s)", target))
+			return
+		}
+		// Arguments may be passed in the query string or in the body.
+		filters := map[string]interface{}{}
+		args := map[string]string{}
+		for k, v := range body {
+			if strings.HasPrefix(k, "_") {
+				args[k] = fmt.Sprint(v)
+			} else {
+				filters[k] = v
+			}
+		}
+		for k, v := range r.URL.Query() {
+			if strings.HasPrefix(k, "_") {
+				args[k] = v[0]
+			} else {
+				filters[k] = v[0]
+			}
+		}
+		res := []Object{}
+		for _, obj := range s.search(target, filters) {
+			res = append(res, s.render(obj, returnFields))
+		}
+		if args["_paging"] != "1" {
+			if len(res) > s.MaxResults {
+				writeError(w, protoError("Result set too large (> %d)", s.MaxResults))
+				return
+			}
+			writeJSON(w, http.StatusOK, res)
+			return
+		}
+		page, werr := paged(res, args)
+		if werr != nil {
+			writeError(w, werr)
+			return
+		}
+		writeJSON(w, http.StatusOK, page)
+	case r.Method == http.MethodPost && target == "logout":
+		writeJSON(w, http.StatusOK, "")
+	case r.Method == http.MethodPost && !isRef:
+		ref, werr := s.create(target, body)
+		if werr != nil {
+			writeError(w, werr)
+			return
+		}
+		writeJSON(w, http.StatusCreated, ref)
+	case r.Method == http.MethodPut && isRef:
+		ref, werr := s.update(target, body)
+		if werr != nil {
+			writeError(w, werr)
+			return
+		}
+		writeJSON(w, http.StatusOK, ref)
+	case r.Method == http.MethodDelete && isRef:
+		ref, werr := s.remove(target)
+		if werr != nil {
+			writeError(w, werr)
+			return
+		}
+		writeJSON(w, http.StatusOK, ref)
+	default:
+		writeError(w, protoError("Operation %s not allowed for %s", r.Method, target))
+	}
+}
+
+// paged returns the page of res selected by the paging arguments of a
+// search. Page IDs are the offset of the page's first object.
+func paged(res []Object, args map[string]string) (map[string]interface{}, *wapiError) {
+	if args["_return_as_object"] != "1" {
+		return nil, protoError("_return_as_object must be set for paging")
+	}
+	size, err := strconv.Atoi(args["_max_results"])
+	if err != nil || size <= 0 {
+		return nil, protoError("_max_results must be positive for paging")
+	}
+	offset := 0
+	if id, ok := args["_page_id"]; ok {
+		if offset, err = strconv.Atoi(strings.TrimPrefix(id, "page-")); err != nil || offset < 0 || offset > len(res) {
+			return nil, protoError("Invalid page ID %s", id)
+		}
+	}
+
+	end := offset + size
+	if end > len(res) {
+		end = len(res)
+	}
+	page := map[string]interface{}{"result": res[offset:end]}
+	if end < len(res) {
+		page["next_page_id"] = "page-" + strconv.Itoa(end)
+	}
+	return page, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, werr *wapiError) {
+	writeJSON(w, werr.status, werr)
+}
+
+// extAttrs converts plain values to the WAPI form of extensible attributes.
+func extAttrs(ea map[string]interface{}) map[string]interface{} {
+	res := map[string]interface{}{}
+	for k, v := range ea {
+		res[k] = map[string]interface{}{"value": v}
+	}
+	return res
+}
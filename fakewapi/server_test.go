@@ -0,0 +1,152 @@
+package fakewapi_test
+
+import (
+	. "github.com/infobloxopen/cni-infoblox/fakewapi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	ibclient "github.com/infobloxopen/infoblox-go-client"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+var _ = Describe("Server", func() {
+	log.SetOutput(ioutil.Discard)
+
+	var server *Server
+	var objMgr *ibclient.ObjectManager
+
+	BeforeEach(func() {
+		server = NewServer()
+		conn, err := server.NewConnector()
+		Expect(err).To(BeNil())
+		objMgr = ibclient.NewObjectManager(conn, "Kubernetes", "test-cluster")
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Context("When a client connects", func() {
+		It("Should serve the default network view and a cloud license", func() {
+			netview, err := objMgr.GetNetworkView(DefaultNetworkView)
+			Expect(err).To(BeNil())
+			Expect(netview.Name).To(Equal(DefaultNetworkView))
+
+			licenses, err := objMgr.GetLicense()
+			Expect(err).To(BeNil())
+			Expect(licenses).To(HaveLen(1))
+			Expect(licenses[0].Licensetype).To(Equal("CLOUD"))
+		})
+
+		It("Should reject wrong credentials", func() {
+			config := server.HostConfig()
+			config.Password = "wrong"
+			_, err := ibclient.NewConnector(config, ibclient.NewTransportConfig("false", 10, 1),
+				&ibclient.WapiRequestBuilder{}, &ibclient.WapiHttpRequestor{})
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(ContainSubstring("401"))
+		})
+	})
+
+	Context("When network views are created", func() {
+		It("Should find them by name and reject duplicates", func() {
+			created, err := objMgr.CreateNetworkView("test-view")
+			Expect(err).To(BeNil())
+			Expect(ibclient.BuildNetworkViewFromRef(created.Ref).Name).To(Equal("test-view"))
+
+			netview, err := objMgr.GetNetworkView("test-view")
+			Expect(err).To(BeNil())
+			Expect(netview.Ref).To(Equal(created.Ref))
+			Expect(netview.Ea["CMP Type"]).To(Equal("Kubernetes"))
+
+			_, err = objMgr.CreateNetworkView("test-view")
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(ContainSubstring("already exists"))
+		})
+	})
+
+	Context("When addresses are allocated from a network", func() {
+		It("Should hand out the next available address", func() {
+			_, err := server.AddNetwork(DefaultNetworkView, "10.0.0.0/30", nil)
+			Expect(err).To(BeNil())
+
+			first, err := objMgr.AllocateIP(DefaultNetworkView, "10.0.0.0/30", "", "", "", "")
+			Expect(err).To(BeNil())
+			Expect(first.IPAddress).To(Equal("10.0.0.1"))
+
+			second, err := objMgr.AllocateIP(DefaultNetworkView, "10.0.0.0/30", "", "11:22:33:44:55:66", "", "vm-2")
+			Expect(err).To(BeNil())
+			Expect(second.IPAddress).To(Equal("10.0.0.2"))
+
+			_, err = objMgr.AllocateIP(DefaultNetworkView, "10.0.0.0/30", "", "", "", "")
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(ContainSubstring("Cannot find 1 available IP address(es) in this network"))
+
+			found, err := objMgr.GetFixedAddress(DefaultNetworkView, "", "10.0.0.2", "11:22:33:44:55:66")
+			Expect(err).To(BeNil())
+			Expect(found.Cidr).To(Equal("10.0.0.0/30"))
+			Expect(found.Ea["VM ID"]).To(Equal("vm-2"))
+
+			ref, err := objMgr.ReleaseIP(DefaultNetworkView, "", "10.0.0.1", "")
+			Expect(err).To(BeNil())
+			Expect(ref).To(Equal(first.Ref))
+
+			third, err := objMgr.AllocateIP(DefaultNetworkView, "10.0.0.0/30", "", "", "", "")
+			Expect(err).To(BeNil())
+			Expect(third.IPAddress).To(Equal("10.0.0.1"))
+		})
+
+		It("Should reject an address that is already used", func() {
+			_, err := server.AddNetwork(DefaultNetworkView, "10.0.0.0/24", nil)
+			Expect(err).To(BeNil())
+
+			_, err = objMgr.AllocateIP(DefaultNetworkView, "10.0.0.0/24", "10.0.0.5", "", "", "")
+			Expect(err).To(BeNil())
+			_, err = objMgr.AllocateIP(DefaultNetworkView, "10.0.0.0/24", "10.0.0.5", "", "", "")
+			Expect(err).NotTo(BeNil())
+			Expect(server.Objects("fixedaddress")).To(HaveLen(1))
+		})
+	})
+
+	Context("When networks are allocated from a network container", func() {
+		It("Should carve out the next free network of the prefix length", func() {
+			_, err := objMgr.CreateNetworkContainer(DefaultNetworkView, "10.1.0.0/23")
+			Expect(err).To(BeNil())
+			_, err = server.AddNetwork(DefaultNetworkView, "10.1.0.0/24", nil)
+			Expect(err).To(BeNil())
+
+			network, err := objMgr.AllocateNetwork(DefaultNetworkView, "10.1.0.0/23", 25, "yellow")
+			Expect(err).To(BeNil())
+			Expect(network.Cidr).To(Equal("10.1.1.0/25"))
+
+			byName, err := objMgr.GetNetwork(DefaultNetworkView, "", ibclient.EA{"Network Name": "yellow"})
+			Expect(err).To(BeNil())
+			Expect(byName.Cidr).To(Equal("10.1.1.0/25"))
+
+			network, err = objMgr.AllocateNetwork(DefaultNetworkView, "10.1.0.0/23", 25, "green")
+			Expect(err).To(BeNil())
+			Expect(network.Cidr).To(Equal("10.1.1.128/25"))
+
+			network, err = objMgr.AllocateNetwork(DefaultNetworkView, "10.1.0.0/23", 25, "blue")
+			Expect(network).To(BeNil())
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Context("When a failure is injected", func() {
+		It("Should fail matching requests until failures are cleared", func() {
+			server.Fail(http.MethodPost, "networkview", "injected")
+
+			_, err := objMgr.CreateNetworkView("test-view")
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(ContainSubstring("injected"))
+			Expect(server.Objects("networkview")).To(HaveLen(1))
+
+			server.ClearFailures()
+			_, err = objMgr.CreateNetworkView("test-view")
+			Expect(err).To(BeNil())
+		})
+	})
+})
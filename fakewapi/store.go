@@ -0,0 +1,555 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package fakewapi
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	nextAvailableIP      = "func:nextavailableip:"
+	nextAvailableNetwork = "func:nextavailablenetwork:"
+)
+
+// Object is a WAPI object as the fake stores and returns it. Extensible
+// attributes are kept in their WAPI form, {"Name": {"value": v}}.
+type Object map[string]interface{}
+
+// Ref returns the reference of the object.
+func (o Object) Ref() string {
+	return o.String("_ref")
+}
+
+// String returns the field as a string, or "" if it is not set.
+func (o Object) String(field string) string {
+	v, ok := o[field]
+	if !ok || v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// EA returns the value of the extensible attribute, or nil if it is not set.
+func (o Object) EA(name string) interface{} {
+	ea, _ := o["extattrs"].(map[string]interface{})
+	attr, _ := ea[name].(map[string]interface{})
+	if attr == nil {
+		return nil
+	}
+	return attr["value"]
+}
+
+func (o Object) copy() Object {
+	res := make(Object, len(o))
+	for k, v := range o {
+		res[k] = v
+	}
+	return res
+}
+
+// wapiError is the error document the WAPI returns for a failed request.
+type wapiError struct {
+	status  int
+	Message string `json:"Error"`
+	Code    string `json:"code"`
+	Text    string `json:"text"`
+}
+
+func (e *wapiError) Error() string {
+	return e.Message
+}
+
+func conflictError(format string, args ...interface{}) *wapiError {
+	text := fmt.Sprintf(format, args...)
+	return &wapiError{
+		status:  http.StatusBadRequest,
+		Message: "AdmConDataError: None (IBDataConflictError: IB.Data.Conflict:" + text + ")",
+		Code:    "Client.Ibap.Data.Conflict",
+		Text:    text,
+	}
+}
+
+func notFoundError(format string, args ...interface{}) *wapiError {
+	text := fmt.Sprintf(format, args...)
+	return &wapiError{
+		status:  http.StatusNotFound,
+		Message: "AdmConDataNotFoundError: " + text,
+		Code:    "Client.Ibap.Data.NotFound",
+		Text:    text,
+	}
+}
+
+func protoError(format string, args ...interface{}) *wapiError {
+	text := fmt.Sprintf(format, args...)
+	return &wapiError{
+		status:  http.StatusBadRequest,
+		Message: "AdmConProtoError: " + text,
+		Code:    "Client.Ibap.Proto",
+		Text:    text,
+	}
+}
+
+// objectTypes lists the object types the fake knows, and whether they can be
+// created through the WAPI.
+var objectTypes = map[string]bool{
+	"networkview":            true,
+	"network":                true,
+	"networkcontainer":       true,
+	"fixedaddress":           true,
+	"range":                  true,
+	"extensibleattributedef": true,
+	"member:license":         false,
+	"license:gridwide":       false,
+	"userprofile":            false,
+}
+
+// objectType returns the object type of a reference such as
+// "network/ZG5z...:10.0.0.0/24/default".
+func objectType(ref string) string {
+	return strings.SplitN(ref, "/", 2)[0]
+}
+
+// insert stores an object without any validation and returns its reference.
+func (s *Server) insert(objType string, label string, fields Object) string {
+	s.nextID++
+	id := hex.EncodeToString([]byte(fmt.Sprintf("dns.%s$%d", objType, s.nextID)))
+	ref := fmt.Sprintf("%s/%s:%s", objType, id, label)
+
+	obj := fields.copy()
+	obj["_ref"] = ref
+	s.objects = append(s.objects, obj)
+	return ref
+}
+
+func (s *Server) find(ref string) (int, Object) {
+	for i, obj := range s.objects {
+		if obj.Ref() == ref {
+			return i, obj
+		}
+	}
+	return -1, nil
+}
+
+// search returns the objects of objType matching all filters. Filters named
+// "*Name" match the value of the extensible attribute Name.
+func (s *Server) search(objType string, filters map[string]interface{}) []Object {
+	var res []Object
+	for _, obj := range s.objects {
+		if objectType(obj.Ref()) == objType && matches(obj, filters) {
+			res = append(res, obj)
+		}
+	}
+	return res
+}
+
+func matches(obj Object, filters map[string]interface{}) bool {
+	for k, want := range filters {
+		var got interface{}
+		switch {
+		case strings.HasPrefix(k, "*"):
+			got = obj.EA(k[1:])
+		case k == "extattrs":
+			continue
+		default:
+			got = obj[k]
+		}
+		if got == nil {
+			return false
+		}
+		if k == "mac" {
+			if !strings.EqualFold(fmt.Sprint(got), fmt.Sprint(want)) {
+				return false
+			}
+		} else if fmt.Sprint(got) != fmt.Sprint(want) {
+			return false
+		}
+	}
+	return true
+}
+
+// render returns the object as the WAPI would with the given return fields.
+func (s *Server) render(obj Object, returnFields []string) Object {
+	if len(returnFields) == 0 {
+		return obj.copy()
+	}
+	res := Object{"_ref": obj.Ref()}
+	for _, f := range returnFields {
+		switch {
+		case f == "utilization" && objectType(obj.Ref()) == "network":
+			res[f] = s.utilization(obj.String("network_view"), obj.String("network"))
+		case f == "extattrs":
+			if ea, ok := obj[f]; ok {
+				res[f] = ea
+			} else {
+				res[f] = map[string]interface{}{}
+			}
+		default:
+			if v, ok := obj[f]; ok {
+				res[f] = v
+			}
+		}
+	}
+	return res
+}
+
+func (s *Server) create(objType string, fields Object) (string, *wapiError) {
+	creatable, ok := objectTypes[objType]
+	if !ok {
+		return "", protoError("Unknown object type (%s)", objType)
+	}
+	if !creatable {
+		return "", protoError("Operation create not allowed for %s", objType)
+	}
+	fields = fields.copy()
+	delete(fields, "_ref")
+
+	switch objType {
+	case "networkview":
+		return s.createNetworkView(fields)
+	case "network", "networkcontainer":
+		return s.createNetwork(objType, fields)
+	case "fixedaddress":
+		return s.createFixedAddress(fields)
+	case "range":
+		return s.createRange(fields)
+	default:
+		return s.createEADefinition(fields)
+	}
+}
+
+func (s *Server) createNetworkView(fields Object) (string, *wapiError) {
+	name := fields.String("name")
+	if name == "" {
+		return "", protoError("Field is not writable or required field is missing: name")
+	}
+	if len(s.search("networkview", map[string]interface{}{"name": name})) > 0 {
+		return "", conflictError("Duplicate object '%s' of type 'networkview' already exists in the database.", name)
+	}
+	return s.insert("networkview", name+"/false", fields), nil
+}
+
+func (s *Server) createEADefinition(fields Object) (string, *wapiError) {
+	name := fields.String("name")
+	if name == "" {
+		return "", protoError("Field is not writable or required field is missing: name")
+	}
+	if len(s.search("extensibleattributedef", map[string]interface{}{"name": name})) > 0 {
+		return "", conflictError("Duplicate object '%s' of type 'extensibleattributedef' already exists in the database.", name)
+	}
+	return s.insert("extensibleattributedef", name, fields), nil
+}
+
+// networkView returns the network view of fields, defaulting it like the
+// WAPI does, and checks that it exists.
+func (s *Server) networkView(fields Object) (string, *wapiError) {
+	netview := fields.String("network_view")
+	if netview == "" {
+		netview = DefaultNetworkView
+		fields["network_view"] = netview
+	}
+	if len(s.search("networkview", map[string]interface{}{"name": netview})) == 0 {
+		return "", notFoundError("Network view '%s' not found", netview)
+	}
+	return netview, nil
+}
+
+func (s *Server) createNetwork(objType string, fields Object) (string, *wapiError) {
+	netview, werr := s.networkView(fields)
+	if werr != nil {
+		return "", werr
+	}
+
+	var subnet *net.IPNet
+	cidr := fields.String("network")
+	if strings.HasPrefix(cidr, nextAvailableNetwork) {
+		if subnet, werr = s.nextAvailableNetwork(netview, strings.TrimPrefix(cidr, nextAvailableNetwork)); werr != nil {
+			return "", werr
+		}
+	} else {
+		ip, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil || ip.To4() == nil {
+			return "", protoError("Invalid value for network: '%s'", cidr)
+		}
+		if !ip.Equal(ipNet.IP) {
+			return "", protoError("The network address %s is not a network address for prefix /%d", ip, prefixLen(ipNet))
+		}
+		subnet = ipNet
+	}
+	cidr = subnet.String()
+	fields["network"] = cidr
+
+	for _, obj := range s.search(objType, map[string]interface{}{"network_view": netview}) {
+		_, other, _ := net.ParseCIDR(obj.String("network"))
+		if other.String() == cidr {
+			return "", conflictError("The network %s already exists.  Select another network.", cidr)
+		}
+		if objType == "network" && overlaps(subnet, other) {
+			return "", conflictError("The network %s overlaps with the existing network %s.", cidr, other)
+		}
+	}
+	return s.insert(objType, cidr+"/"+netview, fields), nil
+}
+
+// nextAvailableNetwork carves the first free network out of a network
+// container, for an argument such as "10.0.0.0/16,default,24".
+func (s *Server) nextAvailableNetwork(netview string, arg string) (*net.IPNet, *wapiError) {
+	parts := strings.Split(arg, ",")
+	if len(parts) != 3 {
+		return nil, protoError("Invalid arguments for nextavailablenetwork: '%s'", arg)
+	}
+	_, container, err := net.ParseCIDR(parts[0])
+	if err != nil || parts[1] != netview {
+		return nil, protoError("Invalid arguments for nextavailablenetwork: '%s'", arg)
+	}
+	prefix, err := strconv.Atoi(parts[2])
+	if err != nil || prefix < prefixLen(container) || prefix > 32 {
+		return nil, protoError("Invalid prefix length for nextavailablenetwork: '%s'", parts[2])
+	}
+	if len(s.search("networkcontainer", map[string]interface{}{"network_view": netview, "network": container.String()})) == 0 {
+		return nil, notFoundError("Network container %s not found in network view '%s'", container, netview)
+	}
+
+	networks := s.search("network", map[string]interface{}{"network_view": netview})
+	size := uint64(1) << uint(32-prefix)
+	start, end := ipRange(container)
+	for n := uint64(start); n+size-1 <= uint64(end); n += size {
+		candidate := &net.IPNet{IP: uint32ToIP(uint32(n)), Mask: net.CIDRMask(prefix, 32)}
+		free := true
+		for _, obj := range networks {
+			_, other, _ := net.ParseCIDR(obj.String("network"))
+			if overlaps(candidate, other) {
+				free = false
+				break
+			}
+		}
+		if free {
+			return candidate, nil
+		}
+	}
+	return nil, conflictError("Cannot find 1 available network(s) in this network container")
+}
+
+func (s *Server) createFixedAddress(fields Object) (string, *wapiError) {
+	netview, werr := s.networkView(fields)
+	if werr != nil {
+		return "", werr
+	}
+
+	var ip net.IP
+	addr := fields.String("ipv4addr")
+	if strings.HasPrefix(addr, nextAvailableIP) {
+		if ip, werr = s.nextAvailableIP(netview, strings.TrimPrefix(addr, nextAvailableIP)); werr != nil {
+			return "", werr
+		}
+	} else {
+		if ip = net.ParseIP(addr).To4(); ip == nil {
+			return "", protoError("Invalid value for ipv4addr: '%s'", addr)
+		}
+		if s.addressUsed(netview, ip) {
+			return "", conflictError("The IP address %s is already used by an existing fixed address.", ip)
+		}
+	}
+
+	network := s.networkOf(netview, ip)
+	if network == nil {
+		return "", notFoundError("Cannot find a network for IP address %s in network view '%s'", ip, netview)
+	}
+	fields["ipv4addr"] = ip.String()
+	fields["network"] = network.String()
+
+	return s.insert("fixedaddress", ip.String()+"/"+netview, fields), nil
+}
+
+// nextAvailableIP returns the first free address of a network or of an
+// address range, for an argument such as "10.0.0.0/24,default" or
+// "10.0.0.10-10.0.0.20,default".
+func (s *Server) nextAvailableIP(netview string, arg string) (net.IP, *wapiError) {
+	parts := strings.Split(arg, ",")
+	if len(parts) != 2 || parts[1] != netview {
+		return nil, protoError("Invalid arguments for nextavailableip: '%s'", arg)
+	}
+
+	var start, end uint32
+	if bounds := strings.SplitN(parts[0], "-", 2); len(bounds) == 2 {
+		first, last := net.ParseIP(bounds[0]).To4(), net.ParseIP(bounds[1]).To4()
+		if first == nil || last == nil {
+			return nil, protoError("Invalid address range for nextavailableip: '%s'", parts[0])
+		}
+		start, end = ipToUint32(first), ipToUint32(last)
+	} else {
+		_, subnet, err := net.ParseCIDR(parts[0])
+		if err != nil {
+			return nil, protoError("Invalid network for nextavailableip: '%s'", parts[0])
+		}
+		if len(s.search("network", map[string]interface{}{"network_view": netview, "network": subnet.String()})) == 0 {
+			return nil, notFoundError("Network %s not found in network view '%s'", subnet, netview)
+		}
+		start, end = ipRange(subnet)
+	}
+
+	for n := uint64(start); n <= uint64(end); n++ {
+		ip := uint32ToIP(uint32(n))
+		network := s.networkOf(netview, ip)
+		if network == nil || !usable(network, ip) || s.addressUsed(netview, ip) {
+			continue
+		}
+		return ip, nil
+	}
+	return nil, conflictError("Cannot find 1 available IP address(es) in this network")
+}
+
+func (s *Server) createRange(fields Object) (string, *wapiError) {
+	netview, werr := s.networkView(fields)
+	if werr != nil {
+		return "", werr
+	}
+	start, end := net.ParseIP(fields.String("start_addr")).To4(), net.ParseIP(fields.String("end_addr")).To4()
+	if start == nil || end == nil || ipToUint32(start) > ipToUint32(end) {
+		return "", protoError("Invalid range '%s-%s'", fields.String("start_addr"), fields.String("end_addr"))
+	}
+	network := s.networkOf(netview, start)
+	if network == nil || !network.Contains(end) {
+		return "", notFoundError("Cannot find a network for range %s-%s in network view '%s'", start, end, netview)
+	}
+	fields["network"] = network.String()
+
+	return s.insert("range", fmt.Sprintf("%s/%s/%s", start, end, netview), fields), nil
+}
+
+func (s *Server) update(ref string, fields Object) (string, *wapiError) {
+	_, obj := s.find(ref)
+	if obj == nil {
+		return "", notFoundError("Reference %s not found", ref)
+	}
+	for k, v := range fields {
+		switch k {
+		case "_ref", "network_view", "ipv4addr", "network":
+			continue
+		}
+		obj[k] = v
+	}
+	return ref, nil
+}
+
+// remove deletes the object along with the objects it contains, as the grid
+// does for network views and networks.
+func (s *Server) remove(ref string) (string, *wapiError) {
+	i, obj := s.find(ref)
+	if obj == nil {
+		return "", notFoundError("Reference %s not found", ref)
+	}
+	s.objects = append(s.objects[:i], s.objects[i+1:]...)
+
+	var contained func(Object) bool
+	switch objectType(ref) {
+	case "networkview":
+		contained = func(o Object) bool {
+			return o.String("network_view") == obj.String("name")
+		}
+	case "network", "networkcontainer":
+		_, subnet, _ := net.ParseCIDR(obj.String("network"))
+		contained = func(o Object) bool {
+			if o.String("network_view") != obj.String("network_view") {
+				return false
+			}
+			_, other, err := net.ParseCIDR(o.String("network"))
+			return err == nil && subnet.Contains(other.IP) && prefixLen(other) >= prefixLen(subnet)
+		}
+	default:
+		return ref, nil
+	}
+
+	kept := s.objects[:0]
+	for _, o := range s.objects {
+		if !contained(o) {
+			kept = append(kept, o)
+		}
+	}
+	s.objects = kept
+	return ref, nil
+}
+
+// utilization returns the utilization of a network in tenths of a percent,
+// counting its fixed addresses.
+func (s *Server) utilization(netview string, cidr string) int {
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return 0
+	}
+	used := len(s.search("fixedaddress", map[string]interface{}{"network_view": netview, "network": subnet.String()}))
+	start, end := ipRange(subnet)
+	size := int(end-start) + 1
+	if prefixLen(subnet) < 31 {
+		size -= 2
+	}
+	return used * 1000 / size
+}
+
+func (s *Server) addressUsed(netview string, ip net.IP) bool {
+	return len(s.search("fixedaddress", map[string]interface{}{"network_view": netview, "ipv4addr": ip.String()})) > 0
+}
+
+// networkOf returns the network of the view that contains ip.
+func (s *Server) networkOf(netview string, ip net.IP) *net.IPNet {
+	for _, obj := range s.search("network", map[string]interface{}{"network_view": netview}) {
+		_, subnet, err := net.ParseCIDR(obj.String("network"))
+		if err == nil && subnet.Contains(ip) {
+			return subnet
+		}
+	}
+	return nil
+}
+
+// usable tells whether ip may be handed out, that is whether it is neither
+// the network nor the broadcast address of its network.
+func usable(subnet *net.IPNet, ip net.IP) bool {
+	if prefixLen(subnet) >= 31 {
+		return true
+	}
+	start, end := ipRange(subnet)
+	n := ipToUint32(ip)
+	return n != start && n != end
+}
+
+func overlaps(a *net.IPNet, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+func prefixLen(subnet *net.IPNet) int {
+	ones, _ := subnet.Mask.Size()
+	return ones
+}
+
+func ipRange(subnet *net.IPNet) (uint32, uint32) {
+	start := ipToUint32(subnet.IP.To4())
+	return start, start | ^binary.BigEndian.Uint32(net.IP(subnet.Mask).To4())
+}
+
+func ipToUint32(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+func uint32ToIP(n uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip
+}
@@ -0,0 +1,56 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package ibcni
+
+import (
+	"time"
+)
+
+// WapiHealth describes the state of outbound WAPI traffic.
+type WapiHealth struct {
+	BreakerState        string    `json:"breaker-state"`
+	ConsecutiveFailures int       `json:"consecutive-failures"`
+	LastError           string    `json:"last-error,omitempty"`
+	RetryAt             time.Time `json:"retry-at,omitempty"`
+	RateLimit           float64   `json:"rate-limit"`
+	RateBurst           int       `json:"rate-burst"`
+}
+
+// Utilization levels of a network
+const (
+	UtilizationOK       = "ok"
+	UtilizationWarning  = "warning"
+	UtilizationCritical = "critical"
+)
+
+// NetworkUtilization is the last known utilization of a network the daemon
+// allocates from.
+type NetworkUtilization struct {
+	NetworkView string    `json:"network-view"`
+	Cidr        string    `json:"cidr"`
+	Utilization float64   `json:"utilization"`
+	Level       string    `json:"level"`
+	UpdatedAt   time.Time `json:"updated-at,omitempty"`
+}
+
+// HealthStatus is returned by the daemon's Infoblox.Health RPC method and
+// /health endpoint.
+type HealthStatus struct {
+	Healthy bool       `json:"healthy"`
+	Wapi    WapiHealth `json:"wapi"`
+
+	Networks []NetworkUtilization `json:"networks,omitempty"`
+}
@@ -0,0 +1,80 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package ibcni
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DEFAULT_HOST_LOCAL_DIR is where the host-local IPAM plugin keeps its
+// state, one directory per network.
+const DEFAULT_HOST_LOCAL_DIR = "/var/lib/cni/networks"
+
+// ReadHostLocalAllocations reads the addresses allocated by the host-local
+// IPAM plugin from its state directory for a network. Every allocation is a
+// file named by the IP address, holding the container ID and, with newer
+// versions of the plugin, the interface name on the second line.
+func ReadHostLocalAllocations(dir string) ([]MigrationEntry, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []MigrationEntry
+	for _, file := range files {
+		if file.IsDir() || net.ParseIP(file.Name()) == nil {
+			// Skips the lock and last_reserved_ip.* files.
+			continue
+		}
+		entry, err := readHostLocalFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func readHostLocalFile(path string) (MigrationEntry, error) {
+	entry := MigrationEntry{IPAddress: net.ParseIP(filepath.Base(path)).String()}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return entry, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if scanner.Scan() {
+		entry.ContainerID = strings.TrimSpace(scanner.Text())
+	}
+	if scanner.Scan() {
+		entry.IfName = strings.TrimSpace(scanner.Text())
+	}
+	if err = scanner.Err(); err != nil {
+		return entry, err
+	}
+	if entry.ContainerID == "" {
+		return entry, fmt.Errorf("no container ID in '%s'", path)
+	}
+	return entry, nil
+}
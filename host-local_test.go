@@ -0,0 +1,43 @@
+package ibcni
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var _ = Describe("ReadHostLocalAllocations", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "host-local")
+		Expect(err).To(BeNil())
+		ioutil.WriteFile(filepath.Join(dir, "10.0.0.2"), []byte("abc123"), 0644)
+		ioutil.WriteFile(filepath.Join(dir, "10.0.0.3"), []byte("def456\r\neth0"), 0644)
+		ioutil.WriteFile(filepath.Join(dir, "last_reserved_ip.0"), []byte("10.0.0.3"), 0644)
+		ioutil.WriteFile(filepath.Join(dir, "lock"), []byte(""), 0644)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("Should read the allocation files and skip the others", func() {
+		entries, err := ReadHostLocalAllocations(dir)
+		Expect(err).To(BeNil())
+		Expect(entries).To(Equal([]MigrationEntry{
+			{IPAddress: "10.0.0.2", ContainerID: "abc123"},
+			{IPAddress: "10.0.0.3", ContainerID: "def456", IfName: "eth0"},
+		}))
+	})
+
+	It("Should return an error for an empty allocation file", func() {
+		ioutil.WriteFile(filepath.Join(dir, "10.0.0.4"), []byte(""), 0644)
+		_, err := ReadHostLocalAllocations(dir)
+		Expect(err).NotTo(BeNil())
+	})
+})
@@ -21,6 +21,8 @@ import (
 	"log"
 	"net"
 	"strings"
+	"sync"
+	"time"
 
 	ibclient "github.com/infobloxopen/infoblox-go-client"
 )
@@ -33,32 +35,75 @@ type Container struct {
 }
 
 type IBInfobloxDriver interface {
-	RequestNetworkView(netviewName string) (string, error)
-	RequestAddress(netviewName string, cidr string, ipAddr string, macAddr string, name string, vmID string) (string, error)
+	RequestNetworkView(netviewName string, ea ibclient.EA) (string, error)
+	RequestAddress(netviewName string, cidr string, ipAddr string, macAddr string, name string, vmID string, ea ibclient.EA, ranges []IPRange) (string, error)
+	GetRange(netviewName string, name string) (*IPRange, []IPRange, error)
+	ReserveAddress(netviewName string, cidr string, name string, ea ibclient.EA) (*ibclient.FixedAddress, error)
+	EnsureEADefinitions(names []string) error
 	GetAddress(netviewName string, cidr string, ipAddr string, macAddr string) (*ibclient.FixedAddress, error)
-	UpdateAddress(fixedAddrRef string, macAddr string, name string, vmID string) (*ibclient.FixedAddress, error)
+	UpdateAddress(fixedAddrRef string, macAddr string, name string, vmID string, ea ibclient.EA) (*ibclient.FixedAddress, error)
 	ReleaseAddress(netviewName string, ipAddr string, macAddr string) (ref string, err error)
-	RequestNetwork(netconf NetConfig, netviewName string) (network string, err error)
+	RequestNetwork(netconf NetConfig, netviewName string, ea ibclient.EA) (network string, err error)
+	FindNetwork(netviewName string, cidr string, name string) (*ibclient.Network, error)
+	RequestContainerNetwork(netviewName string, container string, prefixLen uint, name string, ea ibclient.EA, settings *NetworkSettings) (string, error)
+	GetNetworkUtilization(netviewName string, cidr string) (float64, error)
+	GetDHCPOptions(netviewName string, cidr string) (*DHCPOptions, error)
+	ListAddresses(netviewName string, ea ibclient.EA) ([]Allocation, error)
+	FindAddress(netviewName string, ipAddr string) (*Allocation, error)
+	FindAttachment(netviewName string, cidr string, vmID string, attachment string) (*Allocation, error)
+	CheckGrid() error
 	CreateGateway(cidr string, gw net.IP, netviewName string) (string, error)
+	DeleteNetworkIfUnused(netviewName string, cidr string) (bool, error)
+	DeleteNetworkViewIfUnused(netviewName string) (bool, error)
+	InvalidateCache(netviewName string)
 }
 
+const (
+	CMP_TYPE      = "Kubernetes"
+	ZERO_MAC_ADDR = "00:00:00:00:00:00"
+)
+
 type InfobloxDriver struct {
 	objMgr     ibclient.IBObjectManager
+	connector  ibclient.IBConnector
 	Containers []Container
 
 	DefaultNetworkView string
 	DefaultPrefixLen   uint
+	TenantID           string
+
+	// Owner is set as EA_OWNER on the network views and networks the driver
+	// creates, unless it is empty.
+	Owner string
+
+	// CleanupGrace is the time an owned network view or network is kept
+	// after it was created, even if it is unused.
+	CleanupGrace time.Duration
+
+	eaDefsMu sync.Mutex
+	eaDefs   map[string]bool
 }
 
-func (ibDrv *InfobloxDriver) RequestNetworkView(netviewName string) (string, error) {
+func (ibDrv *InfobloxDriver) RequestNetworkView(netviewName string, ea ibclient.EA) (string, error) {
 	var netview *ibclient.NetworkView
+	var err error
 	if netviewName == "" {
 		netviewName = ibDrv.DefaultNetworkView
 	}
-	netview, _ = ibDrv.objMgr.GetNetworkView(netviewName)
+	netview, err = ibDrv.objMgr.GetNetworkView(netviewName)
+	if err != nil {
+		return "", err
+	}
 
 	if netview == nil {
-		netview, _ = ibDrv.objMgr.CreateNetworkView(netviewName)
+		netview, err = ibDrv.createNetworkView(netviewName, ea)
+		if err != nil || netview == nil {
+			log.Printf("RequestNetworkView: cannot create network view '%s': %v", netviewName, err)
+			if err == nil {
+				err = errors.New("no network view returned")
+			}
+			return "", &NetworkViewError{Name: netviewName, Err: err}
+		}
 	}
 
 	log.Printf("RequestNetworkView: netview result is '%s'", *netview)
@@ -74,35 +119,366 @@ func (ibDrv *InfobloxDriver) GetAddress(netviewName string, cidr string, ipAddr
 	return fixedAddr, err
 }
 
-func (ibDrv *InfobloxDriver) RequestAddress(netviewName string, cidr string, ipAddr string, macAddr string, name string, vmID string) (string, error) {
+// RequestAddress allocates an address in cidr for the container. If ranges
+// is not empty, the address is taken from the first of them that has one
+// available.
+func (ibDrv *InfobloxDriver) RequestAddress(netviewName string, cidr string, ipAddr string, macAddr string, name string, vmID string, ea ibclient.EA, ranges []IPRange) (string, error) {
 	var fixedAddr *ibclient.FixedAddress
+	var err error
 	if netviewName == "" {
 		netviewName = ibDrv.DefaultNetworkView
 	}
 
 	if len(macAddr) == 0 {
 		log.Println("RequestAddressRequest contains empty MAC Address. '00:00:00:00:00:00' will be used.")
-	} else {
-		fixedAddr, _ = ibDrv.objMgr.GetFixedAddress(netviewName, cidr, ipAddr, macAddr)
+	}
+	if fixedAddr, err = ibDrv.existingAddress(netviewName, cidr, ipAddr, macAddr, vmID, ea); err != nil {
+		return "", err
 	}
 
 	if fixedAddr == nil {
-		fixedAddr, _ = ibDrv.objMgr.AllocateIP(netviewName, cidr, ipAddr, macAddr, name, vmID)
+		if ipAddr == "" && len(ranges) > 0 {
+			fixedAddr, err = ibDrv.allocateIPInRanges(netviewName, cidr, ranges, macAddr, name, vmID, ea)
+		} else {
+			fixedAddr, err = ibDrv.allocateIP(netviewName, cidr, ipAddr, macAddr, name, vmID, ea)
+		}
+		if err != nil {
+			log.Printf("RequestAddress failed with error '%s'", err)
+			if ipAddr == "" && !IsNetworkExhausted(err) && isNoAvailableIPError(err) {
+				err = &NetworkExhaustedError{Cidr: cidr, Err: err}
+			}
+			return "", err
+		}
 	}
 
 	log.Printf("RequestAddress: fixedAddr result is '%s'", *fixedAddr)
 	return fmt.Sprintf("%s", fixedAddr.IPAddress), nil
 }
 
-func (ibDrv *InfobloxDriver) UpdateAddress(fixedAddrRef string, macAddr string, name string, vmID string) (*ibclient.FixedAddress, error) {
+// existingAddress returns the fixed address a retried request got before,
+// or nil if there is none. Containers may share a MAC address, so the
+// address of an attachment is looked up by container ID and attachment, and
+// only addresses requested without an attachment by MAC address.
+func (ibDrv *InfobloxDriver) existingAddress(netviewName string, cidr string, ipAddr string, macAddr string, vmID string, ea ibclient.EA) (*ibclient.FixedAddress, error) {
+	if attachment, ok := ea[EA_ATTACHMENT].(string); ok && attachment != "" && vmID != "" && ibDrv.connector != nil {
+		allocation, err := ibDrv.FindAttachment(netviewName, cidr, vmID, attachment)
+		if err != nil || allocation == nil {
+			return nil, err
+		}
+		if ipAddr != "" && allocation.IPAddress != ipAddr {
+			return nil, nil
+		}
+		return &ibclient.FixedAddress{
+			Ref:         allocation.Ref,
+			NetviewName: allocation.NetworkView,
+			Cidr:        allocation.Cidr,
+			IPAddress:   allocation.IPAddress,
+			Mac:         allocation.Mac,
+			Name:        allocation.Name,
+		}, nil
+	}
 
-	fixedAddr, err := ibDrv.objMgr.UpdateFixedAddress(fixedAddrRef, macAddr, name, vmID)
+	if len(macAddr) == 0 {
+		return nil, nil
+	}
+	fixedAddr, _ := ibDrv.objMgr.GetFixedAddress(netviewName, cidr, ipAddr, macAddr)
+	return fixedAddr, nil
+}
+
+// ReserveAddress allocates the next available address in cidr without
+// assigning it to a container. The address is held with an empty MAC
+// address and the given EAs until UpdateAddress hands it over to a
+// container.
+func (ibDrv *InfobloxDriver) ReserveAddress(netviewName string, cidr string, name string, ea ibclient.EA) (*ibclient.FixedAddress, error) {
+	if netviewName == "" {
+		netviewName = ibDrv.DefaultNetworkView
+	}
+	fixedAddr, err := ibDrv.allocateIP(netviewName, cidr, "", "", name, "", ea)
+	if err != nil {
+		log.Printf("ReserveAddress failed with error '%s'", err)
+		return nil, err
+	}
+	if fixedAddr == nil || fixedAddr.Ref == "" {
+		return nil, fmt.Errorf("no address could be reserved in '%s'", cidr)
+	}
+
+	return fixedAddr, nil
+}
+
+func (ibDrv *InfobloxDriver) UpdateAddress(fixedAddrRef string, macAddr string, name string, vmID string, ea ibclient.EA) (*ibclient.FixedAddress, error) {
+	var fixedAddr *ibclient.FixedAddress
+	var err error
+	if len(ea) == 0 || ibDrv.connector == nil {
+		fixedAddr, err = ibDrv.objMgr.UpdateFixedAddress(fixedAddrRef, macAddr, name, vmID)
+	} else {
+		fixedAddr, err = ibDrv.updateFixedAddress(fixedAddrRef, macAddr, name, vmID, ea)
+	}
 	if err != nil {
 		log.Printf("UpdateAddress failed with error '%s'", err)
 	}
 	return fixedAddr, err
 }
 
+// basicEA returns the extensible attributes the cloud API expects on every
+// object it owns, as set by ibclient.ObjectManager.
+func (ibDrv *InfobloxDriver) basicEA() ibclient.EA {
+	return ibclient.EA{
+		"Cloud API Owned": ibclient.Bool(true),
+		"CMP Type":        CMP_TYPE,
+		"Tenant ID":       ibDrv.TenantID,
+	}
+}
+
+// allocateIP creates a fixed address like ObjectManager.AllocateIP, with
+// additional extensible attributes.
+func (ibDrv *InfobloxDriver) allocateIP(netview string, cidr string, ipAddr string, macAddr string, name string, vmID string, ea ibclient.EA) (*ibclient.FixedAddress, error) {
+	if len(ea) == 0 || ibDrv.connector == nil {
+		return ibDrv.objMgr.AllocateIP(netview, cidr, ipAddr, macAddr, name, vmID)
+	}
+	nextAvailable := ""
+	if ipAddr == "" {
+		nextAvailable = fmt.Sprintf("func:nextavailableip:%s,%s", cidr, netview)
+	}
+	return ibDrv.createFixedAddress(netview, cidr, ipAddr, nextAvailable, macAddr, name, vmID, ea)
+}
+
+// allocateIPInRanges allocates the next available address of the first
+// range that has one.
+func (ibDrv *InfobloxDriver) allocateIPInRanges(netview string, cidr string, ranges []IPRange, macAddr string, name string, vmID string, ea ibclient.EA) (*ibclient.FixedAddress, error) {
+	if ibDrv.connector == nil {
+		return nil, errors.New("allocating from a range requires a WAPI connector")
+	}
+
+	var err error
+	exhausted := true
+	for _, r := range ranges {
+		var fixedAddr *ibclient.FixedAddress
+		nextAvailable := fmt.Sprintf("func:nextavailableip:%s-%s,%s", r.Start, r.End, netview)
+		fixedAddr, err = ibDrv.createFixedAddress(netview, cidr, "", nextAvailable, macAddr, name, vmID, ea)
+		if err == nil {
+			return fixedAddr, nil
+		}
+		log.Printf("allocateIPInRanges: no address allocated in '%s': %s", r, err)
+		exhausted = exhausted && isNoAvailableIPError(err)
+	}
+	if exhausted {
+		return nil, &NetworkExhaustedError{Cidr: cidr, Err: fmt.Errorf("no available IP address in the allocation ranges: %v", err)}
+	}
+	return nil, fmt.Errorf("no address allocated in the allocation ranges of '%s': %v", cidr, err)
+}
+
+// createFixedAddress creates a fixed address for ipAddr, or for the result
+// of the nextAvailable WAPI function if ipAddr is empty.
+func (ibDrv *InfobloxDriver) createFixedAddress(netview string, cidr string, ipAddr string, nextAvailable string, macAddr string, name string, vmID string, ea ibclient.EA) (*ibclient.FixedAddress, error) {
+
+	if macAddr == "" {
+		macAddr = ZERO_MAC_ADDR
+	}
+	allEA := ibDrv.withBasicEA(ea)
+	if vmID != "" {
+		allEA["VM ID"] = vmID
+	}
+
+	fixedAddr := ibclient.NewFixedAddress(ibclient.FixedAddress{
+		NetviewName: netview,
+		Cidr:        cidr,
+		Mac:         macAddr,
+		Name:        name,
+		Ea:          allEA,
+	})
+	if ipAddr == "" {
+		fixedAddr.IPAddress = nextAvailable
+	} else {
+		fixedAddr.IPAddress = ipAddr
+	}
+
+	ref, err := ibDrv.connector.CreateObject(fixedAddr)
+	if err != nil {
+		return nil, err
+	}
+	fixedAddr.Ref = ref
+	fixedAddr.IPAddress = IPAddressFromRef(ref)
+
+	return fixedAddr, nil
+}
+
+// withBasicEA returns ea merged with the cloud API attributes.
+func (ibDrv *InfobloxDriver) withBasicEA(ea ibclient.EA) ibclient.EA {
+	allEA := ibDrv.basicEA()
+	for k, v := range ea {
+		allEA[k] = v
+	}
+	return allEA
+}
+
+// ownedEA adds the ownership EA of the driver, and the time of creation, to
+// the extensible attributes of a network view or network it creates. They
+// take precedence over the given EAs, so that the EAs of a netconf cannot
+// make the driver claim, or give away, the objects it creates.
+func (ibDrv *InfobloxDriver) ownedEA(ea ibclient.EA) ibclient.EA {
+	if ibDrv.Owner == "" {
+		return ea
+	}
+	res := make(ibclient.EA, len(ea)+2)
+	for k, v := range ea {
+		res[k] = v
+	}
+	res[EA_OWNER] = ibDrv.Owner
+	res[EA_CREATED_AT] = time.Now().UTC().Format(time.RFC3339)
+	return res
+}
+
+// createNetworkView creates a network view like
+// ObjectManager.CreateNetworkView, with additional extensible attributes.
+func (ibDrv *InfobloxDriver) createNetworkView(name string, ea ibclient.EA) (*ibclient.NetworkView, error) {
+	ea = ibDrv.ownedEA(ea)
+	if len(ea) == 0 || ibDrv.connector == nil {
+		return ibDrv.objMgr.CreateNetworkView(name)
+	}
+
+	netview := ibclient.NewNetworkView(ibclient.NetworkView{
+		Name: name,
+		Ea:   ibDrv.withBasicEA(ea),
+	})
+	ref, err := ibDrv.connector.CreateObject(netview)
+	if err != nil {
+		return nil, err
+	}
+	netview.Ref = ref
+	ibDrv.InvalidateCache(name)
+
+	return netview, nil
+}
+
+// createNetwork creates a network like ObjectManager.CreateNetwork, with
+// additional extensible attributes and the network settings of the netconf.
+func (ibDrv *InfobloxDriver) createNetwork(netview string, cidr string, name string, ea ibclient.EA, ipam *IPAMConfig) (*ibclient.Network, error) {
+	ea = ibDrv.ownedEA(ea)
+	var settings *NetworkSettings
+	if ipam != nil {
+		settings = ipam.NetworkSettings
+	}
+	if (len(ea) == 0 && settings == nil) || ibDrv.connector == nil {
+		return ibDrv.objMgr.CreateNetwork(netview, cidr, name)
+	}
+
+	allEA := ibDrv.withBasicEA(ea)
+	create := networkCreate{NetviewName: netview, Cidr: cidr}
+	if settings != nil {
+		for k, v := range settings.ExtAttrs {
+			if _, ok := allEA[k]; !ok {
+				allEA[k] = v
+			}
+		}
+		create.Template = settings.Template
+		create.Comment = settings.Comment
+		create.Options = settings.dhcpOptions(ipam.Gateway)
+	}
+	allEA["Network Name"] = name
+	create.Ea = allEA
+
+	ref, err := ibDrv.connector.CreateObject(newNetworkCreate(create))
+	if err != nil {
+		return nil, err
+	}
+	network := ibclient.NewNetwork(ibclient.Network{
+		NetviewName: netview,
+		Cidr:        cidr,
+		Ea:          allEA,
+	})
+	network.Ref = ref
+	ibDrv.InvalidateCache(netview)
+
+	return network, nil
+}
+
+// updateFixedAddress sets MAC address, name and VM ID of a fixed address,
+// merging ea into its existing extensible attributes. A nil value in ea
+// removes the attribute.
+func (ibDrv *InfobloxDriver) updateFixedAddress(fixedAddrRef string, macAddr string, name string, vmID string, ea ibclient.EA) (*ibclient.FixedAddress, error) {
+	var current ibclient.FixedAddress
+	err := ibDrv.connector.GetObject(ibclient.NewFixedAddress(ibclient.FixedAddress{}), fixedAddrRef, &current)
+	if err != nil {
+		return nil, err
+	}
+
+	allEA := ibclient.EA{}
+	for k, v := range current.Ea {
+		allEA[k] = v
+	}
+	if vmID != "" {
+		allEA["VM ID"] = vmID
+	}
+	for k, v := range ea {
+		if v == nil {
+			delete(allEA, k)
+			continue
+		}
+		allEA[k] = v
+	}
+
+	fixedAddr := ibclient.NewFixedAddress(ibclient.FixedAddress{
+		Mac:  macAddr,
+		Name: name,
+		Ea:   allEA,
+	})
+	ref, err := ibDrv.connector.UpdateObject(fixedAddr, fixedAddrRef)
+	if err != nil {
+		return nil, err
+	}
+	fixedAddr.Ref = ref
+	fixedAddr.NetviewName = current.NetviewName
+	fixedAddr.Cidr = current.Cidr
+	fixedAddr.IPAddress = current.IPAddress
+
+	return fixedAddr, nil
+}
+
+// IPAddressFromRef extracts the address from a fixed address reference such
+// as "fixedaddress/ZG5zLmZpeGVkX2FkZHJlc3Mk:10.0.0.5/default".
+func IPAddressFromRef(ref string) string {
+	parts := strings.SplitN(ref, ":", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+	return strings.SplitN(parts[1], "/", 2)[0]
+}
+
+// EnsureEADefinitions creates the definitions of the given string
+// extensible attributes on the grid if they do not exist yet.
+func (ibDrv *InfobloxDriver) EnsureEADefinitions(names []string) error {
+	ibDrv.eaDefsMu.Lock()
+	defer ibDrv.eaDefsMu.Unlock()
+
+	if ibDrv.eaDefs == nil {
+		ibDrv.eaDefs = make(map[string]bool)
+	}
+	for _, name := range names {
+		if ibDrv.eaDefs[name] {
+			continue
+		}
+		eadef, err := ibDrv.objMgr.GetEADefinition(name)
+		if err != nil {
+			return err
+		}
+		if eadef != nil {
+			ibDrv.eaDefs[name] = true
+			continue
+		}
+		_, err = ibDrv.objMgr.CreateEADefinition(ibclient.EADefinition{
+			Name:    name,
+			Type:    "STRING",
+			Comment: "Set by the Infoblox CNI IPAM driver",
+		})
+		if err != nil {
+			return fmt.Errorf("error creating EA definition '%s': %v", name, err)
+		}
+		log.Printf("Created EA definition '%s'", name)
+		ibDrv.eaDefs[name] = true
+	}
+	return nil
+}
+
 func (ibDrv *InfobloxDriver) ReleaseAddress(netviewName string, ipAddr string, macAddr string) (ref string, err error) {
 	if netviewName == "" {
 		netviewName = ibDrv.DefaultNetworkView
@@ -183,7 +559,7 @@ func (ibDrv *InfobloxDriver) allocateNetwork(prefixLen uint, name string, netvie
 	return
 }
 
-func (ibDrv *InfobloxDriver) requestSpecificNetwork(netview string, subnet string, name string) (*ibclient.Network, error) {
+func (ibDrv *InfobloxDriver) requestSpecificNetwork(netview string, subnet string, name string, ea ibclient.EA, ipam *IPAMConfig) (*ibclient.Network, error) {
 	network, err := ibDrv.objMgr.GetNetwork(netview, subnet, nil)
 	if err != nil {
 		return nil, err
@@ -207,14 +583,14 @@ func (ibDrv *InfobloxDriver) requestSpecificNetwork(netview string, subnet strin
 	}
 
 	if network == nil {
-		network, err = ibDrv.objMgr.CreateNetwork(netview, subnet, name)
-		log.Printf("requestSpecificNetwork: CreateNetwork returns '%s', err='%s'", *network, err)
+		network, err = ibDrv.createNetwork(netview, subnet, name, ea, ipam)
+		log.Printf("requestSpecificNetwork: CreateNetwork returns '%v', err='%v'", network, err)
 	}
 
 	return network, err
 }
 
-func (ibDrv *InfobloxDriver) RequestNetwork(netconf NetConfig, netviewName string) (network string, err error) {
+func (ibDrv *InfobloxDriver) RequestNetwork(netconf NetConfig, netviewName string, ea ibclient.EA) (network string, err error) {
 	var ibNetwork *ibclient.Network
 	// netviewName := netconf.IPAM.NetworkView
 	cidr := net.IPNet{}
@@ -240,7 +616,7 @@ func (ibDrv *InfobloxDriver) RequestNetwork(netconf NetConfig, netviewName strin
 			ibNetwork, err = ibDrv.allocateNetwork(prefixLen, netconf.Name, netviewName)
 		} */
 	}
-	ibNetwork, err = ibDrv.requestSpecificNetwork(netviewName, cidr.String(), netconf.Name)
+	ibNetwork, err = ibDrv.requestSpecificNetwork(netviewName, cidr.String(), netconf.Name, ea, netconf.IPAM)
 
 	log.Printf("RequestNetwork: result='%s'", ibNetwork)
 	if ibNetwork != nil {
@@ -249,6 +625,172 @@ func (ibDrv *InfobloxDriver) RequestNetwork(netconf NetConfig, netviewName strin
 	return network, err
 }
 
+// GetRange looks up the named DHCP range in the network view and returns its
+// addresses along with its exclusion ranges.
+func (ibDrv *InfobloxDriver) GetRange(netviewName string, name string) (*IPRange, []IPRange, error) {
+	if ibDrv.connector == nil {
+		return nil, nil, errors.New("looking up a DHCP range requires a WAPI connector")
+	}
+	if netviewName == "" {
+		netviewName = ibDrv.DefaultNetworkView
+	}
+
+	var res []dhcpRange
+	err := ibDrv.connector.GetObject(newDHCPRange(dhcpRange{NetviewName: netviewName, Name: name}), "", &res)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(res) == 0 {
+		return nil, nil, fmt.Errorf("DHCP range '%s' not found in network view '%s'", name, netviewName)
+	}
+
+	r, err := ParseIPRange(res[0].StartAddr + "-" + res[0].EndAddr)
+	if err != nil {
+		return nil, nil, err
+	}
+	var excludes []IPRange
+	for _, e := range res[0].Exclude {
+		excl, err := ParseIPRange(e.StartAddress + "-" + e.EndAddress)
+		if err != nil {
+			return nil, nil, err
+		}
+		excludes = append(excludes, excl)
+	}
+	return &r, excludes, nil
+}
+
+// FindNetwork looks up an existing network by CIDR or, if cidr is empty, by
+// its "Network Name" EA. It returns nil if there is no such network.
+func (ibDrv *InfobloxDriver) FindNetwork(netviewName string, cidr string, name string) (*ibclient.Network, error) {
+	if cidr != "" {
+		return ibDrv.objMgr.GetNetwork(netviewName, cidr, nil)
+	}
+	return ibDrv.objMgr.GetNetwork(netviewName, "", ibclient.EA{"Network Name": name})
+}
+
+// RequestContainerNetwork returns the CIDR of the network named name,
+// carving a new network of prefixLen out of the network container if there
+// is none yet. A new network is created with the network settings, with its
+// CarvedGateway as router.
+func (ibDrv *InfobloxDriver) RequestContainerNetwork(netviewName string, container string, prefixLen uint, name string, ea ibclient.EA, settings *NetworkSettings) (string, error) {
+	network, err := ibDrv.FindNetwork(netviewName, "", name)
+	if err != nil {
+		return "", err
+	}
+	if network != nil {
+		return network.Cidr, nil
+	}
+
+	if _, err = ibDrv.createNetworkContainer(netviewName, container); err != nil {
+		return "", err
+	}
+	if prefixLen == 0 {
+		prefixLen = ibDrv.DefaultPrefixLen
+	}
+	ea = ibDrv.ownedEA(ea)
+
+	if (len(ea) == 0 && settings == nil) || ibDrv.connector == nil {
+		network, err = ibDrv.objMgr.AllocateNetwork(netviewName, container, prefixLen, name)
+		if err != nil {
+			return "", err
+		}
+		if network == nil {
+			return "", fmt.Errorf("network container '%s' is exhausted", container)
+		}
+		ibDrv.InvalidateCache(netviewName)
+		return network.Cidr, nil
+	}
+
+	allEA := ibDrv.withBasicEA(ea)
+	create := networkCreate{
+		NetviewName: netviewName,
+		Cidr:        fmt.Sprintf("func:nextavailablenetwork:%s,%s,%d", container, netviewName, prefixLen),
+	}
+	if settings != nil {
+		for k, v := range settings.ExtAttrs {
+			if _, ok := allEA[k]; !ok {
+				allEA[k] = v
+			}
+		}
+		create.Template = settings.Template
+		create.Comment = settings.Comment
+		create.Options = settings.dhcpOptions(nil)
+	}
+	allEA["Network Name"] = name
+	create.Ea = allEA
+
+	ref, err := ibDrv.connector.CreateObject(newNetworkCreate(create))
+	if err != nil {
+		return "", err
+	}
+	ibDrv.InvalidateCache(netviewName)
+	cidr := cidrFromNetworkRef(ref)
+	if settings == nil {
+		return cidr, nil
+	}
+
+	// The router is only known once the network is carved, as
+	// next-available-network picks its address, so it is set by a separate
+	// update. A network left without its router would be handed out with
+	// the wrong gateway, so it is deleted again if the update fails.
+	_, carved, err := net.ParseCIDR(cidr)
+	if err == nil {
+		update := newNetworkOptions(networkOptions{Options: settings.dhcpOptions(CarvedGateway(carved))})
+		if _, err = ibDrv.connector.UpdateObject(update, ref); err == nil {
+			return cidr, nil
+		}
+		err = fmt.Errorf("error setting the router of network '%s': %v", cidr, err)
+	} else {
+		err = fmt.Errorf("invalid network '%s' carved out of '%s': %v", cidr, container, err)
+	}
+	if _, delErr := ibDrv.connector.DeleteObject(ref); delErr != nil {
+		log.Printf("RequestContainerNetwork: cannot delete network '%s' left without its router: %v", cidr, delErr)
+	}
+	ibDrv.InvalidateCache(netviewName)
+	return "", err
+}
+
+// CarvedGateway returns the gateway of a network carved out of a network
+// container: its first address.
+func CarvedGateway(network *net.IPNet) net.IP {
+	gw := make(net.IP, len(network.IP))
+	copy(gw, network.IP)
+	gw[len(gw)-1]++
+	return gw
+}
+
+// GetNetworkUtilization returns the utilization of the network in percent,
+// as last computed by the grid.
+func (ibDrv *InfobloxDriver) GetNetworkUtilization(netviewName string, cidr string) (float64, error) {
+	if ibDrv.connector == nil {
+		return 0, errors.New("reading network utilization requires a WAPI connector")
+	}
+
+	var res []networkUtilization
+	err := ibDrv.connector.GetObject(newNetworkUtilization(networkUtilization{NetviewName: netviewName, Cidr: cidr}), "", &res)
+	if err != nil {
+		return 0, err
+	}
+	if len(res) == 0 {
+		return 0, fmt.Errorf("network '%s' not found in network view '%s'", cidr, netviewName)
+	}
+	return float64(res[0].Utilization) / 10, nil
+}
+
+// cidrFromNetworkRef extracts the CIDR from a network reference such as
+// "network/ZG5zLm5ldHdvcmskMTAuMC4xLjAvMjQvMA:10.0.1.0/24/default".
+func cidrFromNetworkRef(ref string) string {
+	parts := strings.SplitN(ref, ":", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+	fields := strings.SplitN(parts[1], "/", 3)
+	if len(fields) < 2 {
+		return ""
+	}
+	return fields[0] + "/" + fields[1]
+}
+
 func (ibDrv *InfobloxDriver) CreateGateway(cidr string, gw net.IP, netviewName string) (string, error) {
 
 	gw = gw.To4() //making sure it is only 4 bytes
@@ -281,6 +823,20 @@ func (ibDrv *InfobloxDriver) CreateGateway(cidr string, gw net.IP, netviewName s
 	return fmt.Sprintf("%s", gatewayIp), nil
 }
 
+// InvalidateCache drops cached lookups for the given network view, or all
+// of them if netviewName is empty. It is a no-op if caching is disabled.
+func (ibDrv *InfobloxDriver) InvalidateCache(netviewName string) {
+	cache, ok := ibDrv.objMgr.(*CachingObjectManager)
+	if !ok {
+		return
+	}
+	if netviewName == "" {
+		cache.Invalidate()
+	} else {
+		cache.InvalidateNetworkView(netviewName)
+	}
+}
+
 // This method should be removed because there is no support for list of subnet hereafter.
 func makeContainers(containerList string) []Container {
 	var containers []Container
@@ -293,9 +849,10 @@ func makeContainers(containerList string) []Container {
 	return containers
 }
 
-func NewInfobloxDriver(objMgr ibclient.IBObjectManager, networkView string, networkContainer string, prefixLength uint) *InfobloxDriver {
+func NewInfobloxDriver(objMgr ibclient.IBObjectManager, connector ibclient.IBConnector, networkView string, networkContainer string, prefixLength uint) *InfobloxDriver {
 	return &InfobloxDriver{
 		objMgr:             objMgr,
+		connector:          connector,
 		DefaultNetworkView: networkView,
 		DefaultPrefixLen:   prefixLength,
 		Containers:         makeContainers(networkContainer),
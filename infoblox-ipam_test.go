@@ -5,599 +5,425 @@ import (
 	. "github.com/onsi/gomega"
 
 	"github.com/containernetworking/cni/pkg/types"
+	"github.com/infobloxopen/cni-infoblox/fakewapi"
 	ibclient "github.com/infobloxopen/infoblox-go-client"
 	"io/ioutil"
 	"log"
 	"net"
+	"net/http"
 	"strings"
+	"time"
 )
 
-type MockObjectManager struct {
-	nameArg                           string
-	globalNetviewArg, localNetviewArg string
-	getNetviewArg, createNetviewArg   string
-
-	netviewArg, cidrArg   string
-	eaArg                 ibclient.EA
-	ipAddrArg, macAddrArg string
-	prefixLenArg          uint
-	vmIDArg               string
-	networkRefArg         string
-	eadefArg              ibclient.EADefinition
-
-	getNetworkView, createNetworkView     *ibclient.NetworkView
-	globalNetviewRef, localNetviewRef     string
-	network                               *ibclient.Network
-	getFixedAddress, allocateFixedAddress *ibclient.FixedAddress
-	eaDefinition                          *ibclient.EADefinition
-	fixedAddressRef, networkRef           string
-	err                                   error
-
-	createNetworkViewCalled, createNetworkCalled, allocateIPCalled bool
-	getNetworkNilEaReturnsNil                                      bool
-	getNetworkReturnsNil                                           bool
-
-	networkContainerPoolArgs                                              []string
-	allocateNetworkReturns                                                []*ibclient.Network
-	createNetworkContainerReturns                                         []*ibclient.NetworkContainer
-	getNetworkContainerReturns                                            []*ibclient.NetworkContainer
-	getNetworkContainerCnt, createNetworkContainerCnt, allocateNetworkCnt int
-}
-
-func (f *MockObjectManager) CreateNetworkView(name string) (*ibclient.NetworkView, error) {
-	Expect(name).To(Equal(f.createNetviewArg))
-
-	f.createNetworkViewCalled = true
-
-	return f.createNetworkView, f.err
-}
-
-func (f *MockObjectManager) CreateDefaultNetviews(globalNetview string, localNetview string) (globalNetviewRef string, localNetviewRef string, err error) {
-	Expect(globalNetview).To(Equal(f.globalNetviewArg))
-	Expect(localNetview).To(Equal(f.localNetviewArg))
-
-	return f.globalNetviewRef, f.localNetviewRef, f.err
-}
-
-func (f *MockObjectManager) CreateNetwork(netview string, cidr string, name string) (*ibclient.Network, error) {
-	Expect(netview).To(Equal(f.netviewArg))
-	Expect(cidr).To(Equal(f.cidrArg))
-	Expect(name).To(Equal(f.nameArg))
-
-	f.createNetworkCalled = true
-
-	return f.network, f.err
-}
-
-func (f *MockObjectManager) CreateNetworkContainer(netview string, cidr string) (*ibclient.NetworkContainer, error) {
-	Expect(netview).To(Equal(f.netviewArg))
-	Expect(cidr).To(Equal(f.networkContainerPoolArgs[f.createNetworkContainerCnt]))
-
-	networkContainer := f.createNetworkContainerReturns[f.createNetworkContainerCnt]
-	f.createNetworkContainerCnt++
-
-	return networkContainer, f.err
-}
-
-func (f *MockObjectManager) GetNetworkView(name string) (*ibclient.NetworkView, error) {
-	Expect(name).To(Equal(f.getNetviewArg))
-
-	return f.getNetworkView, f.err
-}
+var _ = Describe("InfobloxIpam", func() {
+	log.SetOutput(ioutil.Discard)
 
-func (f *MockObjectManager) GetNetwork(netview string, cidr string, ea ibclient.EA) (*ibclient.Network, error) {
-	Expect(netview).To(Equal(f.netviewArg))
+	defaultNetworkView := "default-view"
+	defaultNetworkContainer := "192.168.100.0/24"
+	defaultPrefixLen := uint(24)
 
-	if cidr == "" {
-		Expect(ea).To(Equal(f.eaArg))
-	}
+	testView := "test-view"
+	testCidr := "192.168.10.0/24"
+	testMacAddr := "11:22:33:44:55:66"
+	testVmID := "1234567890abcdef"
+	testNetworkName := "yellow"
 
-	if ea == nil {
-		Expect(cidr).To(Equal(f.cidrArg))
-	}
+	var server *fakewapi.Server
+	var ibDriver *InfobloxDriver
 
-	if f.getNetworkReturnsNil || (f.getNetworkNilEaReturnsNil && ea == nil) {
-		return nil, f.err
+	newDriver := func(networkView string, containers string, prefixLen uint) *InfobloxDriver {
+		conn, err := server.NewConnector()
+		Expect(err).To(BeNil())
+		objMgr := ibclient.NewObjectManager(conn, CMP_TYPE, "test-cluster")
+		return NewInfobloxDriver(objMgr, conn, networkView, containers, prefixLen)
 	}
-	return f.network, f.err
-}
-
-func (f *MockObjectManager) GetNetworkContainer(netview string, cidr string) (*ibclient.NetworkContainer, error) {
-	Expect(netview).To(Equal(f.netviewArg))
-	Expect(cidr).To(Equal(f.networkContainerPoolArgs[f.getNetworkContainerCnt]))
-
-	networkContainer := f.getNetworkContainerReturns[f.getNetworkContainerCnt]
-	f.getNetworkContainerCnt++
-
-	return networkContainer, f.err
-}
-
-func (f *MockObjectManager) AllocateIP(netview string, cidr string, ipAddr string, macAddr string, vmID string) (*ibclient.FixedAddress, error) {
-	Expect(netview).To(Equal(f.netviewArg))
-	Expect(cidr).To(Equal(f.cidrArg))
-	Expect(ipAddr).To(Equal(f.ipAddrArg))
-	Expect(macAddr).To(Equal(f.macAddrArg))
-	Expect(vmID).To(Equal(f.vmIDArg))
-
-	f.allocateIPCalled = true
-
-	return f.allocateFixedAddress, f.err
-}
-
-func (f *MockObjectManager) AllocateNetwork(netview string, cidr string, prefixLen uint, name string) (*ibclient.Network, error) {
-	Expect(netview).To(Equal(f.netviewArg))
-	Expect(cidr).To(Equal(f.networkContainerPoolArgs[f.allocateNetworkCnt]))
-	Expect(prefixLen).To(Equal(f.prefixLenArg))
-	Expect(name).To(Equal(f.nameArg))
-
-	network := f.allocateNetworkReturns[f.allocateNetworkCnt]
-	f.allocateNetworkCnt++
-
-	return network, f.err
-}
-
-func (f *MockObjectManager) GetFixedAddress(netview string, cidr string, ipAddr string, macAddr string) (*ibclient.FixedAddress, error) {
-	Expect(netview).To(Equal(f.netviewArg))
-	Expect(cidr).To(Equal(f.cidrArg))
-	Expect(ipAddr).To(Equal(f.ipAddrArg))
-	Expect(macAddr).To(Equal(f.macAddrArg))
-
-	return f.getFixedAddress, f.err
-}
 
-func (f *MockObjectManager) ReleaseIP(netview string, cidr string, ipAddr string, macAddr string) (string, error) {
-	Expect(netview).To(Equal(f.netviewArg))
-	Expect(cidr).To(Equal(f.cidrArg))
-	Expect(ipAddr).To(Equal(f.ipAddrArg))
-	Expect(macAddr).To(Equal(f.macAddrArg))
-
-	return f.fixedAddressRef, f.err
-}
-
-func (f *MockObjectManager) DeleteNetwork(networkRef string, netview string) (string, error) {
-	Expect(networkRef).To(Equal(f.networkRefArg))
-	Expect(netview).To(Equal(f.netviewArg))
-
-	return f.networkRef, f.err
-}
-
-func (f *MockObjectManager) GetEADefinition(name string) (*ibclient.EADefinition, error) {
-	Expect(name).To(Equal(f.nameArg))
-
-	return f.eaDefinition, f.err
-}
-
-func (f *MockObjectManager) CreateEADefinition(eadef ibclient.EADefinition) (*ibclient.EADefinition, error) {
-	Expect(eadef).To(Equal(f.eadefArg))
-
-	return f.eaDefinition, f.err
-}
-
-var _ = Describe("InfobloxIpam", func() {
-	log.SetOutput(ioutil.Discard)
+	BeforeEach(func() {
+		server = fakewapi.NewServer()
+		ibDriver = newDriver(defaultNetworkView, defaultNetworkContainer, defaultPrefixLen)
+	})
 
-	defaultNetworkView := "default-view"
-	defaultNetworkContainer := "192.168.100.0/24"
-	defaultPrefixLen := uint(24)
+	AfterEach(func() {
+		server.Close()
+	})
 
 	Describe("RequestNetworkView", func() {
 		Context("When requested Network View already exists", func() {
-			testView := "test-view"
-			testNetView := &ibclient.NetworkView{Name: testView}
-
-			objMgr := &MockObjectManager{
-				getNetviewArg:  testView,
-				getNetworkView: testNetView,
-				err:            nil,
-			}
-
-			ibDriver := NewInfobloxDriver(objMgr, defaultNetworkView, defaultNetworkContainer, defaultPrefixLen)
+			It("Should return it without creating another", func() {
+				_, err := server.AddNetworkView(testView, nil)
+				Expect(err).To(BeNil())
 
-			var netview string
-			var err error
-			It("Should pass expected netviewName to ObjectManager.GetNetworkView", func() {
-				netview, err = ibDriver.RequestNetworkView(testView)
-			})
-			It("Should not call ObjectManager.CreateNetworkView", func() {
-				Expect(objMgr.createNetworkViewCalled).To(BeFalse())
-			})
-			It("Should return expected NetworkView Name", func() {
-				Expect(netview).To(Equal(testView))
+				netview, err := ibDriver.RequestNetworkView(testView, nil)
 				Expect(err).To(BeNil())
+				Expect(netview).To(Equal(testView))
+				Expect(server.Objects("networkview")).To(HaveLen(2))
 			})
 		})
 
 		Context("When requested Network View does not already exist", func() {
-			testView := "test-view"
-			testNetView := &ibclient.NetworkView{Name: testView}
-
-			objMgr := &MockObjectManager{
-				getNetviewArg:     testView,
-				getNetworkView:    nil,
-				createNetviewArg:  testView,
-				createNetworkView: testNetView,
-				err:               nil,
-			}
-
-			ibDriver := NewInfobloxDriver(objMgr, defaultNetworkView, defaultNetworkContainer, defaultPrefixLen)
+			It("Should create it with the given EAs", func() {
+				netview, err := ibDriver.RequestNetworkView(testView, ibclient.EA{"Cluster": "blue"})
+				Expect(err).To(BeNil())
+				Expect(netview).To(Equal(testView))
 
-			var netview string
-			var err error
-			It("Should pass expected netviewName to ObjectManager.GetNetworkView and ObjectManager.CreateNetworkView", func() {
-				netview, err = ibDriver.RequestNetworkView(testView)
+				views := server.Objects("networkview")
+				Expect(views).To(HaveLen(2))
+				Expect(views[1].String("name")).To(Equal(testView))
+				Expect(views[1].EA("Cluster")).To(Equal("blue"))
+				Expect(views[1].EA("CMP Type")).To(Equal(CMP_TYPE))
 			})
-			It("Should call ObjectManager.CreateNetworkView", func() {
-				Expect(objMgr.createNetworkViewCalled).To(BeTrue())
+		})
+
+		Context("When the Network View cannot be looked up", func() {
+			It("Should return the error without creating the view", func() {
+				server.Fail(http.MethodGet, "networkview", "grid unavailable")
+
+				_, err := ibDriver.RequestNetworkView(testView, nil)
+				Expect(err).NotTo(BeNil())
+				Expect(err.Error()).To(ContainSubstring("grid unavailable"))
+				Expect(IsNetworkViewMissing(err)).To(BeFalse())
+				Expect(server.Objects("networkview")).To(HaveLen(1))
 			})
-			It("Should return created NetworkView Name", func() {
-				Expect(netview).To(Equal(testView))
+		})
+
+		Context("When no Network View is requested", func() {
+			It("Should create the default Network View", func() {
+				netview, err := ibDriver.RequestNetworkView("", nil)
 				Expect(err).To(BeNil())
+				Expect(netview).To(Equal(defaultNetworkView))
 			})
 		})
 	})
 
 	Describe("RequestAddress", func() {
-		Context("When requested Fixed Address already exists", func() {
-			testView := "test-view"
-			testCidr := "192.168.10.0/24"
-			testIpAddr := "192.168.10.10"
-			testMacAddr := "11:22:33:44:55:66"
-			testVmID := "1234567890abcdef"
-
-			testFixedAddr := &ibclient.FixedAddress{
-				NetviewName: testView,
-				Cidr:        testCidr,
-				IPAddress:   testIpAddr,
-				Mac:         testMacAddr,
-				Ea:          ibclient.EA{"VM ID": testVmID},
-			}
+		BeforeEach(func() {
+			_, err := server.AddNetworkView(testView, nil)
+			Expect(err).To(BeNil())
+			_, err = server.AddNetwork(testView, testCidr, nil)
+			Expect(err).To(BeNil())
+		})
 
-			objMgr := &MockObjectManager{
-				netviewArg: testView,
-				cidrArg:    testCidr,
-				ipAddrArg:  testIpAddr,
-				macAddrArg: testMacAddr,
-				vmIDArg:    testVmID,
+		Context("When requested Fixed Address already exists", func() {
+			It("Should return it without allocating another", func() {
+				first, err := ibDriver.RequestAddress(testView, testCidr, "192.168.10.10", testMacAddr, "", testVmID, nil, nil)
+				Expect(err).To(BeNil())
+				Expect(first).To(Equal("192.168.10.10"))
 
-				getFixedAddress: testFixedAddr,
-				err:             nil,
-			}
+				ipAddr, err := ibDriver.RequestAddress(testView, testCidr, "192.168.10.10", testMacAddr, "", testVmID, nil, nil)
+				Expect(err).To(BeNil())
+				Expect(ipAddr).To(Equal("192.168.10.10"))
+				Expect(server.Objects("fixedaddress")).To(HaveLen(1))
+			})
+		})
 
-			ibDriver := NewInfobloxDriver(objMgr, defaultNetworkView, defaultNetworkContainer, defaultPrefixLen)
+		Context("When the address is requested for an attachment", func() {
+			It("Should return the address the attachment already holds", func() {
+				ea := ibclient.EA{EA_ATTACHMENT: "yellow/eth0"}
+				first, err := ibDriver.RequestAddress(testView, testCidr, "", testMacAddr, "", testVmID, ea, nil)
+				Expect(err).To(BeNil())
 
-			var ipAddr string
-			var err error
-			It("Should pass expected arguments to ObjectManager.RequestAddress", func() {
-				ipAddr, err = ibDriver.RequestAddress(testView, testCidr, testIpAddr, testMacAddr, testVmID)
-			})
-			It("Should not call ObjectManager.AllocateIP", func() {
-				Expect(objMgr.allocateIPCalled).To(BeFalse())
+				ipAddr, err := ibDriver.RequestAddress(testView, testCidr, "", "11:22:33:44:55:77", "", testVmID, ea, nil)
+				Expect(err).To(BeNil())
+				Expect(ipAddr).To(Equal(first))
+				Expect(server.Objects("fixedaddress")).To(HaveLen(1))
 			})
-			It("Should return expected FixedAddress object", func() {
-				Expect(ipAddr).To(Equal(testIpAddr))
+
+			It("Should allocate another address for another attachment with the same MAC address", func() {
+				first, err := ibDriver.RequestAddress(testView, testCidr, "", testMacAddr, "", testVmID, ibclient.EA{EA_ATTACHMENT: "yellow/eth0"}, nil)
 				Expect(err).To(BeNil())
+
+				second, err := ibDriver.RequestAddress(testView, testCidr, "", testMacAddr, "", testVmID, ibclient.EA{EA_ATTACHMENT: "yellow/eth1"}, nil)
+				Expect(err).To(BeNil())
+				Expect(second).NotTo(Equal(first))
+
+				third, err := ibDriver.RequestAddress(testView, testCidr, "", testMacAddr, "", "fedcba0987654321", ibclient.EA{EA_ATTACHMENT: "yellow/eth0"}, nil)
+				Expect(err).To(BeNil())
+				Expect(third).NotTo(BeElementOf(first, second))
+				Expect(server.Objects("fixedaddress")).To(HaveLen(3))
 			})
 		})
 
 		Context("When requested Fixed Address does not already exist", func() {
-			testView := "test-view"
-			testCidr := "192.168.10.0/24"
-			testIpAddr := "192.168.10.10"
-			testMacAddr := "11:22:33:44:55:66"
-			testVmID := "1234567890abcdef"
-
-			testFixedAddr := &ibclient.FixedAddress{
-				NetviewName: testView,
-				Cidr:        testCidr,
-				IPAddress:   testIpAddr,
-				Mac:         testMacAddr,
-				Ea:          ibclient.EA{"VM ID": testVmID},
-			}
-
-			objMgr := &MockObjectManager{
-				netviewArg: testView,
-				cidrArg:    testCidr,
-				ipAddrArg:  testIpAddr,
-				macAddrArg: testMacAddr,
-				vmIDArg:    testVmID,
+			It("Should allocate the requested address", func() {
+				ipAddr, err := ibDriver.RequestAddress(testView, testCidr, "192.168.10.20", testMacAddr, "", testVmID, nil, nil)
+				Expect(err).To(BeNil())
+				Expect(ipAddr).To(Equal("192.168.10.20"))
 
-				getFixedAddress:      nil,
-				allocateFixedAddress: testFixedAddr,
-				err:                  nil,
-			}
+				addrs := server.Objects("fixedaddress")
+				Expect(addrs).To(HaveLen(1))
+				Expect(addrs[0].String("ipv4addr")).To(Equal("192.168.10.20"))
+				Expect(addrs[0].String("mac")).To(Equal(testMacAddr))
+			})
+		})
 
-			ibDriver := NewInfobloxDriver(objMgr, defaultNetworkView, defaultNetworkContainer, defaultPrefixLen)
+		Context("When no address is requested", func() {
+			It("Should allocate the next available address with the given EAs", func() {
+				ipAddr, err := ibDriver.RequestAddress(testView, testCidr, "", testMacAddr, "pod", testVmID, ibclient.EA{"Pod Name": "pod"}, nil)
+				Expect(err).To(BeNil())
+				Expect(ipAddr).To(Equal("192.168.10.1"))
 
-			var ipAddr string
-			var err error
-			It("Should pass expected arguments to ObjectManager.RequestAddress and ObjectManager.AllocateIP", func() {
-				ipAddr, err = ibDriver.RequestAddress(testView, testCidr, testIpAddr, testMacAddr, testVmID)
+				addrs := server.Objects("fixedaddress")
+				Expect(addrs).To(HaveLen(1))
+				Expect(addrs[0].String("mac")).To(Equal(testMacAddr))
+				Expect(addrs[0].EA("VM ID")).To(Equal(testVmID))
+				Expect(addrs[0].EA("Pod Name")).To(Equal("pod"))
 			})
-			It("Should call ObjectManager.AllocateIP", func() {
-				Expect(objMgr.allocateIPCalled).To(BeTrue())
+		})
+
+		Context("When allocation ranges are given", func() {
+			It("Should allocate from the first range with an available address", func() {
+				ranges := []IPRange{
+					{Start: net.ParseIP("192.168.10.20"), End: net.ParseIP("192.168.10.20")},
+					{Start: net.ParseIP("192.168.10.30"), End: net.ParseIP("192.168.10.40")},
+				}
+				ipAddr, err := ibDriver.RequestAddress(testView, testCidr, "", testMacAddr, "", testVmID, ibclient.EA{}, ranges)
+				Expect(err).To(BeNil())
+				Expect(ipAddr).To(Equal("192.168.10.20"))
+
+				ipAddr, err = ibDriver.RequestAddress(testView, testCidr, "", "", "", testVmID, ibclient.EA{}, ranges)
+				Expect(err).To(BeNil())
+				Expect(ipAddr).To(Equal("192.168.10.30"))
 			})
-			It("Should return expected FixedAddress object", func() {
-				Expect(ipAddr).To(Equal(testIpAddr))
+		})
+
+		Context("When the network is exhausted", func() {
+			It("Should return a NetworkExhaustedError", func() {
+				_, err := server.AddNetwork(testView, "192.168.20.0/30", nil)
 				Expect(err).To(BeNil())
+
+				for i := 0; i < 2; i++ {
+					_, err = ibDriver.RequestAddress(testView, "192.168.20.0/30", "", "", "", testVmID, nil, nil)
+					Expect(err).To(BeNil())
+				}
+				_, err = ibDriver.RequestAddress(testView, "192.168.20.0/30", "", "", "", testVmID, nil, nil)
+				Expect(IsNetworkExhausted(err)).To(BeTrue())
 			})
 		})
 	})
 
-	Describe("ReleaseAddress", func() {
-		testView := ""
-		testIpAddr := "192.168.10.10"
-		testMacAddr := "11:22:33:44:55:66"
-		testIpRef := "fixedaddress/ZG5zLmJpbmRfY25h:192.168.10.10/default-view"
-
-		objMgr := &MockObjectManager{
-			netviewArg: defaultNetworkView,
-			ipAddrArg:  testIpAddr,
-			macAddrArg: testMacAddr,
+	Describe("ReserveAddress and UpdateAddress", func() {
+		It("Should hand a reserved address over to a container", func() {
+			_, err := server.AddNetwork(fakewapi.DefaultNetworkView, testCidr, nil)
+			Expect(err).To(BeNil())
 
-			fixedAddressRef: testIpRef,
-			err:             nil,
-		}
+			fixedAddr, err := ibDriver.ReserveAddress(fakewapi.DefaultNetworkView, testCidr, "warm", nil)
+			Expect(err).To(BeNil())
+			Expect(fixedAddr.IPAddress).To(Equal("192.168.10.1"))
 
-		ibDriver := NewInfobloxDriver(objMgr, defaultNetworkView, defaultNetworkContainer, defaultPrefixLen)
+			_, err = ibDriver.UpdateAddress(fixedAddr.Ref, testMacAddr, "pod", testVmID, ibclient.EA{"Pod Name": "pod"})
+			Expect(err).To(BeNil())
 
-		var ipRef string
-		var err error
-		It("Should pass expected arguments to ObjectManager.ReleaseIP", func() {
-			ipRef, err = ibDriver.ReleaseAddress(testView, testIpAddr, testMacAddr)
+			updated := server.Get(fixedAddr.Ref)
+			Expect(updated.String("mac")).To(Equal(testMacAddr))
+			Expect(updated.EA("VM ID")).To(Equal(testVmID))
+			Expect(updated.EA("Pod Name")).To(Equal("pod"))
+			Expect(updated.EA("CMP Type")).To(Equal(CMP_TYPE))
 		})
-		It("Should return expected FixedAddress ref", func() {
-			Expect(ipRef).To(Equal(testIpRef))
+	})
+
+	Describe("ReleaseAddress", func() {
+		It("Should delete the Fixed Address and return its ref", func() {
+			_, err := server.AddNetworkView(defaultNetworkView, nil)
+			Expect(err).To(BeNil())
+			_, err = server.AddNetwork(defaultNetworkView, testCidr, nil)
+			Expect(err).To(BeNil())
+			_, err = ibDriver.RequestAddress("", testCidr, "192.168.10.10", testMacAddr, "", testVmID, nil, nil)
+			Expect(err).To(BeNil())
+			ref := server.Objects("fixedaddress")[0].Ref()
+
+			ipRef, err := ibDriver.ReleaseAddress("", "192.168.10.10", testMacAddr)
 			Expect(err).To(BeNil())
+			Expect(ipRef).To(Equal(ref))
+			Expect(server.Objects("fixedaddress")).To(BeEmpty())
 		})
 	})
 
-	Describe("requestSpecificNetwork", func() {
-		Context("When network with matching cidr and name already exist", func() {
-			testView := "test-view"
-			testCidr := "192.168.10.0/24"
-			testNetworkName := "yellow"
-			testEa := ibclient.EA{"Network Name": testNetworkName}
-
-			testNetwork := &ibclient.Network{
-				NetviewName: testView,
-				Cidr:        testCidr,
-				Ea:          testEa,
-			}
-
-			objMgr := &MockObjectManager{
-				netviewArg: testView,
-				cidrArg:    testCidr,
+	Describe("ListAddresses and FindAddress", func() {
+		It("Should return the allocations carrying the EAs", func() {
+			_, err := server.AddNetwork(fakewapi.DefaultNetworkView, testCidr, nil)
+			Expect(err).To(BeNil())
+			_, err = ibDriver.RequestAddress(fakewapi.DefaultNetworkView, testCidr, "", testMacAddr, "", testVmID, ibclient.EA{EA_NODE_NAME: "node-1"}, nil)
+			Expect(err).To(BeNil())
+			_, err = ibDriver.RequestAddress(fakewapi.DefaultNetworkView, testCidr, "", "", "", "other", ibclient.EA{EA_NODE_NAME: "node-2"}, nil)
+			Expect(err).To(BeNil())
 
-				network: testNetwork,
-				err:     nil,
-			}
+			allocations, err := ibDriver.ListAddresses(fakewapi.DefaultNetworkView, ibclient.EA{EA_NODE_NAME: "node-1"})
+			Expect(err).To(BeNil())
+			Expect(allocations).To(HaveLen(1))
+			Expect(allocations[0].ContainerID).To(Equal(testVmID))
+			Expect(allocations[0].IPAddress).To(Equal("192.168.10.1"))
 
-			ibDriver := NewInfobloxDriver(objMgr, defaultNetworkView, defaultNetworkContainer, defaultPrefixLen)
+			allocation, err := ibDriver.FindAddress(fakewapi.DefaultNetworkView, "192.168.10.2")
+			Expect(err).To(BeNil())
+			Expect(allocation.NodeName).To(Equal("node-2"))
 
-			var network *ibclient.Network
-			var err error
-			It("Should pass expected arguments to ObjectManager.GetNetwork", func() {
-				network, err = ibDriver.requestSpecificNetwork(testView, testCidr, testNetworkName)
-			})
-			It("Should not call ObjectManager.CreateNetwork", func() {
-				Expect(objMgr.createNetworkCalled).To(BeFalse())
-			})
-			It("Should return expected Network object", func() {
-				Expect(network).To(Equal(testNetwork))
-				Expect(err).To(BeNil())
-			})
+			allocation, err = ibDriver.FindAddress(fakewapi.DefaultNetworkView, "192.168.10.3")
+			Expect(err).To(BeNil())
+			Expect(allocation).To(BeNil())
 		})
 
-		Context("When no matching network exists", func() {
-			testView := "test-view"
-			testCidr := "192.168.10.0/24"
-			testNetworkName := "yellow"
-			testEa := ibclient.EA{"Network Name": testNetworkName}
-
-			testNetwork := &ibclient.Network{
-				NetviewName: testView,
-				Cidr:        testCidr,
-				Ea:          testEa,
+		It("Should page through more allocations than a search may return", func() {
+			_, err := server.AddNetwork(fakewapi.DefaultNetworkView, testCidr, nil)
+			Expect(err).To(BeNil())
+			for i := 0; i < 5; i++ {
+				_, err = ibDriver.RequestAddress(fakewapi.DefaultNetworkView, testCidr, "", "", "", testVmID, ibclient.EA{EA_NODE_NAME: "node-1"}, nil)
+				Expect(err).To(BeNil())
 			}
+			server.MaxResults = 3
+			pageSize := wapiPageSize
+			wapiPageSize = 2
+			defer func() { wapiPageSize = pageSize }()
 
-			objMgr := &MockObjectManager{
-				netviewArg: testView,
-				cidrArg:    testCidr,
-				eaArg:      testEa,
-				nameArg:    testNetworkName,
+			_, err = ibDriver.ListAddresses(fakewapi.DefaultNetworkView, ibclient.EA{EA_NODE_NAME: "node-1"})
+			Expect(err).NotTo(BeNil())
 
-				network: testNetwork,
-				err:     nil,
+			conn, err := server.NewConnector()
+			Expect(err).To(BeNil())
+			throttled := NewThrottledConnector(conn, GridConfig{})
+			objMgr := ibclient.NewObjectManager(throttled, CMP_TYPE, "test-cluster")
+			ibDriver = NewInfobloxDriver(objMgr, throttled, defaultNetworkView, defaultNetworkContainer, defaultPrefixLen)
 
-				getNetworkReturnsNil: true,
-			}
+			allocations, err := ibDriver.ListAddresses(fakewapi.DefaultNetworkView, ibclient.EA{EA_NODE_NAME: "node-1"})
+			Expect(err).To(BeNil())
+			Expect(allocations).To(HaveLen(5))
+			Expect(allocations[4].IPAddress).To(Equal("192.168.10.5"))
+		})
+	})
 
-			ibDriver := NewInfobloxDriver(objMgr, defaultNetworkView, defaultNetworkContainer, defaultPrefixLen)
+	Describe("requestSpecificNetwork", func() {
+		BeforeEach(func() {
+			_, err := server.AddNetworkView(testView, nil)
+			Expect(err).To(BeNil())
+		})
 
-			var network *ibclient.Network
-			var err error
-			It("Should pass expected arguments to ObjectManager.GetNetwork", func() {
-				network, err = ibDriver.requestSpecificNetwork(testView, testCidr, testNetworkName)
-			})
-			It("Should not call ObjectManager.CreateNetwork", func() {
-				Expect(objMgr.createNetworkCalled).To(BeTrue())
-			})
-			It("Should return nil Network object", func() {
-				Expect(network).To(Equal(testNetwork))
+		Context("When network with matching cidr and name already exist", func() {
+			It("Should return it without creating another", func() {
+				_, err := server.AddNetwork(testView, testCidr, map[string]interface{}{"Network Name": testNetworkName})
 				Expect(err).To(BeNil())
+
+				network, err := ibDriver.requestSpecificNetwork(testView, testCidr, testNetworkName, nil, nil)
+				Expect(err).To(BeNil())
+				Expect(network.Cidr).To(Equal(testCidr))
+				Expect(server.Objects("network")).To(HaveLen(1))
 			})
 		})
 
-		Context("When network with same name exists but has different cidr", func() {
-			testView := "test-view"
-			testCidr := "192.168.10.0/24"
-			mismatchCidr := "192.168.20.0/24"
-			testNetworkName := "yellow"
-			testEa := ibclient.EA{"Network Name": testNetworkName}
-
-			testNetwork := &ibclient.Network{
-				NetviewName: testView,
-				Cidr:        mismatchCidr,
-				Ea:          testEa,
-			}
-
-			objMgr := &MockObjectManager{
-				netviewArg: testView,
-				cidrArg:    testCidr,
-				eaArg:      testEa,
+		Context("When no matching network exists", func() {
+			It("Should create the network", func() {
+				network, err := ibDriver.requestSpecificNetwork(testView, testCidr, testNetworkName, nil, nil)
+				Expect(err).To(BeNil())
+				Expect(network.Cidr).To(Equal(testCidr))
 
-				network: testNetwork,
-				err:     nil,
+				networks := server.Objects("network")
+				Expect(networks).To(HaveLen(1))
+				Expect(networks[0].EA("Network Name")).To(Equal(testNetworkName))
+			})
+		})
 
-				getNetworkNilEaReturnsNil: true,
-			}
+		Context("With network settings", func() {
+			It("Should create the network from the template with comment, DHCP options and EAs", func() {
+				ipam := &IPAMConfig{
+					Gateway: net.ParseIP("192.168.10.1"),
+					NetworkSettings: &NetworkSettings{
+						Template:   "k8s-pods",
+						Comment:    "Pods of the yellow network",
+						DNSServers: []string{"10.0.0.53", "10.0.1.53"},
+						DomainName: "example.com",
+						ExtAttrs:   map[string]string{"Site": "DC1", "Network Name": "other"},
+					},
+				}
+				network, err := ibDriver.requestSpecificNetwork(testView, testCidr, testNetworkName, nil, ipam)
+				Expect(err).To(BeNil())
+				Expect(network.Cidr).To(Equal(testCidr))
 
-			ibDriver := NewInfobloxDriver(objMgr, defaultNetworkView, defaultNetworkContainer, defaultPrefixLen)
+				networks := server.Objects("network")
+				Expect(networks).To(HaveLen(1))
+				Expect(networks[0].String("template")).To(Equal("k8s-pods"))
+				Expect(networks[0].String("comment")).To(Equal("Pods of the yellow network"))
+				Expect(networks[0].EA("Site")).To(Equal("DC1"))
+				Expect(networks[0].EA("Network Name")).To(Equal(testNetworkName))
 
-			var network *ibclient.Network
-			var err error
-			It("Should pass expected arguments to ObjectManager.GetNetwork", func() {
-				network, err = ibDriver.requestSpecificNetwork(testView, testCidr, testNetworkName)
-			})
-			It("Should not call ObjectManager.CreateNetwork", func() {
-				Expect(objMgr.createNetworkCalled).To(BeFalse())
+				options, err := ibDriver.GetDHCPOptions(testView, testCidr)
+				Expect(err).To(BeNil())
+				Expect(options.Routers).To(Equal([]net.IP{net.ParseIP("192.168.10.1")}))
+				Expect(options.DomainNameServers).To(Equal([]string{"10.0.0.53", "10.0.1.53"}))
+				Expect(options.DomainName).To(Equal("example.com"))
 			})
+		})
+
+		Context("When network with same name exists but has different cidr", func() {
 			It("Should return nil Network object", func() {
+				_, err := server.AddNetwork(testView, "192.168.20.0/24", map[string]interface{}{"Network Name": testNetworkName})
+				Expect(err).To(BeNil())
+
+				network, err := ibDriver.requestSpecificNetwork(testView, testCidr, testNetworkName, nil, nil)
+				Expect(err).To(BeNil())
 				Expect(network).To(BeNil())
+				Expect(server.Objects("network")).To(HaveLen(1))
+			})
+		})
+
+		Context("When network with matching cidr has a different name", func() {
+			It("Should return nil Network object", func() {
+				_, err := server.AddNetwork(testView, testCidr, map[string]interface{}{"Network Name": "green"})
+				Expect(err).To(BeNil())
+
+				network, err := ibDriver.requestSpecificNetwork(testView, testCidr, testNetworkName, nil, nil)
 				Expect(err).To(BeNil())
+				Expect(network).To(BeNil())
 			})
 		})
 	})
 
 	Describe("allocateNetworkHelper", func() {
 		Context("When a network can be allocated from a network container", func() {
-			testView := "test-view"
-			testContainerArr := []string{"192.168.10.0/24", "192.168.20.0/24"}
-			testPrefixLen := uint(26)
-			testNetworkName := "yellow"
-
-			testContainers := strings.Join(testContainerArr, ",")
-
-			testNetwork := &ibclient.Network{
-				NetviewName: testView,
-				Cidr:        "192.168.20.100/26",
-			}
+			It("Should create the network container and allocate the network from it", func() {
+				_, err := server.AddNetworkView(testView, nil)
+				Expect(err).To(BeNil())
 
-			objMgr := &MockObjectManager{
-				netviewArg:   testView,
-				prefixLenArg: testPrefixLen,
-				nameArg:      testNetworkName,
-
-				networkContainerPoolArgs: testContainerArr,
-				allocateNetworkReturns: []*ibclient.Network{
-					nil,
-					testNetwork,
-				},
-				createNetworkContainerReturns: []*ibclient.NetworkContainer{
-					&ibclient.NetworkContainer{
-						NetviewName: testView,
-						Cidr:        testContainerArr[0],
-					},
-					&ibclient.NetworkContainer{
-						NetviewName: testView,
-						Cidr:        testContainerArr[1],
-					},
-				},
-				getNetworkContainerReturns: []*ibclient.NetworkContainer{
-					nil,
-					nil,
-				},
+				ibDriver = newDriver(testView, "192.168.10.0/24,192.168.20.0/24", 26)
+				network, err := ibDriver.allocateNetworkHelper(testView, 26, testNetworkName)
+				Expect(err).To(BeNil())
+				Expect(network.Cidr).To(Equal("192.168.10.0/26"))
+				Expect(server.Objects("networkcontainer")).To(HaveLen(1))
+				Expect(server.Objects("network")[0].EA("Network Name")).To(Equal(testNetworkName))
+			})
+		})
 
-				err: nil,
-			}
+		Context("When the first network container is exhausted", func() {
+			testContainerArr := []string{"192.168.10.0/24", "192.168.20.0/24"}
+			testPrefixLen := uint(26)
 
-			ibDriver := NewInfobloxDriver(objMgr, testView, testContainers, testPrefixLen)
+			It("Should allocate the network from the next network container", func() {
+				_, err := server.AddNetworkView(testView, nil)
+				Expect(err).To(BeNil())
+				_, err = server.AddNetwork(testView, "192.168.10.0/24", nil)
+				Expect(err).To(BeNil())
 
-			var network *ibclient.Network
-			var err error
-			It("Should pass expected arguments to ObjectManager.GetNetworkContainer/CreateNetworkContainer/AllocateNetwork", func() {
-				network, err = ibDriver.allocateNetworkHelper(testView, testPrefixLen, testNetworkName)
-			})
-			It("Should call Object Manager the expected no. of times", func() {
-				Expect(objMgr.getNetworkContainerCnt).To(Equal(2))
-				Expect(objMgr.createNetworkContainerCnt).To(Equal(2))
-				Expect(objMgr.allocateNetworkCnt).To(Equal(2))
-			})
-			It("Should return expected Network object", func() {
-				Expect(network).To(Equal(testNetwork))
+				ibDriver = newDriver(testView, strings.Join(testContainerArr, ","), testPrefixLen)
+				network, err := ibDriver.allocateNetworkHelper(testView, testPrefixLen, testNetworkName)
 				Expect(err).To(BeNil())
+				Expect(network.Cidr).To(Equal("192.168.20.0/26"))
+				Expect(server.Objects("networkcontainer")).To(HaveLen(2))
 			})
 		})
 	})
 
 	Describe("allocateNetwork", func() {
 		Context("When no network can be allocated from all Network Containers", func() {
-			testView := "test-view"
 			testContainerArr := []string{"192.168.10.0/24", "192.168.20.0/24"}
 			testPrefixLen := uint(26)
-			testNetworkName := "yellow"
-
-			testContainers := strings.Join(testContainerArr, ",")
-
-			objMgr := &MockObjectManager{
-				netviewArg:   testView,
-				prefixLenArg: testPrefixLen,
-				nameArg:      testNetworkName,
-
-				networkContainerPoolArgs: append(testContainerArr, testContainerArr...),
-				allocateNetworkReturns: []*ibclient.Network{
-					nil,
-					nil,
-					nil,
-					nil,
-				},
-				createNetworkContainerReturns: []*ibclient.NetworkContainer{
-					&ibclient.NetworkContainer{
-						NetviewName: testView,
-						Cidr:        testContainerArr[0],
-					},
-					&ibclient.NetworkContainer{
-						NetviewName: testView,
-						Cidr:        testContainerArr[1],
-					},
-					&ibclient.NetworkContainer{
-						NetviewName: testView,
-						Cidr:        testContainerArr[0],
-					},
-					&ibclient.NetworkContainer{
-						NetviewName: testView,
-						Cidr:        testContainerArr[1],
-					},
-				},
-				getNetworkContainerReturns: []*ibclient.NetworkContainer{
-					nil,
-					nil,
-					&ibclient.NetworkContainer{
-						NetviewName: testView,
-						Cidr:        testContainerArr[0],
-					},
-					&ibclient.NetworkContainer{
-						NetviewName: testView,
-						Cidr:        testContainerArr[1],
-					},
-				},
 
-				err: nil,
-			}
-
-			ibDriver := NewInfobloxDriver(objMgr, testView, testContainers, testPrefixLen)
+			It("Should return an error", func() {
+				_, err := server.AddNetworkView(testView, nil)
+				Expect(err).To(BeNil())
+				for _, c := range testContainerArr {
+					_, err = server.AddNetwork(testView, c, nil)
+					Expect(err).To(BeNil())
+				}
 
-			var network *ibclient.Network
-			var err error
-			It("Should pass expected arguments to ObjectManager.GetNetworkContainer/CreateNetworkContainer/AllocateNetwork", func() {
-				network, err = ibDriver.allocateNetwork(testPrefixLen, testNetworkName)
-			})
-			It("Should call Object Manager the expected no. of times", func() {
-				Expect(objMgr.getNetworkContainerCnt).To(Equal(2))
-				Expect(objMgr.createNetworkContainerCnt).To(Equal(2))
-				Expect(objMgr.allocateNetworkCnt).To(Equal(4))
-			})
-			It("Should return expected Network object", func() {
+				ibDriver = newDriver(testView, strings.Join(testContainerArr, ","), testPrefixLen)
+				network, err := ibDriver.allocateNetwork(testPrefixLen, testNetworkName, testView)
 				Expect(network).To(BeNil())
 				Expect(err.Error()).To(Equal("Cannot allocate network in Address Space"))
 			})
@@ -606,136 +432,220 @@ var _ = Describe("InfobloxIpam", func() {
 
 	Describe("RequestNetwork", func() {
 		Context("When called with a specific subnet cidr", func() {
-			testView := "test-view"
-			testIp := net.IPv4(byte(192), byte(168), byte(30), byte(0))
-			testMask := net.IPv4Mask(byte(255), byte(255), byte(255), byte(0))
-			testIPNet := net.IPNet{IP: testIp, Mask: testMask}
-			testCidr := testIPNet.String()
-			testNetworkName := "yellow"
-			testEa := ibclient.EA{"Network Name": testNetworkName}
-
-			testNetwork := &ibclient.Network{
-				NetviewName: testView,
-				Cidr:        testCidr,
-				Ea:          testEa,
-			}
+			It("Should create the network with the given EAs", func() {
+				_, err := server.AddNetworkView(testView, nil)
+				Expect(err).To(BeNil())
 
-			netconf := NetConfig{
-				Name: testNetworkName,
-				IPAM: &IPAMConfig{
-					NetworkView: testView,
-					Subnet: types.IPNet{
-						IP:   testIp,
-						Mask: testMask,
+				netconf := NetConfig{
+					Name: testNetworkName,
+					IPAM: &IPAMConfig{
+						NetworkView: testView,
+						Subnet: types.IPNet{
+							IP:   net.IPv4(192, 168, 30, 0),
+							Mask: net.IPv4Mask(255, 255, 255, 0),
+						},
 					},
-				},
-			}
+				}
+
+				network, err := ibDriver.RequestNetwork(netconf, testView, ibclient.EA{"Cluster": "blue"})
+				Expect(err).To(BeNil())
+				Expect(network).To(Equal("192.168.30.0/24"))
+
+				networks := server.Objects("network")
+				Expect(networks).To(HaveLen(1))
+				Expect(networks[0].EA("Network Name")).To(Equal(testNetworkName))
+				Expect(networks[0].EA("Cluster")).To(Equal("blue"))
+				Expect(server.Objects("networkcontainer")).To(BeEmpty())
+			})
+		})
+
+		Context("When the network is allocated from a network container", func() {
+			It("Should create the network for the first network container without a subnet", func() {
+				_, err := server.AddNetworkView(testView, nil)
+				Expect(err).To(BeNil())
+				ibDriver = newDriver(testView, "192.168.20.0/24,192.168.40.0/24", 26)
 
-			objMgr := &MockObjectManager{
-				netviewArg:   testView,
-				prefixLenArg: defaultPrefixLen,
-				nameArg:      testNetworkName,
-
-				networkContainerPoolArgs: []string{defaultNetworkContainer},
-				allocateNetworkReturns: []*ibclient.Network{
-					testNetwork,
-				},
-				createNetworkContainerReturns: []*ibclient.NetworkContainer{
-					&ibclient.NetworkContainer{
-						NetviewName: testView,
-						Cidr:        defaultNetworkContainer,
+				netconf := NetConfig{
+					Name: testNetworkName,
+					IPAM: &IPAMConfig{
+						NetworkView: testView,
 					},
-				},
-				getNetworkContainerReturns: []*ibclient.NetworkContainer{
-					nil,
-				},
-
-				cidrArg: testCidr,
-				eaArg:   testEa,
-				network: testNetwork,
-				err:     nil,
-			}
+				}
 
-			ibDriver := NewInfobloxDriver(objMgr, defaultNetworkView, defaultNetworkContainer, defaultPrefixLen)
+				network, err := ibDriver.RequestNetwork(netconf, testView, nil)
+				Expect(err).To(BeNil())
+				Expect(network).To(Equal("192.168.20.0/24"))
 
-			var network string
-			var err error
-			It("Should pass expected arguments to ObjectManager.GetNetworkContainer/CreateNetworkContainer/AllocateNetwork", func() {
-				network, err = ibDriver.RequestNetwork(netconf)
-			})
-			It("Should call Object Manager the expected no. of times", func() {
-				Expect(objMgr.getNetworkContainerCnt).To(Equal(0))
-				Expect(objMgr.createNetworkContainerCnt).To(Equal(0))
-				Expect(objMgr.allocateNetworkCnt).To(Equal(0))
+				network, err = ibDriver.RequestNetwork(netconf, testView, nil)
+				Expect(err).To(BeNil())
+				Expect(network).To(Equal("192.168.20.0/24"))
+
+				networks := server.Objects("network")
+				Expect(networks).To(HaveLen(1))
+				Expect(networks[0].String("network_view")).To(Equal(testView))
+				Expect(networks[0].EA("Network Name")).To(Equal(testNetworkName))
 			})
-			It("Should return expected Network object", func() {
-				Expect(network).To(Equal(testCidr))
+
+			It("Should not use a network of the container taken by another network name", func() {
+				_, err := server.AddNetworkView(testView, nil)
+				Expect(err).To(BeNil())
+				_, err = server.AddNetwork(testView, "192.168.20.0/24", map[string]interface{}{"Network Name": "green"})
+				Expect(err).To(BeNil())
+				ibDriver = newDriver(testView, "192.168.20.0/24", 26)
+
+				netconf := NetConfig{
+					Name: testNetworkName,
+					IPAM: &IPAMConfig{
+						NetworkView: testView,
+					},
+				}
+
+				network, err := ibDriver.RequestNetwork(netconf, testView, nil)
 				Expect(err).To(BeNil())
+				Expect(network).To(BeEmpty())
+				Expect(server.Objects("network")).To(HaveLen(1))
 			})
 		})
+	})
 
-		Context("When a network can be allocated from a network container", func() {
-			testView := "test-view"
-			testContainerArr := []string{"192.168.10.0/24"}
-			testPrefixLen := uint(26)
-			testNetworkName := "yellow"
-			testCidr := "192.168.10.100/26"
-			testEa := ibclient.EA{"Network Name": testNetworkName}
-
-			testContainers := strings.Join(testContainerArr, ",")
-
-			netconf := NetConfig{
-				Name: testNetworkName,
-				IPAM: &IPAMConfig{
-					NetworkView: testView,
-					Subnet:      types.IPNet{},
-				},
-			}
+	Describe("RequestContainerNetwork", func() {
+		It("Should carve a network out of the container once per name", func() {
+			cidr, err := ibDriver.RequestContainerNetwork(fakewapi.DefaultNetworkView, "10.1.0.0/16", 24, testNetworkName, nil, nil)
+			Expect(err).To(BeNil())
+			Expect(cidr).To(Equal("10.1.0.0/24"))
 
-			testNetwork := &ibclient.Network{
-				NetviewName: testView,
-				Cidr:        testCidr,
-			}
+			cidr, err = ibDriver.RequestContainerNetwork(fakewapi.DefaultNetworkView, "10.1.0.0/16", 24, "green", ibclient.EA{"Cluster": "blue"}, nil)
+			Expect(err).To(BeNil())
+			Expect(cidr).To(Equal("10.1.1.0/24"))
 
-			objMgr := &MockObjectManager{
-				netviewArg:   testView,
-				prefixLenArg: testPrefixLen,
-				nameArg:      testNetworkName,
-
-				networkContainerPoolArgs: testContainerArr,
-				allocateNetworkReturns: []*ibclient.Network{
-					testNetwork,
-				},
-				createNetworkContainerReturns: []*ibclient.NetworkContainer{
-					&ibclient.NetworkContainer{
-						NetviewName: testView,
-						Cidr:        testContainerArr[0],
-					},
-				},
-				getNetworkContainerReturns: []*ibclient.NetworkContainer{
-					nil,
-				},
+			cidr, err = ibDriver.RequestContainerNetwork(fakewapi.DefaultNetworkView, "10.1.0.0/16", 24, testNetworkName, nil, nil)
+			Expect(err).To(BeNil())
+			Expect(cidr).To(Equal("10.1.0.0/24"))
+			Expect(server.Objects("network")).To(HaveLen(2))
+		})
 
-				eaArg: testEa,
-				err:   nil,
+		It("Should create the carved network with the network settings and its first address as router", func() {
+			settings := &NetworkSettings{
+				Template:   "k8s-pods",
+				Comment:    "Overflow of the yellow network",
+				DNSServers: []string{"10.0.0.53"},
+				ExtAttrs:   map[string]string{"Site": "DC1"},
 			}
+			cidr, err := ibDriver.RequestContainerNetwork(fakewapi.DefaultNetworkView, "10.1.0.0/16", 24, testNetworkName, nil, settings)
+			Expect(err).To(BeNil())
+			Expect(cidr).To(Equal("10.1.0.0/24"))
 
-			ibDriver := NewInfobloxDriver(objMgr, testView, testContainers, testPrefixLen)
+			networks := server.Objects("network")
+			Expect(networks).To(HaveLen(1))
+			Expect(networks[0].String("template")).To(Equal("k8s-pods"))
+			Expect(networks[0].String("comment")).To(Equal("Overflow of the yellow network"))
+			Expect(networks[0].EA("Site")).To(Equal("DC1"))
+			Expect(networks[0].EA("Network Name")).To(Equal(testNetworkName))
 
-			var network string
-			var err error
-			It("Should pass expected arguments to ObjectManager.GetNetworkContainer/CreateNetworkContainer/AllocateNetwork", func() {
-				network, err = ibDriver.RequestNetwork(netconf)
-			})
-			It("Should call Object Manager the expected no. of times", func() {
-				Expect(objMgr.getNetworkContainerCnt).To(Equal(1))
-				Expect(objMgr.createNetworkContainerCnt).To(Equal(1))
-				Expect(objMgr.allocateNetworkCnt).To(Equal(1))
-			})
-			It("Should return expected Network object", func() {
-				Expect(network).To(Equal(testCidr))
+			options, err := ibDriver.GetDHCPOptions(fakewapi.DefaultNetworkView, cidr)
+			Expect(err).To(BeNil())
+			Expect(options.Routers).To(Equal([]net.IP{net.ParseIP("10.1.0.1")}))
+			Expect(options.DomainNameServers).To(Equal([]string{"10.0.0.53"}))
+		})
+
+		It("Should delete the carved network again if its router cannot be set", func() {
+			server.Fail(http.MethodPut, "network", "permission denied")
+
+			settings := &NetworkSettings{Comment: "Overflow of the yellow network"}
+			_, err := ibDriver.RequestContainerNetwork(fakewapi.DefaultNetworkView, "10.1.0.0/16", 24, testNetworkName, nil, settings)
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(ContainSubstring("permission denied"))
+			Expect(server.Objects("network")).To(BeEmpty())
+
+			server.ClearFailures()
+			cidr, err := ibDriver.RequestContainerNetwork(fakewapi.DefaultNetworkView, "10.1.0.0/16", 24, testNetworkName, nil, settings)
+			Expect(err).To(BeNil())
+			Expect(cidr).To(Equal("10.1.0.0/24"))
+		})
+	})
+
+	Describe("GetRange", func() {
+		It("Should return the addresses of the named DHCP range", func() {
+			_, err := server.AddNetwork(fakewapi.DefaultNetworkView, testCidr, nil)
+			Expect(err).To(BeNil())
+			_, err = server.AddRange(fakewapi.DefaultNetworkView, "pods", "192.168.10.100", "192.168.10.199")
+			Expect(err).To(BeNil())
+
+			r, excludes, err := ibDriver.GetRange(fakewapi.DefaultNetworkView, "pods")
+			Expect(err).To(BeNil())
+			Expect(r.String()).To(Equal("192.168.10.100-192.168.10.199"))
+			Expect(excludes).To(BeEmpty())
+
+			_, _, err = ibDriver.GetRange(fakewapi.DefaultNetworkView, "missing")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Describe("GetDHCPOptions", func() {
+		It("Should read the options of a network once while they are cached", func() {
+			conn, err := server.NewConnector()
+			Expect(err).To(BeNil())
+			cache := NewCachingObjectManager(ibclient.NewObjectManager(conn, CMP_TYPE, "test-cluster"), time.Minute)
+			ibDriver = NewInfobloxDriver(cache, conn, defaultNetworkView, defaultNetworkContainer, defaultPrefixLen)
+			_, err = server.AddNetworkView(testView, nil)
+			Expect(err).To(BeNil())
+
+			ipam := &IPAMConfig{Gateway: net.ParseIP("192.168.10.1"), NetworkSettings: &NetworkSettings{DomainName: "example.com"}}
+			_, err = ibDriver.requestSpecificNetwork(testView, testCidr, testNetworkName, nil, ipam)
+			Expect(err).To(BeNil())
+			options, err := ibDriver.GetDHCPOptions(testView, testCidr)
+			Expect(err).To(BeNil())
+			Expect(options.DomainName).To(Equal("example.com"))
+
+			server.Fail(http.MethodGet, "network", "network lookup failed")
+			options, err = ibDriver.GetDHCPOptions(testView, testCidr)
+			Expect(err).To(BeNil())
+			Expect(options.DomainName).To(Equal("example.com"))
+
+			ibDriver.InvalidateCache(testView)
+			_, err = ibDriver.GetDHCPOptions(testView, testCidr)
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Describe("GetNetworkUtilization", func() {
+		It("Should report the share of used addresses in percent", func() {
+			_, err := server.AddNetwork(fakewapi.DefaultNetworkView, "10.2.0.0/29", nil)
+			Expect(err).To(BeNil())
+			for i := 0; i < 3; i++ {
+				_, err = ibDriver.RequestAddress(fakewapi.DefaultNetworkView, "10.2.0.0/29", "", "", "", testVmID, nil, nil)
 				Expect(err).To(BeNil())
-			})
+			}
+
+			utilization, err := ibDriver.GetNetworkUtilization(fakewapi.DefaultNetworkView, "10.2.0.0/29")
+			Expect(err).To(BeNil())
+			Expect(utilization).To(Equal(50.0))
+		})
+
+		It("Should scale the utilization the grid reports in tenths of a percent", func() {
+			_, err := server.AddNetwork(fakewapi.DefaultNetworkView, "10.2.0.0/29", nil)
+			Expect(err).To(BeNil())
+			_, err = ibDriver.RequestAddress(fakewapi.DefaultNetworkView, "10.2.0.0/29", "", "", "", testVmID, nil, nil)
+			Expect(err).To(BeNil())
+
+			utilization, err := ibDriver.GetNetworkUtilization(fakewapi.DefaultNetworkView, "10.2.0.0/29")
+			Expect(err).To(BeNil())
+			Expect(utilization).To(BeNumerically("~", 16.6, 0.001))
+		})
+
+		It("Should fail for a network that does not exist", func() {
+			_, err := ibDriver.GetNetworkUtilization(fakewapi.DefaultNetworkView, "10.3.0.0/29")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Describe("CheckGrid", func() {
+		It("Should fail once the grid cannot be reached", func() {
+			ibDriver = newDriver(fakewapi.DefaultNetworkView, defaultNetworkContainer, defaultPrefixLen)
+			Expect(ibDriver.CheckGrid()).To(BeNil())
+
+			server.Close()
+			Expect(ibDriver.CheckGrid()).NotTo(BeNil())
 		})
 	})
 })
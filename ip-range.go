@@ -0,0 +1,208 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package ibcni
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+)
+
+// IPRange is an inclusive range of IP addresses.
+type IPRange struct {
+	Start net.IP
+	End   net.IP
+}
+
+func (r IPRange) String() string {
+	if r.Start.Equal(r.End) {
+		return r.Start.String()
+	}
+	return r.Start.String() + "-" + r.End.String()
+}
+
+// Contains tells whether ip lies within the range.
+func (r IPRange) Contains(ip net.IP) bool {
+	return compareIP(ip, r.Start) >= 0 && compareIP(ip, r.End) <= 0
+}
+
+// ParseIPRange parses a range given as "start-end", as a CIDR or as a
+// single address.
+func ParseIPRange(s string) (IPRange, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		_, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return IPRange{}, err
+		}
+		return IPRange{Start: ipnet.IP, End: lastIP(ipnet)}, nil
+	}
+
+	parts := strings.SplitN(s, "-", 2)
+	start := net.ParseIP(strings.TrimSpace(parts[0]))
+	end := start
+	if len(parts) == 2 {
+		end = net.ParseIP(strings.TrimSpace(parts[1]))
+	}
+	if start == nil || end == nil {
+		return IPRange{}, fmt.Errorf("invalid IP range '%s'", s)
+	}
+	if compareIP(start, end) > 0 {
+		return IPRange{}, fmt.Errorf("invalid IP range '%s': start is after end", s)
+	}
+	return IPRange{Start: start, End: end}, nil
+}
+
+// normalizeIP returns the 4-byte form of IPv4 addresses, so that addresses
+// of the same family compare byte by byte.
+func normalizeIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip.To16()
+}
+
+func compareIP(a net.IP, b net.IP) int {
+	return ipToInt(a).Cmp(ipToInt(b))
+}
+
+func ipToInt(ip net.IP) *big.Int {
+	return new(big.Int).SetBytes(normalizeIP(ip))
+}
+
+// addToIP returns ip + n, keeping the address family of ip.
+func addToIP(ip net.IP, n int64) net.IP {
+	ip = normalizeIP(ip)
+	i := new(big.Int).Add(ipToInt(ip), big.NewInt(n))
+	b := i.Bytes()
+	res := make(net.IP, len(ip))
+	if len(b) > len(res) || i.Sign() < 0 {
+		return nil
+	}
+	copy(res[len(res)-len(b):], b)
+	return res
+}
+
+func lastIP(ipnet *net.IPNet) net.IP {
+	ip := normalizeIP(ipnet.IP)
+	mask := ipnet.Mask
+	if len(mask) != len(ip) {
+		mask = mask[len(mask)-len(ip):]
+	}
+	last := make(net.IP, len(ip))
+	for i := range ip {
+		last[i] = ip[i] | ^mask[i]
+	}
+	return last
+}
+
+// intersectRanges returns the parts of ranges that lie within r.
+func intersectRanges(ranges []IPRange, r IPRange) []IPRange {
+	var res []IPRange
+	for _, cur := range ranges {
+		start, end := cur.Start, cur.End
+		if compareIP(r.Start, start) > 0 {
+			start = r.Start
+		}
+		if compareIP(r.End, end) < 0 {
+			end = r.End
+		}
+		if compareIP(start, end) <= 0 {
+			res = append(res, IPRange{Start: start, End: end})
+		}
+	}
+	return res
+}
+
+// subtractRange returns ranges without the addresses of excl.
+func subtractRange(ranges []IPRange, excl IPRange) []IPRange {
+	var res []IPRange
+	for _, cur := range ranges {
+		if compareIP(excl.End, cur.Start) < 0 || compareIP(excl.Start, cur.End) > 0 {
+			res = append(res, cur)
+			continue
+		}
+		if compareIP(excl.Start, cur.Start) > 0 {
+			res = append(res, IPRange{Start: cur.Start, End: addToIP(excl.Start, -1)})
+		}
+		if compareIP(excl.End, cur.End) < 0 {
+			res = append(res, IPRange{Start: addToIP(excl.End, 1), End: cur.End})
+		}
+	}
+	return res
+}
+
+// RestrictsAllocation tells whether the IPAM configuration limits allocation
+// to part of the subnet.
+func (ipam *IPAMConfig) RestrictsAllocation() bool {
+	return ipam.Range != "" || ipam.RangeStart != nil || ipam.RangeEnd != nil ||
+		len(ipam.Exclude) > 0 || ipam.ReservedStart > 0 || ipam.ReservedEnd > 0
+}
+
+// AllocationRanges returns the ranges of subnet addresses may be allocated
+// from. The usable addresses of the subnet are shrunk by the reserved
+// addresses at its start and end, limited to the named DHCP range (if any)
+// and to range-start/range-end, and the exclusion ranges of the
+// configuration and of the DHCP range are taken out.
+func (ipam *IPAMConfig) AllocationRanges(subnet *net.IPNet, dhcpRange *IPRange, dhcpExcludes []IPRange) ([]IPRange, error) {
+	first := normalizeIP(subnet.IP.Mask(subnet.Mask))
+	last := lastIP(subnet)
+	if first.To4() != nil {
+		if ones, bits := subnet.Mask.Size(); bits-ones > 1 {
+			// Skip the network and broadcast addresses.
+			first = addToIP(first, 1)
+			last = addToIP(last, -1)
+		}
+	}
+	first = addToIP(first, int64(ipam.ReservedStart))
+	last = addToIP(last, -int64(ipam.ReservedEnd))
+	if first == nil || last == nil || compareIP(first, last) > 0 {
+		return nil, fmt.Errorf("no addresses left in '%s' after reserving %d at the start and %d at the end", subnet, ipam.ReservedStart, ipam.ReservedEnd)
+	}
+	ranges := []IPRange{{Start: first, End: last}}
+
+	if dhcpRange != nil {
+		ranges = intersectRanges(ranges, *dhcpRange)
+	}
+	if ipam.RangeStart != nil || ipam.RangeEnd != nil {
+		r := IPRange{Start: first, End: last}
+		if ipam.RangeStart != nil {
+			r.Start = normalizeIP(ipam.RangeStart)
+		}
+		if ipam.RangeEnd != nil {
+			r.End = normalizeIP(ipam.RangeEnd)
+		}
+		ranges = intersectRanges(ranges, r)
+	}
+
+	excludes := dhcpExcludes
+	for _, s := range ipam.Exclude {
+		excl, err := ParseIPRange(s)
+		if err != nil {
+			return nil, err
+		}
+		excludes = append(excludes, excl)
+	}
+	for _, excl := range excludes {
+		ranges = subtractRange(ranges, excl)
+	}
+
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no addresses of '%s' are left for allocation", subnet)
+	}
+	return ranges, nil
+}
@@ -0,0 +1,79 @@
+package ibcni
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"net"
+)
+
+func rangeStrings(ranges []IPRange) []string {
+	var res []string
+	for _, r := range ranges {
+		res = append(res, r.String())
+	}
+	return res
+}
+
+var _ = Describe("ParseIPRange", func() {
+	It("Should parse start-end ranges, CIDRs and single addresses", func() {
+		r, err := ParseIPRange("10.0.0.10-10.0.0.20")
+		Expect(err).To(BeNil())
+		Expect(r.String()).To(Equal("10.0.0.10-10.0.0.20"))
+
+		r, err = ParseIPRange("10.0.0.16/30")
+		Expect(err).To(BeNil())
+		Expect(r.String()).To(Equal("10.0.0.16-10.0.0.19"))
+
+		r, err = ParseIPRange("10.0.0.5")
+		Expect(err).To(BeNil())
+		Expect(r.String()).To(Equal("10.0.0.5"))
+	})
+
+	It("Should reject invalid ranges", func() {
+		_, err := ParseIPRange("10.0.0.20-10.0.0.10")
+		Expect(err).NotTo(BeNil())
+		_, err = ParseIPRange("not-an-ip")
+		Expect(err).NotTo(BeNil())
+	})
+})
+
+var _ = Describe("AllocationRanges", func() {
+	_, subnet, _ := net.ParseCIDR("10.0.0.0/24")
+
+	It("Should use the usable addresses of the subnet by default", func() {
+		ranges, err := (&IPAMConfig{}).AllocationRanges(subnet, nil, nil)
+		Expect(err).To(BeNil())
+		Expect(rangeStrings(ranges)).To(Equal([]string{"10.0.0.1-10.0.0.254"}))
+	})
+
+	It("Should skip reserved addresses and exclusion ranges", func() {
+		ipam := &IPAMConfig{
+			ReservedStart: 9,
+			ReservedEnd:   4,
+			Exclude:       []string{"10.0.0.100-10.0.0.109", "10.0.0.200"},
+		}
+		ranges, err := ipam.AllocationRanges(subnet, nil, nil)
+		Expect(err).To(BeNil())
+		Expect(rangeStrings(ranges)).To(Equal([]string{
+			"10.0.0.10-10.0.0.99",
+			"10.0.0.110-10.0.0.199",
+			"10.0.0.201-10.0.0.250",
+		}))
+	})
+
+	It("Should limit allocation to the DHCP range and range-start/range-end", func() {
+		dhcpRange, _ := ParseIPRange("10.0.0.50-10.0.0.150")
+		dhcpExclude, _ := ParseIPRange("10.0.0.60-10.0.0.69")
+		ipam := &IPAMConfig{RangeEnd: net.ParseIP("10.0.0.99")}
+		ranges, err := ipam.AllocationRanges(subnet, &dhcpRange, []IPRange{dhcpExclude})
+		Expect(err).To(BeNil())
+		Expect(rangeStrings(ranges)).To(Equal([]string{"10.0.0.50-10.0.0.59", "10.0.0.70-10.0.0.99"}))
+	})
+
+	It("Should return an error when no addresses are left", func() {
+		ipam := &IPAMConfig{Exclude: []string{"10.0.0.0/24"}}
+		_, err := ipam.AllocationRanges(subnet, nil, nil)
+		Expect(err).NotTo(BeNil())
+	})
+})
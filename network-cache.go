@@ -0,0 +1,233 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package ibcni
+
+import (
+	"expvar"
+	"strings"
+	"sync"
+	"time"
+
+	ibclient "github.com/infobloxopen/infoblox-go-client"
+)
+
+var cacheStats = expvar.NewMap("network_cache")
+
+func init() {
+	expvar.Publish("network_cache_hit_rate", expvar.Func(cacheHitRate))
+}
+
+func cacheHitRate() interface{} {
+	var hits, misses int64
+	if v, ok := cacheStats.Get("hits").(*expvar.Int); ok {
+		hits = v.Value()
+	}
+	if v, ok := cacheStats.Get("misses").(*expvar.Int); ok {
+		misses = v.Value()
+	}
+	if hits+misses == 0 {
+		return 0.0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// CachingObjectManager wraps an ibclient.IBObjectManager and keeps network
+// views and networks, and the DHCP options of networks, in memory for a
+// limited time, since they rarely change once created. Lookups that find
+// nothing are not cached so that objects created outside the daemon become
+// visible immediately.
+type CachingObjectManager struct {
+	ibclient.IBObjectManager
+
+	ttl time.Duration
+
+	mu             sync.Mutex
+	views          map[string]cacheEntry
+	networks       map[string]cacheEntry
+	networksByName map[string]cacheEntry
+	dhcpOptions    map[string]cacheEntry
+}
+
+func NewCachingObjectManager(objMgr ibclient.IBObjectManager, ttl time.Duration) *CachingObjectManager {
+	return &CachingObjectManager{
+		IBObjectManager: objMgr,
+		ttl:             ttl,
+		views:           make(map[string]cacheEntry),
+		networks:        make(map[string]cacheEntry),
+		networksByName:  make(map[string]cacheEntry),
+		dhcpOptions:     make(map[string]cacheEntry),
+	}
+}
+
+func networkKey(netview string, s string) string {
+	return netview + "|" + s
+}
+
+func (c *CachingObjectManager) lookup(entries map[string]cacheEntry, key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := entries[key]
+	if ok && time.Now().Before(entry.expires) {
+		cacheStats.Add("hits", 1)
+		return entry.value, true
+	}
+	if ok {
+		delete(entries, key)
+	}
+	cacheStats.Add("misses", 1)
+	return nil, false
+}
+
+func (c *CachingObjectManager) store(entries map[string]cacheEntry, key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries[key] = cacheEntry{value: value, expires: time.Now().Add(c.ttl)}
+}
+
+// Invalidate drops every cached object.
+func (c *CachingObjectManager) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, entries := range []map[string]cacheEntry{c.views, c.networks, c.networksByName, c.dhcpOptions} {
+		for key := range entries {
+			delete(entries, key)
+		}
+	}
+	cacheStats.Add("invalidations", 1)
+}
+
+// InvalidateNetworkView drops the cached network view and all networks
+// cached for it.
+func (c *CachingObjectManager) InvalidateNetworkView(netview string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.views, netview)
+	c.invalidateNetworks(netview)
+	cacheStats.Add("invalidations", 1)
+}
+
+func (c *CachingObjectManager) invalidateNetworks(netview string) {
+	for _, entries := range []map[string]cacheEntry{c.networks, c.networksByName} {
+		for key, entry := range entries {
+			if nw, ok := entry.value.(ibclient.Network); ok && nw.NetviewName == netview {
+				delete(entries, key)
+			}
+		}
+	}
+	for key := range c.dhcpOptions {
+		if strings.HasPrefix(key, networkKey(netview, "")) {
+			delete(c.dhcpOptions, key)
+		}
+	}
+}
+
+func (c *CachingObjectManager) GetNetworkView(name string) (*ibclient.NetworkView, error) {
+	if v, ok := c.lookup(c.views, name); ok {
+		netview := v.(ibclient.NetworkView)
+		return &netview, nil
+	}
+
+	netview, err := c.IBObjectManager.GetNetworkView(name)
+	if err == nil && netview != nil {
+		c.store(c.views, name, *netview)
+	}
+	return netview, err
+}
+
+func (c *CachingObjectManager) CreateNetworkView(name string) (*ibclient.NetworkView, error) {
+	netview, err := c.IBObjectManager.CreateNetworkView(name)
+	if err == nil && netview != nil {
+		c.store(c.views, name, *netview)
+	}
+	return netview, err
+}
+
+// networkCacheKey returns the cache and key to use for a GetNetwork query,
+// or nil if the query is not cacheable.
+func (c *CachingObjectManager) networkCacheKey(netview string, cidr string, ea ibclient.EA) (map[string]cacheEntry, string) {
+	if cidr != "" && len(ea) == 0 {
+		return c.networks, networkKey(netview, cidr)
+	}
+	if cidr == "" && len(ea) == 1 {
+		if name, ok := ea["Network Name"].(string); ok {
+			return c.networksByName, networkKey(netview, name)
+		}
+	}
+	return nil, ""
+}
+
+func (c *CachingObjectManager) GetNetwork(netview string, cidr string, ea ibclient.EA) (*ibclient.Network, error) {
+	entries, key := c.networkCacheKey(netview, cidr, ea)
+	if entries == nil {
+		return c.IBObjectManager.GetNetwork(netview, cidr, ea)
+	}
+
+	if v, ok := c.lookup(entries, key); ok {
+		network := v.(ibclient.Network)
+		return &network, nil
+	}
+
+	network, err := c.IBObjectManager.GetNetwork(netview, cidr, ea)
+	if err == nil && network != nil {
+		c.store(entries, key, *network)
+	}
+	return network, err
+}
+
+func (c *CachingObjectManager) CreateNetwork(netview string, cidr string, name string) (*ibclient.Network, error) {
+	network, err := c.IBObjectManager.CreateNetwork(netview, cidr, name)
+
+	c.mu.Lock()
+	c.invalidateNetworks(netview)
+	delete(c.networks, networkKey(netview, cidr))
+	delete(c.networksByName, networkKey(netview, name))
+	c.mu.Unlock()
+
+	return network, err
+}
+
+func (c *CachingObjectManager) DeleteNetwork(ref string, netview string) (string, error) {
+	c.mu.Lock()
+	c.invalidateNetworks(netview)
+	c.mu.Unlock()
+
+	return c.IBObjectManager.DeleteNetwork(ref, netview)
+}
+
+// cachedDHCPOptions returns the DHCP options of the network cidr, reading
+// them with get unless they are cached.
+func (c *CachingObjectManager) cachedDHCPOptions(netview string, cidr string, get func() (*DHCPOptions, error)) (*DHCPOptions, error) {
+	key := networkKey(netview, cidr)
+	if v, ok := c.lookup(c.dhcpOptions, key); ok {
+		opts := v.(DHCPOptions)
+		return &opts, nil
+	}
+
+	opts, err := get()
+	if err == nil && opts != nil {
+		c.store(c.dhcpOptions, key, *opts)
+	}
+	return opts, err
+}
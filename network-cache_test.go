@@ -0,0 +1,98 @@
+package ibcni
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	ibclient "github.com/infobloxopen/infoblox-go-client"
+	"time"
+)
+
+type countingObjectManager struct {
+	ibclient.IBObjectManager
+
+	netview *ibclient.NetworkView
+	network *ibclient.Network
+
+	getNetworkViewCnt, getNetworkCnt, createNetworkCnt int
+}
+
+func (f *countingObjectManager) GetNetworkView(name string) (*ibclient.NetworkView, error) {
+	f.getNetworkViewCnt++
+	return f.netview, nil
+}
+
+func (f *countingObjectManager) GetNetwork(netview string, cidr string, ea ibclient.EA) (*ibclient.Network, error) {
+	f.getNetworkCnt++
+	return f.network, nil
+}
+
+func (f *countingObjectManager) CreateNetwork(netview string, cidr string, name string) (*ibclient.Network, error) {
+	f.createNetworkCnt++
+	return f.network, nil
+}
+
+var _ = Describe("CachingObjectManager", func() {
+	testView := "test-view"
+	testCidr := "192.168.10.0/24"
+	testNetworkName := "yellow"
+
+	testNetwork := &ibclient.Network{
+		NetviewName: testView,
+		Cidr:        testCidr,
+		Ea:          ibclient.EA{"Network Name": testNetworkName},
+	}
+
+	Context("When the same network view is requested twice", func() {
+		objMgr := &countingObjectManager{netview: &ibclient.NetworkView{Name: testView}}
+		cache := NewCachingObjectManager(objMgr, time.Minute)
+
+		It("Should query the grid only once", func() {
+			cache.GetNetworkView(testView)
+			netview, err := cache.GetNetworkView(testView)
+			Expect(err).To(BeNil())
+			Expect(netview.Name).To(Equal(testView))
+			Expect(objMgr.getNetworkViewCnt).To(Equal(1))
+		})
+	})
+
+	Context("When a network is looked up by cidr and by name", func() {
+		objMgr := &countingObjectManager{network: testNetwork}
+		cache := NewCachingObjectManager(objMgr, time.Minute)
+
+		It("Should cache both lookups separately", func() {
+			cache.GetNetwork(testView, testCidr, nil)
+			cache.GetNetwork(testView, testCidr, nil)
+			cache.GetNetwork(testView, "", ibclient.EA{"Network Name": testNetworkName})
+			cache.GetNetwork(testView, "", ibclient.EA{"Network Name": testNetworkName})
+			Expect(objMgr.getNetworkCnt).To(Equal(2))
+		})
+		It("Should query the grid again after invalidation", func() {
+			cache.InvalidateNetworkView(testView)
+			cache.GetNetwork(testView, testCidr, nil)
+			Expect(objMgr.getNetworkCnt).To(Equal(3))
+		})
+	})
+
+	Context("When cached entries have expired", func() {
+		objMgr := &countingObjectManager{network: testNetwork}
+		cache := NewCachingObjectManager(objMgr, 0)
+
+		It("Should query the grid every time", func() {
+			cache.GetNetwork(testView, testCidr, nil)
+			cache.GetNetwork(testView, testCidr, nil)
+			Expect(objMgr.getNetworkCnt).To(Equal(2))
+		})
+	})
+
+	Context("When nothing is found", func() {
+		objMgr := &countingObjectManager{}
+		cache := NewCachingObjectManager(objMgr, time.Minute)
+
+		It("Should not cache the empty result", func() {
+			cache.GetNetwork(testView, testCidr, nil)
+			cache.GetNetwork(testView, testCidr, nil)
+			Expect(objMgr.getNetworkCnt).To(Equal(2))
+		})
+	})
+})
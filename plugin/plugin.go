@@ -25,14 +25,24 @@ import (
 
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
-	"github.com/containernetworking/cni/pkg/types/current"
+	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/cni/pkg/version"
 	"github.com/containernetworking/plugins/pkg/ns"
 	. "github.com/infobloxopen/cni-infoblox"
 )
 
+// Error code of the CNI STATUS verb for a plugin that cannot serve ADD
+// requests.
+const errPluginNotAvailable uint = 50
+
 func runPlugin() {
-	skel.PluginMain(cmdAdd, cmdDel, version.All)
+	skel.PluginMainFuncs(skel.CNIFuncs{
+		Add:    cmdAdd,
+		Check:  cmdCheck,
+		Del:    cmdDel,
+		GC:     cmdGC,
+		Status: cmdStatus,
+	}, version.All, "Infoblox IPAM plugin")
 }
 
 type InterfaceInfo struct {
@@ -83,6 +93,11 @@ func cmdAdd(args *skel.CmdArgs) error {
 		return err
 	}
 
+	prevResult, err := ParsePrevResult(args.StdinData)
+	if err != nil {
+		return fmt.Errorf("error parsing prevResult: %v", err)
+	}
+
 	result := &current.Result{}
 	extArgs := &ExtCmdArgs{CmdArgs: *args}
 
@@ -93,6 +108,9 @@ func cmdAdd(args *skel.CmdArgs) error {
 		return err
 	}
 
+	// The interface index is merged in here rather than by the daemon, as
+	// gob drops the index of the first interface on the way.
+	MergeResult(result, prevResult, extArgs)
 	return types.PrintResult(result, confVersion)
 }
 
@@ -109,6 +127,34 @@ func cmdDel(args *skel.CmdArgs) error {
 	return nil
 }
 
+func cmdCheck(args *skel.CmdArgs) error {
+	result := struct{}{}
+	extArgs := &ExtCmdArgs{CmdArgs: *args}
+
+	mac := getMacAddress(args.Netns, args.IfName)
+	extArgs.IfMac = mac
+	return rpcCall("Infoblox.Check", extArgs, &result)
+}
+
+// cmdGC has the daemon release the addresses of attachments that are not in
+// the runtime's list of valid attachments.
+func cmdGC(args *skel.CmdArgs) error {
+	var released []string
+	extArgs := &ExtCmdArgs{CmdArgs: *args}
+	return rpcCall("Infoblox.GC", extArgs, &released)
+}
+
+// cmdStatus reports the plugin as not available unless both the daemon and
+// the grid can be reached.
+func cmdStatus(args *skel.CmdArgs) error {
+	result := struct{}{}
+	extArgs := &ExtCmdArgs{CmdArgs: *args}
+	if err := rpcCall("Infoblox.Status", extArgs, &result); err != nil {
+		return types.NewError(errPluginNotAvailable, "Infoblox IPAM is not available", err.Error())
+	}
+	return nil
+}
+
 func rpcCall(method string, args *ExtCmdArgs, result interface{}) error {
 	conf := NetConfig{}
 	if err := json.Unmarshal(args.StdinData, &conf); err != nil {
@@ -121,12 +167,15 @@ func rpcCall(method string, args *ExtCmdArgs, result interface{}) error {
 	}
 
 	// The daemon may be running under a different working dir
-	// so make sure the netns path is absolute.
-	netns, err := filepath.Abs(args.Netns)
-	if err != nil {
-		return fmt.Errorf("failed to make %q an absolute path: %v", args.Netns, err)
+	// so make sure the netns path is absolute. GC and STATUS come
+	// without one.
+	if args.Netns != "" {
+		netns, err := filepath.Abs(args.Netns)
+		if err != nil {
+			return fmt.Errorf("failed to make %q an absolute path: %v", args.Netns, err)
+		}
+		args.Netns = netns
 	}
-	args.Netns = netns
 
 	err = client.Call(method, args, result)
 	if err != nil {
@@ -0,0 +1,92 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package ibcni
+
+import (
+	"encoding/json"
+
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/cni/pkg/version"
+)
+
+// ParsePrevResult returns the prevResult of the netconf as a CNI 1.x
+// result, or nil if there is none.
+func ParsePrevResult(stdinData []byte) (*current.Result, error) {
+	conf := types.NetConf{}
+	if err := json.Unmarshal(stdinData, &conf); err != nil {
+		return nil, err
+	}
+	if err := version.ParsePrevResult(&conf); err != nil {
+		return nil, err
+	}
+	if conf.PrevResult == nil {
+		return nil, nil
+	}
+	return current.NewResultFromResult(conf.PrevResult)
+}
+
+// MergeResult points the allocated addresses of result at the container
+// interface, so that chained plugins such as tuning and portmap can tell
+// which interface they belong to, and merges them into the prevResult of a
+// chained invocation. Interfaces, addresses and routes of the prevResult
+// are kept.
+func MergeResult(result *current.Result, prevResult *current.Result, args *ExtCmdArgs) {
+	merged := &current.Result{CNIVersion: current.ImplementedSpecVersion}
+	if prevResult != nil {
+		merged.Interfaces = append(merged.Interfaces, prevResult.Interfaces...)
+		merged.IPs = append(merged.IPs, prevResult.IPs...)
+		merged.Routes = append(merged.Routes, prevResult.Routes...)
+		merged.DNS = prevResult.DNS
+	}
+
+	if args.IfName != "" {
+		index := -1
+		for i, iface := range merged.Interfaces {
+			if iface.Name == args.IfName && iface.Sandbox != "" {
+				index = i
+			}
+		}
+		if index < 0 {
+			merged.Interfaces = append(merged.Interfaces, &current.Interface{
+				Name:    args.IfName,
+				Mac:     args.IfMac,
+				Sandbox: args.Netns,
+			})
+			index = len(merged.Interfaces) - 1
+		}
+		for _, ipConfig := range result.IPs {
+			ipConfig.Interface = current.Int(index)
+		}
+	}
+
+	merged.IPs = append(merged.IPs, result.IPs...)
+	merged.Routes = append(merged.Routes, result.Routes...)
+	if len(result.DNS.Nameservers) > 0 {
+		merged.DNS = result.DNS
+	}
+	*result = *merged
+}
+
+// OnInterface reports whether an address of result is configured on the
+// container interface ifName. Addresses without an interface are assumed to
+// be.
+func OnInterface(result *current.Result, ipConfig *current.IPConfig, ifName string) bool {
+	if ipConfig.Interface == nil || *ipConfig.Interface < 0 || *ipConfig.Interface >= len(result.Interfaces) {
+		return true
+	}
+	return result.Interfaces[*ipConfig.Interface].Name == ifName
+}
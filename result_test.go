@@ -0,0 +1,110 @@
+package ibcni
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"net"
+)
+
+var _ = Describe("Results", func() {
+	newArgs := func() *ExtCmdArgs {
+		args := &ExtCmdArgs{}
+		args.ContainerID = "test-container"
+		args.IfName = "eth0"
+		args.IfMac = "02:42:ac:11:00:02"
+		args.Netns = "/var/run/netns/test"
+		return args
+	}
+	newResult := func(cidr string) *current.Result {
+		ip, ipn, _ := net.ParseCIDR(cidr)
+		ipn.IP = ip
+		return &current.Result{IPs: []*current.IPConfig{{Address: *ipn}}}
+	}
+
+	Context("ParsePrevResult", func() {
+		It("Should return nil without a prevResult", func() {
+			prevResult, err := ParsePrevResult([]byte(`{"cniVersion": "1.0.0", "name": "test"}`))
+			Expect(err).To(BeNil())
+			Expect(prevResult).To(BeNil())
+		})
+
+		It("Should convert an older prevResult to the current version", func() {
+			prevResult, err := ParsePrevResult([]byte(`{
+    "cniVersion": "0.4.0",
+    "name": "test",
+    "prevResult": {
+        "cniVersion": "0.4.0",
+        "interfaces": [{"name": "eth0", "sandbox": "/var/run/netns/test"}],
+        "ips": [{"version": "4", "address": "10.99.0.5/24", "interface": 0}]
+    }
+}`))
+			Expect(err).To(BeNil())
+			Expect(prevResult.CNIVersion).To(Equal(current.ImplementedSpecVersion))
+			Expect(prevResult.IPs).To(HaveLen(1))
+			Expect(*prevResult.IPs[0].Interface).To(Equal(0))
+		})
+
+		It("Should fail on invalid JSON", func() {
+			_, err := ParsePrevResult([]byte(`{`))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Context("MergeResult", func() {
+		It("Should add the container interface to a result of its own", func() {
+			result := newResult("192.168.30.1/24")
+			MergeResult(result, nil, newArgs())
+			Expect(result.CNIVersion).To(Equal(current.ImplementedSpecVersion))
+			Expect(result.Interfaces).To(Equal([]*current.Interface{
+				{Name: "eth0", Mac: "02:42:ac:11:00:02", Sandbox: "/var/run/netns/test"},
+			}))
+			Expect(*result.IPs[0].Interface).To(Equal(0))
+		})
+
+		It("Should append the addresses to the prevResult on its container interface", func() {
+			prevResult := newResult("10.99.0.5/24")
+			prevResult.Interfaces = []*current.Interface{
+				{Name: "veth1234"},
+				{Name: "eth0", Mac: "11:22:33:44:55:66", Sandbox: "/var/run/netns/test"},
+			}
+			prevResult.IPs[0].Interface = current.Int(0)
+
+			result := newResult("192.168.30.1/24")
+			MergeResult(result, prevResult, newArgs())
+			Expect(result.Interfaces).To(HaveLen(2))
+			Expect(result.IPs).To(HaveLen(2))
+			Expect(result.IPs[0].Address.String()).To(Equal("10.99.0.5/24"))
+			Expect(result.IPs[1].Address.String()).To(Equal("192.168.30.1/24"))
+			Expect(*result.IPs[1].Interface).To(Equal(1))
+		})
+
+		It("Should keep the DNS of the prevResult unless it has its own", func() {
+			prevResult := newResult("10.99.0.5/24")
+			prevResult.DNS.Nameservers = []string{"10.99.0.1"}
+
+			result := newResult("192.168.30.1/24")
+			MergeResult(result, prevResult, newArgs())
+			Expect(result.DNS.Nameservers).To(Equal([]string{"10.99.0.1"}))
+
+			result = newResult("192.168.30.1/24")
+			result.DNS.Nameservers = []string{"192.168.30.53"}
+			MergeResult(result, prevResult, newArgs())
+			Expect(result.DNS.Nameservers).To(Equal([]string{"192.168.30.53"}))
+		})
+	})
+
+	Context("OnInterface", func() {
+		It("Should match addresses by the name of their interface", func() {
+			result := newResult("10.99.0.5/24")
+			result.Interfaces = []*current.Interface{{Name: "veth1234"}, {Name: "eth0"}}
+			Expect(OnInterface(result, result.IPs[0], "eth0")).To(BeTrue())
+
+			result.IPs[0].Interface = current.Int(0)
+			Expect(OnInterface(result, result.IPs[0], "eth0")).To(BeFalse())
+			result.IPs[0].Interface = current.Int(1)
+			Expect(OnInterface(result, result.IPs[0], "eth0")).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,286 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package ibcni
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"reflect"
+	"sync"
+	"time"
+
+	ibclient "github.com/infobloxopen/infoblox-go-client"
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+var ErrCircuitOpen = errors.New("Infoblox grid is unavailable (circuit breaker open), failing fast")
+
+var wapiStats = expvar.NewMap("wapi")
+
+// tokenBucket limits the rate of outbound WAPI calls. A rate of 0 disables
+// limiting.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes a token and returns how long the caller has to wait before
+// using it.
+func (b *tokenBucket) reserve() time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) wait() {
+	if d := b.reserve(); d > 0 {
+		wapiStats.Add("throttled", 1)
+		time.Sleep(d)
+	}
+}
+
+// circuitBreaker fails WAPI calls fast once threshold consecutive calls
+// could not reach the grid. After cooldown a single trial call is let
+// through; its outcome closes or re-opens the breaker. A threshold of 0
+// disables the breaker.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	state     string
+	openedAt  time.Time
+	lastErr   error
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     BreakerClosed,
+	}
+}
+
+func (cb *circuitBreaker) allow() error {
+	if cb.threshold <= 0 {
+		return nil
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case BreakerOpen:
+		if time.Since(cb.openedAt) < cb.cooldown {
+			wapiStats.Add("rejected", 1)
+			return ErrCircuitOpen
+		}
+		cb.state = BreakerHalfOpen
+		return nil
+	case BreakerHalfOpen:
+		// A trial call is already in flight.
+		wapiStats.Add("rejected", 1)
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+func (cb *circuitBreaker) record(err error) {
+	if cb.threshold <= 0 {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if !IsGridUnreachable(err) {
+		cb.failures = 0
+		cb.state = BreakerClosed
+		return
+	}
+
+	cb.failures++
+	cb.lastErr = err
+	if cb.state == BreakerHalfOpen || cb.failures >= cb.threshold {
+		cb.state = BreakerOpen
+		cb.openedAt = time.Now()
+		wapiStats.Add("breaker_opened", 1)
+	}
+}
+
+// ThrottledConnector wraps an ibclient.IBConnector with a token-bucket rate
+// limiter and a circuit breaker, so that bursts of pod starts do not
+// overload the grid and calls fail fast while the grid is unreachable.
+type ThrottledConnector struct {
+	ibclient.IBConnector
+
+	limiter *tokenBucket
+	breaker *circuitBreaker
+}
+
+func NewThrottledConnector(conn ibclient.IBConnector, config GridConfig) *ThrottledConnector {
+	return &ThrottledConnector{
+		IBConnector: conn,
+		limiter:     newTokenBucket(config.WapiRateLimit, config.WapiRateBurst),
+		breaker:     newCircuitBreaker(config.BreakerThreshold, time.Duration(config.BreakerCooldown)*time.Second),
+	}
+}
+
+func (c *ThrottledConnector) call(fn func() error) error {
+	if err := c.breaker.allow(); err != nil {
+		return err
+	}
+	c.limiter.wait()
+
+	wapiStats.Add("calls", 1)
+	err := fn()
+	if err != nil {
+		wapiStats.Add("errors", 1)
+	}
+	c.breaker.record(err)
+
+	return err
+}
+
+func (c *ThrottledConnector) CreateObject(obj ibclient.IBObject) (ref string, err error) {
+	err = c.call(func() error {
+		ref, err = c.IBConnector.CreateObject(obj)
+		return err
+	})
+	return
+}
+
+func (c *ThrottledConnector) GetObject(obj ibclient.IBObject, ref string, res interface{}) error {
+	return c.call(func() error {
+		return c.IBConnector.GetObject(obj, ref, res)
+	})
+}
+
+// GetAllObjects reads the objects matching obj into res, a pointer to a
+// slice, a page at a time. Each page is a call of its own.
+func (c *ThrottledConnector) GetAllObjects(obj ibclient.IBObject, res interface{}) error {
+	conn, ok := c.IBConnector.(*ibclient.Connector)
+	if !ok {
+		return c.GetObject(obj, "", res)
+	}
+
+	all := reflect.ValueOf(res).Elem()
+	pageID := ""
+	for {
+		var page searchPage
+		err := c.call(func() error {
+			// GetObject cannot read paged results, so the request is
+			// sent through the connector's request builder directly.
+			req, err := conn.RequestBuilder.BuildRequest(ibclient.GET, &pagedSearch{IBObject: obj, pageID: pageID}, "", ibclient.QueryParams{})
+			if err != nil {
+				return err
+			}
+			resp, err := conn.Requestor.SendRequest(req)
+			if err != nil {
+				return err
+			}
+			return json.Unmarshal(resp, &page)
+		})
+		if err != nil {
+			return err
+		}
+
+		items := reflect.New(all.Type())
+		if len(page.Result) > 0 {
+			if err := json.Unmarshal(page.Result, items.Interface()); err != nil {
+				return err
+			}
+		}
+		all.Set(reflect.AppendSlice(all, items.Elem()))
+		if page.NextPageID == "" {
+			return nil
+		}
+		pageID = page.NextPageID
+	}
+}
+
+func (c *ThrottledConnector) DeleteObject(ref string) (refRes string, err error) {
+	err = c.call(func() error {
+		refRes, err = c.IBConnector.DeleteObject(ref)
+		return err
+	})
+	return
+}
+
+func (c *ThrottledConnector) UpdateObject(obj ibclient.IBObject, ref string) (refRes string, err error) {
+	err = c.call(func() error {
+		refRes, err = c.IBConnector.UpdateObject(obj, ref)
+		return err
+	})
+	return
+}
+
+// Health reports the state of the rate limiter and circuit breaker.
+func (c *ThrottledConnector) Health() WapiHealth {
+	c.breaker.mu.Lock()
+	defer c.breaker.mu.Unlock()
+
+	health := WapiHealth{
+		BreakerState:        c.breaker.state,
+		ConsecutiveFailures: c.breaker.failures,
+		RateLimit:           c.limiter.rate,
+		RateBurst:           int(c.limiter.burst),
+	}
+	if c.breaker.lastErr != nil {
+		health.LastError = c.breaker.lastErr.Error()
+	}
+	if c.breaker.state == BreakerOpen {
+		health.RetryAt = c.breaker.openedAt.Add(c.breaker.cooldown)
+	}
+	return health
+}
@@ -0,0 +1,75 @@
+package ibcni
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"errors"
+	"net"
+	"time"
+)
+
+type testNetError struct{}
+
+func (testNetError) Error() string   { return "connection refused" }
+func (testNetError) Timeout() bool   { return false }
+func (testNetError) Temporary() bool { return true }
+
+var _ net.Error = testNetError{}
+
+var _ = Describe("ThrottledConnector", func() {
+	Describe("tokenBucket", func() {
+		It("Should not delay calls within the burst", func() {
+			b := newTokenBucket(1, 2)
+			Expect(b.reserve()).To(Equal(time.Duration(0)))
+			Expect(b.reserve()).To(Equal(time.Duration(0)))
+		})
+		It("Should delay calls beyond the burst", func() {
+			b := newTokenBucket(1, 1)
+			b.reserve()
+			Expect(b.reserve()).To(BeNumerically(">", 900*time.Millisecond))
+		})
+		It("Should never delay when rate limiting is disabled", func() {
+			b := newTokenBucket(0, 1)
+			b.reserve()
+			Expect(b.reserve()).To(Equal(time.Duration(0)))
+		})
+	})
+
+	Describe("circuitBreaker", func() {
+		Context("When the grid is unreachable", func() {
+			cb := newCircuitBreaker(2, time.Hour)
+
+			It("Should open after threshold consecutive failures", func() {
+				Expect(cb.allow()).To(BeNil())
+				cb.record(testNetError{})
+				Expect(cb.allow()).To(BeNil())
+				cb.record(testNetError{})
+				Expect(cb.state).To(Equal(BreakerOpen))
+				Expect(cb.allow()).To(Equal(ErrCircuitOpen))
+			})
+		})
+
+		Context("When the grid rejects a request", func() {
+			cb := newCircuitBreaker(1, time.Hour)
+
+			It("Should stay closed", func() {
+				cb.record(errors.New("WAPI request error: 400"))
+				Expect(cb.state).To(Equal(BreakerClosed))
+				Expect(cb.allow()).To(BeNil())
+			})
+		})
+
+		Context("When the cooldown has passed", func() {
+			cb := newCircuitBreaker(1, 0)
+
+			It("Should let a trial call through and close on success", func() {
+				cb.record(testNetError{})
+				Expect(cb.allow()).To(BeNil())
+				Expect(cb.state).To(Equal(BreakerHalfOpen))
+				cb.record(nil)
+				Expect(cb.state).To(Equal(BreakerClosed))
+			})
+		})
+	})
+})
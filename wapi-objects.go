@@ -0,0 +1,185 @@
+// Copyright 2016 Infoblox Inc.
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package ibcni
+
+import (
+	"encoding/json"
+
+	ibclient "github.com/infobloxopen/infoblox-go-client"
+)
+
+// Number of objects read per page of a search. The WAPI refuses searches
+// returning more than 1000 objects unless they are paged.
+var wapiPageSize = 1000
+
+// wapiBase implements ibclient.IBObject for WAPI object types that the
+// client library does not provide.
+type wapiBase struct {
+	objectType   string
+	returnFields []string
+	eaSearch     ibclient.EASearch
+}
+
+func (b *wapiBase) ObjectType() string {
+	return b.objectType
+}
+
+func (b *wapiBase) ReturnFields() []string {
+	return b.returnFields
+}
+
+func (b *wapiBase) EaSearch() ibclient.EASearch {
+	return b.eaSearch
+}
+
+type rangeExclusion struct {
+	StartAddress string `json:"start_address,omitempty"`
+	EndAddress   string `json:"end_address,omitempty"`
+}
+
+// dhcpRange is the WAPI "range" object, a DHCP range of a network.
+type dhcpRange struct {
+	wapiBase    `json:"-"`
+	Ref         string           `json:"_ref,omitempty"`
+	Name        string           `json:"name,omitempty"`
+	NetviewName string           `json:"network_view,omitempty"`
+	Network     string           `json:"network,omitempty"`
+	StartAddr   string           `json:"start_addr,omitempty"`
+	EndAddr     string           `json:"end_addr,omitempty"`
+	Exclude     []rangeExclusion `json:"exclude,omitempty"`
+}
+
+func newDHCPRange(r dhcpRange) *dhcpRange {
+	res := r
+	res.objectType = "range"
+	res.returnFields = []string{"name", "network_view", "network", "start_addr", "end_addr", "exclude"}
+	return &res
+}
+
+// dhcpOption is a DHCP option of a network. UseOption is only returned for
+// the options that can be inherited, such as routers; the network's own
+// value applies if it is set.
+type dhcpOption struct {
+	Name      string `json:"name,omitempty"`
+	Num       int    `json:"num,omitempty"`
+	Value     string `json:"value"`
+	UseOption *bool  `json:"use_option,omitempty"`
+}
+
+// networkOptions reads the DHCP options of a network, along with its
+// extensible attributes.
+type networkOptions struct {
+	wapiBase    `json:"-"`
+	Ref         string       `json:"_ref,omitempty"`
+	NetviewName string       `json:"network_view,omitempty"`
+	Cidr        string       `json:"network,omitempty"`
+	Options     []dhcpOption `json:"options,omitempty"`
+	Ea          ibclient.EA  `json:"extattrs,omitempty"`
+}
+
+func newNetworkOptions(n networkOptions) *networkOptions {
+	res := n
+	res.objectType = "network"
+	res.returnFields = []string{"network", "network_view", "options", "extattrs"}
+	return &res
+}
+
+// networkCreate creates a network from an optional network template, with a
+// comment and DHCP options.
+type networkCreate struct {
+	wapiBase    `json:"-"`
+	NetviewName string       `json:"network_view,omitempty"`
+	Cidr        string       `json:"network,omitempty"`
+	Template    string       `json:"template,omitempty"`
+	Comment     string       `json:"comment,omitempty"`
+	Options     []dhcpOption `json:"options,omitempty"`
+	Ea          ibclient.EA  `json:"extattrs,omitempty"`
+}
+
+func newNetworkCreate(n networkCreate) *networkCreate {
+	res := n
+	res.objectType = "network"
+	res.returnFields = []string{"extattrs", "network", "network_view"}
+	return &res
+}
+
+// networkUtilization reads the utilization of a network, which the WAPI
+// reports in tenths of a percent.
+type networkUtilization struct {
+	wapiBase    `json:"-"`
+	Ref         string `json:"_ref,omitempty"`
+	NetviewName string `json:"network_view,omitempty"`
+	Cidr        string `json:"network,omitempty"`
+	Utilization int    `json:"utilization,omitempty"`
+}
+
+func newNetworkUtilization(n networkUtilization) *networkUtilization {
+	res := n
+	res.objectType = "network"
+	res.returnFields = []string{"network", "network_view", "utilization"}
+	return &res
+}
+
+// fixedAddressSearch searches fixed addresses by extensible attributes.
+type fixedAddressSearch struct {
+	wapiBase    `json:"-"`
+	Ref         string      `json:"_ref,omitempty"`
+	NetviewName string      `json:"network_view,omitempty"`
+	Cidr        string      `json:"network,omitempty"`
+	IPAddress   string      `json:"ipv4addr,omitempty"`
+	Mac         string      `json:"mac,omitempty"`
+	Name        string      `json:"name,omitempty"`
+	Ea          ibclient.EA `json:"extattrs,omitempty"`
+}
+
+func newFixedAddressSearch(netview string, ipAddr string, ea ibclient.EA) *fixedAddressSearch {
+	res := &fixedAddressSearch{NetviewName: netview, IPAddress: ipAddr}
+	res.objectType = "fixedaddress"
+	res.returnFields = []string{"ipv4addr", "mac", "name", "network", "network_view", "extattrs"}
+	res.eaSearch = ibclient.EASearch(ea)
+	return res
+}
+
+// pagedSearch is a page of a search for the objects matching IBObject. The
+// paging arguments are sent in the body along with the search fields.
+type pagedSearch struct {
+	ibclient.IBObject
+	pageID string
+}
+
+func (p *pagedSearch) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(p.IBObject)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	fields["_paging"] = 1
+	fields["_return_as_object"] = 1
+	fields["_max_results"] = wapiPageSize
+	if p.pageID != "" {
+		fields["_page_id"] = p.pageID
+	}
+	return json.Marshal(fields)
+}
+
+// searchPage is the result of a pagedSearch.
+type searchPage struct {
+	Result     json.RawMessage `json:"result"`
+	NextPageID string          `json:"next_page_id,omitempty"`
+}